@@ -1,13 +1,18 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/hex"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 
+	"github.com/fragments/fragments/internal/api"
 	"github.com/fragments/fragments/internal/client"
 	"github.com/fragments/fragments/internal/model"
 	"github.com/fragments/fragments/internal/server"
@@ -24,23 +29,18 @@ func newApplyCommand() *cobra.Command {
 
 	flags := cmd.Flags()
 	ignore := flags.StringSliceP("ignore", "i", []string{"node_modules", "vendor"}, "File/directory patterns to ignore")
+	dryRun := flags.Bool("dry-run", false, "Print the changes apply would make without applying them")
+	prune := flags.Bool("prune", false, "Delete functions and deployments owned by --owner that are not in the applied models")
+	owner := flags.String("owner", "", "Owner to apply models as, typically the repository the models are in")
+	yes := flags.BoolP("yes", "y", false, "Prune without asking for confirmation")
+	lock := flags.Bool("lock", false, "Prevent others from modifying models until apply has finished")
+	lockTTL := flags.Duration("lock-ttl", 15*time.Minute, "Time the lock is released after in case apply does not finish")
 
 	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
-		if len(args) == 0 {
-			return errors.New("target directory must be set")
+		if *prune && *owner == "" {
+			return errors.New("owner must be set to prune")
 		}
-
-		for _, target := range args {
-			stat, err := os.Stat(target)
-			if err != nil {
-				return err
-			}
-			if !stat.IsDir() {
-				return errors.Errorf("target must be a directory: %s", target)
-			}
-		}
-
-		return nil
+		return checkTargets(cmd, args)
 	}
 
 	cmd.Run = func(cmd *cobra.Command, args []string) {
@@ -50,25 +50,94 @@ func newApplyCommand() *cobra.Command {
 		excludeSource := functionDirs(models)
 		excludeSource = append(excludeSource, *ignore...)
 
-		fileStore, err := getFilestore()
-		checkErr(errors.Wrap(err, "could not set up local filestore"))
+		c, err := getClient(flags)
+		checkErr(err)
 
-		etcd, err := getETCD(flags)
-		checkErr(errors.Wrap(err, "could not set up etcd"))
+		ctx := contextFromSignal()
 
-		s := server.New(etcd, nil, fileStore)
+		if *dryRun {
+			p, err := plan(ctx, c, models, excludeSource, *owner, *prune)
+			checkErr(err)
+			printPlan(os.Stdout, p)
+			return
+		}
 
-		ctx := contextFromSignal()
-		err = apply(ctx, s, models, excludeSource)
-		checkErr(err)
+		if *lock {
+			_, err = c.LockApply(ctx, *lockTTL)
+			checkErr(errors.Wrap(err, "could not lock apply"))
+		}
+
+		err = func() error {
+			// Models are applied with the revisions they were planned at so
+			// models modified in the meantime are not overwritten
+			p, err := plan(ctx, c, models, excludeSource, *owner, *prune)
+			if err != nil {
+				return err
+			}
+
+			var pruneInput *server.PruneInput
+			if *prune {
+				pruneInput = pruneChanges(p, *owner)
+				if len(pruneInput.Functions)+len(pruneInput.Deployments) == 0 {
+					pruneInput = nil
+				} else if !*yes {
+					for _, change := range p.Changes {
+						if change.Action == server.ActionDelete {
+							fmt.Printf("- %s %s\n", change.Type, change.Name)
+						}
+					}
+					if !confirm("Delete the models above?") {
+						return errors.New("aborted")
+					}
+				}
+			}
 
-		err = etcd.Close()
+			if err := apply(ctx, c, models, excludeSource, *owner, planRevisions(p)); err != nil {
+				if api.IsConflict(err) {
+					return errors.Wrap(err, "models were modified after they were planned, run plan to review the changes and apply again")
+				}
+				return err
+			}
+
+			if pruneInput != nil {
+				if err := c.Prune(ctx, pruneInput); err != nil {
+					return errors.Wrap(err, "could not prune")
+				}
+			}
+			return nil
+		}()
+
+		if *lock {
+			// The lock is released even if apply was interrupted
+			if unlockErr := c.UnlockApply(context.Background()); unlockErr != nil {
+				fmt.Fprintln(os.Stderr, errors.Wrap(unlockErr, "could not unlock apply"))
+			}
+		}
 		checkErr(err)
 	}
 
 	return cmd
 }
 
+// checkTargets checks that all target arguments are directories.
+func checkTargets(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 {
+		return errors.New("target directory must be set")
+	}
+
+	for _, target := range args {
+		stat, err := os.Stat(target)
+		if err != nil {
+			return err
+		}
+		if !stat.IsDir() {
+			return errors.Errorf("target must be a directory: %s", target)
+		}
+	}
+
+	return nil
+}
+
 // functionDirs returns directories containing a function model.
 func functionDirs(models []client.Model) []string {
 	out := []string{}
@@ -80,27 +149,72 @@ func functionDirs(models []client.Model) []string {
 	return out
 }
 
-// apply applies all models on the server.
-func apply(ctx context.Context, server *server.Server, models []client.Model, excludeSource []string) error {
+// pruneChanges returns the models a plan deletes.
+func pruneChanges(p *server.Plan, owner string) *server.PruneInput {
+	input := &server.PruneInput{
+		Owner: owner,
+	}
+	for _, c := range p.Changes {
+		if c.Action != server.ActionDelete {
+			continue
+		}
+		switch c.Type {
+		case string(client.ModelTypeFunction):
+			input.Functions = append(input.Functions, c.Name)
+		case string(client.ModelTypeDeployment):
+			input.Deployments = append(input.Deployments, c.Name)
+		}
+	}
+	return input
+}
+
+// planRevisions returns the revisions of the stored models a plan is based on,
+// keyed by revisionKey.
+func planRevisions(p *server.Plan) map[string]int64 {
+	out := make(map[string]int64)
+	for _, c := range p.Changes {
+		if c.Revision != 0 {
+			out[revisionKey(c.Type, c.Name)] = c.Revision
+		}
+	}
+	return out
+}
+
+// revisionKey identifies a model in a map of revisions.
+func revisionKey(modelType, name string) string {
+	return fmt.Sprintf("%s/%s", modelType, name)
+}
+
+// apply applies all models on the server. The models are owned by owner, if
+// set. Models are only updated if they have not been modified after their
+// revision in revisions.
+func apply(ctx context.Context, c *api.Client, models []client.Model, excludeSource []string, owner string, revisions map[string]int64) error {
 	g, ctx := errgroup.WithContext(ctx)
 	for _, r := range models {
 		r := r
 		g.Go(func() error {
 			meta := r.Meta()
 			file := r.File()
+			revision := revisions[revisionKey(string(r.Type()), meta.Name)]
 			if function, ok := r.(client.Function); ok {
 				spec := function.Function()
-				if err := applyFunction(ctx, server, meta, file, spec, excludeSource); err != nil {
+				if err := applyFunction(ctx, c, meta, file, spec, excludeSource, owner, revision); err != nil {
 					return errors.Wrap(err, "could not apply function")
 				}
 				return nil
 			}
 			if deployment, ok := r.(client.Deployment); ok {
-				if err := applyDeployment(ctx, server, meta, deployment.Deployment()); err != nil {
+				if err := applyDeployment(ctx, c, meta, deployment.Deployment(), owner, revision); err != nil {
 					return errors.Wrap(err, "could not apply deployment")
 				}
 				return nil
 			}
+			if environment, ok := r.(client.Environment); ok {
+				if err := applyEnvironment(ctx, c, meta, file, environment.Environment(), revision); err != nil {
+					return errors.Wrap(err, "could not apply environment")
+				}
+				return nil
+			}
 			return errors.Errorf("unsupported model %q: %s", r.Type(), file)
 		})
 	}
@@ -135,16 +249,44 @@ func getModels(targets, ignore []string) ([]client.Model, error) {
 	return models, nil
 }
 
-func applyFunction(ctx context.Context, srv *server.Server, meta *client.Meta, file string, spec *client.FunctionSpec, ignore []string) error {
+func applyFunction(ctx context.Context, c *api.Client, meta *client.Meta, file string, spec *client.FunctionSpec, ignore []string, owner string, revision int64) error {
+	function, source, err := loadFunction(meta, file, spec, ignore, owner)
+	if err != nil {
+		return err
+	}
+	function.Revision = revision
+
+	uploadReq, err := c.PutFunction(ctx, function)
+	if err != nil {
+		return errors.Wrap(err, "could not put function")
+	}
+
+	if uploadReq != nil {
+		digest, err := upload(source, uploadReq)
+		if err != nil {
+			return errors.Wrap(err, "upload failed")
+		}
+
+		if err := c.ConfirmUpload(ctx, uploadReq.Token, digest); err != nil {
+			return errors.Wrap(err, "could not confirm upload")
+		}
+	}
+
+	return nil
+}
+
+// loadFunction collects the source of a function and returns the function
+// model with the source checksum set, together with the source files.
+func loadFunction(meta *client.Meta, file string, spec *client.FunctionSpec, ignore []string, owner string) (*model.Function, []string, error) {
 	// Collect function source files
 	dir := filepath.Dir(file)
 	source, err := client.CollectSource(dir, ignore)
 	if err != nil {
-		return errors.Wrap(err, "could not collect function source")
+		return nil, nil, errors.Wrap(err, "could not collect function source")
 	}
 
 	if len(source) == 0 {
-		return errors.New("function contains no source")
+		return nil, nil, errors.New("function contains no source")
 	}
 
 	// Ensure consistent order for hashing
@@ -165,7 +307,7 @@ func applyFunction(ctx context.Context, srv *server.Server, meta *client.Meta, f
 	}
 	shasum, err := client.Checksum(source, checksumExclude)
 	if err != nil {
-		return errors.Wrap(err, "could not calculate source checksum")
+		return nil, nil, errors.Wrap(err, "could not calculate source checksum")
 	}
 
 	// Construct request
@@ -174,53 +316,133 @@ func applyFunction(ctx context.Context, srv *server.Server, meta *client.Meta, f
 		Labels:   meta.Labels,
 		Checksum: hex.EncodeToString(shasum),
 		Runtime:  spec.Runtime,
+		Owner:    owner,
 	}
 	if spec.AWS != nil {
 		function.AWS = &model.FunctionAWS{
 			Timeout: spec.AWS.Timeout,
 			Memory:  spec.AWS.Memory,
+			Handler: spec.AWS.Handler,
 		}
 	}
 
-	uploadReq, err := srv.PutFunction(ctx, function)
+	return function, source, nil
+}
+
+func applyDeployment(ctx context.Context, c *api.Client, meta *client.Meta, deployment *client.DeploymentSpec, owner string, revision int64) error {
+	deploy := deploymentModel(meta, deployment, owner)
+	deploy.Revision = revision
+	if err := c.PutDeployment(ctx, deploy); err != nil {
+		return errors.Wrap(err, "PutDeployment failed")
+	}
+	return nil
+}
+
+// deploymentModel returns the deployment model for a deployment spec.
+func deploymentModel(meta *client.Meta, deployment *client.DeploymentSpec, owner string) *model.Deployment {
+	return &model.Deployment{
+		Name:              meta.Name,
+		EnvironmentLabels: deployment.EnvironmentLabels,
+		FunctionLabels:    deployment.FunctionLabels,
+		Owner:             owner,
+	}
+}
+
+// applyEnvironment creates an environment or updates it if it already exists.
+// The credentials are resolved from their references, credentials stored in
+// the secret store are resolved by the server.
+func applyEnvironment(ctx context.Context, c *api.Client, meta *client.Meta, file string, spec *client.EnvironmentSpec, revision int64) error {
+	env, err := environmentModel(meta, spec)
 	if err != nil {
-		return errors.Wrap(err, "could not put function")
+		return err
 	}
 
-	if uploadReq != nil {
-		if err := upload(source, uploadReq); err != nil {
-			return errors.Wrap(err, "upload failed")
-		}
+	username, err := spec.Credentials.Username.Resolve(file)
+	if err != nil {
+		return errors.Wrap(err, "could not resolve username")
+	}
+	password, err := spec.Credentials.Password.Resolve(file)
+	if err != nil {
+		return errors.Wrap(err, "could not resolve password")
+	}
 
-		if err := srv.ConfirmUpload(ctx, uploadReq.Token); err != nil {
-			return errors.Wrap(err, "could not confirm upload")
+	_, err = c.GetEnvironment(ctx, env.Name)
+	if api.IsNotFound(err) {
+		input := &server.EnvironmentInput{
+			Name:           env.Name,
+			Labels:         env.Labels,
+			Infrastructure: env.Infrastructure,
+			Username:       username,
+			Password:       password,
+			UsernameSecret: spec.Credentials.Username.Vault,
+			PasswordSecret: spec.Credentials.Password.Vault,
+			AWS:            env.AWS,
+		}
+		if err := c.CreateEnvironment(ctx, input); err != nil {
+			return errors.Wrap(err, "CreateEnvironment failed")
 		}
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "could not check existing environment")
 	}
 
+	update := &server.EnvironmentUpdate{
+		Name:           env.Name,
+		Labels:         env.Labels,
+		AWS:            env.AWS,
+		Username:       username,
+		Password:       password,
+		UsernameSecret: spec.Credentials.Username.Vault,
+		PasswordSecret: spec.Credentials.Password.Vault,
+		Revision:       revision,
+	}
+	if err := c.UpdateEnvironment(ctx, update); err != nil {
+		return errors.Wrap(err, "UpdateEnvironment failed")
+	}
 	return nil
 }
 
-func applyDeployment(ctx context.Context, srv *server.Server, meta *client.Meta, deployment *client.DeploymentSpec) error {
-	deploy := &model.Deployment{
-		Name:              meta.Name,
-		EnvironmentLabels: deployment.EnvironmentLabels,
-		FunctionLabels:    deployment.FunctionLabels,
+// environmentModel returns the environment model for an environment spec.
+// Credentials are not part of the model.
+func environmentModel(meta *client.Meta, spec *client.EnvironmentSpec) (*model.Environment, error) {
+	infra, err := parseInfrastructure(spec.Infrastructure)
+	if err != nil {
+		return nil, err
 	}
-	if err := srv.PutDeployment(ctx, deploy); err != nil {
-		return errors.Wrap(err, "PutDeployment failed")
+	env := &model.Environment{
+		Name:           meta.Name,
+		Labels:         meta.Labels,
+		Infrastructure: infra,
 	}
-	return nil
+	if spec.AWS != nil {
+		env.AWS = &model.InfrastructureAWS{
+			Region: spec.AWS.Region,
+			Role:   spec.AWS.Role,
+		}
+	}
+	return env, nil
 }
 
-func upload(source []string, uploadReq *server.UploadRequest) error {
+// upload archives and uploads the source. Returns the digest of the uploaded
+// archive, the server verifies the upload against it.
+func upload(source []string, uploadReq *server.UploadRequest) (*model.Digest, error) {
 	targz, err := client.Compress(source)
 	if err != nil {
-		return errors.Wrap(err, "could not archive source")
+		return nil, errors.Wrap(err, "could not archive source")
+	}
+	archive, err := ioutil.ReadAll(targz)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not archive source")
+	}
+	digest, err := model.NewDigest(bytes.NewReader(archive))
+	if err != nil {
+		return nil, err
 	}
 
-	if err := client.Upload(targz, uploadReq.URL); err != nil {
-		return errors.Wrap(err, "upload failed")
+	if err := client.Upload(bytes.NewReader(archive), uploadReq.URL, digest); err != nil {
+		return nil, errors.Wrap(err, "upload failed")
 	}
 
-	return nil
+	return digest, nil
 }
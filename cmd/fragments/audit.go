@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/fragments/fragments/internal/model"
+	"github.com/fragments/fragments/internal/server"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func newAuditCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "audit [function|deployment|environment [name]]",
+		Short: "Show who changed models and when",
+		Long:  "Show the audit log of the namespace, oldest first. Events can be limited to a model type or a single model, an actor and a time range.",
+	}
+
+	flags := cmd.Flags()
+	output := flags.StringP("output", "o", outputTable, "Output format: table, json or yaml")
+	actor := flags.String("actor", "", "Only show changes made by the actor")
+	since := flags.String("since", "", "Only show changes after a time, RFC 3339 or a duration before now like 24h")
+	until := flags.String("until", "", "Only show changes before a time, RFC 3339 or a duration before now like 1h")
+
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		if len(args) > 2 {
+			return errors.New("too many arguments")
+		}
+		if len(args) > 0 {
+			modelType, err := parseModelType(args[0])
+			if err != nil {
+				return err
+			}
+			if modelType == modelTypeNamespace {
+				return errors.New("namespaces are not audited")
+			}
+		}
+		for _, v := range []string{*since, *until} {
+			if _, err := parseAuditTime(v, time.Now()); err != nil {
+				return err
+			}
+		}
+		return checkOutput(*output)
+	}
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		now := time.Now()
+		filter := &server.AuditFilter{Actor: *actor}
+		if len(args) > 0 {
+			modelType, err := parseModelType(args[0])
+			checkErr(err)
+			filter.ModelType = modelType
+		}
+		if len(args) > 1 {
+			filter.ModelName = args[1]
+		}
+		var err error
+		filter.Since, err = parseAuditTime(*since, now)
+		checkErr(err)
+		filter.Until, err = parseAuditTime(*until, now)
+		checkErr(err)
+
+		c, err := getClient(flags)
+		checkErr(err)
+
+		ctx := contextFromSignal()
+		events, err := c.ListAuditEvents(ctx, filter)
+		checkErr(errors.Wrap(err, "could not list audit events"))
+
+		err = printOutput(os.Stdout, *output, events, func(w io.Writer) {
+			printAuditEventTable(w, events)
+		})
+		checkErr(err)
+	}
+
+	return cmd
+}
+
+// parseAuditTime parses an RFC 3339 time or a duration before now. Returns
+// the zero time if v is empty.
+func parseAuditTime(v string, now time.Time) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, errors.Errorf("invalid time %q, must be RFC 3339 or a duration", v)
+	}
+	return t, nil
+}
+
+func printAuditEventTable(w io.Writer, events []*model.AuditEvent) {
+	fmt.Fprintln(w, "TIME\tACTOR\tSOURCE\tACTION\tMODEL\tCHECKSUM\tOUTCOME")
+	for _, e := range events {
+		checksum := e.NewChecksum
+		switch {
+		case e.NewChecksum == "":
+			checksum = e.OldChecksum
+		case e.OldChecksum != "" && e.OldChecksum != e.NewChecksum:
+			checksum = fmt.Sprintf("%s -> %s", e.OldChecksum, e.NewChecksum)
+		}
+		outcome := string(e.Outcome)
+		if e.Error != "" {
+			outcome = fmt.Sprintf("%s: %s", outcome, e.Error)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s/%s\t%s\t%s\n", e.Time.Local().Format(time.RFC3339), e.Actor, e.SourceHost, e.Action, e.ModelType, e.ModelName, checksum, outcome)
+	}
+}
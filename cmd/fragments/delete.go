@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/fragments/fragments/internal/client"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func newDeleteCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "delete function|deployment|environment [name]",
+		Short: "Delete a model from the server",
+		Long: "Delete a model from the server. A function's source and an environment's " +
+			"credentials are deleted with it. Functions and environments that are selected " +
+			"by a deployment are not deleted unless --force is set.",
+	}
+
+	flags := cmd.Flags()
+	force := flags.BoolP("force", "f", false, "Delete even if the model is selected by a deployment")
+	yes := flags.BoolP("yes", "y", false, "Delete without asking for confirmation")
+
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return errors.New("model type and name must be set")
+		}
+		_, err := parseModelType(args[0])
+		return err
+	}
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		modelType, err := parseModelType(args[0])
+		checkErr(err)
+		name := args[1]
+
+		c, err := getClient(flags)
+		checkErr(err)
+
+		if !*yes && !confirm(fmt.Sprintf("Delete %s %s?", modelType, name)) {
+			checkErr(errors.New("aborted"))
+		}
+
+		ctx := contextFromSignal()
+		switch modelType {
+		case string(client.ModelTypeFunction):
+			err = c.DeleteFunction(ctx, name, *force)
+		case string(client.ModelTypeDeployment):
+			err = c.DeleteDeployment(ctx, name)
+		case modelTypeEnvironment:
+			err = c.DeleteEnvironment(ctx, name, *force)
+		}
+		checkErr(errors.Wrapf(err, "could not delete %s", modelType))
+	}
+
+	return cmd
+}
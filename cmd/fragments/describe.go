@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/fragments/fragments/internal/server"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func newDescribeCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "describe",
+		Short: "Show details of a model",
+	}
+
+	cmd.AddCommand(newDescribeDeploymentCommand())
+
+	return cmd
+}
+
+func newDescribeDeploymentCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "deployment [name]",
+		Short: "Show a deployment and the functions and environments it selects",
+	}
+
+	flags := cmd.Flags()
+	output := flags.StringP("output", "o", outputTable, "Output format: table, json or yaml")
+
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("deployment name must be set")
+		}
+		return checkOutput(*output)
+	}
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		c, err := getClient(flags)
+		checkErr(err)
+
+		ctx := contextFromSignal()
+		description, err := c.DescribeDeployment(ctx, args[0])
+		checkErr(errors.Wrap(err, "could not describe deployment"))
+
+		err = printOutput(os.Stdout, *output, description, func(w io.Writer) {
+			printDeploymentDescription(w, description)
+		})
+		checkErr(err)
+	}
+
+	return cmd
+}
+
+func printDeploymentDescription(w io.Writer, description *server.DeploymentDescription) {
+	d := description.Deployment
+	fmt.Fprintf(w, "Name:\t%s\n", d.Name)
+	fmt.Fprintf(w, "Owner:\t%s\n", d.Owner)
+	fmt.Fprintf(w, "Function selector:\t%s\n", formatLabels(d.FunctionLabels))
+	fmt.Fprintf(w, "Environment selector:\t%s\n", formatLabels(d.EnvironmentLabels))
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Functions:")
+	printFunctionTable(w, description.Functions)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Environments:")
+	printEnvironmentTable(w, description.Environments)
+}
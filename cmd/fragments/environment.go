@@ -18,6 +18,8 @@ func newEnvironmentCommand() *cobra.Command {
 	}
 
 	cmd.AddCommand(newEnvironmentCreateCommand())
+	cmd.AddCommand(newEnvironmentUpdateCommand())
+	cmd.AddCommand(newEnvironmentRotateCredentialsCommand())
 
 	return cmd
 }
@@ -35,6 +37,7 @@ func newEnvironmentCreateCommand() *cobra.Command {
 	password := flags.StringP("password", "p", "", "Password for authenticating with infrastructure provider")
 	labels := flags.StringSliceP("label", "l", []string{}, "Label(s) to put on environment")
 	awsRegion := flags.String("aws.region", "", "AWS region")
+	awsRole := flags.String("aws.role", "", "ARN of the IAM role AWS functions are executed as")
 
 	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
 		if *name == "" {
@@ -60,11 +63,8 @@ func newEnvironmentCreateCommand() *cobra.Command {
 		l, err := extractLabels(*labels)
 		checkErr(errors.Wrap(err, "format must be key=value"))
 
-		etcd, err := getETCD(flags)
-		checkErr(errors.Wrap(err, "could not set up etcd"))
-
-		vault, err := getVault(flags)
-		checkErr(errors.Wrap(err, "could not set up vault"))
+		c, err := getClient(flags)
+		checkErr(err)
 
 		input := &server.EnvironmentInput{
 			Name:           *name,
@@ -74,18 +74,103 @@ func newEnvironmentCreateCommand() *cobra.Command {
 			Password:       *password,
 			AWS: &model.InfrastructureAWS{
 				Region: *awsRegion,
+				Role:   *awsRole,
 			},
 		}
 
-		s := server.New(etcd, vault, nil)
-
 		ctx := contextFromSignal()
 
-		err = s.CreateEnvironment(ctx, input)
+		err = c.CreateEnvironment(ctx, input)
 		checkErr(errors.Wrap(err, "create environment failed"))
+	}
+
+	return cmd
+}
+
+func newEnvironmentUpdateCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "update",
+		Short: "Update an existing environment",
+		Long:  "Update an existing environment. Only the flags that are set are updated.",
+	}
+
+	flags := cmd.Flags()
+	name := flags.StringP("name", "n", "", "Environment name")
+	labels := flags.StringSliceP("label", "l", []string{}, "Label(s) to put on environment, replaces existing labels")
+	awsRegion := flags.String("aws.region", "", "AWS region")
+	awsRole := flags.String("aws.role", "", "ARN of the IAM role AWS functions are executed as")
 
-		err = etcd.Close()
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		if *name == "" {
+			return errors.New("name must be set")
+		}
+		return nil
+	}
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		input := &server.EnvironmentUpdate{
+			Name: *name,
+		}
+		if flags.Changed("label") {
+			l, err := extractLabels(*labels)
+			checkErr(errors.Wrap(err, "format must be key=value"))
+			input.Labels = l
+		}
+		if *awsRegion != "" || *awsRole != "" {
+			input.AWS = &model.InfrastructureAWS{
+				Region: *awsRegion,
+				Role:   *awsRole,
+			}
+		}
+
+		c, err := getClient(flags)
 		checkErr(err)
+
+		ctx := contextFromSignal()
+
+		err = c.UpdateEnvironment(ctx, input)
+		checkErr(errors.Wrap(err, "update environment failed"))
+	}
+
+	return cmd
+}
+
+func newEnvironmentRotateCredentialsCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "rotate-credentials",
+		Short: "Replace the credentials of an environment",
+	}
+
+	flags := cmd.Flags()
+	name := flags.StringP("name", "n", "", "Environment name")
+	username := flags.StringP("username", "u", "", "Username for authenticating with infrastructure provider")
+	password := flags.StringP("password", "p", "", "Password for authenticating with infrastructure provider")
+
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		if *name == "" {
+			return errors.New("name must be set")
+		}
+		if *username == "" {
+			return errors.New("username must be set")
+		}
+		if *password == "" {
+			return errors.New("password must be set")
+		}
+		return nil
+	}
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		c, err := getClient(flags)
+		checkErr(err)
+
+		ctx := contextFromSignal()
+
+		err = c.UpdateEnvironment(ctx, &server.EnvironmentUpdate{
+			Name:     *name,
+			Username: *username,
+			Password: *password,
+		})
+		checkErr(errors.Wrap(err, "rotate credentials failed"))
 	}
 
 	return cmd
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/fragments/fragments/internal/server"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func newGCCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "gc",
+		Short: "Delete source that is no longer referenced",
+		Long:  "Delete persisted source of all namespaces that is not referenced by any function, function version or pending upload and is older than the grace period.",
+	}
+
+	flags := cmd.Flags()
+	output := flags.StringP("output", "o", outputTable, "Output format: table, json or yaml")
+	dryRun := flags.Bool("dry-run", false, "Only report the source that would be deleted")
+	gracePeriod := flags.Duration("grace-period", server.DefaultSourceGracePeriod, "Age unreferenced source must have reached to be deleted")
+
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		if len(args) > 0 {
+			return errors.New("too many arguments")
+		}
+		if *gracePeriod < time.Second {
+			return errors.New("grace-period must be at least one second")
+		}
+		return checkOutput(*output)
+	}
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		c, err := getClient(flags)
+		checkErr(err)
+
+		ctx := contextFromSignal()
+		res, err := c.CollectSource(ctx, *gracePeriod, *dryRun)
+		checkErr(errors.Wrap(err, "could not collect source"))
+
+		err = printOutput(os.Stdout, *output, res, func(w io.Writer) {
+			printSourceCollectionTable(w, res)
+		})
+		checkErr(err)
+	}
+
+	return cmd
+}
+
+func printSourceCollectionTable(w io.Writer, res *server.SourceCollection) {
+	fmt.Fprintln(w, "NAME\tSIZE\tMODIFIED\tSTATUS")
+	deleted, size := 0, int64(0)
+	for _, f := range res.Files {
+		status := string(f.Status)
+		if res.DryRun && f.Status == server.SourceDeleted {
+			status = "would delete"
+		}
+		if f.Missing {
+			status = fmt.Sprintf("%s (missing)", status)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", f.Name, f.Size, f.Modified.Local().Format(time.RFC3339), status)
+		if f.Status == server.SourceDeleted {
+			deleted++
+			size += f.Size
+		}
+	}
+	verb := "Deleted"
+	if res.DryRun {
+		verb = "Would delete"
+	}
+	fmt.Fprintf(w, "\n%s %d of %d files, %d bytes\n", verb, deleted, len(res.Files), size)
+}
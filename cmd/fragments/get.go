@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/fragments/fragments/internal/api"
+	"github.com/fragments/fragments/internal/client"
+	"github.com/fragments/fragments/internal/model"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+const modelTypeEnvironment = "environment"
+
+// modelTypeNamespace lists the namespaces. Namespaces can only be listed.
+const modelTypeNamespace = "namespace"
+
+func newGetCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "get function|deployment|environment [name]",
+		Short: "Get models from the server",
+		Long:  "Get a model by name from the server. All models of the type are listed if no name is given.",
+	}
+
+	flags := cmd.Flags()
+	output := flags.StringP("output", "o", outputTable, "Output format: table, json or yaml")
+
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 || len(args) > 2 {
+			return errors.New("model type and optional name must be set")
+		}
+		if _, err := parseModelType(args[0]); err != nil {
+			return err
+		}
+		return checkOutput(*output)
+	}
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		modelType, err := parseModelType(args[0])
+		checkErr(err)
+
+		name := ""
+		if len(args) > 1 {
+			name = args[1]
+		}
+
+		c, err := getClient(flags)
+		checkErr(err)
+
+		ctx := contextFromSignal()
+		err = get(ctx, c, os.Stdout, *output, modelType, name)
+		checkErr(err)
+	}
+
+	return cmd
+}
+
+func newListCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "list function|deployment|environment|namespace",
+		Short: "List models on the server",
+	}
+
+	flags := cmd.Flags()
+	output := flags.StringP("output", "o", outputTable, "Output format: table, json or yaml")
+
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("model type must be set")
+		}
+		if _, err := parseModelType(args[0]); err != nil {
+			return err
+		}
+		return checkOutput(*output)
+	}
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		modelType, err := parseModelType(args[0])
+		checkErr(err)
+
+		c, err := getClient(flags)
+		checkErr(err)
+
+		ctx := contextFromSignal()
+		err = get(ctx, c, os.Stdout, *output, modelType, "")
+		checkErr(err)
+	}
+
+	return cmd
+}
+
+// parseModelType parses a model type argument. Both singular and plural forms
+// are accepted.
+func parseModelType(arg string) (string, error) {
+	t := strings.TrimSuffix(strings.ToLower(arg), "s")
+	switch t {
+	case string(client.ModelTypeFunction), string(client.ModelTypeDeployment), modelTypeEnvironment:
+		return t, nil
+	case "env":
+		return modelTypeEnvironment, nil
+	case modelTypeNamespace:
+		return modelTypeNamespace, nil
+	default:
+		return "", errors.Errorf("unsupported model type %q", arg)
+	}
+}
+
+// get prints a model of a type. All models of the type are printed if name is
+// not set.
+func get(ctx context.Context, c *api.Client, w io.Writer, output, modelType, name string) error {
+	switch modelType {
+	case string(client.ModelTypeFunction):
+		if name != "" {
+			f, err := c.GetFunction(ctx, name)
+			if err != nil {
+				return errors.Wrap(err, "could not get function")
+			}
+			return printOutput(w, output, f, func(w io.Writer) {
+				printFunctionTable(w, []*model.Function{f})
+			})
+		}
+		functions, err := c.ListFunctions(ctx)
+		if err != nil {
+			return errors.Wrap(err, "could not list functions")
+		}
+		return printOutput(w, output, functions, func(w io.Writer) {
+			printFunctionTable(w, functions)
+		})
+	case string(client.ModelTypeDeployment):
+		if name != "" {
+			d, err := c.GetDeployment(ctx, name)
+			if err != nil {
+				return errors.Wrap(err, "could not get deployment")
+			}
+			return printOutput(w, output, d, func(w io.Writer) {
+				printDeploymentTable(w, []*model.Deployment{d})
+			})
+		}
+		deployments, err := c.ListDeployments(ctx)
+		if err != nil {
+			return errors.Wrap(err, "could not list deployments")
+		}
+		return printOutput(w, output, deployments, func(w io.Writer) {
+			printDeploymentTable(w, deployments)
+		})
+	case modelTypeEnvironment:
+		if name != "" {
+			e, err := c.GetEnvironment(ctx, name)
+			if err != nil {
+				return errors.Wrap(err, "could not get environment")
+			}
+			return printOutput(w, output, e, func(w io.Writer) {
+				printEnvironmentTable(w, []*model.Environment{e})
+			})
+		}
+		environments, err := c.ListEnvironments(ctx)
+		if err != nil {
+			return errors.Wrap(err, "could not list environments")
+		}
+		return printOutput(w, output, environments, func(w io.Writer) {
+			printEnvironmentTable(w, environments)
+		})
+	case modelTypeNamespace:
+		if name != "" {
+			return errors.New("namespaces can only be listed")
+		}
+		namespaces, err := c.ListNamespaces(ctx)
+		if err != nil {
+			return errors.Wrap(err, "could not list namespaces")
+		}
+		return printOutput(w, output, namespaces, func(w io.Writer) {
+			printNamespaceTable(w, namespaces)
+		})
+	default:
+		return errors.Errorf("unsupported model type %q", modelType)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/fragments/fragments/internal/model"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func newHistoryCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "history",
+		Short: "Show the version history of a model",
+	}
+
+	cmd.AddCommand(newHistoryFunctionCommand())
+
+	return cmd
+}
+
+func newHistoryFunctionCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "function [name]",
+		Short: "List the versions of a function",
+	}
+
+	flags := cmd.Flags()
+	output := flags.StringP("output", "o", outputTable, "Output format: table, json or yaml")
+
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("function name must be set")
+		}
+		return checkOutput(*output)
+	}
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		c, err := getClient(flags)
+		checkErr(err)
+
+		ctx := contextFromSignal()
+		versions, err := c.FunctionHistory(ctx, args[0])
+		checkErr(errors.Wrap(err, "could not get function history"))
+
+		err = printOutput(os.Stdout, *output, versions, func(w io.Writer) {
+			printFunctionVersionTable(w, versions)
+		})
+		checkErr(err)
+	}
+
+	return cmd
+}
+
+func printFunctionVersionTable(w io.Writer, versions []*model.FunctionVersion) {
+	fmt.Fprintln(w, "VERSION\tCREATED\tAPPLIED BY\tCHECKSUM\tNOTE")
+	for _, v := range versions {
+		checksum := ""
+		if v.Function != nil {
+			checksum = v.Function.Checksum
+		}
+		note := ""
+		if v.RollbackOf > 0 {
+			note = fmt.Sprintf("rollback to %d", v.RollbackOf)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", v.Version, v.Created.Local().Format(time.RFC3339), v.AppliedBy, checksum, note)
+	}
+}
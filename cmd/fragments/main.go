@@ -1,16 +1,24 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"os/signal"
+	"os/user"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/fragments/fragments/internal/api"
 	"github.com/fragments/fragments/internal/backend"
 	"github.com/fragments/fragments/internal/filestore"
+	"github.com/fragments/fragments/internal/server"
+	"github.com/ghodss/yaml"
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
@@ -23,20 +31,201 @@ func main() {
 	}
 
 	flags := cmd.PersistentFlags()
-	flags.StringSliceP("etcd", "e", []string{"0.0.0.0:2379"}, "ETCD endpoints to connect to for storing state")
-	flags.String("vault", "http://0.0.0.0:8200", "Vault address for storing secrets")
+	flags.StringP("server", "s", "http://127.0.0.1:7100", "Address of the fragments server")
+	flags.String("namespace", "", "Namespace of the models, defaults to FRAGMENTS_NAMESPACE or the namespace in ~/.fragments/config.yml")
+	flags.String("token", "", "Token to authenticate to the server with, defaults to FRAGMENTS_TOKEN or the token in ~/.fragments/config.yml")
 
 	cmd.AddCommand(newApplyCommand())
+	cmd.AddCommand(newAuditCommand())
+	cmd.AddCommand(newDeleteCommand())
+	cmd.AddCommand(newDescribeCommand())
 	cmd.AddCommand(newEnvironmentCommand())
+	cmd.AddCommand(newGCCommand())
+	cmd.AddCommand(newGetCommand())
+	cmd.AddCommand(newHistoryCommand())
+	cmd.AddCommand(newListCommand())
+	cmd.AddCommand(newPlanCommand())
+	cmd.AddCommand(newRollbackCommand())
+	cmd.AddCommand(newServerCommand())
+	cmd.AddCommand(newSourceCommand())
+	cmd.AddCommand(newWatchCommand())
 
 	_ = cmd.Execute()
 }
 
+func getClient(flags *pflag.FlagSet) (*api.Client, error) {
+	address, err := flags.GetString("server")
+	if err != nil {
+		return nil, err
+	}
+	client, err := api.NewClient(address)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create server client")
+	}
+	client.SetActor(actor())
+	namespace, err := getNamespace(flags)
+	if err != nil {
+		return nil, err
+	}
+	client.SetNamespace(namespace)
+	token, err := getToken(flags)
+	if err != nil {
+		return nil, err
+	}
+	client.SetToken(token)
+	return client, nil
+}
+
+// config is the client configuration read from ~/.fragments/config.yml.
+type config struct {
+	// Namespace is the namespace used if no namespace is set with the
+	// namespace flag or FRAGMENTS_NAMESPACE.
+	Namespace string `json:"namespace"`
+	// Token is the token used if no token is set with the token flag or
+	// FRAGMENTS_TOKEN.
+	Token string `json:"token"`
+}
+
+// getNamespace returns the namespace requests are scoped to. The namespace
+// flag takes precedence over the FRAGMENTS_NAMESPACE environment variable,
+// which takes precedence over the config file. Returns an empty string if no
+// namespace is set, the server uses the default namespace then.
+func getNamespace(flags *pflag.FlagSet) (string, error) {
+	namespace, err := flags.GetString("namespace")
+	if err != nil {
+		return "", err
+	}
+	if namespace == "" {
+		namespace = os.Getenv("FRAGMENTS_NAMESPACE")
+	}
+	if namespace == "" {
+		conf, err := readConfig()
+		if err != nil {
+			return "", err
+		}
+		namespace = conf.Namespace
+	}
+	if namespace == "" {
+		return "", nil
+	}
+	if err := server.ValidateNamespace(namespace); err != nil {
+		return "", err
+	}
+	return namespace, nil
+}
+
+// getToken returns the token to authenticate with. The token flag takes
+// precedence over the FRAGMENTS_TOKEN environment variable, which takes
+// precedence over the config file. Returns an empty string if no token is set.
+func getToken(flags *pflag.FlagSet) (string, error) {
+	token, err := flags.GetString("token")
+	if err != nil {
+		return "", err
+	}
+	if token == "" {
+		token = os.Getenv("FRAGMENTS_TOKEN")
+	}
+	if token == "" {
+		conf, err := readConfig()
+		if err != nil {
+			return "", err
+		}
+		token = conf.Token
+	}
+	return token, nil
+}
+
+// readConfig reads the client configuration. An empty configuration is
+// returned if the config file does not exist.
+func readConfig() (*config, error) {
+	home, err := homedir.Dir()
+	if err != nil {
+		return nil, err
+	}
+	raw, err := ioutil.ReadFile(filepath.Join(home, ".fragments", "config.yml"))
+	if os.IsNotExist(err) {
+		return &config{}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read config")
+	}
+	var conf config
+	if err := yaml.Unmarshal(raw, &conf); err != nil {
+		return nil, errors.Wrap(err, "could not parse config")
+	}
+	return &conf, nil
+}
+
+// actor returns the user and host the command is run by.
+func actor() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return name
+	}
+	return fmt.Sprintf("%s@%s", name, host)
+}
+
+// stateStore is a backend the server can store state in.
+type stateStore interface {
+	backend.Reader
+	backend.Writer
+	backend.Lister
+	backend.Locker
+	backend.RevisionReader
+	backend.RevisionWriter
+	backend.Txn
+	backend.Watcher
+	io.Closer
+}
+
+// getState returns the backend state is stored in. The state flag selects
+// the backend with etcd://host:port[,host:port] or file:///path/to/state.db,
+// the etcd endpoints are used if it is not set.
+func getState(flags *pflag.FlagSet) (stateStore, error) {
+	state, err := flags.GetString("state")
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case state == "" || strings.HasPrefix(state, "etcd://"):
+		var etcd *backend.ETCD
+		if state == "" {
+			etcd, err = getETCD(flags)
+		} else {
+			etcd, err = newETCD(strings.Split(strings.TrimPrefix(state, "etcd://"), ","))
+		}
+		if err != nil {
+			return nil, err
+		}
+		return etcd, nil
+	case strings.HasPrefix(state, "file://"):
+		path := strings.TrimPrefix(state, "file://")
+		if path == "" {
+			return nil, errors.New("state file path not set")
+		}
+		b, err := backend.NewBoltClient(path, 3*time.Second)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not get backend")
+		}
+		return b, nil
+	default:
+		return nil, errors.Errorf("unsupported state backend %q", state)
+	}
+}
+
 func getETCD(flags *pflag.FlagSet) (*backend.ETCD, error) {
 	endpoints, err := flags.GetStringSlice("etcd")
 	if err != nil {
 		return nil, err
 	}
+	return newETCD(endpoints)
+}
+
+func newETCD(endpoints []string) (*backend.ETCD, error) {
 	etcd, err := backend.NewETCDClient(endpoints, 3*time.Second)
 	if err != nil {
 		return nil, errors.Wrap(err, "could not get backend")
@@ -44,32 +233,141 @@ func getETCD(flags *pflag.FlagSet) (*backend.ETCD, error) {
 	return etcd, nil
 }
 
-func getVault(flags *pflag.FlagSet) (*backend.Vault, error) {
-	address, err := flags.GetString("vault")
+// secretStore is a backend the server can store secrets in.
+type secretStore interface {
+	backend.Reader
+	backend.Writer
+}
+
+// getSecrets returns the backend secrets are stored in. The secrets flag
+// selects a Vault address or an encrypted file with file:///path/to/secrets.db,
+// the vault address is used if it is not set. Encrypted files are encrypted
+// with the keys in the secrets-key-file, or the FRAGMENTS_SECRETS_KEY
+// environment variable if no key file is set.
+func getSecrets(flags *pflag.FlagSet) (secretStore, error) {
+	secrets, err := flags.GetString("secrets")
 	if err != nil {
 		return nil, err
 	}
-	vault, err := backend.NewVaultClient(address)
+	switch {
+	case secrets == "" || strings.HasPrefix(secrets, "http://") || strings.HasPrefix(secrets, "https://"):
+		conf, err := getVaultConfig(flags)
+		if err != nil {
+			return nil, err
+		}
+		if secrets != "" {
+			conf.Address = secrets
+		}
+		vault, err := backend.NewVault(conf)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not get secret backend")
+		}
+		return vault, nil
+	case strings.HasPrefix(secrets, "file://"):
+		path := strings.TrimPrefix(secrets, "file://")
+		if path == "" {
+			return nil, errors.New("secrets file path not set")
+		}
+		keys, err := getSecretKeys(flags)
+		if err != nil {
+			return nil, err
+		}
+		b, err := backend.NewBoltClient(path, 3*time.Second)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not get secret backend")
+		}
+		encrypted, err := backend.NewEncrypted(b, keys...)
+		if err != nil {
+			_ = b.Close()
+			return nil, err
+		}
+		return encrypted, nil
+	default:
+		return nil, errors.Errorf("unsupported secret backend %q", secrets)
+	}
+}
+
+// getVaultConfig returns the configuration to connect to Vault with.
+func getVaultConfig(flags *pflag.FlagSet) (backend.VaultConfig, error) {
+	var conf backend.VaultConfig
+	var err error
+	if conf.Address, err = flags.GetString("vault"); err != nil {
+		return conf, err
+	}
+	if conf.Mount, err = flags.GetString("vault.mount"); err != nil {
+		return conf, err
+	}
+	if conf.KVVersion, err = flags.GetInt("vault.kv-version"); err != nil {
+		return conf, err
+	}
+	if conf.TokenFile, err = flags.GetString("vault.token-file"); err != nil {
+		return conf, err
+	}
+	if conf.AppRoleID, err = flags.GetString("vault.approle-role-id"); err != nil {
+		return conf, err
+	}
+	if conf.AppRoleMount, err = flags.GetString("vault.approle-mount"); err != nil {
+		return conf, err
+	}
+	secretIDFile, err := flags.GetString("vault.approle-secret-id-file")
 	if err != nil {
-		return nil, errors.Wrap(err, "could not get secret backend")
+		return conf, err
+	}
+	if secretIDFile != "" {
+		raw, err := ioutil.ReadFile(secretIDFile)
+		if err != nil {
+			return conf, errors.Wrap(err, "could not read approle secret id")
+		}
+		conf.AppRoleSecretID = strings.TrimSpace(string(raw))
 	}
-	return vault, nil
+	return conf, nil
 }
 
-func getFilestore() (*filestore.Local, error) {
+// getSecretKeys reads the keys secrets are encrypted with.
+func getSecretKeys(flags *pflag.FlagSet) ([]backend.SecretKey, error) {
+	keyFile, err := flags.GetString("secrets-key-file")
+	if err != nil {
+		return nil, err
+	}
+	if keyFile == "" {
+		env := os.Getenv("FRAGMENTS_SECRETS_KEY")
+		if env == "" {
+			return nil, errors.New("secrets-key-file or FRAGMENTS_SECRETS_KEY must be set to encrypt secrets")
+		}
+		return backend.ParseSecretKeys(env)
+	}
+	raw, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read secret keys")
+	}
+	return backend.ParseSecretKeys(string(raw))
+}
+
+func getFilestore(listen, uploadURL string) (*filestore.Local, error) {
 	home, err := homedir.Dir()
 	if err != nil {
 		return nil, err
 	}
 	uploads := filepath.Join(home, ".fragments", "uploads")
 	source := filepath.Join(home, ".fragments", "source")
-	sourceStore, err := filestore.NewLocal(uploads, source)
+	sourceStore, err := filestore.NewLocal(uploads, source, listen, uploadURL)
 	if err != nil {
 		return nil, err
 	}
 	return sourceStore, nil
 }
 
+// confirm asks the user a yes/no question. Returns true if the answer is yes.
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
 func checkErr(err error) {
 	if err == nil {
 		return
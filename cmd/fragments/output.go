@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/fragments/fragments/internal/model"
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+// checkOutput returns an error if the output format is not supported.
+func checkOutput(format string) error {
+	switch format {
+	case outputTable, outputJSON, outputYAML:
+		return nil
+	default:
+		return errors.Errorf("unsupported output format %q, must be one of: %s, %s, %s", format, outputTable, outputJSON, outputYAML)
+	}
+}
+
+// printOutput writes v to w in the output format. The table function is
+// called to write v in table format.
+func printOutput(w io.Writer, format string, v interface{}, table func(w io.Writer)) error {
+	switch format {
+	case outputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case outputYAML:
+		data, err := yaml.Marshal(v)
+		if err != nil {
+			return errors.Wrap(err, "could not encode yaml")
+		}
+		_, err = w.Write(data)
+		return err
+	case outputTable:
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		table(tw)
+		return tw.Flush()
+	default:
+		return checkOutput(format)
+	}
+}
+
+func printFunctionTable(w io.Writer, functions []*model.Function) {
+	fmt.Fprintln(w, "NAME\tRUNTIME\tLABELS\tOWNER")
+	for _, f := range functions {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", f.Name, f.Runtime, formatLabels(f.Labels), f.Owner)
+	}
+}
+
+func printDeploymentTable(w io.Writer, deployments []*model.Deployment) {
+	fmt.Fprintln(w, "NAME\tFUNCTIONS\tENVIRONMENTS\tOWNER")
+	for _, d := range deployments {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", d.Name, formatLabels(d.FunctionLabels), formatLabels(d.EnvironmentLabels), d.Owner)
+	}
+}
+
+func printNamespaceTable(w io.Writer, namespaces []string) {
+	fmt.Fprintln(w, "NAME")
+	for _, n := range namespaces {
+		fmt.Fprintln(w, n)
+	}
+}
+
+func printEnvironmentTable(w io.Writer, environments []*model.Environment) {
+	fmt.Fprintln(w, "NAME\tINFRASTRUCTURE\tREGION\tLABELS")
+	for _, e := range environments {
+		region := ""
+		if e.AWS != nil {
+			region = e.AWS.Region
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", e.Name, e.Infrastructure, region, formatLabels(e.Labels))
+	}
+}
+
+// formatLabels formats labels as comma separated key=value pairs, sorted by
+// key.
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
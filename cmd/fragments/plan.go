@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/fragments/fragments/internal/api"
+	"github.com/fragments/fragments/internal/client"
+	"github.com/fragments/fragments/internal/server"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func newPlanCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "plan [dir]",
+		Short: "Show the changes apply would make",
+	}
+
+	flags := cmd.Flags()
+	ignore := flags.StringSliceP("ignore", "i", []string{"node_modules", "vendor"}, "File/directory patterns to ignore")
+	prune := flags.Bool("prune", false, "Show functions and deployments owned by --owner that apply --prune would delete")
+	owner := flags.String("owner", "", "Owner the models would be applied as")
+
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		if *prune && *owner == "" {
+			return errors.New("owner must be set to prune")
+		}
+		return checkTargets(cmd, args)
+	}
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		models, err := getModels(args, *ignore)
+		checkErr(err)
+
+		excludeSource := functionDirs(models)
+		excludeSource = append(excludeSource, *ignore...)
+
+		c, err := getClient(flags)
+		checkErr(err)
+
+		ctx := contextFromSignal()
+		p, err := plan(ctx, c, models, excludeSource, *owner, *prune)
+		checkErr(err)
+
+		printPlan(os.Stdout, p)
+	}
+
+	return cmd
+}
+
+// plan computes the checksums of all models and asks the server for the
+// changes applying them would make. If prune is set, the plan includes
+// deleting models owned by owner that are not part of the models.
+func plan(ctx context.Context, c *api.Client, models []client.Model, excludeSource []string, owner string, prune bool) (*server.Plan, error) {
+	input := &server.PlanInput{
+		Owner: owner,
+		Prune: prune,
+	}
+	for _, r := range models {
+		meta := r.Meta()
+		file := r.File()
+		if function, ok := r.(client.Function); ok {
+			f, _, err := loadFunction(meta, file, function.Function(), excludeSource, owner)
+			if err != nil {
+				return nil, errors.Wrapf(err, "could not load function: %s", file)
+			}
+			input.Functions = append(input.Functions, f)
+			continue
+		}
+		if deployment, ok := r.(client.Deployment); ok {
+			input.Deployments = append(input.Deployments, deploymentModel(meta, deployment.Deployment(), owner))
+			continue
+		}
+		if environment, ok := r.(client.Environment); ok {
+			e, err := environmentModel(meta, environment.Environment())
+			if err != nil {
+				return nil, errors.Wrapf(err, "could not load environment: %s", file)
+			}
+			input.Environments = append(input.Environments, e)
+			continue
+		}
+		return nil, errors.Errorf("unsupported model %q: %s", r.Type(), file)
+	}
+
+	p, err := c.Plan(ctx, input)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not plan changes")
+	}
+	return p, nil
+}
+
+// planSymbols are printed in front of each change.
+var planSymbols = map[server.Action]string{
+	server.ActionCreate: "+",
+	server.ActionUpdate: "~",
+	server.ActionNone:   " ",
+	server.ActionDelete: "-",
+}
+
+// printPlan prints a plan in a diff like format.
+func printPlan(w io.Writer, p *server.Plan) {
+	for _, c := range p.Changes {
+		line := fmt.Sprintf("%s %s %s", planSymbols[c.Action], c.Type, c.Name)
+		if len(c.Fields) > 0 {
+			line += ": " + strings.Join(c.Fields, ", ")
+		}
+		if c.Upload {
+			line += " (upload source)"
+		}
+		fmt.Fprintln(w, line)
+	}
+	fmt.Fprintf(w, "\nPlan: %d to create, %d to update, %d unchanged, %d to delete\n",
+		p.Count(server.ActionCreate),
+		p.Count(server.ActionUpdate),
+		p.Count(server.ActionNone),
+		p.Count(server.ActionDelete),
+	)
+}
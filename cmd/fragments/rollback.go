@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func newRollbackCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "rollback",
+		Short: "Restore a previous version of a model",
+	}
+
+	cmd.AddCommand(newRollbackFunctionCommand())
+
+	return cmd
+}
+
+func newRollbackFunctionCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "function [name]",
+		Short: "Restore the configuration and source of a previous function version",
+		Long:  "Restore the configuration and source of a previous function version. The source is not uploaded again, the rollback is recorded as a new version.",
+	}
+
+	flags := cmd.Flags()
+	to := flags.Int64("to", 0, "Version to restore, see fragments history function")
+
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("function name must be set")
+		}
+		if *to <= 0 {
+			return errors.New("version to restore must be set with --to")
+		}
+		return nil
+	}
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		c, err := getClient(flags)
+		checkErr(err)
+
+		ctx := contextFromSignal()
+		function, err := c.RollbackFunction(ctx, args[0], *to)
+		checkErr(errors.Wrap(err, "could not roll back function"))
+
+		fmt.Printf("Function %s rolled back to version %d as version %d\n", function.Name, *to, function.Version)
+	}
+
+	return cmd
+}
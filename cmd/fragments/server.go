@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/fragments/fragments/internal/api"
+	"github.com/fragments/fragments/internal/auth"
+	"github.com/fragments/fragments/internal/backend"
+	"github.com/fragments/fragments/internal/filestore"
+	"github.com/fragments/fragments/internal/reconciler"
+	"github.com/fragments/fragments/internal/server"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+func newServerCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "server",
+		Short: "Run the fragments server",
+	}
+
+	flags := cmd.Flags()
+	listen := flags.String("listen", "127.0.0.1:7100", "Address to listen on for API requests")
+	tlsCert := flags.String("tls-cert", "", "TLS certificate file to serve the API with")
+	tlsKey := flags.String("tls-key", "", "TLS key file to serve the API with")
+	flags.String("state", "", "Backend to store state in, etcd://host:port[,host:port] or file:///path/to/state.db. The etcd endpoints are used if not set")
+	flags.StringSliceP("etcd", "e", []string{"0.0.0.0:2379"}, "ETCD endpoints to connect to for storing state")
+	flags.String("vault", "http://0.0.0.0:8200", "Vault address for storing secrets")
+	flags.String("vault.mount", "secret", "Path the Vault KV secret engine is mounted at")
+	flags.Int("vault.kv-version", 0, "Version of the Vault KV secret engine, detected from the mount if 0")
+	flags.String("vault.token-file", "", "File to read the Vault token from. VAULT_TOKEN is used if no auth method is set")
+	flags.String("vault.approle-role-id", "", "Role ID to log in to Vault with AppRole")
+	flags.String("vault.approle-secret-id-file", "", "File to read the secret ID to log in to Vault with AppRole from")
+	flags.String("vault.approle-mount", "approle", "Path the Vault AppRole auth method is mounted at")
+	flags.String("secrets", "", "Backend to store secrets in, a Vault address or file:///path/to/secrets.db to encrypt secrets in a local file. The vault address is used if not set")
+	flags.String("secrets-key-file", "", "File with the keys to encrypt a secrets file with, one id:base64key per line. The first key encrypts, previous keys are kept to decrypt. Read from FRAGMENTS_SECRETS_KEY if not set")
+	flags.String("s3.upload-bucket", "", "S3 bucket to upload source to. The local filestore is used if not set")
+	flags.String("s3.source-bucket", "", "S3 bucket to persist source in")
+	flags.Duration("s3.upload-expiry", 15*time.Minute, "Expiry of S3 upload urls")
+	flags.String("s3.region", "", "AWS region of the S3 buckets")
+	flags.String("local.listen", "127.0.0.1:0", "Address the local filestore listens on for uploads to signed upload urls")
+	flags.String("local.upload-url", "", "URL clients upload source to the local filestore at. The local.listen address is used if not set")
+	reconcileInterval := flags.Duration("reconcile-interval", 1*time.Minute, "Interval to deploy functions to environments at, 0 disables deploying")
+	uploadTTL := flags.Duration("upload-ttl", server.DefaultUploadTTL, "Time source uploads must be confirmed in, 0 disables expiry")
+	lockTimeout := flags.Duration("lock-timeout", server.DefaultLockTimeout, "Time to wait for models that are being modified by someone else")
+	flags.String("auth.tokens-file", "", "File with static tokens to authenticate API requests with, a list of token, subject and groups")
+	flags.String("auth.jwks-file", "", "File with the JSON web key set to verify OIDC/JWT tokens with")
+	flags.String("auth.jwt-issuer", "", "Issuer JWT tokens must be issued by")
+	flags.String("auth.jwt-audience", "", "Audience JWT tokens must be issued for")
+	flags.String("auth.jwt-groups-claim", "groups", "JWT claim to read the groups of the subject from")
+	flags.String("auth.policy-file", "", "File with the roles and role bindings to authorize API requests with. Required if authentication is enabled")
+	auditLog := flags.String("audit-log", "", "File to append audit events to as JSON lines, in addition to the state store")
+	uploadGCInterval := flags.Duration("upload-gc-interval", 10*time.Minute, "Interval to delete expired source uploads at, 0 disables deleting")
+	sourceGCInterval := flags.Duration("source-gc-interval", 0, "Interval to delete unreferenced source at, 0 disables deleting")
+	sourceGCGracePeriod := flags.Duration("source-gc-grace-period", server.DefaultSourceGracePeriod, "Age unreferenced source must have reached to be deleted")
+
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		if (*tlsCert == "") != (*tlsKey == "") {
+			return errors.New("both tls-cert and tls-key must be set")
+		}
+		uploadExpiry, err := flags.GetDuration("s3.upload-expiry")
+		if err != nil {
+			return err
+		}
+		if *uploadTTL > 0 && *uploadTTL < uploadExpiry {
+			return errors.New("upload-ttl must not be shorter than s3.upload-expiry")
+		}
+		authEnabled := flags.Changed("auth.tokens-file") || flags.Changed("auth.jwks-file")
+		if authEnabled != flags.Changed("auth.policy-file") {
+			return errors.New("auth.policy-file must be set if and only if auth.tokens-file or auth.jwks-file is set")
+		}
+		uploadBucket, err := flags.GetString("s3.upload-bucket")
+		if err != nil {
+			return err
+		}
+		if uploadBucket == "" && !flags.Changed("local.upload-url") && !isLoopback(*listen) {
+			return errors.New("local.upload-url must be set to use the local filestore if the API does not listen on a loopback address")
+		}
+		if *sourceGCGracePeriod < time.Second {
+			return errors.New("source-gc-grace-period must be at least one second")
+		}
+		return nil
+	}
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		sourceStore, err := getSourceTarget(flags)
+		checkErr(errors.Wrap(err, "could not set up filestore"))
+
+		state, err := getState(flags)
+		checkErr(errors.Wrap(err, "could not set up state backend"))
+
+		secrets, err := getSecrets(flags)
+		checkErr(errors.Wrap(err, "could not set up secret backend"))
+
+		s := server.New(state, secrets, sourceStore)
+		s.UploadTTL = *uploadTTL
+		s.LockTimeout = *lockTimeout
+		if *auditLog != "" {
+			f, err := os.OpenFile(*auditLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+			checkErr(errors.Wrap(err, "could not open audit log"))
+			defer f.Close() // nolint: errcheck
+			s.AuditLog = f
+		}
+
+		handler := api.NewHandler(s)
+		authenticator, err := getAuthenticator(flags)
+		checkErr(errors.Wrap(err, "could not set up authentication"))
+		if authenticator != nil {
+			policy, err := getPolicy(flags)
+			checkErr(errors.Wrap(err, "could not set up authorization"))
+			handler.SetAuthenticator(authenticator)
+			s.Authorizer = policy
+		}
+
+		httpServer := &http.Server{
+			Addr:    *listen,
+			Handler: handler,
+		}
+
+		// Background work is not done on behalf of a user
+		ctx := auth.WithIdentity(contextFromSignal(), auth.SystemIdentity())
+
+		n, err := s.ReencryptSecrets(ctx)
+		checkErr(err)
+		if n > 0 {
+			log.Printf("Re-encrypted %d secrets with the current key", n)
+		}
+
+		if vault, ok := secrets.(*backend.Vault); ok {
+			go vault.RunTokenRenewer(ctx)
+		}
+
+		if *uploadGCInterval > 0 && *uploadTTL > 0 {
+			go s.RunUploadCollector(ctx, *uploadGCInterval)
+		}
+
+		if *sourceGCInterval > 0 {
+			if _, ok := sourceStore.(filestore.SourceLister); ok {
+				go s.RunSourceCollector(ctx, *sourceGCInterval, *sourceGCGracePeriod)
+			} else {
+				log.Println("Source store can not be listed, unreferenced source will not be deleted")
+			}
+		}
+
+		if *reconcileInterval > 0 {
+			if sourceReader, ok := sourceStore.(filestore.SourceReader); ok {
+				r := reconciler.New(s, sourceReader)
+				go r.Run(ctx, *reconcileInterval)
+			} else {
+				log.Println("Source store can not be read from, functions will not be deployed")
+			}
+		}
+
+		go func() {
+			<-ctx.Done()
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := httpServer.Shutdown(shutdownCtx); err != nil {
+				log.Println(errors.Wrap(err, "failed to gracefully shut down server"))
+			}
+		}()
+
+		log.Printf("Listening on %s", *listen)
+		if *tlsCert != "" {
+			err = httpServer.ListenAndServeTLS(*tlsCert, *tlsKey)
+		} else {
+			err = httpServer.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
+			checkErr(err)
+		}
+
+		err = state.Close()
+		checkErr(err)
+		if closer, ok := secrets.(io.Closer); ok {
+			checkErr(closer.Close())
+		}
+	}
+
+	return cmd
+}
+
+// getAuthenticator returns the authenticator to authenticate API requests
+// with. Static tokens are tried before JWT tokens. Returns nil if
+// authentication is not configured.
+func getAuthenticator(flags *pflag.FlagSet) (auth.Authenticator, error) {
+	var chain auth.Chain
+	tokensFile, err := flags.GetString("auth.tokens-file")
+	if err != nil {
+		return nil, err
+	}
+	if tokensFile != "" {
+		tokens, err := auth.LoadStaticTokens(tokensFile)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, tokens)
+	}
+	jwksFile, err := flags.GetString("auth.jwks-file")
+	if err != nil {
+		return nil, err
+	}
+	if jwksFile != "" {
+		var conf auth.JWTConfig
+		if conf.Issuer, err = flags.GetString("auth.jwt-issuer"); err != nil {
+			return nil, err
+		}
+		if conf.Audience, err = flags.GetString("auth.jwt-audience"); err != nil {
+			return nil, err
+		}
+		if conf.GroupsClaim, err = flags.GetString("auth.jwt-groups-claim"); err != nil {
+			return nil, err
+		}
+		jwt, err := auth.LoadJWT(jwksFile, conf)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, jwt)
+	}
+	if len(chain) == 0 {
+		return nil, nil
+	}
+	return chain, nil
+}
+
+// getPolicy returns the policy to authorize API requests with.
+func getPolicy(flags *pflag.FlagSet) (*auth.Policy, error) {
+	path, err := flags.GetString("auth.policy-file")
+	if err != nil {
+		return nil, err
+	}
+	return auth.LoadPolicy(path)
+}
+
+// getSourceTarget returns the filestore source is uploaded to. S3 is used if
+// an upload bucket is configured, otherwise source is stored locally.
+func getSourceTarget(flags *pflag.FlagSet) (filestore.SourceTarget, error) {
+	uploadBucket, err := flags.GetString("s3.upload-bucket")
+	if err != nil {
+		return nil, err
+	}
+	if uploadBucket == "" {
+		listen, err := flags.GetString("local.listen")
+		if err != nil {
+			return nil, err
+		}
+		uploadURL, err := flags.GetString("local.upload-url")
+		if err != nil {
+			return nil, err
+		}
+		return getFilestore(listen, uploadURL)
+	}
+	sourceBucket, err := flags.GetString("s3.source-bucket")
+	if err != nil {
+		return nil, err
+	}
+	expiry, err := flags.GetDuration("s3.upload-expiry")
+	if err != nil {
+		return nil, err
+	}
+	region, err := flags.GetString("s3.region")
+	if err != nil {
+		return nil, err
+	}
+	conf := aws.NewConfig()
+	if region != "" {
+		conf = conf.WithRegion(region)
+	}
+	return filestore.NewS3(conf, uploadBucket, expiry, sourceBucket)
+}
+
+// isLoopback returns true if an address only listens on a loopback
+// interface.
+func isLoopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
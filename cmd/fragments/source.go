@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func newSourceCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "source",
+		Short: "Manage function source",
+	}
+
+	cmd.AddCommand(newSourceDownloadCommand())
+
+	return cmd
+}
+
+func newSourceDownloadCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "download [function]",
+		Short: "Download the source archive of a function",
+		Long:  "Download the source archive of the current version of a function, the source that is deployed. The archive is written to <function>.tar.gz unless a file is set, - writes it to stdout.",
+	}
+
+	flags := cmd.Flags()
+	file := flags.StringP("file", "f", "", "File to write the archive to, - for stdout")
+
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("function name must be set")
+		}
+		return nil
+	}
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		filename := *file
+		if filename == "" {
+			filename = fmt.Sprintf("%s.tar.gz", name)
+		}
+
+		c, err := getClient(flags)
+		checkErr(err)
+
+		ctx := contextFromSignal()
+		source, checksum, err := c.DownloadFunctionSource(ctx, name)
+		checkErr(errors.Wrap(err, "could not download source"))
+		defer source.Close() // nolint: errcheck
+
+		if filename == "-" {
+			_, err = io.Copy(os.Stdout, source)
+			checkErr(errors.Wrap(err, "could not download source"))
+			return
+		}
+
+		n, err := writeFileAtomic(filename, source)
+		checkErr(errors.Wrap(err, "could not download source"))
+		fmt.Fprintf(os.Stderr, "Downloaded source %s of function %s to %s, %d bytes\n", checksum, name, filename, n)
+	}
+
+	return cmd
+}
+
+// writeFileAtomic writes r to a temporary file next to filename and renames it
+// to filename once r has been read completely, an interrupted download does
+// not leave a truncated file. Returns the number of bytes written.
+func writeFileAtomic(filename string, r io.Reader) (int64, error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename))
+	if err != nil {
+		return 0, errors.Wrap(err, "could not create file")
+	}
+	// Temporary files are only readable by the owner
+	err = tmp.Chmod(0644)
+	var n int64
+	if err == nil {
+		n, err = io.Copy(tmp, r)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filename)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return 0, err
+	}
+	return n, nil
+}
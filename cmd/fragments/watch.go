@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/fragments/fragments/internal/server"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func newWatchCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "watch",
+		Short: "Stream changes to functions, deployments and environments",
+	}
+
+	flags := cmd.Flags()
+	output := flags.StringP("output", "o", outputTable, "Output format: table or json")
+
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		if *output != outputTable && *output != outputJSON {
+			return errors.Errorf("unsupported output format %q, must be one of: %s, %s", *output, outputTable, outputJSON)
+		}
+		return nil
+	}
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		c, err := getClient(flags)
+		checkErr(err)
+
+		ctx := contextFromSignal()
+		events, err := c.Watch(ctx)
+		checkErr(errors.Wrap(err, "could not watch"))
+
+		if *output == outputTable {
+			fmt.Fprintf(os.Stdout, "%-10s %-8s %-12s %s\n", "REVISION", "CHANGE", "TYPE", "NAME")
+		}
+		for event := range events {
+			checkErr(printWatchEvent(os.Stdout, *output, event))
+		}
+		if ctx.Err() == nil {
+			checkErr(errors.New("connection to the server was lost"))
+		}
+	}
+
+	return cmd
+}
+
+// printWatchEvent writes an event to w. The json format writes every event on
+// a single line.
+func printWatchEvent(w io.Writer, format string, event *server.WatchEvent) error {
+	if format == outputJSON {
+		return json.NewEncoder(w).Encode(event)
+	}
+	_, err := fmt.Fprintf(w, "%-10d %-8s %-12s %s\n", event.Revision, event.Type, event.Model, event.Name)
+	return err
+}
@@ -0,0 +1,253 @@
+// Package api exposes the fragments server over a versioned HTTP/JSON API and
+// provides a client for talking to it.
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/fragments/fragments/internal/model"
+	"github.com/fragments/fragments/internal/server"
+	"github.com/pkg/errors"
+)
+
+// Version is the API version. It prefixes every path served by the handler.
+const Version = "v1"
+
+func functionPath(name string) string {
+	return fmt.Sprintf("/%s/functions/%s", Version, url.PathEscape(name))
+}
+
+func uploadPath(token string) string {
+	return fmt.Sprintf("/%s/uploads/%s", Version, token)
+}
+
+func deploymentPath(name string) string {
+	return fmt.Sprintf("/%s/deployments/%s", Version, url.PathEscape(name))
+}
+
+func environmentsPath() string {
+	return fmt.Sprintf("/%s/environments", Version)
+}
+
+func environmentPath(name string) string {
+	return fmt.Sprintf("/%s/environments/%s", Version, url.PathEscape(name))
+}
+
+// forceParam is the query parameter set to delete models that are still
+// referenced.
+const forceParam = "force"
+
+// describeSegment is the path segment after a model name to describe the
+// model.
+const describeSegment = "describe"
+
+func describeDeploymentPath(name string) string {
+	return deploymentPath(name) + "/" + describeSegment
+}
+
+// historySegment is the path segment after a function name to list its
+// versions.
+const historySegment = "history"
+
+func functionHistoryPath(name string) string {
+	return functionPath(name) + "/" + historySegment
+}
+
+// rollbackSegment is the path segment after a function name to roll it back.
+const rollbackSegment = "rollback"
+
+func functionRollbackPath(name string) string {
+	return functionPath(name) + "/" + rollbackSegment
+}
+
+// sourceSegment is the path segment after a function name to download its
+// source.
+const sourceSegment = "source"
+
+func functionSourcePath(name string) string {
+	return functionPath(name) + "/" + sourceSegment
+}
+
+// checksumHeader is the response header carrying the checksum of downloaded
+// source.
+const checksumHeader = "Fragments-Checksum"
+
+// actorHeader is the request header identifying who performs a request.
+const actorHeader = "Fragments-Actor"
+
+// authorizationHeader carries the bearer token requests are authenticated
+// with.
+const authorizationHeader = "Authorization"
+
+// bearerPrefix prefixes the token in the authorization header.
+const bearerPrefix = "Bearer "
+
+// namespaceHeader is the request header selecting the namespace a request is
+// scoped to. Requests without the header use the default namespace.
+const namespaceHeader = "Fragments-Namespace"
+
+func planPath() string {
+	return fmt.Sprintf("/%s/plan", Version)
+}
+
+func prunePath() string {
+	return fmt.Sprintf("/%s/prune", Version)
+}
+
+func applyLockPath() string {
+	return fmt.Sprintf("/%s/lock", Version)
+}
+
+func watchPath() string {
+	return fmt.Sprintf("/%s/watch", Version)
+}
+
+func namespacesPath() string {
+	return fmt.Sprintf("/%s/namespaces", Version)
+}
+
+func auditPath() string {
+	return fmt.Sprintf("/%s/audit", Version)
+}
+
+func sourceCollectPath() string {
+	return fmt.Sprintf("/%s/source/collect", Version)
+}
+
+// Query parameters filtering audit events.
+const (
+	auditTypeParam  = "type"
+	auditNameParam  = "name"
+	auditActorParam = "actor"
+	auditSinceParam = "since"
+	auditUntilParam = "until"
+)
+
+// encodeAuditFilter returns the query parameters of an audit filter.
+func encodeAuditFilter(f *server.AuditFilter) url.Values {
+	q := url.Values{}
+	if f == nil {
+		return q
+	}
+	set := func(key, value string) {
+		if value != "" {
+			q.Set(key, value)
+		}
+	}
+	set(auditTypeParam, f.ModelType)
+	set(auditNameParam, f.ModelName)
+	set(auditActorParam, f.Actor)
+	if !f.Since.IsZero() {
+		q.Set(auditSinceParam, f.Since.Format(time.RFC3339Nano))
+	}
+	if !f.Until.IsZero() {
+		q.Set(auditUntilParam, f.Until.Format(time.RFC3339Nano))
+	}
+	return q
+}
+
+// decodeAuditFilter returns the audit filter of query parameters.
+func decodeAuditFilter(q url.Values) (*server.AuditFilter, error) {
+	f := &server.AuditFilter{
+		ModelType: q.Get(auditTypeParam),
+		ModelName: q.Get(auditNameParam),
+		Actor:     q.Get(auditActorParam),
+	}
+	var err error
+	if f.Since, err = parseTimeParam(q, auditSinceParam); err != nil {
+		return nil, err
+	}
+	if f.Until, err = parseTimeParam(q, auditUntilParam); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// parseTimeParam parses an RFC 3339 query parameter. Returns the zero time if
+// the parameter is not set.
+func parseTimeParam(q url.Values, param string) (time.Time, error) {
+	v := q.Get(param)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return time.Time{}, errors.Errorf("invalid %s: %s", param, v)
+	}
+	return t, nil
+}
+
+// pathName returns the last segment of a request path after prefix. Returns
+// an empty string if the path contains more segments.
+func pathName(path, prefix string) string {
+	name := strings.TrimPrefix(path, prefix)
+	if strings.Contains(name, "/") {
+		return ""
+	}
+	return name
+}
+
+// splitPath splits an escaped request path after prefix into the unescaped
+// model name and the sub-resource segment following it. The sub-resource is
+// empty if the path names the model itself. Returns false if the name is not
+// set or the path contains more segments.
+func splitPath(path, prefix string) (name, sub string, ok bool) {
+	segments := strings.Split(strings.TrimPrefix(path, prefix), "/")
+	if len(segments) > 2 {
+		return "", "", false
+	}
+	name, err := url.PathUnescape(segments[0])
+	if err != nil || name == "" {
+		return "", "", false
+	}
+	if len(segments) == 2 {
+		if segments[1] == "" {
+			return "", "", false
+		}
+		sub = segments[1]
+	}
+	return name, sub, true
+}
+
+// putFunctionResponse is the response returned from putting a function.
+type putFunctionResponse struct {
+	// Upload is set if the server requests the function source to be
+	// uploaded.
+	Upload *server.UploadRequest `json:"upload,omitempty"`
+}
+
+// confirmUploadRequest is the request to confirm an upload.
+type confirmUploadRequest struct {
+	// Digest is the digest of the uploaded source archive.
+	Digest *model.Digest `json:"digest"`
+}
+
+// rollbackRequest is the request to roll back a function.
+type rollbackRequest struct {
+	// Version is the version to restore.
+	Version int64 `json:"version"`
+}
+
+// lockRequest is the request to take the apply lock.
+type lockRequest struct {
+	// TTL is the time in seconds the lock is held for unless released.
+	TTL int64 `json:"ttl"`
+}
+
+// sourceCollectRequest is the request to collect unreferenced source.
+type sourceCollectRequest struct {
+	// GracePeriod is the age in seconds unreferenced source must have
+	// reached to be deleted. The server default is used if it is 0.
+	GracePeriod int64 `json:"grace_period"`
+	// DryRun reports the source that would be deleted without deleting it.
+	DryRun bool `json:"dry_run"`
+}
+
+// errorResponse is returned by the handler in case a request fails.
+type errorResponse struct {
+	// Error is the error message.
+	Error string `json:"error"`
+}
@@ -0,0 +1,522 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/fragments/fragments/internal/model"
+	"github.com/fragments/fragments/internal/server"
+	"github.com/pkg/errors"
+)
+
+// Client is a client for the fragments server API.
+type Client struct {
+	address    string
+	actor      string
+	namespace  string
+	token      string
+	httpClient *http.Client
+}
+
+// NewClient creates a new client for the server listening on address.
+// Returns an error if the address is not a valid url.
+func NewClient(address string) (*Client, error) {
+	if address == "" {
+		return nil, errors.New("no address supplied")
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse server address")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.Errorf("unsupported scheme %q in server address", u.Scheme)
+	}
+	return &Client{
+		address: strings.TrimSuffix(address, "/"),
+		httpClient: &http.Client{
+			Timeout: 1 * time.Minute,
+		},
+	}, nil
+}
+
+// SetActor sets who performs the requests made by the client. The server
+// records the actor in function versions.
+func (c *Client) SetActor(actor string) {
+	c.actor = actor
+}
+
+// SetToken sets the bearer token the client authenticates with.
+func (c *Client) SetToken(token string) {
+	c.token = token
+}
+
+// SetNamespace sets the namespace the requests made by the client are scoped
+// to. The default namespace is used if it is not set.
+func (c *Client) SetNamespace(namespace string) {
+	c.namespace = namespace
+}
+
+// PutFunction creates or updates a function. Returns an upload request in
+// case the server requests the source to be uploaded.
+func (c *Client) PutFunction(ctx context.Context, input *model.Function) (*server.UploadRequest, error) {
+	if input == nil {
+		return nil, errors.New("no function supplied")
+	}
+	var res putFunctionResponse
+	if err := c.do(ctx, http.MethodPut, functionPath(input.Name), input, &res); err != nil {
+		return nil, err
+	}
+	return res.Upload, nil
+}
+
+// ConfirmUpload confirms that the source for a pending upload has been
+// uploaded. The digest is the digest of the uploaded archive, the upload is
+// rejected if it does not match.
+func (c *Client) ConfirmUpload(ctx context.Context, token string, digest *model.Digest) error {
+	if token == "" {
+		return errors.New("token not set")
+	}
+	if err := digest.Validate(); err != nil {
+		return err
+	}
+	return c.do(ctx, http.MethodPost, uploadPath(token), &confirmUploadRequest{Digest: digest}, nil)
+}
+
+// PutDeployment creates or updates a deployment.
+func (c *Client) PutDeployment(ctx context.Context, input *model.Deployment) error {
+	if input == nil {
+		return errors.New("no deployment supplied")
+	}
+	return c.do(ctx, http.MethodPut, deploymentPath(input.Name), input, nil)
+}
+
+// CreateEnvironment creates a new target deployment environment.
+func (c *Client) CreateEnvironment(ctx context.Context, input *server.EnvironmentInput) error {
+	if input == nil {
+		return errors.New("no environment supplied")
+	}
+	return c.do(ctx, http.MethodPost, environmentsPath(), input, nil)
+}
+
+// UpdateEnvironment updates an existing environment. Credentials are rotated
+// if set.
+func (c *Client) UpdateEnvironment(ctx context.Context, input *server.EnvironmentUpdate) error {
+	if input == nil {
+		return errors.New("no environment supplied")
+	}
+	if input.Name == "" {
+		return errors.New("environment name not set")
+	}
+	return c.do(ctx, http.MethodPut, environmentPath(input.Name), input, nil)
+}
+
+// GetFunction returns a function.
+func (c *Client) GetFunction(ctx context.Context, name string) (*model.Function, error) {
+	if name == "" {
+		return nil, errors.New("function name not set")
+	}
+	var function model.Function
+	if err := c.do(ctx, http.MethodGet, functionPath(name), nil, &function); err != nil {
+		return nil, err
+	}
+	return &function, nil
+}
+
+// ListFunctions returns all functions, sorted by name.
+func (c *Client) ListFunctions(ctx context.Context) ([]*model.Function, error) {
+	var functions []*model.Function
+	if err := c.do(ctx, http.MethodGet, functionPath(""), nil, &functions); err != nil {
+		return nil, err
+	}
+	return functions, nil
+}
+
+// FunctionHistory returns the versions of a function, oldest first.
+func (c *Client) FunctionHistory(ctx context.Context, name string) ([]*model.FunctionVersion, error) {
+	if name == "" {
+		return nil, errors.New("function name not set")
+	}
+	var versions []*model.FunctionVersion
+	if err := c.do(ctx, http.MethodGet, functionHistoryPath(name), nil, &versions); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// RollbackFunction restores the configuration and source of a previous
+// version of a function. Returns the restored function.
+func (c *Client) RollbackFunction(ctx context.Context, name string, version int64) (*model.Function, error) {
+	if name == "" {
+		return nil, errors.New("function name not set")
+	}
+	var function model.Function
+	if err := c.do(ctx, http.MethodPost, functionRollbackPath(name), &rollbackRequest{Version: version}, &function); err != nil {
+		return nil, err
+	}
+	return &function, nil
+}
+
+// DownloadFunctionSource streams the source archive of the current version
+// of a function. Returns the archive and the source checksum, the archive
+// must be closed. The download is not limited by the client timeout, it ends
+// when the context is cancelled.
+func (c *Client) DownloadFunctionSource(ctx context.Context, name string) (io.ReadCloser, string, error) {
+	if name == "" {
+		return nil, "", errors.New("function name not set")
+	}
+	req, err := c.newRequest(ctx, http.MethodGet, functionSourcePath(name), nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	httpClient := &http.Client{Transport: c.httpClient.Transport}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "request failed")
+	}
+	if res.StatusCode >= http.StatusBadRequest {
+		defer res.Body.Close() // nolint: errcheck
+		return nil, "", decodeError(res)
+	}
+	return res.Body, res.Header.Get(checksumHeader), nil
+}
+
+// GetDeployment returns a deployment.
+func (c *Client) GetDeployment(ctx context.Context, name string) (*model.Deployment, error) {
+	if name == "" {
+		return nil, errors.New("deployment name not set")
+	}
+	var deployment model.Deployment
+	if err := c.do(ctx, http.MethodGet, deploymentPath(name), nil, &deployment); err != nil {
+		return nil, err
+	}
+	return &deployment, nil
+}
+
+// ListDeployments returns all deployments, sorted by name.
+func (c *Client) ListDeployments(ctx context.Context) ([]*model.Deployment, error) {
+	var deployments []*model.Deployment
+	if err := c.do(ctx, http.MethodGet, deploymentPath(""), nil, &deployments); err != nil {
+		return nil, err
+	}
+	return deployments, nil
+}
+
+// DescribeDeployment returns a deployment together with the functions and
+// environments it currently selects.
+func (c *Client) DescribeDeployment(ctx context.Context, name string) (*server.DeploymentDescription, error) {
+	if name == "" {
+		return nil, errors.New("deployment name not set")
+	}
+	var description server.DeploymentDescription
+	if err := c.do(ctx, http.MethodGet, describeDeploymentPath(name), nil, &description); err != nil {
+		return nil, err
+	}
+	return &description, nil
+}
+
+// GetEnvironment returns an environment.
+func (c *Client) GetEnvironment(ctx context.Context, name string) (*model.Environment, error) {
+	if name == "" {
+		return nil, errors.New("environment name not set")
+	}
+	var environment model.Environment
+	if err := c.do(ctx, http.MethodGet, environmentPath(name), nil, &environment); err != nil {
+		return nil, err
+	}
+	return &environment, nil
+}
+
+// ListEnvironments returns all environments, sorted by name.
+func (c *Client) ListEnvironments(ctx context.Context) ([]*model.Environment, error) {
+	var environments []*model.Environment
+	if err := c.do(ctx, http.MethodGet, environmentsPath(), nil, &environments); err != nil {
+		return nil, err
+	}
+	return environments, nil
+}
+
+// DeleteFunction deletes a function. Unless force is set, the function is not
+// deleted if it is selected by a deployment.
+func (c *Client) DeleteFunction(ctx context.Context, name string, force bool) error {
+	if name == "" {
+		return errors.New("function name not set")
+	}
+	return c.do(ctx, http.MethodDelete, withForce(functionPath(name), force), nil, nil)
+}
+
+// DeleteDeployment deletes a deployment.
+func (c *Client) DeleteDeployment(ctx context.Context, name string) error {
+	if name == "" {
+		return errors.New("deployment name not set")
+	}
+	return c.do(ctx, http.MethodDelete, deploymentPath(name), nil, nil)
+}
+
+// DeleteEnvironment deletes an environment and its credentials. Unless force
+// is set, the environment is not deleted if it is selected by a deployment.
+func (c *Client) DeleteEnvironment(ctx context.Context, name string, force bool) error {
+	if name == "" {
+		return errors.New("environment name not set")
+	}
+	return c.do(ctx, http.MethodDelete, withForce(environmentPath(name), force), nil, nil)
+}
+
+// withForce adds the force query parameter to a path if force is set.
+func withForce(path string, force bool) string {
+	if !force {
+		return path
+	}
+	return fmt.Sprintf("%s?%s=true", path, forceParam)
+}
+
+// Plan returns the changes applying the input would make.
+func (c *Client) Plan(ctx context.Context, input *server.PlanInput) (*server.Plan, error) {
+	if input == nil {
+		return nil, errors.New("no plan input supplied")
+	}
+	var plan server.Plan
+	if err := c.do(ctx, http.MethodPost, planPath(), input, &plan); err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// Prune deletes functions and deployments owned by the input owner.
+func (c *Client) Prune(ctx context.Context, input *server.PruneInput) error {
+	if input == nil {
+		return errors.New("no prune input supplied")
+	}
+	return c.do(ctx, http.MethodPost, prunePath(), input, nil)
+}
+
+// LockApply reserves all models for the client's actor until UnlockApply is
+// called or the ttl has passed. Locking again extends the lock.
+func (c *Client) LockApply(ctx context.Context, ttl time.Duration) (*model.Lock, error) {
+	if ttl < time.Second {
+		return nil, errors.New("lock ttl must be at least one second")
+	}
+	var lock model.Lock
+	if err := c.do(ctx, http.MethodPost, applyLockPath(), &lockRequest{TTL: int64(ttl / time.Second)}, &lock); err != nil {
+		return nil, err
+	}
+	return &lock, nil
+}
+
+// UnlockApply releases the apply lock held by the client's actor.
+func (c *Client) UnlockApply(ctx context.Context) error {
+	return c.do(ctx, http.MethodDelete, applyLockPath(), nil, nil)
+}
+
+// ListNamespaces returns the names of all namespaces models have been stored
+// in.
+func (c *Client) ListNamespaces(ctx context.Context) ([]string, error) {
+	var namespaces []string
+	if err := c.do(ctx, http.MethodGet, namespacesPath(), nil, &namespaces); err != nil {
+		return nil, err
+	}
+	return namespaces, nil
+}
+
+// ListAuditEvents returns the audit events selected by the filter, oldest
+// first.
+func (c *Client) ListAuditEvents(ctx context.Context, filter *server.AuditFilter) ([]*model.AuditEvent, error) {
+	path := auditPath()
+	if q := encodeAuditFilter(filter); len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+	var events []*model.AuditEvent
+	if err := c.do(ctx, http.MethodGet, path, nil, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// CollectSource deletes source of all namespaces that is not referenced and
+// older than the grace period, the server default is used if it is 0.
+// Nothing is deleted in a dry run.
+func (c *Client) CollectSource(ctx context.Context, gracePeriod time.Duration, dryRun bool) (*server.SourceCollection, error) {
+	if gracePeriod < 0 {
+		return nil, errors.New("grace period must not be negative")
+	}
+	input := &sourceCollectRequest{
+		GracePeriod: int64(gracePeriod / time.Second),
+		DryRun:      dryRun,
+	}
+	var res server.SourceCollection
+	if err := c.do(ctx, http.MethodPost, sourceCollectPath(), input, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Watch streams changes to functions, deployments and environments. The
+// stream is not limited by the client timeout, it ends when the context is
+// cancelled or the connection to the server is lost. The channel is closed
+// when the stream ends.
+func (c *Client) Watch(ctx context.Context) (<-chan *server.WatchEvent, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, watchPath(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient := &http.Client{Transport: c.httpClient.Transport}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "request failed")
+	}
+	if res.StatusCode >= http.StatusBadRequest {
+		defer res.Body.Close() // nolint: errcheck
+		return nil, decodeError(res)
+	}
+
+	out := make(chan *server.WatchEvent)
+	go func() {
+		defer close(out)
+		defer res.Body.Close() // nolint: errcheck
+		dec := json.NewDecoder(res.Body)
+		for {
+			var event server.WatchEvent
+			if err := dec.Decode(&event); err != nil {
+				return
+			}
+			select {
+			case out <- &event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// newRequest creates a request to the server. The input is encoded as json
+// to the request body, if set.
+func (c *Client) newRequest(ctx context.Context, method, path string, input interface{}) (*http.Request, error) {
+	var body io.Reader
+	if input != nil {
+		data, err := json.Marshal(input)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not encode request")
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.address+path, body)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create request")
+	}
+	req = req.WithContext(ctx)
+	if input != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.actor != "" {
+		req.Header.Set(actorHeader, c.actor)
+	}
+	if c.namespace != "" {
+		req.Header.Set(namespaceHeader, c.namespace)
+	}
+	if c.token != "" {
+		req.Header.Set(authorizationHeader, bearerPrefix+c.token)
+	}
+	return req, nil
+}
+
+// do sends a request to the server. The input is encoded as json to the
+// request body, if set. The response is decoded to output, if set.
+func (c *Client) do(ctx context.Context, method, path string, input, output interface{}) error {
+	req, err := c.newRequest(ctx, method, path, input)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "request failed")
+	}
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, res.Body)
+		_ = res.Body.Close()
+	}()
+
+	if res.StatusCode >= http.StatusBadRequest {
+		return decodeError(res)
+	}
+
+	if output == nil {
+		return nil
+	}
+	if err := json.NewDecoder(res.Body).Decode(output); err != nil {
+		return errors.Wrap(err, "could not decode response")
+	}
+	return nil
+}
+
+// Error is an error returned by the server.
+type Error struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+	// Message is the error message returned by the server.
+	Message string
+}
+
+// Error returns the error message.
+func (e *Error) Error() string { return e.Message }
+
+// IsNotFound returns true if the error was returned by the server because a
+// model does not exist.
+func IsNotFound(err error) bool {
+	e, ok := errors.Cause(err).(*Error)
+	return ok && e.StatusCode == http.StatusNotFound
+}
+
+// IsLocked returns true if the error was returned by the server because a
+// model is locked.
+func IsLocked(err error) bool {
+	e, ok := errors.Cause(err).(*Error)
+	return ok && e.StatusCode == http.StatusLocked
+}
+
+// IsUnauthenticated returns true if the error was returned by the server
+// because the client could not be authenticated.
+func IsUnauthenticated(err error) bool {
+	e, ok := errors.Cause(err).(*Error)
+	return ok && e.StatusCode == http.StatusUnauthorized
+}
+
+// IsPermissionDenied returns true if the error was returned by the server
+// because the client is not allowed to perform the request.
+func IsPermissionDenied(err error) bool {
+	e, ok := errors.Cause(err).(*Error)
+	return ok && e.StatusCode == http.StatusForbidden
+}
+
+// IsConflict returns true if the error was returned by the server because a
+// model has been modified after the revision it was applied with.
+func IsConflict(err error) bool {
+	e, ok := errors.Cause(err).(*Error)
+	return ok && e.StatusCode == http.StatusPreconditionFailed
+}
+
+// decodeError returns the error from an error response.
+func decodeError(res *http.Response) error {
+	var e errorResponse
+	if err := json.NewDecoder(res.Body).Decode(&e); err != nil || e.Error == "" {
+		return &Error{
+			StatusCode: res.StatusCode,
+			Message:    fmt.Sprintf("received unexpected status %v", res.StatusCode),
+		}
+	}
+	return &Error{
+		StatusCode: res.StatusCode,
+		Message:    e.Error,
+	}
+}
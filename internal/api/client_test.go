@@ -0,0 +1,738 @@
+package api
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fragments/fragments/internal/auth"
+	"github.com/fragments/fragments/internal/backend"
+	"github.com/fragments/fragments/internal/filestore"
+	fsmocks "github.com/fragments/fragments/internal/filestore/mocks"
+	"github.com/fragments/fragments/internal/model"
+	"github.com/fragments/fragments/internal/server"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestClient starts a test server backed by in memory stores and returns a
+// client for it. The returned function stops the server.
+func newTestClient(t *testing.T, kv, secrets *backend.TestKV, sourceStore *fsmocks.SourceTarget) (*Client, func()) {
+	t.Helper()
+	srv := server.New(kv, secrets, sourceStore)
+	srv.GenerateToken = func() string {
+		return "token"
+	}
+	ts := httptest.NewServer(NewHandler(srv))
+	client, err := NewClient(ts.URL)
+	require.NoError(t, err)
+	return client, ts.Close
+}
+
+func TestNewClient(t *testing.T) {
+	tests := []struct {
+		TestName string
+		Address  string
+		Error    bool
+	}{
+		{
+			TestName: "No address",
+			Address:  "",
+			Error:    true,
+		},
+		{
+			TestName: "No scheme",
+			Address:  "127.0.0.1:7100",
+			Error:    true,
+		},
+		{
+			TestName: "Ok",
+			Address:  "http://127.0.0.1:7100/",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			_, err := NewClient(test.Address)
+			if test.Error {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestClientFunction(t *testing.T) {
+	ctx := context.Background()
+	kv := backend.NewTestKV()
+	sourceStore := &fsmocks.SourceTarget{}
+	sourceStore.On("NewUploadURL", "token").Return("https://token", nil)
+	sourceStore.On("Persist", mock.Anything, "token", mock.Anything, testDigest()).Return(nil)
+
+	client, stop := newTestClient(t, kv, nil, sourceStore)
+	defer stop()
+
+	_, err := client.PutFunction(ctx, nil)
+	require.Error(t, err)
+
+	_, err = client.PutFunction(ctx, &model.Function{})
+	require.Error(t, err)
+
+	function := &model.Function{
+		Name:     "foo",
+		Runtime:  "go",
+		Checksum: "abc",
+	}
+
+	upload, err := client.PutFunction(ctx, function)
+	require.NoError(t, err)
+	assert.Equal(t, &server.UploadRequest{Token: "token", URL: "https://token"}, upload)
+
+	err = client.ConfirmUpload(ctx, "", testDigest())
+	require.Error(t, err)
+
+	err = client.ConfirmUpload(ctx, upload.Token, nil)
+	require.Error(t, err)
+
+	err = client.ConfirmUpload(ctx, "nonexisting", testDigest())
+	require.Error(t, err)
+
+	err = client.ConfirmUpload(ctx, upload.Token, testDigest())
+	require.NoError(t, err)
+	assert.Contains(t, kv.Data, "function/foo")
+	assert.NotContains(t, kv.Data, "pendingupload/token")
+
+	// Putting the function again with the same checksum doesn't request an
+	// upload.
+	upload, err = client.PutFunction(ctx, function)
+	require.NoError(t, err)
+	assert.Nil(t, upload)
+}
+
+func TestClientFunctionHistory(t *testing.T) {
+	ctx := context.Background()
+	kv := backend.NewTestKV()
+	sourceStore := &fsmocks.SourceTarget{}
+	sourceStore.On("NewUploadURL", "token").Return("https://token", nil)
+	sourceStore.On("Persist", mock.Anything, "token", mock.Anything, testDigest()).Return(nil)
+
+	client, stop := newTestClient(t, kv, nil, sourceStore)
+	defer stop()
+	client.SetActor("user@host")
+
+	for _, checksum := range []string{"v1", "v2"} {
+		upload, err := client.PutFunction(ctx, &model.Function{Name: "foo", Checksum: checksum})
+		require.NoError(t, err)
+		require.NoError(t, client.ConfirmUpload(ctx, upload.Token, testDigest()))
+	}
+
+	_, err := client.FunctionHistory(ctx, "")
+	require.Error(t, err)
+
+	_, err = client.FunctionHistory(ctx, "bar")
+	assert.True(t, IsNotFound(err))
+
+	versions, err := client.FunctionHistory(ctx, "foo")
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	assert.Equal(t, "v1", versions[0].Function.Checksum)
+	assert.Equal(t, "user@host", versions[0].AppliedBy)
+
+	_, err = client.RollbackFunction(ctx, "", 1)
+	require.Error(t, err)
+
+	_, err = client.RollbackFunction(ctx, "foo", 5)
+	assert.True(t, IsNotFound(err))
+
+	function, err := client.RollbackFunction(ctx, "foo", 1)
+	require.NoError(t, err)
+	assert.Equal(t, "v1", function.Checksum)
+	assert.EqualValues(t, 3, function.Version)
+}
+
+func TestClientFunctionSubResourceNames(t *testing.T) {
+	ctx := context.Background()
+	kv := backend.NewTestKV()
+	sourceStore := &fsmocks.SourceTarget{}
+	sourceStore.On("NewUploadURL", "token").Return("https://token", nil)
+	sourceStore.On("Persist", mock.Anything, "token", mock.Anything, testDigest()).Return(nil)
+
+	client, stop := newTestClient(t, kv, nil, sourceStore)
+	defer stop()
+
+	// Names of sub-resources are valid function names
+	for _, name := range []string{"history", "rollback", "source"} {
+		upload, err := client.PutFunction(ctx, &model.Function{Name: name, Checksum: "abc"})
+		require.NoError(t, err, name)
+		if upload != nil {
+			require.NoError(t, client.ConfirmUpload(ctx, upload.Token, testDigest()), name)
+		}
+
+		function, err := client.GetFunction(ctx, name)
+		require.NoError(t, err, name)
+		assert.Equal(t, name, function.Name)
+		versions, err := client.FunctionHistory(ctx, name)
+		require.NoError(t, err, name)
+		assert.Len(t, versions, 1, name)
+
+		require.NoError(t, client.DeleteFunction(ctx, name, false), name)
+		_, err = client.GetFunction(ctx, name)
+		assert.True(t, IsNotFound(err), name)
+	}
+}
+
+func TestClientConfirmUploadMismatch(t *testing.T) {
+	ctx := context.Background()
+	kv := backend.NewTestKV()
+	sourceStore := &fsmocks.SourceTarget{}
+	sourceStore.On("NewUploadURL", "token").Return("https://token", nil)
+	sourceStore.On("Persist", mock.Anything, "token", mock.Anything, testDigest()).Return(&filestore.DigestMismatchError{Name: "token"})
+	sourceStore.On("DeleteUpload", mock.Anything, "token").Return(nil)
+
+	client, stop := newTestClient(t, kv, nil, sourceStore)
+	defer stop()
+
+	upload, err := client.PutFunction(ctx, &model.Function{Name: "foo", Checksum: "abc"})
+	require.NoError(t, err)
+	err = client.ConfirmUpload(ctx, upload.Token, testDigest())
+	require.Error(t, err)
+	e, ok := errors.Cause(err).(*Error)
+	require.True(t, ok)
+	assert.Equal(t, http.StatusBadRequest, e.StatusCode)
+	sourceStore.AssertExpectations(t)
+
+	// The upload is rejected and can not be confirmed again
+	assert.NotContains(t, kv.Data, "pendingupload/token")
+	err = client.ConfirmUpload(ctx, upload.Token, testDigest())
+	require.Error(t, err)
+}
+
+// testDigest returns the digest of an uploaded archive.
+func testDigest() *model.Digest {
+	return digestOf("source")
+}
+
+// digestOf returns the digest of an archive with the content.
+func digestOf(content string) *model.Digest {
+	digest, err := model.NewDigest(strings.NewReader(content))
+	if err != nil {
+		panic(err)
+	}
+	return digest
+}
+
+// readingSourceStore is a source store that reads files from memory.
+type readingSourceStore struct {
+	*fsmocks.SourceTarget
+	files map[string]string
+}
+
+func (r *readingSourceStore) OpenFile(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	content, ok := r.files[name]
+	if !ok {
+		return nil, &filestore.NotFoundError{Name: name}
+	}
+	return ioutil.NopCloser(strings.NewReader(content)), nil
+}
+
+func TestClientDownloadFunctionSource(t *testing.T) {
+	ctx := context.Background()
+	sourceStore := &readingSourceStore{
+		SourceTarget: &fsmocks.SourceTarget{},
+		files:        map[string]string{hex.EncodeToString(testDigest().SHA256): "source"},
+	}
+	sourceStore.On("NewUploadURL", "token").Return("https://token", nil)
+	sourceStore.On("Persist", mock.Anything, "token", mock.Anything, mock.Anything).Return(nil)
+
+	srv := server.New(backend.NewTestKV(), nil, sourceStore)
+	srv.GenerateToken = func() string { return "token" }
+	ts := httptest.NewServer(NewHandler(srv))
+	defer ts.Close()
+	client, err := NewClient(ts.URL)
+	require.NoError(t, err)
+
+	_, _, err = client.DownloadFunctionSource(ctx, "")
+	require.Error(t, err)
+	_, _, err = client.DownloadFunctionSource(ctx, "foo")
+	assert.True(t, IsNotFound(err))
+
+	for name, content := range map[string]string{"foo": "source", "bar": "other"} {
+		upload, err := client.PutFunction(ctx, &model.Function{Name: name, Checksum: name})
+		require.NoError(t, err)
+		require.NoError(t, client.ConfirmUpload(ctx, upload.Token, digestOf(content)))
+	}
+
+	source, checksum, err := client.DownloadFunctionSource(ctx, "foo")
+	require.NoError(t, err)
+	assert.Equal(t, "foo", checksum)
+	data, err := ioutil.ReadAll(source)
+	require.NoError(t, err)
+	assert.Equal(t, "source", string(data))
+	require.NoError(t, source.Close())
+
+	// The source of bar is missing from the filestore
+	_, _, err = client.DownloadFunctionSource(ctx, "bar")
+	assert.True(t, IsNotFound(err))
+}
+
+func TestClientLockApply(t *testing.T) {
+	ctx := context.Background()
+	kv := backend.NewTestKV()
+
+	alice, stop := newTestClient(t, kv, nil, nil)
+	defer stop()
+	alice.SetActor("alice")
+	bob, stopBob := newTestClient(t, kv, nil, nil)
+	defer stopBob()
+	bob.SetActor("bob")
+
+	_, err := alice.LockApply(ctx, 0)
+	require.Error(t, err)
+
+	lock, err := alice.LockApply(ctx, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", lock.Holder)
+
+	err = bob.PutDeployment(ctx, &model.Deployment{Name: "foo"})
+	require.Error(t, err)
+	assert.True(t, IsLocked(err))
+	assert.Contains(t, err.Error(), "locked by alice")
+
+	require.NoError(t, alice.PutDeployment(ctx, &model.Deployment{Name: "foo"}))
+	require.NoError(t, alice.UnlockApply(ctx))
+	require.NoError(t, bob.PutDeployment(ctx, &model.Deployment{Name: "foo"}))
+}
+
+func TestClientNamespace(t *testing.T) {
+	ctx := context.Background()
+	kv := backend.NewTestKV()
+
+	teamA, stop := newTestClient(t, kv, nil, nil)
+	defer stop()
+	teamA.SetNamespace("team-a")
+	teamB, stopB := newTestClient(t, kv, nil, nil)
+	defer stopB()
+	teamB.SetNamespace("team-b")
+
+	require.NoError(t, teamA.PutDeployment(ctx, &model.Deployment{Name: "api"}))
+	require.NoError(t, teamB.PutDeployment(ctx, &model.Deployment{Name: "api"}))
+	require.NoError(t, teamA.DeleteDeployment(ctx, "api"))
+
+	_, err := teamA.GetDeployment(ctx, "api")
+	assert.True(t, IsNotFound(err))
+	d, err := teamB.GetDeployment(ctx, "api")
+	require.NoError(t, err)
+	assert.Equal(t, "team-b", d.Namespace)
+
+	namespaces, err := teamA.ListNamespaces(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"default", "team-a", "team-b"}, namespaces)
+
+	teamA.SetNamespace("Team A")
+	_, err = teamA.ListDeployments(ctx)
+	require.Error(t, err)
+}
+
+func TestClientAuth(t *testing.T) {
+	ctx := context.Background()
+	srv := server.New(backend.NewTestKV(), backend.NewTestKV(), nil)
+	srv.Authorizer = &auth.Policy{
+		Bindings: []auth.Binding{
+			{Role: "editor", Groups: []string{"team-a"}, Namespaces: []string{"team-a"}},
+			{Role: "admin", Subjects: []string{"ops"}, Namespaces: []string{"*"}},
+		},
+	}
+	tokens, err := auth.NewStaticTokens([]auth.StaticToken{
+		{Token: "alice-token", Subject: "alice", Groups: []string{"team-a"}},
+		{Token: "ops-token", Subject: "ops"},
+	})
+	require.NoError(t, err)
+	h := NewHandler(srv)
+	h.SetAuthenticator(tokens)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	newClient := func(token string) *Client {
+		c, err := NewClient(ts.URL)
+		require.NoError(t, err)
+		c.SetNamespace("team-a")
+		c.SetToken(token)
+		c.SetActor("spoofed")
+		return c
+	}
+
+	_, err = newClient("").ListDeployments(ctx)
+	assert.True(t, IsUnauthenticated(err))
+	_, err = newClient("invalid").ListDeployments(ctx)
+	assert.True(t, IsUnauthenticated(err))
+
+	alice := newClient("alice-token")
+	require.NoError(t, alice.PutDeployment(ctx, &model.Deployment{Name: "api"}))
+	err = alice.CreateEnvironment(ctx, &server.EnvironmentInput{Name: "prod", Username: "u", Password: "p"})
+	assert.True(t, IsPermissionDenied(err))
+	assert.Contains(t, err.Error(), "alice is not allowed to write environment in namespace team-a")
+
+	alice.SetNamespace("team-b")
+	err = alice.PutDeployment(ctx, &model.Deployment{Name: "api"})
+	assert.True(t, IsPermissionDenied(err))
+
+	ops := newClient("ops-token")
+	require.NoError(t, ops.CreateEnvironment(ctx, &server.EnvironmentInput{Name: "prod", Username: "u", Password: "p"}))
+
+	// The actor is the authenticated subject
+	lock, err := ops.LockApply(ctx, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, "ops", lock.Holder)
+}
+
+func TestClientAudit(t *testing.T) {
+	ctx := context.Background()
+	client, stop := newTestClient(t, backend.NewTestKV(), backend.NewTestKV(), nil)
+	defer stop()
+	client.SetActor("alice")
+
+	since := time.Now().Add(-time.Minute)
+	require.NoError(t, client.PutDeployment(ctx, &model.Deployment{Name: "foo"}))
+	require.NoError(t, client.PutDeployment(ctx, &model.Deployment{Name: "bar"}))
+	require.Error(t, client.DeleteDeployment(ctx, "baz"))
+
+	events, err := client.ListAuditEvents(ctx, nil)
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+	assert.Equal(t, "alice", events[0].Actor)
+	assert.Equal(t, "127.0.0.1", events[0].SourceHost)
+	assert.Equal(t, model.AuditOutcomeFailure, events[2].Outcome)
+
+	events, err = client.ListAuditEvents(ctx, &server.AuditFilter{ModelType: "deployment", ModelName: "foo", Actor: "alice", Since: since})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, model.AuditActionPut, events[0].Action)
+
+	events, err = client.ListAuditEvents(ctx, &server.AuditFilter{Until: since})
+	require.NoError(t, err)
+	assert.Empty(t, events)
+}
+
+// listingSourceStore is a source store that lists a fixed set of files.
+type listingSourceStore struct {
+	*fsmocks.SourceTarget
+	files []*filestore.File
+}
+
+func (l *listingSourceStore) ListFiles(ctx context.Context) ([]*filestore.File, error) {
+	return l.files, nil
+}
+
+func TestClientCollectSource(t *testing.T) {
+	ctx := context.Background()
+	sourceStore := &listingSourceStore{
+		SourceTarget: &fsmocks.SourceTarget{},
+		files: []*filestore.File{
+			{Name: "new", Modified: time.Now()},
+			{Name: "old", Modified: time.Now().Add(-48 * time.Hour)},
+		},
+	}
+	sourceStore.On("Delete", mock.Anything, "old").Return(nil).Once()
+
+	ts := httptest.NewServer(NewHandler(server.New(backend.NewTestKV(), nil, sourceStore)))
+	defer ts.Close()
+	client, err := NewClient(ts.URL)
+	require.NoError(t, err)
+
+	_, err = client.CollectSource(ctx, -time.Second, false)
+	require.Error(t, err)
+
+	res, err := client.CollectSource(ctx, 0, true)
+	require.NoError(t, err)
+	assert.True(t, res.DryRun)
+	require.Len(t, res.Files, 2)
+	assert.Equal(t, server.SourceRecent, res.Files[0].Status)
+	assert.Equal(t, server.SourceDeleted, res.Files[1].Status)
+	sourceStore.AssertNotCalled(t, "Delete", mock.Anything, "old")
+
+	res, err = client.CollectSource(ctx, time.Hour, false)
+	require.NoError(t, err)
+	assert.False(t, res.DryRun)
+	assert.Equal(t, server.SourceDeleted, res.Files[1].Status)
+	sourceStore.AssertExpectations(t)
+}
+
+func TestClientWatch(t *testing.T) {
+	kv := backend.NewTestKV()
+
+	client, stop := newTestClient(t, kv, nil, nil)
+	defer stop()
+
+	// The watch is stopped before the server
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := client.Watch(ctx)
+	require.NoError(t, err)
+
+	require.NoError(t, client.PutDeployment(ctx, &model.Deployment{Name: "foo"}))
+	select {
+	case e := <-events:
+		assert.Equal(t, backend.EventPut, e.Type)
+		assert.Equal(t, "deployment", e.Model)
+		assert.Equal(t, "foo", e.Name)
+		require.NotNil(t, e.Deployment)
+		assert.Equal(t, e.Revision, e.Deployment.Revision)
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+	}
+
+	cancel()
+	select {
+	case _, ok := <-events:
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("watch not closed")
+	}
+}
+
+func TestClientDeployment(t *testing.T) {
+	ctx := context.Background()
+	kv := backend.NewTestKV()
+
+	client, stop := newTestClient(t, kv, nil, nil)
+	defer stop()
+
+	err := client.PutDeployment(ctx, nil)
+	require.Error(t, err)
+
+	err = client.PutDeployment(ctx, &model.Deployment{
+		Name:           "foo",
+		FunctionLabels: map[string]string{"foo": "foo"},
+	})
+	require.NoError(t, err)
+	assert.Contains(t, kv.Data, "deployment/foo")
+
+	d, err := client.GetDeployment(ctx, "foo")
+	require.NoError(t, err)
+	require.NotZero(t, d.Revision)
+
+	err = client.PutDeployment(ctx, &model.Deployment{Name: "foo", Revision: d.Revision})
+	require.NoError(t, err)
+
+	// Modified since d was read
+	err = client.PutDeployment(ctx, &model.Deployment{Name: "foo", Revision: d.Revision})
+	require.Error(t, err)
+	assert.True(t, IsConflict(err))
+
+	// Names of sub-resources are valid deployment names
+	err = client.PutDeployment(ctx, &model.Deployment{Name: "describe"})
+	require.NoError(t, err)
+	d, err = client.GetDeployment(ctx, "describe")
+	require.NoError(t, err)
+	assert.Equal(t, "describe", d.Name)
+	_, err = client.DescribeDeployment(ctx, "describe")
+	require.NoError(t, err)
+	require.NoError(t, client.DeleteDeployment(ctx, "describe"))
+	_, err = client.GetDeployment(ctx, "describe")
+	assert.True(t, IsNotFound(err))
+}
+
+func TestClientEnvironment(t *testing.T) {
+	ctx := context.Background()
+	kv := backend.NewTestKV()
+	secrets := backend.NewTestKV()
+
+	client, stop := newTestClient(t, kv, secrets, nil)
+	defer stop()
+
+	err := client.CreateEnvironment(ctx, nil)
+	require.Error(t, err)
+
+	input := &server.EnvironmentInput{
+		Name:           "foo",
+		Infrastructure: model.InfrastructureTypeAWS,
+		Username:       "user",
+		Password:       "pass",
+	}
+
+	err = client.CreateEnvironment(ctx, input)
+	require.NoError(t, err)
+	assert.Contains(t, kv.Data, "environment/foo")
+	assert.Equal(t, "user", secrets.Data["user/foo/name"])
+	assert.Equal(t, "pass", secrets.Data["user/foo/pass"])
+
+	// Environment already exists
+	err = client.CreateEnvironment(ctx, input)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "already exists")
+
+	err = client.UpdateEnvironment(ctx, nil)
+	require.Error(t, err)
+
+	err = client.UpdateEnvironment(ctx, &server.EnvironmentUpdate{
+		Name:     "foo",
+		Labels:   map[string]string{"stage": "prod"},
+		Username: "newuser",
+		Password: "newpass",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "newuser", secrets.Data["user/foo/1/name"])
+	assert.Equal(t, "newpass", secrets.Data["user/foo/1/pass"])
+	assert.NotContains(t, secrets.Data, "user/foo/name")
+
+	env, err := client.GetEnvironment(ctx, "foo")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"stage": "prod"}, env.Labels)
+	assert.Equal(t, int64(1), env.CredentialsGeneration)
+
+	// Names are escaped in the request path
+	for _, name := range []string{"a/b", "50%", "what?"} {
+		err = client.CreateEnvironment(ctx, &server.EnvironmentInput{
+			Name:           name,
+			Infrastructure: model.InfrastructureTypeAWS,
+		})
+		require.NoError(t, err, name)
+		env, err = client.GetEnvironment(ctx, name)
+		require.NoError(t, err, name)
+		assert.Equal(t, name, env.Name)
+		err = client.UpdateEnvironment(ctx, &server.EnvironmentUpdate{Name: name})
+		require.NoError(t, err, name)
+		require.NoError(t, client.DeleteEnvironment(ctx, name, false), name)
+		_, err = client.GetEnvironment(ctx, name)
+		assert.True(t, IsNotFound(err), name)
+	}
+}
+
+func TestClientPlan(t *testing.T) {
+	ctx := context.Background()
+	kv := backend.NewTestKV()
+
+	client, stop := newTestClient(t, kv, nil, nil)
+	defer stop()
+
+	_, err := client.Plan(ctx, nil)
+	require.Error(t, err)
+
+	plan, err := client.Plan(ctx, &server.PlanInput{
+		Functions: []*model.Function{
+			{Name: "foo", Checksum: "abc"},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, &server.Plan{
+		Changes: []*server.Change{
+			{Type: "function", Name: "foo", Action: server.ActionCreate, Upload: true},
+		},
+	}, plan)
+}
+
+func TestClientPrune(t *testing.T) {
+	ctx := context.Background()
+	kv := backend.NewTestKV()
+	kv.Data["deployment/foo"] = `{"name":"foo","owner":"repo"}`
+	kv.Data["deployment/bar"] = `{"name":"bar","owner":"other"}`
+
+	client, stop := newTestClient(t, kv, nil, nil)
+	defer stop()
+
+	err := client.Prune(ctx, nil)
+	require.Error(t, err)
+
+	err = client.Prune(ctx, &server.PruneInput{Owner: "repo", Deployments: []string{"bar"}})
+	require.Error(t, err)
+
+	err = client.Prune(ctx, &server.PruneInput{Owner: "repo", Deployments: []string{"foo"}})
+	require.NoError(t, err)
+	assert.NotContains(t, kv.Data, "deployment/foo")
+	assert.Contains(t, kv.Data, "deployment/bar")
+}
+
+func TestClientGet(t *testing.T) {
+	ctx := context.Background()
+	kv := backend.NewTestKV()
+	kv.Data["function/foo"] = `{"name":"foo","labels":{"app":"foo"}}`
+	kv.Data["deployment/foo"] = `{"name":"foo","function_labels":{"app":"foo"},"environment_labels":{"stage":"prod"}}`
+	kv.Data["environment/prod"] = `{"name":"prod","labels":{"stage":"prod"}}`
+
+	client, stop := newTestClient(t, kv, nil, nil)
+	defer stop()
+
+	function, err := client.GetFunction(ctx, "foo")
+	require.NoError(t, err)
+	assert.Equal(t, "foo", function.Name)
+	_, err = client.GetFunction(ctx, "bar")
+	assert.True(t, IsNotFound(err))
+
+	functions, err := client.ListFunctions(ctx)
+	require.NoError(t, err)
+	assert.Len(t, functions, 1)
+
+	deployment, err := client.GetDeployment(ctx, "foo")
+	require.NoError(t, err)
+	assert.Equal(t, "foo", deployment.Name)
+
+	deployments, err := client.ListDeployments(ctx)
+	require.NoError(t, err)
+	assert.Len(t, deployments, 1)
+
+	description, err := client.DescribeDeployment(ctx, "foo")
+	require.NoError(t, err)
+	assert.Equal(t, "foo", description.Deployment.Name)
+	require.Len(t, description.Functions, 1)
+	assert.Equal(t, "foo", description.Functions[0].Name)
+	require.Len(t, description.Environments, 1)
+	assert.Equal(t, "prod", description.Environments[0].Name)
+
+	environment, err := client.GetEnvironment(ctx, "prod")
+	require.NoError(t, err)
+	assert.Equal(t, "prod", environment.Name)
+
+	environments, err := client.ListEnvironments(ctx)
+	require.NoError(t, err)
+	assert.Len(t, environments, 1)
+}
+
+func TestClientDelete(t *testing.T) {
+	ctx := context.Background()
+	kv := backend.NewTestKV()
+	secrets := backend.NewTestKV()
+	kv.Data["function/foo"] = `{"name":"foo","labels":{"app":"foo"}}`
+	kv.Data["deployment/foo"] = `{"name":"foo","function_labels":{"app":"foo"},"environment_labels":{"stage":"prod"}}`
+	kv.Data["environment/prod"] = `{"name":"prod","labels":{"stage":"prod"}}`
+	secrets.Data["user/prod/name"] = "user"
+	secrets.Data["user/prod/pass"] = "pass"
+
+	client, stop := newTestClient(t, kv, secrets, nil)
+	defer stop()
+
+	// Selected by deployment
+	err := client.DeleteFunction(ctx, "foo", false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "selected by deployments")
+	err = client.DeleteEnvironment(ctx, "prod", false)
+	require.Error(t, err)
+
+	err = client.DeleteFunction(ctx, "foo", true)
+	require.NoError(t, err)
+	assert.NotContains(t, kv.Data, "function/foo")
+
+	err = client.DeleteDeployment(ctx, "foo")
+	require.NoError(t, err)
+	assert.NotContains(t, kv.Data, "deployment/foo")
+
+	err = client.DeleteEnvironment(ctx, "prod", false)
+	require.NoError(t, err)
+	// Only the audit events of the deletions remain
+	for k := range kv.Data {
+		assert.True(t, strings.HasPrefix(k, "audit/"), k)
+	}
+	assert.Empty(t, secrets.Data)
+
+	err = client.DeleteDeployment(ctx, "foo")
+	require.Error(t, err)
+}
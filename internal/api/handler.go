@@ -0,0 +1,611 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fragments/fragments/internal/auth"
+	"github.com/fragments/fragments/internal/backend"
+	"github.com/fragments/fragments/internal/filestore"
+	"github.com/fragments/fragments/internal/model"
+	"github.com/fragments/fragments/internal/server"
+	"github.com/pkg/errors"
+)
+
+// maxBodySize is the maximum size of a request body accepted by the handler.
+const maxBodySize = 1 << 20
+
+// Handler serves the fragments server over HTTP.
+type Handler struct {
+	server        *server.Server
+	mux           *http.ServeMux
+	authenticator auth.Authenticator
+}
+
+// NewHandler creates a new HTTP handler for the server.
+func NewHandler(srv *server.Server) *Handler {
+	h := &Handler{
+		server: srv,
+		mux:    http.NewServeMux(),
+	}
+
+	h.mux.HandleFunc(functionPath(""), h.handleFunction)
+	h.mux.HandleFunc(uploadPath(""), h.handleUpload)
+	h.mux.HandleFunc(deploymentPath(""), h.handleDeployment)
+	h.mux.HandleFunc(environmentsPath(), h.handleEnvironments)
+	h.mux.HandleFunc(environmentPath(""), h.handleEnvironment)
+	h.mux.HandleFunc(planPath(), h.handlePlan)
+	h.mux.HandleFunc(prunePath(), h.handlePrune)
+	h.mux.HandleFunc(applyLockPath(), h.handleApplyLock)
+	h.mux.HandleFunc(watchPath(), h.handleWatch)
+	h.mux.HandleFunc(namespacesPath(), h.handleNamespaces)
+	h.mux.HandleFunc(auditPath(), h.handleAudit)
+	h.mux.HandleFunc(sourceCollectPath(), h.handleSourceCollect)
+
+	return h
+}
+
+// SetAuthenticator enables authentication. Requests must carry a bearer
+// token the authenticator accepts, the authenticated identity is passed to
+// the server in the request context.
+func (h *Handler) SetAuthenticator(a auth.Authenticator) {
+	h.authenticator = a
+}
+
+// ServeHTTP serves an API request. The actor and namespace set in the request
+// headers and the host the request is sent from are passed to the server in
+// the request context. In case authentication is enabled the actor is the
+// authenticated subject.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		r = r.WithContext(server.WithSourceHost(r.Context(), host))
+	}
+	if h.authenticator != nil {
+		id, err := h.authenticate(r)
+		if err != nil {
+			writeServerError(w, err)
+			return
+		}
+		ctx := auth.WithIdentity(r.Context(), id)
+		r = r.WithContext(server.WithActor(ctx, id.Subject))
+	} else if actor := r.Header.Get(actorHeader); actor != "" {
+		r = r.WithContext(server.WithActor(r.Context(), actor))
+	}
+	if namespace := r.Header.Get(namespaceHeader); namespace != "" {
+		if err := server.ValidateNamespace(namespace); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		r = r.WithContext(server.WithNamespace(r.Context(), namespace))
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+// authenticate returns the identity of the bearer token of a request.
+func (h *Handler) authenticate(r *http.Request) (*auth.Identity, error) {
+	header := r.Header.Get(authorizationHeader)
+	if !strings.HasPrefix(header, bearerPrefix) {
+		return nil, &auth.UnauthenticatedError{Reason: "no bearer token"}
+	}
+	return h.authenticator.Authenticate(r.Context(), strings.TrimPrefix(header, bearerPrefix))
+}
+
+func (h *Handler) handleFunction(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == functionPath("") && r.Method == http.MethodGet {
+		functions, err := h.server.ListFunctions(r.Context())
+		if err != nil {
+			writeServerError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, functions)
+		return
+	}
+
+	name, sub, ok := splitPath(r.URL.EscapedPath(), functionPath(""))
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("function name not set"))
+		return
+	}
+	switch sub {
+	case "":
+	case historySegment:
+		h.handleFunctionHistory(w, r, name)
+		return
+	case rollbackSegment:
+		h.handleFunctionRollback(w, r, name)
+		return
+	case sourceSegment:
+		h.handleFunctionSource(w, r, name)
+		return
+	default:
+		writeError(w, http.StatusNotFound, errors.Errorf("function resource %s not found", sub))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		function, err := h.server.GetFunction(r.Context(), name)
+		if err != nil {
+			writeServerError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, function)
+	case http.MethodPut:
+		var function model.Function
+		if err := readJSON(w, r, &function); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if function.Name != name {
+			writeError(w, http.StatusBadRequest, errors.Errorf("function name %q does not match path", function.Name))
+			return
+		}
+		upload, err := h.server.PutFunction(r.Context(), &function)
+		if err != nil {
+			writeServerError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, &putFunctionResponse{Upload: upload})
+	case http.MethodDelete:
+		if err := h.server.DeleteFunction(r.Context(), name, forced(r)); err != nil {
+			writeServerError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeMethodNotAllowed(w, r)
+	}
+}
+
+func (h *Handler) handleFunctionHistory(w http.ResponseWriter, r *http.Request, name string) {
+	switch r.Method {
+	case http.MethodGet:
+		versions, err := h.server.FunctionHistory(r.Context(), name)
+		if err != nil {
+			writeServerError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, versions)
+	default:
+		writeMethodNotAllowed(w, r)
+	}
+}
+
+func (h *Handler) handleFunctionRollback(w http.ResponseWriter, r *http.Request, name string) {
+	switch r.Method {
+	case http.MethodPost:
+		var input rollbackRequest
+		if err := readJSON(w, r, &input); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		function, err := h.server.RollbackFunction(r.Context(), name, input.Version)
+		if err != nil {
+			writeServerError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, function)
+	default:
+		writeMethodNotAllowed(w, r)
+	}
+}
+
+// handleFunctionSource streams the source archive of a function.
+func (h *Handler) handleFunctionSource(w http.ResponseWriter, r *http.Request, name string) {
+	switch r.Method {
+	case http.MethodGet:
+		source, function, err := h.server.OpenFunctionSource(r.Context(), name)
+		if err != nil {
+			writeServerError(w, err)
+			return
+		}
+		defer source.Close() // nolint: errcheck
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set(checksumHeader, function.Checksum)
+		w.WriteHeader(http.StatusOK)
+		// The status has already been sent, the connection is aborted so
+		// the client does not mistake a truncated archive for a complete one
+		if _, err := io.Copy(w, source); err != nil {
+			log.Println(errors.Wrapf(err, "could not send source of function %s", name))
+			panic(http.ErrAbortHandler)
+		}
+	default:
+		writeMethodNotAllowed(w, r)
+	}
+}
+
+func (h *Handler) handleUpload(w http.ResponseWriter, r *http.Request) {
+	token := pathName(r.URL.Path, uploadPath(""))
+	if token == "" {
+		writeError(w, http.StatusNotFound, errors.New("upload token not set"))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		var input confirmUploadRequest
+		if err := readJSON(w, r, &input); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := input.Digest.Validate(); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := h.server.ConfirmUpload(r.Context(), token, input.Digest); err != nil {
+			writeServerError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeMethodNotAllowed(w, r)
+	}
+}
+
+func (h *Handler) handleDeployment(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == deploymentPath("") && r.Method == http.MethodGet {
+		deployments, err := h.server.ListDeployments(r.Context())
+		if err != nil {
+			writeServerError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, deployments)
+		return
+	}
+
+	name, sub, ok := splitPath(r.URL.EscapedPath(), deploymentPath(""))
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("deployment name not set"))
+		return
+	}
+	switch sub {
+	case "":
+	case describeSegment:
+		h.handleDescribeDeployment(w, r, name)
+		return
+	default:
+		writeError(w, http.StatusNotFound, errors.Errorf("deployment resource %s not found", sub))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		deployment, err := h.server.GetDeployment(r.Context(), name)
+		if err != nil {
+			writeServerError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, deployment)
+	case http.MethodPut:
+		var deployment model.Deployment
+		if err := readJSON(w, r, &deployment); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if deployment.Name != name {
+			writeError(w, http.StatusBadRequest, errors.Errorf("deployment name %q does not match path", deployment.Name))
+			return
+		}
+		if err := h.server.PutDeployment(r.Context(), &deployment); err != nil {
+			writeServerError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		if err := h.server.DeleteDeployment(r.Context(), name); err != nil {
+			writeServerError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeMethodNotAllowed(w, r)
+	}
+}
+
+func (h *Handler) handleDescribeDeployment(w http.ResponseWriter, r *http.Request, name string) {
+	switch r.Method {
+	case http.MethodGet:
+		description, err := h.server.DescribeDeployment(r.Context(), name)
+		if err != nil {
+			writeServerError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, description)
+	default:
+		writeMethodNotAllowed(w, r)
+	}
+}
+
+func (h *Handler) handleEnvironments(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		environments, err := h.server.ListEnvironments(r.Context())
+		if err != nil {
+			writeServerError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, environments)
+	case http.MethodPost:
+		var input server.EnvironmentInput
+		if err := readJSON(w, r, &input); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := h.server.CreateEnvironment(r.Context(), &input); err != nil {
+			writeServerError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	default:
+		writeMethodNotAllowed(w, r)
+	}
+}
+
+func (h *Handler) handleEnvironment(w http.ResponseWriter, r *http.Request) {
+	name, sub, ok := splitPath(r.URL.EscapedPath(), environmentPath(""))
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("environment name not set"))
+		return
+	}
+	if sub != "" {
+		writeError(w, http.StatusNotFound, errors.Errorf("environment resource %s not found", sub))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		environment, err := h.server.GetEnvironment(r.Context(), name)
+		if err != nil {
+			writeServerError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, environment)
+	case http.MethodPut:
+		var input server.EnvironmentUpdate
+		if err := readJSON(w, r, &input); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if input.Name != name {
+			writeError(w, http.StatusBadRequest, errors.Errorf("environment name %q does not match path", input.Name))
+			return
+		}
+		if err := h.server.UpdateEnvironment(r.Context(), &input); err != nil {
+			writeServerError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		if err := h.server.DeleteEnvironment(r.Context(), name, forced(r)); err != nil {
+			writeServerError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeMethodNotAllowed(w, r)
+	}
+}
+
+func (h *Handler) handlePlan(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var input server.PlanInput
+		if err := readJSON(w, r, &input); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		plan, err := h.server.Plan(r.Context(), &input)
+		if err != nil {
+			writeServerError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, plan)
+	default:
+		writeMethodNotAllowed(w, r)
+	}
+}
+
+func (h *Handler) handlePrune(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var input server.PruneInput
+		if err := readJSON(w, r, &input); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := h.server.Prune(r.Context(), &input); err != nil {
+			writeServerError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeMethodNotAllowed(w, r)
+	}
+}
+
+func (h *Handler) handleApplyLock(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var input lockRequest
+		if err := readJSON(w, r, &input); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		lock, err := h.server.LockApply(r.Context(), time.Duration(input.TTL)*time.Second)
+		if err != nil {
+			writeServerError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, lock)
+	case http.MethodDelete:
+		if err := h.server.UnlockApply(r.Context()); err != nil {
+			writeServerError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeMethodNotAllowed(w, r)
+	}
+}
+
+func (h *Handler) handleNamespaces(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		namespaces, err := h.server.ListNamespaces(r.Context())
+		if err != nil {
+			writeServerError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, namespaces)
+	default:
+		writeMethodNotAllowed(w, r)
+	}
+}
+
+// handleWatch streams changes to models as newline delimited json until the
+// client disconnects.
+func (h *Handler) handleWatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming not supported"))
+		return
+	}
+
+	events, err := h.server.Watch(r.Context())
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	enc := json.NewEncoder(w)
+	for event := range events {
+		if err := enc.Encode(event); err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func (h *Handler) handleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+	filter, err := decodeAuditFilter(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	events, err := h.server.ListAuditEvents(r.Context(), filter)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, events)
+}
+
+func (h *Handler) handleSourceCollect(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var input sourceCollectRequest
+		if err := readJSON(w, r, &input); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if input.GracePeriod < 0 {
+			writeError(w, http.StatusBadRequest, errors.New("grace period must not be negative"))
+			return
+		}
+		res, err := h.server.CollectSource(r.Context(), time.Duration(input.GracePeriod)*time.Second, input.DryRun)
+		if err != nil {
+			writeServerError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+	default:
+		writeMethodNotAllowed(w, r)
+	}
+}
+
+// forced returns true if the force query parameter is set.
+func forced(r *http.Request) bool {
+	return r.URL.Query().Get(forceParam) == "true"
+}
+
+// readJSON decodes a json request body to v.
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err := dec.Decode(v); err != nil {
+		return errors.Wrap(err, "could not decode request body")
+	}
+	return nil
+}
+
+// writeJSON writes v as a json response.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error response.
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, &errorResponse{Error: err.Error()})
+}
+
+// writeServerError writes an error returned from the server. Errors for
+// models or files that don't exist are returned as not found, errors for models that
+// are still referenced as conflicts, errors for models that are locked as
+// locked, errors for models modified after the revision of the request as
+// failed preconditions, authentication errors as unauthorized and denied
+// permissions as forbidden.
+func writeServerError(w http.ResponseWriter, err error) {
+	if auth.IsUnauthenticated(err) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	if auth.IsPermissionDenied(err) {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+	if backend.IsNotFound(errors.Cause(err)) || filestore.IsNotFound(err) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if filestore.IsDigestMismatch(err) {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if server.IsReferenced(err) {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	if server.IsLocked(err) {
+		writeError(w, http.StatusLocked, err)
+		return
+	}
+	if backend.IsConflict(errors.Cause(err)) {
+		writeError(w, http.StatusPreconditionFailed, err)
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err)
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+}
@@ -0,0 +1,390 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fragments/fragments/internal/backend"
+	"github.com/fragments/fragments/internal/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		TestName  string
+		Method    string
+		Path      string
+		Body      string
+		Namespace string
+		Status    int
+	}{
+		{
+			TestName: "Unknown path",
+			Method:   http.MethodGet,
+			Path:     "/v1/foo",
+			Status:   http.StatusNotFound,
+		},
+		{
+			TestName: "Function without name",
+			Method:   http.MethodPut,
+			Path:     "/v1/functions/",
+			Status:   http.StatusNotFound,
+		},
+		{
+			TestName: "Function method not allowed",
+			Method:   http.MethodPost,
+			Path:     "/v1/functions/foo",
+			Status:   http.StatusMethodNotAllowed,
+		},
+		{
+			TestName: "Function malformed",
+			Method:   http.MethodPut,
+			Path:     "/v1/functions/foo",
+			Body:     "{",
+			Status:   http.StatusBadRequest,
+		},
+		{
+			TestName: "Function name mismatch",
+			Method:   http.MethodPut,
+			Path:     "/v1/functions/foo",
+			Body:     `{"name":"bar"}`,
+			Status:   http.StatusBadRequest,
+		},
+		{
+			TestName: "Function not found",
+			Method:   http.MethodGet,
+			Path:     "/v1/functions/foo",
+			Status:   http.StatusNotFound,
+		},
+		{
+			TestName: "Functions",
+			Method:   http.MethodGet,
+			Path:     "/v1/functions/",
+			Status:   http.StatusOK,
+		},
+		{
+			TestName: "Delete function not found",
+			Method:   http.MethodDelete,
+			Path:     "/v1/functions/foo?force=true",
+			Status:   http.StatusNotFound,
+		},
+		{
+			TestName: "Upload without token",
+			Method:   http.MethodPost,
+			Path:     "/v1/uploads/",
+			Status:   http.StatusNotFound,
+		},
+		{
+			TestName: "Upload without digest",
+			Method:   http.MethodPost,
+			Path:     "/v1/uploads/foo",
+			Body:     `{}`,
+			Status:   http.StatusBadRequest,
+		},
+		{
+			TestName: "Upload invalid digest",
+			Method:   http.MethodPost,
+			Path:     "/v1/uploads/foo",
+			Body:     `{"digest":{"md5":"bWQ1"}}`,
+			Status:   http.StatusBadRequest,
+		},
+		{
+			TestName: "Upload method not allowed",
+			Method:   http.MethodGet,
+			Path:     "/v1/uploads/foo",
+			Status:   http.StatusMethodNotAllowed,
+		},
+		{
+			TestName: "Deployment name mismatch",
+			Method:   http.MethodPut,
+			Path:     "/v1/deployments/foo",
+			Body:     `{"name":"bar"}`,
+			Status:   http.StatusBadRequest,
+		},
+		{
+			TestName: "Deployment",
+			Method:   http.MethodPut,
+			Path:     "/v1/deployments/foo",
+			Body:     `{"name":"foo"}`,
+			Status:   http.StatusNoContent,
+		},
+		{
+			TestName: "Deployment not found",
+			Method:   http.MethodGet,
+			Path:     "/v1/deployments/foo",
+			Status:   http.StatusNotFound,
+		},
+		{
+			TestName: "Describe deployment not found",
+			Method:   http.MethodGet,
+			Path:     "/v1/deployments/foo/describe",
+			Status:   http.StatusNotFound,
+		},
+		{
+			TestName: "Deployment named describe",
+			Method:   http.MethodPut,
+			Path:     "/v1/deployments/describe",
+			Body:     `{"name":"describe"}`,
+			Status:   http.StatusNoContent,
+		},
+		{
+			TestName: "Deployment unknown resource",
+			Method:   http.MethodGet,
+			Path:     "/v1/deployments/foo/bar",
+			Status:   http.StatusNotFound,
+		},
+		{
+			TestName: "Describe deployment too many segments",
+			Method:   http.MethodGet,
+			Path:     "/v1/deployments/foo/describe/bar",
+			Status:   http.StatusNotFound,
+		},
+		{
+			TestName: "Describe deployment method not allowed",
+			Method:   http.MethodPut,
+			Path:     "/v1/deployments/foo/describe",
+			Status:   http.StatusMethodNotAllowed,
+		},
+		{
+			TestName: "Environments",
+			Method:   http.MethodGet,
+			Path:     "/v1/environments",
+			Status:   http.StatusOK,
+		},
+		{
+			TestName: "Environment not found",
+			Method:   http.MethodGet,
+			Path:     "/v1/environments/foo",
+			Status:   http.StatusNotFound,
+		},
+		{
+			TestName: "Environment unknown resource",
+			Method:   http.MethodGet,
+			Path:     "/v1/environments/foo/bar",
+			Status:   http.StatusNotFound,
+		},
+		{
+			TestName: "Environment escaped name mismatch",
+			Method:   http.MethodPut,
+			Path:     "/v1/environments/foo%2Fbar",
+			Body:     `{"name":"foo"}`,
+			Status:   http.StatusBadRequest,
+		},
+		{
+			TestName: "Delete deployment not found",
+			Method:   http.MethodDelete,
+			Path:     "/v1/deployments/foo",
+			Status:   http.StatusNotFound,
+		},
+		{
+			TestName: "Delete environment not found",
+			Method:   http.MethodDelete,
+			Path:     "/v1/environments/foo",
+			Status:   http.StatusNotFound,
+		},
+		{
+			TestName: "Update environment name mismatch",
+			Method:   http.MethodPut,
+			Path:     "/v1/environments/foo",
+			Body:     `{"name":"bar"}`,
+			Status:   http.StatusBadRequest,
+		},
+		{
+			TestName: "Update environment not found",
+			Method:   http.MethodPut,
+			Path:     "/v1/environments/foo",
+			Body:     `{"name":"foo"}`,
+			Status:   http.StatusNotFound,
+		},
+		{
+			TestName: "Environment malformed",
+			Method:   http.MethodPost,
+			Path:     "/v1/environments",
+			Body:     "[]",
+			Status:   http.StatusBadRequest,
+		},
+		{
+			TestName: "Environment server error",
+			Method:   http.MethodPost,
+			Path:     "/v1/environments",
+			Body:     "{}",
+			Status:   http.StatusInternalServerError,
+		},
+		{
+			TestName: "Function history not found",
+			Method:   http.MethodGet,
+			Path:     "/v1/functions/foo/history",
+			Status:   http.StatusNotFound,
+		},
+		{
+			TestName: "Function unknown resource",
+			Method:   http.MethodGet,
+			Path:     "/v1/functions/foo/bar",
+			Status:   http.StatusNotFound,
+		},
+		{
+			TestName: "Function history too many segments",
+			Method:   http.MethodGet,
+			Path:     "/v1/functions/foo/history/bar",
+			Status:   http.StatusNotFound,
+		},
+		{
+			TestName: "Function history method not allowed",
+			Method:   http.MethodPost,
+			Path:     "/v1/functions/foo/history",
+			Status:   http.StatusMethodNotAllowed,
+		},
+		{
+			TestName: "Function rollback not found",
+			Method:   http.MethodPost,
+			Path:     "/v1/functions/foo/rollback",
+			Body:     `{"version":1}`,
+			Status:   http.StatusNotFound,
+		},
+		{
+			TestName: "Function rollback malformed",
+			Method:   http.MethodPost,
+			Path:     "/v1/functions/foo/rollback",
+			Body:     "[]",
+			Status:   http.StatusBadRequest,
+		},
+		{
+			TestName: "Lock without actor",
+			Method:   http.MethodPost,
+			Path:     "/v1/lock",
+			Body:     `{"ttl":60}`,
+			Status:   http.StatusInternalServerError,
+		},
+		{
+			TestName: "Lock malformed",
+			Method:   http.MethodPost,
+			Path:     "/v1/lock",
+			Body:     "[]",
+			Status:   http.StatusBadRequest,
+		},
+		{
+			TestName: "Unlock",
+			Method:   http.MethodDelete,
+			Path:     "/v1/lock",
+			Status:   http.StatusNoContent,
+		},
+		{
+			TestName: "Plan method not allowed",
+			Method:   http.MethodGet,
+			Path:     "/v1/plan",
+			Status:   http.StatusMethodNotAllowed,
+		},
+		{
+			TestName: "Plan",
+			Method:   http.MethodPost,
+			Path:     "/v1/plan",
+			Body:     `{"functions":[{"name":"foo"}]}`,
+			Status:   http.StatusOK,
+		},
+		{
+			TestName: "Prune without owner",
+			Method:   http.MethodPost,
+			Path:     "/v1/prune",
+			Body:     "{}",
+			Status:   http.StatusInternalServerError,
+		},
+		{
+			TestName: "Prune",
+			Method:   http.MethodPost,
+			Path:     "/v1/prune",
+			Body:     `{"owner":"foo","functions":["foo"]}`,
+			Status:   http.StatusNoContent,
+		},
+		{
+			TestName:  "Invalid namespace",
+			Method:    http.MethodGet,
+			Path:      "/v1/functions/",
+			Namespace: "Team A",
+			Status:    http.StatusBadRequest,
+		},
+		{
+			TestName:  "Namespaced functions",
+			Method:    http.MethodGet,
+			Path:      "/v1/functions/",
+			Namespace: "team-a",
+			Status:    http.StatusOK,
+		},
+		{
+			TestName: "Function source not found",
+			Method:   http.MethodGet,
+			Path:     "/v1/functions/foo/source",
+			Status:   http.StatusNotFound,
+		},
+		{
+			TestName: "Function source method not allowed",
+			Method:   http.MethodPut,
+			Path:     "/v1/functions/foo/source",
+			Status:   http.StatusMethodNotAllowed,
+		},
+		{
+			TestName: "Namespaces",
+			Method:   http.MethodGet,
+			Path:     "/v1/namespaces",
+			Status:   http.StatusOK,
+		},
+		{
+			TestName: "Namespaces method not allowed",
+			Method:   http.MethodPost,
+			Path:     "/v1/namespaces",
+			Status:   http.StatusMethodNotAllowed,
+		},
+		{
+			TestName: "Audit",
+			Method:   http.MethodGet,
+			Path:     "/v1/audit?type=function&since=2017-11-01T12:00:00Z",
+			Status:   http.StatusOK,
+		},
+		{
+			TestName: "Audit invalid time",
+			Method:   http.MethodGet,
+			Path:     "/v1/audit?until=yesterday",
+			Status:   http.StatusBadRequest,
+		},
+		{
+			TestName: "Audit method not allowed",
+			Method:   http.MethodPost,
+			Path:     "/v1/audit",
+			Status:   http.StatusMethodNotAllowed,
+		},
+		{
+			TestName: "Source collect malformed",
+			Method:   http.MethodPost,
+			Path:     "/v1/source/collect",
+			Body:     "{",
+			Status:   http.StatusBadRequest,
+		},
+		{
+			TestName: "Source collect negative grace period",
+			Method:   http.MethodPost,
+			Path:     "/v1/source/collect",
+			Body:     `{"grace_period":-1}`,
+			Status:   http.StatusBadRequest,
+		},
+		{
+			TestName: "Source collect method not allowed",
+			Method:   http.MethodGet,
+			Path:     "/v1/source/collect",
+			Status:   http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			h := NewHandler(server.New(backend.NewTestKV(), backend.NewTestKV(), nil))
+			req := httptest.NewRequest(test.Method, test.Path, strings.NewReader(test.Body))
+			if test.Namespace != "" {
+				req.Header.Set(namespaceHeader, test.Namespace)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			assert.Equal(t, test.Status, rec.Code, rec.Body.String())
+		})
+	}
+}
@@ -0,0 +1,161 @@
+// Package auth authenticates the callers of the fragments server and decides
+// which namespaces and models they are allowed to read and modify.
+package auth
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// Identity is an authenticated caller.
+type Identity struct {
+	// Subject identifies the caller, for example a user name or the name of
+	// a CI job.
+	Subject string `json:"subject"`
+	// Groups are the groups the caller is a member of. Permissions can be
+	// granted to groups instead of single subjects.
+	Groups []string `json:"groups,omitempty"`
+	// System is set for the identity the server uses for its own background
+	// work. System identities are not subject to authorization. It can't be
+	// set by authenticators.
+	System bool `json:"-"`
+}
+
+// SystemIdentity returns the identity the server performs background work,
+// like reconciling deployments, with.
+func SystemIdentity() *Identity {
+	return &Identity{Subject: "system:fragments", System: true}
+}
+
+// Authenticator authenticates a caller by the token it presents.
+type Authenticator interface {
+	// Authenticate returns the identity a token belongs to. Returns an
+	// UnauthenticatedError if the token is not valid.
+	Authenticate(ctx context.Context, token string) (*Identity, error)
+}
+
+// Authorizer decides if an identity is allowed to perform an action.
+type Authorizer interface {
+	// Authorize returns a PermissionDeniedError if the identity is not
+	// allowed to perform verb on resource in a namespace.
+	Authorize(id *Identity, namespace string, verb Verb, resource Resource) error
+}
+
+// Verb is an action performed on a resource.
+type Verb string
+
+const (
+	// VerbRead reads, lists and watches a resource.
+	VerbRead Verb = "read"
+	// VerbWrite creates, updates and deletes a resource.
+	VerbWrite Verb = "write"
+)
+
+// Resource is a type of model access is controlled for.
+type Resource string
+
+const (
+	// ResourceFunction are functions, their versions and source uploads.
+	ResourceFunction Resource = "function"
+	// ResourceDeployment are deployments.
+	ResourceDeployment Resource = "deployment"
+	// ResourceEnvironment are environments.
+	ResourceEnvironment Resource = "environment"
+	// ResourceCredentials are the credentials of environments.
+	ResourceCredentials Resource = "credentials"
+	// ResourceLock is the apply lock of a namespace.
+	ResourceLock Resource = "lock"
+	// ResourceAudit is the audit log of a namespace.
+	ResourceAudit Resource = "audit"
+	// ResourceNamespace are the namespaces. Namespaces are not namespaced,
+	// they are authorized with an empty namespace.
+	ResourceNamespace Resource = "namespace"
+)
+
+type identityKey struct{}
+
+// WithIdentity returns a context that carries the identity of the caller.
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFromContext returns the identity set with WithIdentity. Returns nil
+// if no identity is set.
+func IdentityFromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(identityKey{}).(*Identity)
+	return id
+}
+
+// UnauthenticatedError is returned when a caller could not be authenticated.
+type UnauthenticatedError struct {
+	// Reason describes why the caller could not be authenticated.
+	Reason string
+}
+
+// Error returns the error string for an unauthenticated error.
+func (e *UnauthenticatedError) Error() string {
+	return fmt.Sprintf("unauthenticated: %s", e.Reason)
+}
+
+// IsUnauthenticated returns true if the error is caused by a caller that
+// could not be authenticated.
+func IsUnauthenticated(err error) bool {
+	_, ok := errors.Cause(err).(*UnauthenticatedError)
+	return ok
+}
+
+// PermissionDeniedError is returned when an identity is not allowed to
+// perform an action.
+type PermissionDeniedError struct {
+	// Subject is the subject of the identity. It is empty for anonymous
+	// callers.
+	Subject string
+	// Namespace is the namespace the action was performed in.
+	Namespace string
+	// Verb is the action that was denied.
+	Verb Verb
+	// Resource is the resource the action was performed on.
+	Resource Resource
+}
+
+// Error returns the error string for a permission denied error.
+func (e *PermissionDeniedError) Error() string {
+	subject := e.Subject
+	if subject == "" {
+		subject = "anonymous"
+	}
+	if e.Namespace == "" {
+		return fmt.Sprintf("permission denied: %s is not allowed to %s %s", subject, e.Verb, e.Resource)
+	}
+	return fmt.Sprintf("permission denied: %s is not allowed to %s %s in namespace %s", subject, e.Verb, e.Resource, e.Namespace)
+}
+
+// IsPermissionDenied returns true if the error is caused by an identity that
+// is not allowed to perform an action.
+func IsPermissionDenied(err error) bool {
+	_, ok := errors.Cause(err).(*PermissionDeniedError)
+	return ok
+}
+
+// Chain authenticates tokens with the first authenticator that accepts them.
+type Chain []Authenticator
+
+// Authenticate returns the identity of the first authenticator that accepts
+// the token. Returns an UnauthenticatedError if none accepts it.
+func (c Chain) Authenticate(ctx context.Context, token string) (*Identity, error) {
+	if token == "" {
+		return nil, &UnauthenticatedError{Reason: "no token"}
+	}
+	for _, a := range c {
+		id, err := a.Authenticate(ctx, token)
+		if err == nil {
+			return id, nil
+		}
+		if !IsUnauthenticated(err) {
+			return nil, err
+		}
+	}
+	return nil, &UnauthenticatedError{Reason: "invalid token"}
+}
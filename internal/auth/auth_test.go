@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type failingAuthenticator struct{}
+
+func (failingAuthenticator) Authenticate(ctx context.Context, token string) (*Identity, error) {
+	return nil, errors.New("unavailable")
+}
+
+func TestIdentityFromContext(t *testing.T) {
+	ctx := context.Background()
+	assert.Nil(t, IdentityFromContext(ctx))
+	id := &Identity{Subject: "alice"}
+	assert.Equal(t, id, IdentityFromContext(WithIdentity(ctx, id)))
+	assert.True(t, SystemIdentity().System)
+}
+
+func TestPermissionDeniedError(t *testing.T) {
+	err := errors.Wrap(&PermissionDeniedError{Subject: "alice", Namespace: "team-a", Verb: VerbWrite, Resource: ResourceEnvironment}, "create")
+	assert.True(t, IsPermissionDenied(err))
+	assert.False(t, IsUnauthenticated(err))
+	assert.Equal(t, "create: permission denied: alice is not allowed to write environment in namespace team-a", err.Error())
+
+	err = &PermissionDeniedError{Verb: VerbRead, Resource: ResourceNamespace}
+	assert.Equal(t, "permission denied: anonymous is not allowed to read namespace", err.Error())
+}
+
+func TestChain(t *testing.T) {
+	ctx := context.Background()
+	alice, err := NewStaticTokens([]StaticToken{{Token: "alice-token", Subject: "alice"}})
+	require.NoError(t, err)
+	bob, err := NewStaticTokens([]StaticToken{{Token: "bob-token", Subject: "bob"}})
+	require.NoError(t, err)
+	chain := Chain{alice, bob}
+
+	id, err := chain.Authenticate(ctx, "bob-token")
+	require.NoError(t, err)
+	assert.Equal(t, "bob", id.Subject)
+
+	_, err = chain.Authenticate(ctx, "")
+	assert.True(t, IsUnauthenticated(err))
+	_, err = chain.Authenticate(ctx, "unknown")
+	assert.True(t, IsUnauthenticated(err))
+
+	// Errors other than invalid tokens are returned
+	_, err = Chain{failingAuthenticator{}, bob}.Authenticate(ctx, "bob-token")
+	require.Error(t, err)
+	assert.False(t, IsUnauthenticated(err))
+}
@@ -0,0 +1,294 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // registers SHA-256 for crypto.SHA256
+	_ "crypto/sha512" // registers SHA-384 and SHA-512
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// jwtLeeway is the clock skew allowed when checking the validity period of a
+// token.
+const jwtLeeway = time.Minute
+
+// JWTConfig configures which JSON Web Tokens are accepted.
+type JWTConfig struct {
+	// Issuer is the required iss claim. The issuer is not checked if empty.
+	Issuer string
+	// Audience must be contained in the aud claim. The audience is not
+	// checked if empty.
+	Audience string
+	// GroupsClaim is the claim containing the groups of the subject. It
+	// defaults to groups.
+	GroupsClaim string
+}
+
+// JWT authenticates callers with JSON Web Tokens issued by an OpenID Connect
+// provider. Signatures are verified with the keys of a JSON Web Key Set, keys
+// are not fetched from the provider. RS256, RS384, RS512, ES256, ES384 and
+// ES512 signatures are supported.
+type JWT struct {
+	conf JWTConfig
+	keys []jsonWebKey
+	// Now returns the current time tokens are validated at.
+	Now func() time.Time
+}
+
+// jsonWebKey is a public key of a key set.
+type jsonWebKey struct {
+	ID  string
+	Key crypto.PublicKey
+}
+
+// NewJWT creates an authenticator that accepts tokens signed by one of the
+// keys in the JSON Web Key Set.
+func NewJWT(jwks []byte, conf JWTConfig) (*JWT, error) {
+	keys, err := parseJWKS(jwks)
+	if err != nil {
+		return nil, err
+	}
+	if conf.GroupsClaim == "" {
+		conf.GroupsClaim = "groups"
+	}
+	return &JWT{
+		conf: conf,
+		keys: keys,
+		Now:  time.Now,
+	}, nil
+}
+
+// LoadJWT creates an authenticator with the JSON Web Key Set stored in a
+// file.
+func LoadJWT(path string, conf JWTConfig) (*JWT, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read key set")
+	}
+	return NewJWT(raw, conf)
+}
+
+// parseJWKS parses the RSA and EC keys of a JSON Web Key Set. Other keys are
+// ignored.
+func parseJWKS(raw []byte) ([]jsonWebKey, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(raw, &set); err != nil {
+		return nil, errors.Wrap(err, "could not parse key set")
+	}
+	var keys []jsonWebKey
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, err := decodeBigInt(k.N)
+			if err != nil {
+				return nil, errors.Wrapf(err, "key %d", i)
+			}
+			e, err := decodeBigInt(k.E)
+			if err != nil {
+				return nil, errors.Wrapf(err, "key %d", i)
+			}
+			if !e.IsInt64() || e.Int64() > 1<<31-1 {
+				return nil, errors.Errorf("key %d: invalid exponent", i)
+			}
+			keys = append(keys, jsonWebKey{ID: k.Kid, Key: &rsa.PublicKey{N: n, E: int(e.Int64())}})
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				return nil, errors.Errorf("key %d: unsupported curve %q", i, k.Crv)
+			}
+			x, err := decodeBigInt(k.X)
+			if err != nil {
+				return nil, errors.Wrapf(err, "key %d", i)
+			}
+			y, err := decodeBigInt(k.Y)
+			if err != nil {
+				return nil, errors.Wrapf(err, "key %d", i)
+			}
+			if !curve.IsOnCurve(x, y) {
+				return nil, errors.Errorf("key %d: point is not on curve", i)
+			}
+			keys = append(keys, jsonWebKey{ID: k.Kid, Key: &ecdsa.PublicKey{Curve: curve, X: x, Y: y}})
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("key set contains no signing keys")
+	}
+	return keys, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(raw) == 0 {
+		return nil, errors.New("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(raw), nil
+}
+
+// Authenticate verifies the signature and claims of a token and returns the
+// identity of its subject.
+func (j *JWT) Authenticate(ctx context.Context, token string) (*Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, &UnauthenticatedError{Reason: "malformed token"}
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, &UnauthenticatedError{Reason: "malformed token header"}
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, &UnauthenticatedError{Reason: "malformed token signature"}
+	}
+	if err := j.verify(header.Alg, header.Kid, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, &UnauthenticatedError{Reason: "malformed token claims"}
+	}
+	return j.identity(claims)
+}
+
+func decodeSegment(s string, v interface{}) error {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// verify verifies the signature of the signed part of a token. The key is
+// selected by its id, all keys are tried if the token has no key id.
+func (j *JWT) verify(alg, kid string, signed, signature []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return &UnauthenticatedError{Reason: "unsupported signature algorithm " + alg}
+	}
+	h := hash.New()
+	_, _ = h.Write(signed)
+	digest := h.Sum(nil)
+
+	for _, k := range j.keys {
+		if kid != "" && k.ID != kid {
+			continue
+		}
+		switch key := k.Key.(type) {
+		case *rsa.PublicKey:
+			if alg[0] == 'R' && rsa.VerifyPKCS1v15(key, hash, digest, signature) == nil {
+				return nil
+			}
+		case *ecdsa.PublicKey:
+			size := (key.Curve.Params().BitSize + 7) / 8
+			if alg[0] == 'E' && len(signature) == 2*size {
+				r := new(big.Int).SetBytes(signature[:size])
+				s := new(big.Int).SetBytes(signature[size:])
+				if ecdsa.Verify(key, digest, r, s) {
+					return nil
+				}
+			}
+		}
+	}
+	return &UnauthenticatedError{Reason: "invalid token signature"}
+}
+
+// identity checks the claims of a token and returns the identity of its
+// subject.
+func (j *JWT) identity(claims map[string]interface{}) (*Identity, error) {
+	now := j.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, &UnauthenticatedError{Reason: "token has no expiry"}
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(jwtLeeway)) {
+		return nil, &UnauthenticatedError{Reason: "token expired"}
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, &UnauthenticatedError{Reason: "token not valid yet"}
+	}
+	if j.conf.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != j.conf.Issuer {
+			return nil, &UnauthenticatedError{Reason: "token issued by unknown issuer"}
+		}
+	}
+	if j.conf.Audience != "" && !containsString(stringsClaim(claims["aud"]), j.conf.Audience) {
+		return nil, &UnauthenticatedError{Reason: "token issued for another audience"}
+	}
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return nil, &UnauthenticatedError{Reason: "token has no subject"}
+	}
+	return &Identity{
+		Subject: sub,
+		Groups:  stringsClaim(claims[j.conf.GroupsClaim]),
+	}, nil
+}
+
+// stringsClaim returns the values of a claim that is a string or a list of
+// strings.
+func stringsClaim(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var out []string
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	default:
+		return nil
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testJWTNow is the time test tokens are validated at.
+var testJWTNow = time.Date(2017, 11, 1, 12, 0, 0, 0, time.UTC)
+
+func encodeSegment(t *testing.T, v interface{}) string {
+	raw, err := json.Marshal(v)
+	require.NoError(t, err)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// signToken creates a token signed with RS256 or ES256 depending on the key.
+func signToken(t *testing.T, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	alg := "RS256"
+	if _, ok := key.(*ecdsa.PrivateKey); ok {
+		alg = "ES256"
+	}
+	signed := encodeSegment(t, map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + encodeSegment(t, claims)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		require.NoError(t, err)
+		signature = make([]byte, 64)
+		rb, sb := r.Bytes(), s.Bytes()
+		copy(signature[32-len(rb):32], rb)
+		copy(signature[64-len(sb):], sb)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func encodeBigInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+// newTestJWT returns an authenticator trusting an RSA and an EC key.
+func newTestJWT(t *testing.T) (*JWT, *rsa.PrivateKey, *ecdsa.PrivateKey) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	jwks, err := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa", "use": "sig", "n": encodeBigInt(rsaKey.N), "e": encodeBigInt(big.NewInt(int64(rsaKey.E)))},
+			{"kty": "EC", "kid": "ec", "crv": "P-256", "x": encodeBigInt(ecKey.X), "y": encodeBigInt(ecKey.Y)},
+			{"kty": "oct", "kid": "symmetric", "k": "c2VjcmV0"},
+		},
+	})
+	require.NoError(t, err)
+
+	j, err := NewJWT(jwks, JWTConfig{Issuer: "https://issuer", Audience: "fragments"})
+	require.NoError(t, err)
+	j.Now = func() time.Time { return testJWTNow }
+	return j, rsaKey, ecKey
+}
+
+func TestJWT(t *testing.T) {
+	j, rsaKey, ecKey := newTestJWT(t)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	claims := func(modify func(c map[string]interface{})) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss":    "https://issuer",
+			"aud":    []string{"other", "fragments"},
+			"sub":    "alice",
+			"exp":    testJWTNow.Add(time.Hour).Unix(),
+			"nbf":    testJWTNow.Add(-time.Hour).Unix(),
+			"groups": []string{"team-a"},
+		}
+		if modify != nil {
+			modify(c)
+		}
+		return c
+	}
+
+	tests := []struct {
+		TestName string
+		Token    string
+		Identity *Identity
+	}{
+		{
+			TestName: "RSA",
+			Token:    signToken(t, "rsa", rsaKey, claims(nil)),
+			Identity: &Identity{Subject: "alice", Groups: []string{"team-a"}},
+		},
+		{
+			TestName: "EC",
+			Token:    signToken(t, "ec", ecKey, claims(nil)),
+			Identity: &Identity{Subject: "alice", Groups: []string{"team-a"}},
+		},
+		{
+			TestName: "NoKeyID",
+			Token:    signToken(t, "", ecKey, claims(func(c map[string]interface{}) { c["aud"] = "fragments" })),
+			Identity: &Identity{Subject: "alice", Groups: []string{"team-a"}},
+		},
+		{
+			TestName: "WrongKeyID",
+			Token:    signToken(t, "rsa", ecKey, claims(nil)),
+		},
+		{
+			TestName: "UnknownKey",
+			Token:    signToken(t, "ec", otherKey, claims(nil)),
+		},
+		{
+			TestName: "Expired",
+			Token:    signToken(t, "rsa", rsaKey, claims(func(c map[string]interface{}) { c["exp"] = testJWTNow.Add(-2 * time.Minute).Unix() })),
+		},
+		{
+			TestName: "NoExpiry",
+			Token:    signToken(t, "rsa", rsaKey, claims(func(c map[string]interface{}) { delete(c, "exp") })),
+		},
+		{
+			TestName: "NotValidYet",
+			Token:    signToken(t, "rsa", rsaKey, claims(func(c map[string]interface{}) { c["nbf"] = testJWTNow.Add(time.Hour).Unix() })),
+		},
+		{
+			TestName: "Issuer",
+			Token:    signToken(t, "rsa", rsaKey, claims(func(c map[string]interface{}) { c["iss"] = "https://other" })),
+		},
+		{
+			TestName: "Audience",
+			Token:    signToken(t, "rsa", rsaKey, claims(func(c map[string]interface{}) { c["aud"] = "other" })),
+		},
+		{
+			TestName: "NoSubject",
+			Token:    signToken(t, "rsa", rsaKey, claims(func(c map[string]interface{}) { delete(c, "sub") })),
+		},
+		{
+			TestName: "Malformed",
+			Token:    "a.b",
+		},
+		{
+			TestName: "None",
+			Token:    encodeSegment(t, map[string]string{"alg": "none"}) + "." + encodeSegment(t, claims(nil)) + ".",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			id, err := j.Authenticate(context.Background(), test.Token)
+			if test.Identity == nil {
+				require.Error(t, err)
+				assert.True(t, IsUnauthenticated(err), err.Error())
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.Identity, id)
+		})
+	}
+}
+
+func TestJWTTamperedClaims(t *testing.T) {
+	j, rsaKey, _ := newTestJWT(t)
+	token := signToken(t, "rsa", rsaKey, map[string]interface{}{
+		"iss": "https://issuer",
+		"aud": "fragments",
+		"sub": "alice",
+		"exp": testJWTNow.Add(time.Hour).Unix(),
+	})
+	_, err := j.Authenticate(context.Background(), token)
+	require.NoError(t, err)
+
+	other := signToken(t, "rsa", rsaKey, map[string]interface{}{
+		"iss": "https://issuer",
+		"aud": "fragments",
+		"sub": "admin",
+		"exp": testJWTNow.Add(time.Hour).Unix(),
+	})
+	parts := strings.Split(token, ".")
+	otherParts := strings.Split(other, ".")
+	_, err = j.Authenticate(context.Background(), parts[0]+"."+otherParts[1]+"."+parts[2])
+	assert.True(t, IsUnauthenticated(err))
+}
+
+func TestNewJWT(t *testing.T) {
+	_, err := NewJWT([]byte("{"), JWTConfig{})
+	require.Error(t, err)
+	_, err = NewJWT([]byte(`{"keys":[]}`), JWTConfig{})
+	require.Error(t, err)
+	_, err = NewJWT([]byte(`{"keys":[{"kty":"EC","crv":"P-256","x":"AQ","y":"AQ"}]}`), JWTConfig{})
+	require.Error(t, err)
+}
@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"io/ioutil"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+)
+
+// wildcard matches every verb, resource or namespace.
+const wildcard = "*"
+
+// Rule grants verbs on resources.
+type Rule struct {
+	// Verbs are the verbs granted, * grants all verbs.
+	Verbs []string `json:"verbs"`
+	// Resources are the resources the verbs are granted on, * grants them on
+	// all resources.
+	Resources []string `json:"resources"`
+}
+
+// Binding grants a role to subjects and groups in namespaces.
+type Binding struct {
+	// Role is the name of the role granted.
+	Role string `json:"role"`
+	// Subjects are the subjects the role is granted to.
+	Subjects []string `json:"subjects,omitempty"`
+	// Groups are the groups the role is granted to.
+	Groups []string `json:"groups,omitempty"`
+	// Namespaces are the namespaces the role is granted in, * grants it in
+	// all namespaces. Only bindings in all namespaces grant access to
+	// resources that are not namespaced.
+	Namespaces []string `json:"namespaces"`
+}
+
+// Policy authorizes identities with role bindings. A role is a list of rules,
+// bindings grant roles to subjects and groups in namespaces. Everything that
+// is not granted by a binding is denied.
+//
+// The roles admin, editor and viewer are predefined. Admins can do
+// everything, editors can read everything except credentials and write
+// functions, deployments and the apply lock, viewers can read functions,
+// deployments and environments. Predefined roles can be overridden.
+type Policy struct {
+	// Roles are the roles by name.
+	Roles map[string][]Rule `json:"roles,omitempty"`
+	// Bindings are the role bindings.
+	Bindings []Binding `json:"bindings"`
+}
+
+// predefinedRoles are the roles every policy has.
+var predefinedRoles = map[string][]Rule{
+	"admin": {
+		{Verbs: []string{wildcard}, Resources: []string{wildcard}},
+	},
+	"editor": {
+		{
+			Verbs:     []string{string(VerbRead)},
+			Resources: []string{string(ResourceFunction), string(ResourceDeployment), string(ResourceEnvironment), string(ResourceLock), string(ResourceAudit), string(ResourceNamespace)},
+		},
+		{
+			Verbs:     []string{string(VerbWrite)},
+			Resources: []string{string(ResourceFunction), string(ResourceDeployment), string(ResourceLock)},
+		},
+	},
+	"viewer": {
+		{
+			Verbs:     []string{string(VerbRead)},
+			Resources: []string{string(ResourceFunction), string(ResourceDeployment), string(ResourceEnvironment), string(ResourceNamespace)},
+		},
+	},
+}
+
+// LoadPolicy reads a policy from a yaml or json file:
+//
+//	roles:
+//	  deployer:
+//	  - verbs: [read, write]
+//	    resources: [function, deployment]
+//	bindings:
+//	- role: deployer
+//	  groups: [team-a]
+//	  namespaces: [team-a]
+//	- role: admin
+//	  groups: [ops]
+//	  namespaces: ["*"]
+func LoadPolicy(path string) (*Policy, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read policy file")
+	}
+	var p Policy
+	if err := yaml.Unmarshal(raw, &p); err != nil {
+		return nil, errors.Wrap(err, "could not parse policy file")
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Validate returns an error if a binding references a role that does not
+// exist or grants nothing.
+func (p *Policy) Validate() error {
+	for i, b := range p.Bindings {
+		if _, ok := p.role(b.Role); !ok {
+			return errors.Errorf("binding %d: unknown role %q", i, b.Role)
+		}
+		if len(b.Subjects) == 0 && len(b.Groups) == 0 {
+			return errors.Errorf("binding %d: no subjects or groups", i)
+		}
+		if len(b.Namespaces) == 0 {
+			return errors.Errorf("binding %d: no namespaces", i)
+		}
+	}
+	return nil
+}
+
+// role returns the rules of a role.
+func (p *Policy) role(name string) ([]Rule, bool) {
+	if rules, ok := p.Roles[name]; ok {
+		return rules, true
+	}
+	rules, ok := predefinedRoles[name]
+	return rules, ok
+}
+
+// Authorize returns a PermissionDeniedError unless a binding of the identity
+// grants verb on resource in the namespace.
+func (p *Policy) Authorize(id *Identity, namespace string, verb Verb, resource Resource) error {
+	denied := &PermissionDeniedError{Namespace: namespace, Verb: verb, Resource: resource}
+	if id == nil {
+		return denied
+	}
+	denied.Subject = id.Subject
+	for _, b := range p.Bindings {
+		if !b.matches(id) || !matchNamespace(b.Namespaces, namespace) {
+			continue
+		}
+		rules, _ := p.role(b.Role)
+		for _, r := range rules {
+			if matchAny(r.Verbs, string(verb)) && matchAny(r.Resources, string(resource)) {
+				return nil
+			}
+		}
+	}
+	return denied
+}
+
+// matches returns true if the binding applies to the identity.
+func (b *Binding) matches(id *Identity) bool {
+	if containsString(b.Subjects, id.Subject) {
+		return true
+	}
+	for _, g := range id.Groups {
+		if containsString(b.Groups, g) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchNamespace returns true if the namespaces include namespace. Resources
+// that are not namespaced are only matched by the wildcard.
+func matchNamespace(namespaces []string, namespace string) bool {
+	if containsString(namespaces, wildcard) {
+		return true
+	}
+	return namespace != "" && containsString(namespaces, namespace)
+}
+
+// matchAny returns true if values include v or the wildcard.
+func matchAny(values []string, v string) bool {
+	return containsString(values, wildcard) || containsString(values, v)
+}
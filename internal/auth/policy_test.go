@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPolicy = `
+roles:
+  deployer:
+  - verbs: [read, write]
+    resources: [function, deployment]
+bindings:
+- role: deployer
+  groups: [team-a]
+  namespaces: [team-a]
+- role: admin
+  groups: [ops]
+  namespaces: ["*"]
+- role: viewer
+  subjects: [auditor]
+  namespaces: [team-a, team-b]
+- role: editor
+  subjects: [ci]
+  namespaces: [team-b]
+`
+
+func loadTestPolicy(t *testing.T, policy string) (*Policy, error) {
+	dir, err := ioutil.TempDir("", "policy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	path := filepath.Join(dir, "policy.yml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(policy), 0600))
+	return LoadPolicy(path)
+}
+
+func TestPolicyAuthorize(t *testing.T) {
+	p, err := loadTestPolicy(t, testPolicy)
+	require.NoError(t, err)
+
+	alice := &Identity{Subject: "alice", Groups: []string{"team-a"}}
+	ops := &Identity{Subject: "olivia", Groups: []string{"team-a", "ops"}}
+	auditor := &Identity{Subject: "auditor"}
+	ci := &Identity{Subject: "ci"}
+
+	tests := []struct {
+		TestName  string
+		Identity  *Identity
+		Namespace string
+		Verb      Verb
+		Resource  Resource
+		Allowed   bool
+	}{
+		{"ApplyFunction", alice, "team-a", VerbWrite, ResourceFunction, true},
+		{"ApplyDeployment", alice, "team-a", VerbWrite, ResourceDeployment, true},
+		{"OtherNamespace", alice, "team-b", VerbWrite, ResourceFunction, false},
+		{"CreateEnvironment", alice, "team-a", VerbWrite, ResourceEnvironment, false},
+		{"ReadEnvironment", alice, "team-a", VerbRead, ResourceEnvironment, false},
+		{"OpsCreateEnvironment", ops, "team-a", VerbWrite, ResourceEnvironment, true},
+		{"OpsAnyNamespace", ops, "default", VerbWrite, ResourceEnvironment, true},
+		{"OpsNamespaces", ops, "", VerbRead, ResourceNamespace, true},
+		{"ViewerRead", auditor, "team-b", VerbRead, ResourceEnvironment, true},
+		{"ViewerWrite", auditor, "team-b", VerbWrite, ResourceFunction, false},
+		{"ViewerCredentials", auditor, "team-b", VerbRead, ResourceCredentials, false},
+		{"ViewerNamespaces", auditor, "", VerbRead, ResourceNamespace, false},
+		{"EditorLock", ci, "team-b", VerbWrite, ResourceLock, true},
+		{"EditorEnvironment", ci, "team-b", VerbWrite, ResourceEnvironment, false},
+		{"Unknown", &Identity{Subject: "mallory"}, "team-a", VerbRead, ResourceFunction, false},
+		{"Anonymous", nil, "team-a", VerbRead, ResourceFunction, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			err := p.Authorize(test.Identity, test.Namespace, test.Verb, test.Resource)
+			if test.Allowed {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.True(t, IsPermissionDenied(err))
+		})
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	tests := []struct {
+		TestName string
+		Policy   string
+		Error    bool
+	}{
+		{
+			TestName: "Valid",
+			Policy:   testPolicy,
+		},
+		{
+			TestName: "UnknownRole",
+			Policy:   "bindings:\n- role: other\n  subjects: [a]\n  namespaces: [a]\n",
+			Error:    true,
+		},
+		{
+			TestName: "NoSubjects",
+			Policy:   "bindings:\n- role: admin\n  namespaces: [a]\n",
+			Error:    true,
+		},
+		{
+			TestName: "NoNamespaces",
+			Policy:   "bindings:\n- role: admin\n  subjects: [a]\n",
+			Error:    true,
+		},
+		{
+			TestName: "Malformed",
+			Policy:   "bindings: {",
+			Error:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			_, err := loadTestPolicy(t, test.Policy)
+			if test.Error {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
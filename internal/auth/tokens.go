@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"io/ioutil"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+)
+
+// StaticToken is a token that is configured in advance.
+type StaticToken struct {
+	// Token is the secret the caller presents.
+	Token string `json:"token"`
+	// Subject identifies the caller.
+	Subject string `json:"subject"`
+	// Groups are the groups the caller is a member of.
+	Groups []string `json:"groups,omitempty"`
+}
+
+// StaticTokens authenticates callers with tokens from a list.
+type StaticTokens struct {
+	tokens []StaticToken
+}
+
+// NewStaticTokens creates an authenticator that accepts the tokens. Tokens
+// must be unique and have a subject.
+func NewStaticTokens(tokens []StaticToken) (*StaticTokens, error) {
+	seen := make(map[string]bool, len(tokens))
+	for i, t := range tokens {
+		if t.Token == "" {
+			return nil, errors.Errorf("token %d has no token", i)
+		}
+		if t.Subject == "" {
+			return nil, errors.Errorf("token %d has no subject", i)
+		}
+		if seen[t.Token] {
+			return nil, errors.Errorf("token of %s is not unique", t.Subject)
+		}
+		seen[t.Token] = true
+	}
+	return &StaticTokens{tokens: tokens}, nil
+}
+
+// LoadStaticTokens reads tokens from a yaml or json file containing a list
+// of tokens:
+//
+//   - token: secret
+//     subject: alice
+//     groups: [team-a]
+func LoadStaticTokens(path string) (*StaticTokens, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read tokens file")
+	}
+	var tokens []StaticToken
+	if err := yaml.Unmarshal(raw, &tokens); err != nil {
+		return nil, errors.Wrap(err, "could not parse tokens file")
+	}
+	return NewStaticTokens(tokens)
+}
+
+// Authenticate returns the identity of the token. Tokens are compared in
+// constant time.
+func (s *StaticTokens) Authenticate(ctx context.Context, token string) (*Identity, error) {
+	// Tokens are hashed so the comparison does not leak their length.
+	presented := sha256.Sum256([]byte(token))
+	var match *StaticToken
+	for i := range s.tokens {
+		expected := sha256.Sum256([]byte(s.tokens[i].Token))
+		if subtle.ConstantTimeCompare(presented[:], expected[:]) == 1 {
+			match = &s.tokens[i]
+		}
+	}
+	if match == nil {
+		return nil, &UnauthenticatedError{Reason: "unknown token"}
+	}
+	return &Identity{
+		Subject: match.Subject,
+		Groups:  append([]string(nil), match.Groups...),
+	}, nil
+}
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewStaticTokens(t *testing.T) {
+	tests := []struct {
+		TestName string
+		Tokens   []StaticToken
+		Error    bool
+	}{
+		{
+			TestName: "Valid",
+			Tokens:   []StaticToken{{Token: "a", Subject: "alice"}, {Token: "b", Subject: "bob"}},
+		},
+		{
+			TestName: "NoToken",
+			Tokens:   []StaticToken{{Subject: "alice"}},
+			Error:    true,
+		},
+		{
+			TestName: "NoSubject",
+			Tokens:   []StaticToken{{Token: "a"}},
+			Error:    true,
+		},
+		{
+			TestName: "Duplicate",
+			Tokens:   []StaticToken{{Token: "a", Subject: "alice"}, {Token: "a", Subject: "bob"}},
+			Error:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			_, err := NewStaticTokens(test.Tokens)
+			if test.Error {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestLoadStaticTokens(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "tokens")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	path := filepath.Join(dir, "tokens.yml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`
+- token: alice-secret
+  subject: alice
+  groups: [team-a, ops]
+- token: ci-secret
+  subject: ci
+`), 0600))
+
+	tokens, err := LoadStaticTokens(path)
+	require.NoError(t, err)
+
+	id, err := tokens.Authenticate(ctx, "alice-secret")
+	require.NoError(t, err)
+	assert.Equal(t, &Identity{Subject: "alice", Groups: []string{"team-a", "ops"}}, id)
+
+	id, err = tokens.Authenticate(ctx, "ci-secret")
+	require.NoError(t, err)
+	assert.Equal(t, "ci", id.Subject)
+
+	_, err = tokens.Authenticate(ctx, "alice")
+	assert.True(t, IsUnauthenticated(err))
+
+	_, err = LoadStaticTokens(filepath.Join(dir, "nonexisting.yml"))
+	require.Error(t, err)
+}
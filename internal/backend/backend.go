@@ -15,7 +15,7 @@ type Reader interface {
 // The Writer interface is implemented by a backend that can be written to.
 type Writer interface {
 	// Put inserts a value under a key. In case the value already exists it is
-	// overwritten. The key is case-insensitive.
+	// overwritten. Keys are case-sensitive.
 	Put(ctx context.Context, key, value string) error
 	// Delete deletes a key. In case the key does not exist, returns NotFoundError
 	// if the key was not found.
@@ -25,14 +25,133 @@ type Writer interface {
 // The Lister interface is implemented by backends that can list keys under a
 // key.
 type Lister interface {
-	// List lists keys directly under a root key.
+	// List lists all keys under a root key. The returned keys are relative
+	// to the root, keys that only share a prefix with the root are not
+	// listed.
 	List(ctx context.Context, root string) (map[string]string, error)
 }
 
+// The RevisionReader interface is implemented by backends that keep track of
+// the revision every key was last modified at.
+type RevisionReader interface {
+	// GetRevision gets a value and the revision the key was last modified
+	// at. Returns NotFoundError if the key was not found.
+	GetRevision(ctx context.Context, key string) (string, int64, error)
+	// ListRevisions lists all keys under a root key like List, with the
+	// revision every key was last modified at.
+	ListRevisions(ctx context.Context, root string) (map[string]RevisionValue, error)
+}
+
+// RevisionValue is a value and the revision its key was last modified at.
+type RevisionValue struct {
+	Value    string
+	Revision int64
+}
+
+// The RevisionWriter interface is implemented by backends that can write a
+// value only if the key has not been modified since it was read.
+type RevisionWriter interface {
+	// PutRevision inserts a value under a key if the key was last modified at
+	// revision. Revision 0 requires the key to not exist. Returns the new
+	// revision of the key, or ConflictError if the key has been modified.
+	PutRevision(ctx context.Context, key, value string, revision int64) (int64, error)
+}
+
+// OpType is the type of an operation in a transaction.
+type OpType int
+
+const (
+	// OpPut puts a value under a key.
+	OpPut OpType = iota
+	// OpDelete deletes a key. Deleting a key that does not exist is not an
+	// error.
+	OpDelete
+)
+
+// Op is an operation in a transaction.
+type Op struct {
+	// Type is the type of the operation.
+	Type OpType
+	// Key is the key the operation is applied to.
+	Key string
+	// Value is the value to put.
+	Value string
+	// CheckRevision is set if the transaction must only be committed if the
+	// key was last modified at Revision.
+	CheckRevision bool
+	// Revision is the revision the key must have been last modified at.
+	// Revision 0 requires the key to not exist.
+	Revision int64
+}
+
+// PutOp returns an operation that puts a value under a key.
+func PutOp(key, value string) Op {
+	return Op{Type: OpPut, Key: key, Value: value}
+}
+
+// PutRevisionOp returns an operation that puts a value under a key if the key
+// was last modified at revision.
+func PutRevisionOp(key, value string, revision int64) Op {
+	return Op{Type: OpPut, Key: key, Value: value, CheckRevision: true, Revision: revision}
+}
+
+// DeleteOp returns an operation that deletes a key.
+func DeleteOp(key string) Op {
+	return Op{Type: OpDelete, Key: key}
+}
+
+// DeleteRevisionOp returns an operation that deletes a key if the key was
+// last modified at revision.
+func DeleteRevisionOp(key string, revision int64) Op {
+	return Op{Type: OpDelete, Key: key, CheckRevision: true, Revision: revision}
+}
+
+// The Txn interface is implemented by backends that can apply multiple
+// operations atomically.
+type Txn interface {
+	// Txn applies either all operations or none of them. A key may only be
+	// part of one operation. Returns ConflictError if a key has been modified
+	// after the revision of its operation, otherwise the revision the
+	// transaction was committed at.
+	Txn(ctx context.Context, ops ...Op) (int64, error)
+}
+
+// EventType is the type of a change to a key.
+type EventType string
+
+const (
+	// EventPut is a value being put under a key.
+	EventPut EventType = "put"
+	// EventDelete is a key being deleted.
+	EventDelete EventType = "delete"
+)
+
+// Event is a change to a key.
+type Event struct {
+	// Type is the type of the change.
+	Type EventType
+	// Key is the key that changed.
+	Key string
+	// Value is the new value of the key. It is empty for deletes.
+	Value string
+	// Revision is the revision the change was made at.
+	Revision int64
+}
+
+// The Watcher interface is implemented by backends that can notify about
+// changes to keys.
+type Watcher interface {
+	// Watch sends an event for every change to a key with prefix, in the
+	// order the changes were made. The channel is closed when the context is
+	// cancelled or the watch fails.
+	Watch(ctx context.Context, prefix string) (<-chan Event, error)
+}
+
 // Locker locks resources, preventing multiple clients modifying the same
 // resource in the backend.
 type Locker interface {
-	// Locker creates a distributed lock on a key.
+	// Locker creates a distributed lock on a key. Waiting for the lock stops
+	// with an error when the context is cancelled.
 	Lock(ctx context.Context, key string) (func(), error)
 }
 
@@ -51,3 +170,24 @@ func IsNotFound(err error) bool {
 	_, ok := err.(*NotFoundError)
 	return ok
 }
+
+// ConflictError indicates that a key was modified after the revision a write
+// was based on.
+type ConflictError struct {
+	// Key is the key that was modified.
+	Key string
+	// Revision is the revision the write was based on.
+	Revision int64
+}
+
+// Error returns the error string for a conflict error.
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("key modified after revision %d: %s", e.Revision, e.Key)
+}
+
+// IsConflict returns true if the error returned is for a key that was
+// modified.
+func IsConflict(err error) bool {
+	_, ok := err.(*ConflictError)
+	return ok
+}
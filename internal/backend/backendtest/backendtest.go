@@ -0,0 +1,165 @@
+// Package backendtest verifies that backends behave the way the interfaces in
+// package backend describe. Every backend should run the tests for the
+// interfaces it implements.
+package backendtest
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/fragments/fragments/internal/backend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ReaderWriter is a backend that can be read from and written to.
+type ReaderWriter interface {
+	backend.Reader
+	backend.Writer
+}
+
+// TestReaderWriter tests a Reader and Writer. newBackend is called for every
+// test, the backend is closed after the test if it implements io.Closer. Keys
+// are written under backendtest/.
+func TestReaderWriter(t *testing.T, newBackend func(t *testing.T) ReaderWriter) {
+	tests := []struct {
+		Name string
+		Test func(t *testing.T, kv ReaderWriter)
+	}{
+		{Name: "GetNotFound", Test: testGetNotFound},
+		{Name: "DeleteNotFound", Test: testDeleteNotFound},
+		{Name: "PutGet", Test: testPutGet},
+		{Name: "Delete", Test: testDelete},
+		{Name: "CaseSensitive", Test: testCaseSensitive},
+		{Name: "Cancelled", Test: testReaderWriterCancelled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			kv := newBackend(t)
+			defer closeBackend(t, kv)
+			test.Test(t, kv)
+		})
+	}
+}
+
+func testGetNotFound(t *testing.T, kv ReaderWriter) {
+	ctx := context.Background()
+	key := "backendtest/missing"
+	clean(ctx, kv, key)
+
+	_, err := kv.Get(ctx, key)
+	require.Error(t, err)
+	assert.True(t, backend.IsNotFound(err), "error is not NotFoundError: %v", err)
+	if nf, ok := err.(*backend.NotFoundError); ok {
+		assert.Equal(t, key, nf.Key)
+	}
+}
+
+func testDeleteNotFound(t *testing.T, kv ReaderWriter) {
+	ctx := context.Background()
+	key := "backendtest/missing"
+	clean(ctx, kv, key)
+
+	err := kv.Delete(ctx, key)
+	require.Error(t, err)
+	assert.True(t, backend.IsNotFound(err), "error is not NotFoundError: %v", err)
+}
+
+func testPutGet(t *testing.T, kv ReaderWriter) {
+	ctx := context.Background()
+	key := "backendtest/putget"
+	clean(ctx, kv, key)
+	defer clean(ctx, kv, key)
+
+	require.NoError(t, kv.Put(ctx, key, "foo"))
+	value, err := kv.Get(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, "foo", value)
+
+	// Values are overwritten
+	require.NoError(t, kv.Put(ctx, key, "bar"))
+	value, err = kv.Get(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, "bar", value)
+
+	// Values are stored as is
+	raw := "{\"foo\": \"bär\"}\n\tbaz\n"
+	require.NoError(t, kv.Put(ctx, key, raw))
+	value, err = kv.Get(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, raw, value)
+}
+
+func testDelete(t *testing.T, kv ReaderWriter) {
+	ctx := context.Background()
+	key := "backendtest/delete"
+	clean(ctx, kv, key)
+
+	require.NoError(t, kv.Put(ctx, key, "foo"))
+	require.NoError(t, kv.Delete(ctx, key))
+
+	_, err := kv.Get(ctx, key)
+	assert.True(t, backend.IsNotFound(err), "error is not NotFoundError: %v", err)
+	err = kv.Delete(ctx, key)
+	assert.True(t, backend.IsNotFound(err), "error is not NotFoundError: %v", err)
+}
+
+func testCaseSensitive(t *testing.T, kv ReaderWriter) {
+	ctx := context.Background()
+	upper, lower := "backendtest/Case", "backendtest/case"
+	clean(ctx, kv, upper, lower)
+	defer clean(ctx, kv, upper, lower)
+
+	require.NoError(t, kv.Put(ctx, upper, "upper"))
+	_, err := kv.Get(ctx, lower)
+	assert.True(t, backend.IsNotFound(err), "key differing in case exists: %v", err)
+
+	require.NoError(t, kv.Put(ctx, lower, "lower"))
+	value, err := kv.Get(ctx, upper)
+	require.NoError(t, err)
+	assert.Equal(t, "upper", value)
+	value, err = kv.Get(ctx, lower)
+	require.NoError(t, err)
+	assert.Equal(t, "lower", value)
+
+	require.NoError(t, kv.Delete(ctx, lower))
+	value, err = kv.Get(ctx, upper)
+	require.NoError(t, err)
+	assert.Equal(t, "upper", value)
+}
+
+func testReaderWriterCancelled(t *testing.T, kv ReaderWriter) {
+	key := "backendtest/cancelled"
+	clean(context.Background(), kv, key)
+	defer clean(context.Background(), kv, key)
+	require.NoError(t, kv.Put(context.Background(), key, "foo"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := kv.Get(ctx, key)
+	assert.Error(t, err)
+	assert.Error(t, kv.Put(ctx, key, "bar"))
+	assert.Error(t, kv.Delete(ctx, key))
+
+	value, err := kv.Get(context.Background(), key)
+	require.NoError(t, err)
+	assert.Equal(t, "foo", value)
+}
+
+// clean deletes keys left over from previous tests.
+func clean(ctx context.Context, kv backend.Writer, keys ...string) {
+	for _, key := range keys {
+		_ = kv.Delete(ctx, key)
+	}
+}
+
+// closeBackend closes a backend if it implements io.Closer.
+func closeBackend(t *testing.T, kv interface{}) {
+	t.Helper()
+	if closer, ok := kv.(io.Closer); ok {
+		require.NoError(t, closer.Close())
+	}
+}
@@ -0,0 +1,114 @@
+package backendtest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fragments/fragments/internal/backend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ListerWriter is a backend that can list keys and be written to.
+type ListerWriter interface {
+	backend.Lister
+	backend.Writer
+}
+
+// TestLister tests a Lister. newBackend is called for every test, the backend
+// is closed after the test if it implements io.Closer. Keys are written under
+// backendtest/.
+func TestLister(t *testing.T, newBackend func(t *testing.T) ListerWriter) {
+	tests := []struct {
+		Name string
+		Test func(t *testing.T, kv ListerWriter)
+	}{
+		{Name: "Empty", Test: testListEmpty},
+		{Name: "Prefix", Test: testListPrefix},
+		{Name: "Deleted", Test: testListDeleted},
+		{Name: "Cancelled", Test: testListCancelled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			kv := newBackend(t)
+			defer closeBackend(t, kv)
+			test.Test(t, kv)
+		})
+	}
+}
+
+func testListEmpty(t *testing.T, kv ListerWriter) {
+	values, err := kv.List(context.Background(), "backendtest/empty")
+	require.NoError(t, err)
+	assert.NotNil(t, values)
+	assert.Empty(t, values)
+}
+
+func testListPrefix(t *testing.T, kv ListerWriter) {
+	ctx := context.Background()
+	keys := map[string]string{
+		"backendtest/list":          "root",
+		"backendtest/list/foo":      "foo",
+		"backendtest/list/bar":      "bar",
+		"backendtest/list/baz/qux":  "qux",
+		"backendtest/listing/foo":   "sibling",
+		"backendtest/other/list/ba": "other",
+	}
+	clean(ctx, kv, keysOf(keys)...)
+	defer clean(ctx, kv, keysOf(keys)...)
+	for k, v := range keys {
+		require.NoError(t, kv.Put(ctx, k, v))
+	}
+
+	// Keys are relative to the root, keys that only share a prefix with the
+	// root are not listed.
+	want := map[string]string{
+		"foo":     "foo",
+		"bar":     "bar",
+		"baz/qux": "qux",
+	}
+	values, err := kv.List(ctx, "backendtest/list")
+	require.NoError(t, err)
+	assert.Equal(t, want, values)
+
+	// A trailing slash does not change the result
+	values, err = kv.List(ctx, "backendtest/list/")
+	require.NoError(t, err)
+	assert.Equal(t, want, values)
+
+	values, err = kv.List(ctx, "backendtest/list/baz")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"qux": "qux"}, values)
+}
+
+func testListDeleted(t *testing.T, kv ListerWriter) {
+	ctx := context.Background()
+	keys := []string{"backendtest/deleted/foo", "backendtest/deleted/bar"}
+	clean(ctx, kv, keys...)
+	defer clean(ctx, kv, keys...)
+	for _, k := range keys {
+		require.NoError(t, kv.Put(ctx, k, "value"))
+	}
+
+	require.NoError(t, kv.Delete(ctx, "backendtest/deleted/foo"))
+	values, err := kv.List(ctx, "backendtest/deleted")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"bar": "value"}, values)
+}
+
+func testListCancelled(t *testing.T, kv ListerWriter) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := kv.List(ctx, "backendtest/cancelled")
+	assert.Error(t, err)
+}
+
+// keysOf returns the keys of a map.
+func keysOf(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
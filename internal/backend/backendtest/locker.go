@@ -0,0 +1,147 @@
+package backendtest
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fragments/fragments/internal/backend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// LockerReaderWriter is a backend that can lock keys, be read from and be
+// written to.
+type LockerReaderWriter interface {
+	backend.Locker
+	backend.Reader
+	backend.Writer
+}
+
+// TestLocker tests a Locker. newBackend is called for every test, the backend
+// is closed after the test if it implements io.Closer. Keys are written and
+// locked under backendtest/.
+func TestLocker(t *testing.T, newBackend func(t *testing.T) LockerReaderWriter) {
+	tests := []struct {
+		Name string
+		Test func(t *testing.T, kv LockerReaderWriter)
+	}{
+		{Name: "Contention", Test: testLockContention},
+		{Name: "Release", Test: testLockRelease},
+		{Name: "Independent", Test: testLockIndependent},
+		{Name: "Timeout", Test: testLockTimeout},
+		{Name: "Cancelled", Test: testLockCancelled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			kv := newBackend(t)
+			defer closeBackend(t, kv)
+			test.Test(t, kv)
+		})
+	}
+}
+
+// testLockContention starts many goroutines that all try to acquire the same
+// lock. One succeeds, reads the value that doesn't exist and creates it. After
+// the lock is released the other goroutines find the value and exit.
+func testLockContention(t *testing.T, kv LockerReaderWriter) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	key := "backendtest/contention"
+	clean(ctx, kv, key)
+	defer clean(ctx, kv, key)
+
+	var counter int64
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			unlock, err := kv.Lock(ctx, "backendtest/contention-lock")
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer unlock()
+
+			value, err := kv.Get(ctx, key)
+			if backend.IsNotFound(err) {
+				atomic.AddInt64(&counter, 1)
+				assert.NoError(t, kv.Put(ctx, key, "value"))
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "value", value)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(1), counter)
+}
+
+func testLockRelease(t *testing.T, kv LockerReaderWriter) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	unlock, err := kv.Lock(ctx, "backendtest/release")
+	require.NoError(t, err)
+
+	// A waiter acquires the lock once it is released
+	acquired := make(chan struct{})
+	go func() {
+		defer close(acquired)
+		unlock, err := kv.Lock(ctx, "backendtest/release")
+		if assert.NoError(t, err) {
+			unlock()
+		}
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("lock acquired while held")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	unlock()
+	select {
+	case <-acquired:
+	case <-ctx.Done():
+		t.Fatal("lock not acquired after release")
+	}
+}
+
+func testLockIndependent(t *testing.T, kv LockerReaderWriter) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	unlockFoo, err := kv.Lock(ctx, "backendtest/independent/foo")
+	require.NoError(t, err)
+	defer unlockFoo()
+
+	// Locking a different key does not wait
+	unlockBar, err := kv.Lock(ctx, "backendtest/independent/bar")
+	require.NoError(t, err)
+	unlockBar()
+}
+
+func testLockTimeout(t *testing.T, kv LockerReaderWriter) {
+	unlock, err := kv.Lock(context.Background(), "backendtest/timeout")
+	require.NoError(t, err)
+	defer unlock()
+
+	// Waiting for a lock that is held stops when the context times out
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = kv.Lock(ctx, "backendtest/timeout")
+	assert.Error(t, err)
+}
+
+func testLockCancelled(t *testing.T, kv LockerReaderWriter) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := kv.Lock(ctx, "backendtest/cancelled")
+	assert.Error(t, err)
+}
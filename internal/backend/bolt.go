@@ -0,0 +1,267 @@
+package backend
+
+import (
+	"context"
+	"encoding/binary"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/pkg/errors"
+)
+
+// boltBucket is the bucket all keys are stored in.
+var boltBucket = []byte("state")
+
+// Bolt stores state in a single file. The file can only be opened by one
+// process at a time, locks and watches are therefore kept in memory.
+type Bolt struct {
+	db       *bolt.DB
+	mu       sync.Mutex
+	locker   localLocker
+	watchers localWatchers
+}
+
+// NewBoltClient opens the bolt database at path. The file is created if it
+// does not exist. Returns an error if the file is in use by another process
+// for longer than timeout.
+func NewBoltClient(path string, timeout time.Duration) (*Bolt, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: timeout})
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not open %s", path)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, errors.Wrap(err, "could not create bucket")
+	}
+	return &Bolt{
+		db: db,
+	}, nil
+}
+
+// Close closes the database file.
+func (b *Bolt) Close() error {
+	return b.db.Close()
+}
+
+// encodeBoltValue prefixes a value with the revision it was written at.
+func encodeBoltValue(value string, revision int64) []byte {
+	buf := make([]byte, 8+len(value))
+	binary.BigEndian.PutUint64(buf, uint64(revision))
+	copy(buf[8:], value)
+	return buf
+}
+
+// decodeBoltValue returns the value and revision of a stored value.
+func decodeBoltValue(raw []byte) (string, int64) {
+	if len(raw) < 8 {
+		return "", 0
+	}
+	return string(raw[8:]), int64(binary.BigEndian.Uint64(raw))
+}
+
+// update applies a write transaction. The events returned by fn are sent to
+// watchers once the transaction has been committed.
+func (b *Bolt) update(ctx context.Context, fn func(bucket *bolt.Bucket) ([]Event, error)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var events []Event
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		events, err = fn(tx.Bucket(boltBucket))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	b.watchers.notify(events...)
+	return nil
+}
+
+// Get gets a value. Returns NotFoundError in case the key does not exist.
+func (b *Bolt) Get(ctx context.Context, key string) (string, error) {
+	value, _, err := b.GetRevision(ctx, key)
+	return value, err
+}
+
+// GetRevision gets a value and the revision it was last modified at. Returns
+// NotFoundError in case the key does not exist.
+func (b *Bolt) GetRevision(ctx context.Context, key string) (string, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return "", 0, err
+	}
+	var (
+		value    string
+		revision int64
+		found    bool
+	)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		raw := tx.Bucket(boltBucket).Get([]byte(key))
+		if raw == nil {
+			return nil
+		}
+		found = true
+		value, revision = decodeBoltValue(raw)
+		return nil
+	})
+	if err != nil {
+		return "", 0, errors.Wrapf(err, "could not get key: %s", key)
+	}
+	if !found {
+		return "", 0, &NotFoundError{key}
+	}
+	return value, revision, nil
+}
+
+// Put stores a value. The key is created if it doesn't exist, if it exists it
+// is overwritten.
+func (b *Bolt) Put(ctx context.Context, key, value string) error {
+	_, err := b.Txn(ctx, PutOp(key, value))
+	return err
+}
+
+// PutRevision stores a value if the key was last modified at revision.
+// Returns ConflictError in case the key has been modified.
+func (b *Bolt) PutRevision(ctx context.Context, key, value string, revision int64) (int64, error) {
+	return b.Txn(ctx, PutRevisionOp(key, value, revision))
+}
+
+// Delete deletes a key. Returns NotFoundError in case the key does not exist.
+func (b *Bolt) Delete(ctx context.Context, key string) error {
+	return b.update(ctx, func(bucket *bolt.Bucket) ([]Event, error) {
+		if bucket.Get([]byte(key)) == nil {
+			return nil, &NotFoundError{key}
+		}
+		revision, err := bucket.NextSequence()
+		if err != nil {
+			return nil, errors.Wrap(err, "could not increment revision")
+		}
+		if err := bucket.Delete([]byte(key)); err != nil {
+			return nil, errors.Wrapf(err, "could not delete key: %s", key)
+		}
+		return []Event{{Type: EventDelete, Key: key, Revision: int64(revision)}}, nil
+	})
+}
+
+// Txn applies operations atomically. All operations share the revision of
+// the transaction.
+func (b *Bolt) Txn(ctx context.Context, ops ...Op) (int64, error) {
+	var revision int64
+	err := b.update(ctx, func(bucket *bolt.Bucket) ([]Event, error) {
+		keys := make(map[string]bool)
+		for _, op := range ops {
+			if op.Key == "" {
+				return nil, errors.New("key is empty")
+			}
+			if keys[op.Key] {
+				return nil, errors.Errorf("duplicate key in transaction: %s", op.Key)
+			}
+			keys[op.Key] = true
+			if op.Type != OpPut && op.Type != OpDelete {
+				return nil, errors.Errorf("unsupported operation %d: %s", op.Type, op.Key)
+			}
+			if !op.CheckRevision {
+				continue
+			}
+			var current int64
+			if raw := bucket.Get([]byte(op.Key)); raw != nil {
+				_, current = decodeBoltValue(raw)
+			}
+			if current != op.Revision {
+				return nil, &ConflictError{Key: op.Key, Revision: op.Revision}
+			}
+		}
+
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return nil, errors.Wrap(err, "could not increment revision")
+		}
+		revision = int64(seq)
+
+		events := make([]Event, 0, len(ops))
+		for _, op := range ops {
+			switch op.Type {
+			case OpPut:
+				if err := bucket.Put([]byte(op.Key), encodeBoltValue(op.Value, revision)); err != nil {
+					return nil, errors.Wrapf(err, "could not put key: %s", op.Key)
+				}
+				events = append(events, Event{Type: EventPut, Key: op.Key, Value: op.Value, Revision: revision})
+			case OpDelete:
+				if bucket.Get([]byte(op.Key)) == nil {
+					continue
+				}
+				if err := bucket.Delete([]byte(op.Key)); err != nil {
+					return nil, errors.Wrapf(err, "could not delete key: %s", op.Key)
+				}
+				events = append(events, Event{Type: EventDelete, Key: op.Key, Revision: revision})
+			}
+		}
+		return events, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return revision, nil
+}
+
+// List lists keys with root as a prefix.
+func (b *Bolt) List(ctx context.Context, root string) (map[string]string, error) {
+	values, err := b.ListRevisions(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]string, len(values))
+	for k, v := range values {
+		out[k] = v.Value
+	}
+	return out, nil
+}
+
+// ListRevisions lists keys with root as a prefix with the revisions they
+// were last modified at.
+func (b *Bolt) ListRevisions(ctx context.Context, root string) (map[string]RevisionValue, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if !strings.HasSuffix(root, "/") {
+		root = root + "/"
+	}
+
+	out := make(map[string]RevisionValue)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		prefix := []byte(root)
+		c := tx.Bucket(boltBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && strings.HasPrefix(string(k), root); k, v = c.Next() {
+			value, revision := decodeBoltValue(v)
+			out[strings.TrimPrefix(string(k), root)] = RevisionValue{Value: value, Revision: revision}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not list keys: %s", root)
+	}
+
+	return out, nil
+}
+
+// Lock locks a key. The key is locked for concurrent access until unlocked
+// by calling the returned function. Returns an error if the context is
+// cancelled before the lock is acquired.
+func (b *Bolt) Lock(ctx context.Context, key string) (func(), error) {
+	return b.locker.Lock(ctx, key)
+}
+
+// Watch sends changes to keys with prefix. Changes made before the watch was
+// started are not sent.
+func (b *Bolt) Watch(ctx context.Context, prefix string) (<-chan Event, error) {
+	return b.watchers.Watch(ctx, prefix)
+}
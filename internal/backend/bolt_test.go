@@ -0,0 +1,146 @@
+package backend
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testBolt is a bolt database in a temporary directory. The directory is
+// removed when the database is closed.
+type testBolt struct {
+	*Bolt
+	dir string
+}
+
+func newTestBolt(t *testing.T) *testBolt {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "fragments-bolt")
+	require.NoError(t, err)
+	b, err := NewBoltClient(filepath.Join(dir, "state.db"), 1*time.Second)
+	require.NoError(t, err)
+	return &testBolt{Bolt: b, dir: dir}
+}
+
+func (b *testBolt) Close() error {
+	defer os.RemoveAll(b.dir) // nolint: errcheck
+	return b.Bolt.Close()
+}
+
+// NOTE(akupila): megacheck doesn't seem to see that this is used in the tests
+// and reports U1000. It is used, so we'll disable the linter.
+// nolint: megacheck
+type boltValidator struct {
+	db *bolt.DB
+}
+
+func (v *boltValidator) put(t *testing.T, key, value string) {
+	t.Helper()
+	err := v.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+		revision, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(key), encodeBoltValue(value, int64(revision)))
+	})
+	require.NoError(t, err)
+}
+
+func (v *boltValidator) get(t *testing.T, key string) (string, bool) {
+	t.Helper()
+	var (
+		value string
+		found bool
+	)
+	err := v.db.View(func(tx *bolt.Tx) error {
+		raw := tx.Bucket(boltBucket).Get([]byte(key))
+		if raw != nil {
+			value, _ = decodeBoltValue(raw)
+			found = true
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	return value, found
+}
+
+func TestBoltReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fragments-bolt")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	path := filepath.Join(dir, "state.db")
+	ctx := context.Background()
+
+	b, err := NewBoltClient(path, 1*time.Second)
+	require.NoError(t, err)
+	rev, err := b.PutRevision(ctx, "foo/bar", "bar", 0)
+	require.NoError(t, err)
+	require.NoError(t, b.Close())
+
+	b, err = NewBoltClient(path, 1*time.Second)
+	require.NoError(t, err)
+	defer b.Close() // nolint: errcheck
+
+	// Values and revisions are kept
+	value, got, err := b.GetRevision(ctx, "foo/bar")
+	require.NoError(t, err)
+	assert.Equal(t, "bar", value)
+	assert.Equal(t, rev, got)
+	listed, err := b.ListRevisions(ctx, "foo")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]RevisionValue{"bar": {Value: "bar", Revision: rev}}, listed)
+
+	// Revisions keep increasing
+	next, err := b.PutRevision(ctx, "foo/baz", "baz", 0)
+	require.NoError(t, err)
+	assert.True(t, next > rev)
+}
+
+func TestBoltTxn(t *testing.T) {
+	b := newTestBolt(t)
+	defer b.Close() // nolint: errcheck
+	ctx := context.Background()
+
+	require.NoError(t, b.Put(ctx, "foo/a", "a"))
+	_, rev, err := b.GetRevision(ctx, "foo/a")
+	require.NoError(t, err)
+
+	// Nothing is applied if a revision does not match
+	_, err = b.Txn(ctx, PutOp("foo/b", "b"), PutRevisionOp("foo/a", "b", rev+1))
+	assert.True(t, IsConflict(err))
+	list, err := b.List(ctx, "foo")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "a"}, list)
+
+	_, err = b.Txn(ctx, PutOp("foo/a", "b"), DeleteOp("foo/a"))
+	require.Error(t, err)
+	_, err = b.Txn(ctx, PutOp("", "b"))
+	require.Error(t, err)
+
+	txnRev, err := b.Txn(ctx,
+		PutOp("foo/b", "b"),
+		PutRevisionOp("foo/a", "b", rev),
+		DeleteOp("foo/missing"),
+	)
+	require.NoError(t, err)
+	list, err = b.List(ctx, "foo/")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "b", "b": "b"}, list)
+	_, got, err := b.GetRevision(ctx, "foo/b")
+	require.NoError(t, err)
+	assert.Equal(t, txnRev, got)
+
+	// Keys that only share a prefix with the root are not listed
+	require.NoError(t, b.Put(ctx, "foobar", "foobar"))
+	list, err = b.List(ctx, "foo")
+	require.NoError(t, err)
+	assert.Len(t, list, 2)
+}
@@ -0,0 +1,88 @@
+// +build integration
+
+package backend_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/fragments/fragments/internal/backend"
+	"github.com/fragments/fragments/internal/backend/backendtest"
+	vaultapi "github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestETCD(t *testing.T) *backend.ETCD {
+	endpoint := fmt.Sprintf("127.0.0.1:%s", os.Getenv("ETCD_TEST_LISTEN_PORT"))
+	etcd, err := backend.NewETCDClient([]string{endpoint}, 1*time.Second)
+	require.NoError(t, err)
+	return etcd
+}
+
+func TestETCDConformance(t *testing.T) {
+	t.Run("ReaderWriter", func(t *testing.T) {
+		backendtest.TestReaderWriter(t, func(t *testing.T) backendtest.ReaderWriter {
+			return newTestETCD(t)
+		})
+	})
+	t.Run("Lister", func(t *testing.T) {
+		backendtest.TestLister(t, func(t *testing.T) backendtest.ListerWriter {
+			return newTestETCD(t)
+		})
+	})
+	t.Run("Locker", func(t *testing.T) {
+		backendtest.TestLocker(t, func(t *testing.T) backendtest.LockerReaderWriter {
+			return newTestETCD(t)
+		})
+	})
+}
+
+func TestVaultConformance(t *testing.T) {
+	// The Vault client reads the token from VAULT_TOKEN
+	token := os.Getenv("VAULT_TOKEN")
+	defer os.Setenv("VAULT_TOKEN", token) // nolint: errcheck
+	require.NoError(t, os.Setenv("VAULT_TOKEN", os.Getenv("VAULT_TEST_ROOT_TOKEN")))
+	address := fmt.Sprintf("http://127.0.0.1:%s", os.Getenv("VAULT_TEST_PORT"))
+
+	backendtest.TestReaderWriter(t, func(t *testing.T) backendtest.ReaderWriter {
+		vault, err := backend.NewVaultClient(address)
+		require.NoError(t, err)
+		return vault
+	})
+
+	for _, version := range []int{1, 2} {
+		t.Run(fmt.Sprintf("KVV%d", version), func(t *testing.T) {
+			mount := fmt.Sprintf("fragments-conformance-kv%d", version)
+			root, err := vaultapi.NewClient(&vaultapi.Config{Address: address})
+			require.NoError(t, err)
+			_ = root.Sys().Unmount(mount)
+			err = root.Sys().Mount(mount, &vaultapi.MountInput{
+				Type:    "kv",
+				Options: map[string]string{"version": fmt.Sprintf("%d", version)},
+			})
+			require.NoError(t, err)
+
+			newVault := func(t *testing.T) *backend.Vault {
+				vault, err := backend.NewVault(backend.VaultConfig{
+					Address:   address,
+					Mount:     mount,
+					KVVersion: version,
+				})
+				require.NoError(t, err)
+				return vault
+			}
+			t.Run("ReaderWriter", func(t *testing.T) {
+				backendtest.TestReaderWriter(t, func(t *testing.T) backendtest.ReaderWriter {
+					return newVault(t)
+				})
+			})
+			t.Run("Lister", func(t *testing.T) {
+				backendtest.TestLister(t, func(t *testing.T) backendtest.ListerWriter {
+					return newVault(t)
+				})
+			})
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package backend_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fragments/fragments/internal/backend"
+	"github.com/fragments/fragments/internal/backend/backendtest"
+	"github.com/stretchr/testify/require"
+)
+
+// tempBolt is a bolt database in a temporary directory. The directory is
+// removed when the database is closed.
+type tempBolt struct {
+	*backend.Bolt
+	dir string
+}
+
+func newTempBolt(t *testing.T) *tempBolt {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "fragments-bolt")
+	require.NoError(t, err)
+	b, err := backend.NewBoltClient(filepath.Join(dir, "state.db"), 1*time.Second)
+	require.NoError(t, err)
+	return &tempBolt{Bolt: b, dir: dir}
+}
+
+func (b *tempBolt) Close() error {
+	defer os.RemoveAll(b.dir) // nolint: errcheck
+	return b.Bolt.Close()
+}
+
+func TestTestKVConformance(t *testing.T) {
+	t.Run("ReaderWriter", func(t *testing.T) {
+		backendtest.TestReaderWriter(t, func(t *testing.T) backendtest.ReaderWriter {
+			return backend.NewTestKV()
+		})
+	})
+	t.Run("Lister", func(t *testing.T) {
+		backendtest.TestLister(t, func(t *testing.T) backendtest.ListerWriter {
+			return backend.NewTestKV()
+		})
+	})
+	t.Run("Locker", func(t *testing.T) {
+		backendtest.TestLocker(t, func(t *testing.T) backendtest.LockerReaderWriter {
+			return backend.NewTestKV()
+		})
+	})
+}
+
+func TestBoltConformance(t *testing.T) {
+	t.Run("ReaderWriter", func(t *testing.T) {
+		backendtest.TestReaderWriter(t, func(t *testing.T) backendtest.ReaderWriter {
+			return newTempBolt(t)
+		})
+	})
+	t.Run("Lister", func(t *testing.T) {
+		backendtest.TestLister(t, func(t *testing.T) backendtest.ListerWriter {
+			return newTempBolt(t)
+		})
+	})
+	t.Run("Locker", func(t *testing.T) {
+		backendtest.TestLocker(t, func(t *testing.T) backendtest.LockerReaderWriter {
+			return newTempBolt(t)
+		})
+	})
+}
+
+func TestEncryptedConformance(t *testing.T) {
+	newEncrypted := func(t *testing.T) *backend.Encrypted {
+		e, err := backend.NewEncrypted(newTempBolt(t), backend.SecretKey{
+			ID:  "test",
+			Key: []byte("0123456789abcdef0123456789abcdef"),
+		})
+		require.NoError(t, err)
+		return e
+	}
+	t.Run("ReaderWriter", func(t *testing.T) {
+		backendtest.TestReaderWriter(t, func(t *testing.T) backendtest.ReaderWriter {
+			return newEncrypted(t)
+		})
+	})
+	t.Run("Lister", func(t *testing.T) {
+		backendtest.TestLister(t, func(t *testing.T) backendtest.ListerWriter {
+			return newEncrypted(t)
+		})
+	})
+}
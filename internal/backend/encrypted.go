@@ -0,0 +1,220 @@
+package backend
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SecretKey is a key values are encrypted with.
+type SecretKey struct {
+	// ID identifies the key a value was encrypted with. It must not contain
+	// a colon.
+	ID string
+	// Key is the AES key, it must be 16, 24 or 32 bytes long.
+	Key []byte
+}
+
+// ParseSecretKeys parses keys in the format id:base64key. Keys are separated
+// by newlines or commas, empty lines and lines starting with # are ignored.
+func ParseSecretKeys(s string) ([]SecretKey, error) {
+	var keys []SecretKey
+	for _, line := range strings.FieldsFunc(s, func(r rune) bool { return r == '\n' || r == ',' }) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.New("secret key must be in the format id:base64key")
+		}
+		key, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not decode secret key %s", parts[0])
+		}
+		keys = append(keys, SecretKey{ID: parts[0], Key: key})
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no secret keys found")
+	}
+	return keys, nil
+}
+
+// Encrypted encrypts values with AES-GCM before writing them to a backend and
+// decrypts them when read. Values are encrypted with the first key, any of the
+// keys can decrypt them. To rotate keys a new key is added first, values
+// encrypted with the previous keys are re-encrypted with Reencrypt.
+type Encrypted struct {
+	kv      ReaderWriterLister
+	primary string
+	ciphers map[string]cipher.AEAD
+}
+
+// ReaderWriterLister is a backend that can be read from, written to and
+// listed.
+type ReaderWriterLister interface {
+	Reader
+	Writer
+	Lister
+}
+
+// NewEncrypted creates a backend that encrypts values written to kv. At least
+// one key must be passed.
+func NewEncrypted(kv ReaderWriterLister, keys ...SecretKey) (*Encrypted, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no secret keys supplied")
+	}
+	e := &Encrypted{
+		kv:      kv,
+		primary: keys[0].ID,
+		ciphers: make(map[string]cipher.AEAD),
+	}
+	for _, k := range keys {
+		if k.ID == "" || strings.Contains(k.ID, ":") {
+			return nil, errors.Errorf("invalid secret key id %q", k.ID)
+		}
+		if _, ok := e.ciphers[k.ID]; ok {
+			return nil, errors.Errorf("duplicate secret key id %q", k.ID)
+		}
+		block, err := aes.NewCipher(k.Key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid secret key %s", k.ID)
+		}
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid secret key %s", k.ID)
+		}
+		e.ciphers[k.ID] = gcm
+	}
+	return e, nil
+}
+
+// encrypt encrypts a value with the primary key. The key the value is stored
+// under is authenticated so values can't be swapped between keys.
+func (e *Encrypted) encrypt(key, value string) (string, error) {
+	gcm := e.ciphers[e.primary]
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", errors.Wrap(err, "could not generate nonce")
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(value), []byte(key))
+	return e.primary + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt decrypts a value. Returns the id of the key the value was encrypted
+// with.
+func (e *Encrypted) decrypt(key, raw string) (string, string, error) {
+	parts := strings.SplitN(raw, ":", 2)
+	if len(parts) != 2 {
+		return "", "", errors.Errorf("value is not encrypted: %s", key)
+	}
+	gcm, ok := e.ciphers[parts[0]]
+	if !ok {
+		return "", "", errors.Errorf("value encrypted with unknown key %s: %s", parts[0], key)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", "", errors.Wrapf(err, "could not decode value: %s", key)
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", "", errors.Errorf("value too short: %s", key)
+	}
+	nonce, sealed := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	value, err := gcm.Open(nil, nonce, sealed, []byte(key))
+	if err != nil {
+		return "", "", errors.Wrapf(err, "could not decrypt value: %s", key)
+	}
+	return string(value), parts[0], nil
+}
+
+// Get reads and decrypts a value. Returns NotFoundError if the key does not
+// exist.
+func (e *Encrypted) Get(ctx context.Context, key string) (string, error) {
+	raw, err := e.kv.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	value, _, err := e.decrypt(key, raw)
+	return value, err
+}
+
+// Put encrypts a value with the primary key and writes it.
+func (e *Encrypted) Put(ctx context.Context, key, value string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	raw, err := e.encrypt(key, value)
+	if err != nil {
+		return err
+	}
+	return e.kv.Put(ctx, key, raw)
+}
+
+// Delete deletes a key. Returns NotFoundError if the key does not exist.
+func (e *Encrypted) Delete(ctx context.Context, key string) error {
+	return e.kv.Delete(ctx, key)
+}
+
+// List lists and decrypts all values under a root key.
+func (e *Encrypted) List(ctx context.Context, root string) (map[string]string, error) {
+	raw, err := e.kv.List(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(root, "/") {
+		root = root + "/"
+	}
+	out := make(map[string]string, len(raw))
+	for k, v := range raw {
+		value, _, err := e.decrypt(root+k, v)
+		if err != nil {
+			return nil, err
+		}
+		out[k] = value
+	}
+	return out, nil
+}
+
+// Reencrypt encrypts all values under root that were encrypted with a key
+// other than the primary key with the primary key. Returns the number of
+// values that were re-encrypted. Once all values are re-encrypted previous
+// keys can be removed.
+func (e *Encrypted) Reencrypt(ctx context.Context, root string) (int, error) {
+	raw, err := e.kv.List(ctx, root)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not list values")
+	}
+	if !strings.HasSuffix(root, "/") {
+		root = root + "/"
+	}
+	n := 0
+	for k, v := range raw {
+		key := root + k
+		value, id, err := e.decrypt(key, v)
+		if err != nil {
+			return n, err
+		}
+		if id == e.primary {
+			continue
+		}
+		if err := e.Put(ctx, key, value); err != nil {
+			return n, errors.Wrapf(err, "could not re-encrypt value: %s", key)
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Close closes the underlying backend if it can be closed.
+func (e *Encrypted) Close() error {
+	if closer, ok := e.kv.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package backend
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testSecretKey  = SecretKey{ID: "a", Key: []byte("0123456789abcdef0123456789abcdef")}
+	testSecretKey2 = SecretKey{ID: "b", Key: []byte("fedcba9876543210fedcba9876543210")}
+)
+
+func TestParseSecretKeys(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(testSecretKey.Key)
+	key2 := base64.StdEncoding.EncodeToString(testSecretKey2.Key)
+
+	tests := []struct {
+		TestName string
+		Input    string
+		Keys     []SecretKey
+		Error    bool
+	}{
+		{
+			TestName: "Single",
+			Input:    "a:" + key,
+			Keys:     []SecretKey{testSecretKey},
+		},
+		{
+			TestName: "Lines",
+			Input:    "# current\nb:" + key2 + "\n\n# previous\na:" + key + "\n",
+			Keys:     []SecretKey{testSecretKey2, testSecretKey},
+		},
+		{
+			TestName: "Commas",
+			Input:    "b:" + key2 + ",a:" + key,
+			Keys:     []SecretKey{testSecretKey2, testSecretKey},
+		},
+		{
+			TestName: "Empty",
+			Input:    "\n# nothing\n",
+			Error:    true,
+		},
+		{
+			TestName: "NoID",
+			Input:    key,
+			Error:    true,
+		},
+		{
+			TestName: "NotBase64",
+			Input:    "a:not base64",
+			Error:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			keys, err := ParseSecretKeys(test.Input)
+			if test.Error {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.Keys, keys)
+		})
+	}
+}
+
+func TestNewEncrypted(t *testing.T) {
+	kv := NewTestKV()
+
+	_, err := NewEncrypted(kv)
+	assert.Error(t, err)
+	_, err = NewEncrypted(kv, SecretKey{ID: "a", Key: []byte("short")})
+	assert.Error(t, err)
+	_, err = NewEncrypted(kv, SecretKey{ID: "a:b", Key: testSecretKey.Key})
+	assert.Error(t, err)
+	_, err = NewEncrypted(kv, testSecretKey, SecretKey{ID: "a", Key: testSecretKey2.Key})
+	assert.Error(t, err)
+}
+
+func TestEncrypted(t *testing.T) {
+	ctx := context.Background()
+	kv := NewTestKV()
+	e, err := NewEncrypted(kv, testSecretKey)
+	require.NoError(t, err)
+
+	require.NoError(t, e.Put(ctx, "secret/foo", "foo"))
+	require.NoError(t, e.Put(ctx, "secret/bar", "foo"))
+
+	// Values are not stored in plain text and use a new nonce every time
+	assert.True(t, strings.HasPrefix(kv.Data["secret/foo"], "a:"))
+	assert.NotContains(t, kv.Data["secret/foo"], "foo")
+	assert.NotEqual(t, kv.Data["secret/foo"], kv.Data["secret/bar"])
+
+	value, err := e.Get(ctx, "secret/foo")
+	require.NoError(t, err)
+	assert.Equal(t, "foo", value)
+
+	values, err := e.List(ctx, "secret")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"foo": "foo", "bar": "foo"}, values)
+
+	// Values can't be moved to another key
+	kv.Data["secret/baz"] = kv.Data["secret/foo"]
+	_, err = e.Get(ctx, "secret/baz")
+	assert.Error(t, err)
+
+	// Values can't be modified
+	kv.Data["secret/baz"] = kv.Data["secret/foo"][:len(kv.Data["secret/foo"])-4] + "AAA="
+	_, err = e.Get(ctx, "secret/baz")
+	assert.Error(t, err)
+
+	kv.Data["secret/baz"] = "plain"
+	_, err = e.Get(ctx, "secret/baz")
+	assert.Error(t, err)
+
+	// Values encrypted with a key that was removed can't be read
+	other, err := NewEncrypted(kv, testSecretKey2)
+	require.NoError(t, err)
+	_, err = other.Get(ctx, "secret/foo")
+	assert.Error(t, err)
+
+	_, err = e.Get(ctx, "secret/missing")
+	assert.True(t, IsNotFound(err))
+}
+
+func TestEncryptedReencrypt(t *testing.T) {
+	ctx := context.Background()
+	kv := NewTestKV()
+	old, err := NewEncrypted(kv, testSecretKey)
+	require.NoError(t, err)
+	require.NoError(t, old.Put(ctx, "secret/foo", "foo"))
+	require.NoError(t, old.Put(ctx, "secret/bar", "bar"))
+	require.NoError(t, old.Put(ctx, "other/baz", "baz"))
+
+	rotated, err := NewEncrypted(kv, testSecretKey2, testSecretKey)
+	require.NoError(t, err)
+
+	// Values encrypted with previous keys can be read
+	value, err := rotated.Get(ctx, "secret/foo")
+	require.NoError(t, err)
+	assert.Equal(t, "foo", value)
+
+	require.NoError(t, rotated.Put(ctx, "secret/qux", "qux"))
+	assert.True(t, strings.HasPrefix(kv.Data["secret/qux"], "b:"))
+
+	n, err := rotated.Reencrypt(ctx, "secret")
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	n, err = rotated.Reencrypt(ctx, "secret")
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	current, err := NewEncrypted(kv, testSecretKey2)
+	require.NoError(t, err)
+	values, err := current.List(ctx, "secret")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"foo": "foo", "bar": "bar", "qux": "qux"}, values)
+
+	// Values outside the root are not re-encrypted
+	_, err = current.Get(ctx, "other/baz")
+	assert.Error(t, err)
+}
@@ -54,6 +54,84 @@ func (e *ETCD) Get(ctx context.Context, key string) (string, error) {
 	return string(res.Kvs[0].Value), nil
 }
 
+// GetRevision retrieves a value and its mod revision from ETCD. Returns
+// NotFoundError in case the key does not exist.
+func (e *ETCD) GetRevision(ctx context.Context, key string) (string, int64, error) {
+	res, err := e.client.Get(ctx, key, clientv3.WithLimit(1))
+	if err != nil {
+		return "", 0, errors.Wrapf(err, "could not get key: %s", key)
+	}
+	if res.Count < 1 {
+		return "", 0, &NotFoundError{key}
+	}
+
+	return string(res.Kvs[0].Value), res.Kvs[0].ModRevision, nil
+}
+
+// PutRevision stores a value in ETCD if the mod revision of the key matches
+// revision. Returns ConflictError in case the key has been modified.
+func (e *ETCD) PutRevision(ctx context.Context, key, value string, revision int64) (int64, error) {
+	res, err := e.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", revision)).
+		Then(clientv3.OpPut(key, value)).
+		Commit()
+	if err != nil {
+		return 0, errors.Wrapf(err, "could not put key: %s", key)
+	}
+	if !res.Succeeded {
+		return 0, &ConflictError{Key: key, Revision: revision}
+	}
+	return res.Header.Revision, nil
+}
+
+// Txn applies operations in a single ETCD transaction. All puts share the
+// revision of the transaction.
+func (e *ETCD) Txn(ctx context.Context, ops ...Op) (int64, error) {
+	cmps := []clientv3.Cmp{}
+	then := make([]clientv3.Op, 0, len(ops))
+	// The checked keys are read in case the transaction fails to find which
+	// key was modified
+	checked := []Op{}
+	reads := []clientv3.Op{}
+	for _, op := range ops {
+		switch op.Type {
+		case OpPut:
+			then = append(then, clientv3.OpPut(op.Key, op.Value))
+		case OpDelete:
+			then = append(then, clientv3.OpDelete(op.Key))
+		default:
+			return 0, errors.Errorf("unsupported operation %d: %s", op.Type, op.Key)
+		}
+		if op.CheckRevision {
+			cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(op.Key), "=", op.Revision))
+			checked = append(checked, op)
+			reads = append(reads, clientv3.OpGet(op.Key))
+		}
+	}
+
+	res, err := e.client.Txn(ctx).
+		If(cmps...).
+		Then(then...).
+		Else(reads...).
+		Commit()
+	if err != nil {
+		return 0, errors.Wrap(err, "could not commit transaction")
+	}
+	if !res.Succeeded {
+		for i, op := range checked {
+			var revision int64
+			if kvs := res.Responses[i].GetResponseRange().Kvs; len(kvs) > 0 {
+				revision = kvs[0].ModRevision
+			}
+			if revision != op.Revision {
+				return 0, &ConflictError{Key: op.Key, Revision: op.Revision}
+			}
+		}
+		return 0, errors.New("transaction was not committed")
+	}
+	return res.Header.Revision, nil
+}
+
 // Delete deletes a key from ETCD. Returns NotFoundError in case the key does not
 // exist.
 func (e *ETCD) Delete(ctx context.Context, key string) error {
@@ -87,24 +165,84 @@ func (e *ETCD) List(ctx context.Context, root string) (map[string]string, error)
 	return out, nil
 }
 
+// ListRevisions lists keys in ETCD that have root as a prefix with their mod
+// revisions.
+func (e *ETCD) ListRevisions(ctx context.Context, root string) (map[string]RevisionValue, error) {
+	if !strings.HasSuffix(root, "/") {
+		root = root + "/"
+	}
+	res, err := e.client.Get(ctx, root, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]RevisionValue)
+	for _, kv := range res.Kvs {
+		key := strings.TrimPrefix(string(kv.Key), root)
+		out[key] = RevisionValue{Value: string(kv.Value), Revision: kv.ModRevision}
+	}
+
+	return out, nil
+}
+
+// Watch watches keys in ETCD that have prefix as a prefix. Changes made
+// before the watch was started are not sent.
+func (e *ETCD) Watch(ctx context.Context, prefix string) (<-chan Event, error) {
+	watch := e.client.Watch(ctx, prefix, clientv3.WithPrefix())
+	out := make(chan Event)
+	go func() {
+		defer close(out)
+		for res := range watch {
+			if res.Err() != nil {
+				return
+			}
+			for _, ev := range res.Events {
+				event := Event{
+					Key:      string(ev.Kv.Key),
+					Revision: ev.Kv.ModRevision,
+				}
+				switch ev.Type {
+				case clientv3.EventTypePut:
+					event.Type = EventPut
+					event.Value = string(ev.Kv.Value)
+				case clientv3.EventTypeDelete:
+					event.Type = EventDelete
+				default:
+					continue
+				}
+				select {
+				case out <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
+
 // Lock creates a new distributes lock on a key. Any future locks on the same
-// key block until the lock is released. The lock is released if the context is
-// cancelled.
+// key block until the lock is released. In case the context is cancelled
+// before the lock is acquired an error is returned.
 //
 // When no longer needed, the lock must be unlocked by calling the returned
-// unlock function.
-//
-// Note: if the context is cancelled due to a timeout, this will still wait to
-// acquire the lock. The lock will timeout automatically by a value set on the
-// ETCD server (default 60 seconds).
+// unlock function. The lock is held until unlocked, in case the client goes
+// away the lock expires with the session lease (default 60 seconds).
 func (e *ETCD) Lock(ctx context.Context, key string) (func(), error) {
-	ses, err := concurrency.NewSession(e.client, concurrency.WithContext(ctx))
+	ses, err := concurrency.NewSession(e.client)
 	if err != nil {
 		return nil, errors.Wrap(err, "could not get etcd session for lock")
 	}
-	lock := concurrency.NewLocker(ses, key)
-	lock.Lock()
-	return lock.Unlock, nil
+	mutex := concurrency.NewMutex(ses, key)
+	if err := mutex.Lock(ctx); err != nil {
+		_ = ses.Close()
+		return nil, errors.Wrap(err, "could not acquire lock")
+	}
+	unlock := func() {
+		_ = mutex.Unlock(context.Background())
+		_ = ses.Close()
+	}
+	return unlock, nil
 }
 
 // Close closes the connection to ETCD.
@@ -38,6 +38,16 @@ var listers = []struct {
 			return &testkvValidator{data: testkv.Data}
 		},
 	},
+	{
+		Name: "Bolt",
+		New: func(t *testing.T) Lister {
+			return newTestBolt(t)
+		},
+		NewValidator: func(t *testing.T, client Lister) validator {
+			b := client.(*testBolt)
+			return &boltValidator{db: b.db}
+		},
+	},
 }
 
 func TestListerList(t *testing.T) {
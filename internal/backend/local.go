@@ -0,0 +1,132 @@
+package backend
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// localLocker locks keys within a single process. It is used by backends that
+// can only be used by one process at a time.
+type localLocker struct {
+	mu    sync.Mutex
+	locks map[string]chan struct{}
+}
+
+// Lock locks a key until unlocked by calling the returned function. Returns an
+// error if the context is cancelled before the lock is acquired.
+func (l *localLocker) Lock(ctx context.Context, key string) (func(), error) {
+	if err := ctx.Err(); err != nil {
+		return func() {}, err
+	}
+
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]chan struct{})
+	}
+	locker, exists := l.locks[key]
+	if !exists {
+		locker = make(chan struct{}, 1)
+		l.locks[key] = locker
+	}
+	l.mu.Unlock()
+
+	select {
+	case locker <- struct{}{}:
+	case <-ctx.Done():
+		return func() {}, ctx.Err()
+	}
+
+	return func() { <-locker }, nil
+}
+
+// localWatchers sends events to watches within a single process.
+type localWatchers struct {
+	mu      sync.Mutex
+	watches map[*localWatch]bool
+}
+
+// localWatch queues events for a watcher so writers never block on a watcher
+// that is not reading.
+type localWatch struct {
+	prefix string
+	mu     sync.Mutex
+	queue  []Event
+	ready  chan struct{}
+}
+
+// push queues an event for the watcher.
+func (w *localWatch) push(e Event) {
+	w.mu.Lock()
+	w.queue = append(w.queue, e)
+	w.mu.Unlock()
+	select {
+	case w.ready <- struct{}{}:
+	default:
+	}
+}
+
+// pop returns and removes all queued events.
+func (w *localWatch) pop() []Event {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	events := w.queue
+	w.queue = nil
+	return events
+}
+
+// Watch sends every event for a key with prefix that is notified after the
+// watch was started. The channel is closed when the context is cancelled.
+func (l *localWatchers) Watch(ctx context.Context, prefix string) (<-chan Event, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	w := &localWatch{
+		prefix: prefix,
+		ready:  make(chan struct{}, 1),
+	}
+	l.mu.Lock()
+	if l.watches == nil {
+		l.watches = make(map[*localWatch]bool)
+	}
+	l.watches[w] = true
+	l.mu.Unlock()
+
+	out := make(chan Event)
+	go func() {
+		defer close(out)
+		defer func() {
+			l.mu.Lock()
+			delete(l.watches, w)
+			l.mu.Unlock()
+		}()
+		for {
+			select {
+			case <-w.ready:
+			case <-ctx.Done():
+				return
+			}
+			for _, e := range w.pop() {
+				select {
+				case out <- e:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
+
+// notify queues an event for every watch on the key.
+func (l *localWatchers) notify(events ...Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, e := range events {
+		for w := range l.watches {
+			if strings.HasPrefix(e.Key, w.prefix) {
+				w.push(e)
+			}
+		}
+	}
+}
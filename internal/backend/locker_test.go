@@ -38,6 +38,12 @@ var lockers = []struct {
 			return NewTestKV()
 		},
 	},
+	{
+		Name: "Bolt",
+		New: func(t *testing.T) LockerReaderWriter {
+			return newTestBolt(t)
+		},
+	},
 }
 
 func TestLockerLock(t *testing.T) {
@@ -88,6 +94,15 @@ func TestLockerLock(t *testing.T) {
 			_, err := client.Lock(ctx, "/lockcancel")
 			require.Error(t, err)
 
+			// Waiting for a lock that is held stops when the context times out
+			unlock, err := client.Lock(context.Background(), "/locktimeout")
+			require.NoError(t, err)
+			timeoutCtx, timeoutCancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			_, err = client.Lock(timeoutCtx, "/locktimeout")
+			timeoutCancel()
+			require.Error(t, err)
+			unlock()
+
 			if closer, ok := client.(io.Closer); ok {
 				err := closer.Close()
 				require.NoError(t, err)
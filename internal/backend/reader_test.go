@@ -51,6 +51,16 @@ var readers = []struct {
 			return &testkvValidator{data: testkv.Data}
 		},
 	},
+	{
+		Name: "Bolt",
+		New: func(t *testing.T) Reader {
+			return newTestBolt(t)
+		},
+		NewValidator: func(t *testing.T, client Reader) validator {
+			b := client.(*testBolt)
+			return &boltValidator{db: b.db}
+		},
+	},
 }
 
 func TestReaderGet(t *testing.T) {
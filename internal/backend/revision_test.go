@@ -0,0 +1,119 @@
+// +build integration
+
+package backend
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type RevisionReaderWriter interface {
+	RevisionReader
+	RevisionWriter
+	Writer
+}
+
+var revisionWriters = []struct {
+	Name string
+	New  func(t *testing.T) RevisionReaderWriter
+}{
+	{
+		Name: "ETCD",
+		New: func(t *testing.T) RevisionReaderWriter {
+			etcd, err := NewETCDClient([]string{testETCDEndpoint}, 1*time.Second)
+			require.NoError(t, err)
+			return etcd
+		},
+	},
+	{
+		Name: "TestKV",
+		New: func(t *testing.T) RevisionReaderWriter {
+			return NewTestKV()
+		},
+	},
+	{
+		Name: "Bolt",
+		New: func(t *testing.T) RevisionReaderWriter {
+			return newTestBolt(t)
+		},
+	},
+}
+
+func TestRevisionWriterPutRevision(t *testing.T) {
+	for _, target := range revisionWriters {
+		t.Run(target.Name, func(t *testing.T) {
+			client := target.New(t)
+			ctx := context.Background()
+
+			// Delete key in case it previously existed
+			_ = client.Delete(ctx, "/revisionkey")
+
+			rev, err := client.PutRevision(ctx, "/revisionkey", "first", 0)
+			require.NoError(t, err)
+			_, err = client.PutRevision(ctx, "/revisionkey", "second", 0)
+			assert.True(t, IsConflict(err))
+
+			value, got, err := client.GetRevision(ctx, "/revisionkey")
+			require.NoError(t, err)
+			assert.Equal(t, "first", value)
+			assert.Equal(t, rev, got)
+
+			_, err = client.PutRevision(ctx, "/revisionkey", "second", rev)
+			require.NoError(t, err)
+			_, err = client.PutRevision(ctx, "/revisionkey", "third", rev)
+			assert.True(t, IsConflict(err))
+
+			value, _, err = client.GetRevision(ctx, "/revisionkey")
+			require.NoError(t, err)
+			assert.Equal(t, "second", value)
+
+			err = client.Delete(ctx, "/revisionkey")
+			require.NoError(t, err)
+			_, _, err = client.GetRevision(ctx, "/revisionkey")
+			assert.True(t, IsNotFound(err))
+
+			if closer, ok := client.(io.Closer); ok {
+				err := closer.Close()
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestRevisionReaderListRevisions(t *testing.T) {
+	for _, target := range revisionWriters {
+		t.Run(target.Name, func(t *testing.T) {
+			client := target.New(t)
+			ctx := context.Background()
+
+			// Delete keys in case they previously existed
+			_ = client.Delete(ctx, "/revisionlist/a")
+			_ = client.Delete(ctx, "/revisionlist/b")
+
+			a, err := client.PutRevision(ctx, "/revisionlist/a", "a", 0)
+			require.NoError(t, err)
+			b, err := client.PutRevision(ctx, "/revisionlist/b", "b", 0)
+			require.NoError(t, err)
+
+			listed, err := client.ListRevisions(ctx, "/revisionlist")
+			require.NoError(t, err)
+			assert.Equal(t, map[string]RevisionValue{
+				"a": {Value: "a", Revision: a},
+				"b": {Value: "b", Revision: b},
+			}, listed)
+
+			require.NoError(t, client.Delete(ctx, "/revisionlist/a"))
+			require.NoError(t, client.Delete(ctx, "/revisionlist/b"))
+
+			if closer, ok := client.(io.Closer); ok {
+				err := closer.Close()
+				require.NoError(t, err)
+			}
+		})
+	}
+}
@@ -10,17 +10,20 @@ import (
 
 // TestKV keeps data in memory. It should only be used for unit tests.
 type TestKV struct {
-	Data  map[string]string
-	mu    sync.Mutex
-	locks map[string]sync.Locker
+	Data      map[string]string
+	mu        sync.Mutex
+	revision  int64
+	revisions map[string]int64
+	locker    localLocker
+	watchers  localWatchers
 }
 
 // NewTestKV creates a new in key-value backend for tests.
 // Snapshots are loaded to set the initial state.
 func NewTestKV() *TestKV {
 	kv := &TestKV{
-		Data:  make(map[string]string),
-		locks: make(map[string]sync.Locker),
+		Data:      make(map[string]string),
+		revisions: make(map[string]int64),
 	}
 
 	return kv
@@ -35,11 +38,20 @@ func (t *TestKV) Put(ctx context.Context, key, value string) error {
 		return err
 	}
 	t.mu.Lock()
-	t.Data[key] = value
+	t.put(key, value)
 	t.mu.Unlock()
 	return nil
 }
 
+// put stores a value and increments the revision. The mutex must be held.
+func (t *TestKV) put(key, value string) int64 {
+	t.revision++
+	t.Data[key] = value
+	t.revisions[key] = t.revision
+	t.notify(Event{Type: EventPut, Key: key, Value: value, Revision: t.revision})
+	return t.revision
+}
+
 // Get returns a value from the in memory store.
 func (t *TestKV) Get(ctx context.Context, key string) (string, error) {
 	if err := ctx.Err(); err != nil {
@@ -54,6 +66,96 @@ func (t *TestKV) Get(ctx context.Context, key string) (string, error) {
 	return v, nil
 }
 
+// GetRevision gets a value and the revision it was last modified at.
+func (t *TestKV) GetRevision(ctx context.Context, key string) (string, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return "", 0, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	v, ok := t.Data[key]
+	if !ok {
+		return "", 0, &NotFoundError{key}
+	}
+	return v, t.revisionOf(key), nil
+}
+
+// PutRevision adds or overwrites a key if the key was last modified at
+// revision.
+func (t *TestKV) PutRevision(ctx context.Context, key, value string, revision int64) (int64, error) {
+	if key == "" {
+		return 0, errors.New("key is empty")
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.revisionOf(key) != revision {
+		return 0, &ConflictError{Key: key, Revision: revision}
+	}
+	return t.put(key, value), nil
+}
+
+// Txn applies operations atomically. All puts share the revision of the
+// transaction.
+func (t *TestKV) Txn(ctx context.Context, ops ...Op) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	keys := make(map[string]bool)
+	for _, op := range ops {
+		if op.Key == "" {
+			return 0, errors.New("key is empty")
+		}
+		if keys[op.Key] {
+			return 0, errors.Errorf("duplicate key in transaction: %s", op.Key)
+		}
+		keys[op.Key] = true
+		if op.Type != OpPut && op.Type != OpDelete {
+			return 0, errors.Errorf("unsupported operation %d: %s", op.Type, op.Key)
+		}
+		if op.CheckRevision && t.revisionOf(op.Key) != op.Revision {
+			return 0, &ConflictError{Key: op.Key, Revision: op.Revision}
+		}
+	}
+
+	t.revision++
+	for _, op := range ops {
+		switch op.Type {
+		case OpPut:
+			t.Data[op.Key] = op.Value
+			t.revisions[op.Key] = t.revision
+			t.notify(Event{Type: EventPut, Key: op.Key, Value: op.Value, Revision: t.revision})
+		case OpDelete:
+			if _, ok := t.Data[op.Key]; !ok {
+				continue
+			}
+			delete(t.Data, op.Key)
+			delete(t.revisions, op.Key)
+			t.notify(Event{Type: EventDelete, Key: op.Key, Revision: t.revision})
+		}
+	}
+	return t.revision, nil
+}
+
+// revisionOf returns the revision a key was last modified at, 0 if the key
+// does not exist. Keys set directly in Data are assigned a revision. The
+// mutex must be held.
+func (t *TestKV) revisionOf(key string) int64 {
+	if _, ok := t.Data[key]; !ok {
+		return 0
+	}
+	if _, ok := t.revisions[key]; !ok {
+		t.revision++
+		t.revisions[key] = t.revision
+	}
+	return t.revisions[key]
+}
+
 // Delete removes a key from the in memory store.
 func (t *TestKV) Delete(ctx context.Context, key string) error {
 	if err := ctx.Err(); err != nil {
@@ -67,6 +169,9 @@ func (t *TestKV) Delete(ctx context.Context, key string) error {
 	}
 	t.mu.Lock()
 	delete(t.Data, key)
+	delete(t.revisions, key)
+	t.revision++
+	t.notify(Event{Type: EventDelete, Key: key, Revision: t.revision})
 	t.mu.Unlock()
 	return nil
 }
@@ -94,24 +199,47 @@ func (t *TestKV) List(ctx context.Context, root string) (map[string]string, erro
 	return out, nil
 }
 
-// Lock locks a key on the test kv. The key is locked for concurrent access
-// until unlocked by calling the returned function.
-func (t *TestKV) Lock(ctx context.Context, key string) (func(), error) {
+// ListRevisions lists keys in the test store with the revisions they were
+// last modified at.
+func (t *TestKV) ListRevisions(ctx context.Context, root string) (map[string]RevisionValue, error) {
 	if err := ctx.Err(); err != nil {
-		return func() {}, err
+		return nil, err
+	}
+
+	if !strings.HasSuffix(root, "/") {
+		root = root + "/"
 	}
 
+	out := make(map[string]RevisionValue)
 	t.mu.Lock()
-	locker, exists := t.locks[key]
-	if !exists {
-		locker = new(sync.Mutex)
-		t.locks[key] = locker
+	for k, v := range t.Data {
+		if strings.HasPrefix(k, root) {
+			key := strings.TrimPrefix(k, root)
+			out[key] = RevisionValue{Value: v, Revision: t.revisionOf(k)}
+		}
 	}
 	t.mu.Unlock()
 
-	locker.Lock()
+	return out, nil
+}
 
-	return locker.Unlock, nil
+// Lock locks a key on the test kv. The key is locked for concurrent access
+// until unlocked by calling the returned function. Returns an error if the
+// context is cancelled before the lock is acquired.
+func (t *TestKV) Lock(ctx context.Context, key string) (func(), error) {
+	return t.locker.Lock(ctx, key)
+}
+
+// Watch sends changes to keys with prefix made through the TestKV. Changes
+// made by modifying Data directly are not sent.
+func (t *TestKV) Watch(ctx context.Context, prefix string) (<-chan Event, error) {
+	return t.watchers.Watch(ctx, prefix)
+}
+
+// notify queues an event for every watch on the key. The mutex must be held
+// so events are queued in the order they were made.
+func (t *TestKV) notify(e Event) {
+	t.watchers.notify(e)
 }
 
 // Copy returns a copy of the TestKV, including its data. This is meant for
@@ -124,7 +252,13 @@ func (t *TestKV) Copy() *TestKV {
 	for k, v := range t.Data {
 		newData[k] = v
 	}
+	newRevisions := make(map[string]int64)
+	for k, v := range t.revisions {
+		newRevisions[k] = v
+	}
 	return &TestKV{
-		Data: newData,
+		Data:      newData,
+		revision:  t.revision,
+		revisions: newRevisions,
 	}
 }
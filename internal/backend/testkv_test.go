@@ -3,8 +3,10 @@ package backend
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // NOTE(akupila): megacheck doesn't seem to see that this is used in the tests
@@ -40,3 +42,137 @@ func TestTestKVCopy(t *testing.T) {
 	assert.Len(t, initial.Data, 3)
 	assert.Len(t, copied.Data, 2)
 }
+
+func TestTestKVRevision(t *testing.T) {
+	kv := NewTestKV()
+	ctx := context.Background()
+
+	_, _, err := kv.GetRevision(ctx, "foo")
+	assert.True(t, IsNotFound(err))
+
+	// Revision 0 creates a key
+	rev, err := kv.PutRevision(ctx, "foo", "foo", 0)
+	require.NoError(t, err)
+	_, err = kv.PutRevision(ctx, "foo", "foo", 0)
+	assert.True(t, IsConflict(err))
+
+	value, got, err := kv.GetRevision(ctx, "foo")
+	require.NoError(t, err)
+	assert.Equal(t, "foo", value)
+	assert.Equal(t, rev, got)
+
+	// Writing the read revision succeeds once
+	next, err := kv.PutRevision(ctx, "foo", "bar", rev)
+	require.NoError(t, err)
+	assert.True(t, next > rev)
+	_, err = kv.PutRevision(ctx, "foo", "baz", rev)
+	assert.True(t, IsConflict(err))
+
+	// Put modifies the revision
+	require.NoError(t, kv.Put(ctx, "foo", "baz"))
+	_, err = kv.PutRevision(ctx, "foo", "foo", next)
+	assert.True(t, IsConflict(err))
+
+	// Keys set directly are assigned a revision
+	kv.Data["bar"] = "bar"
+	_, rev, err = kv.GetRevision(ctx, "bar")
+	require.NoError(t, err)
+	_, err = kv.PutRevision(ctx, "bar", "foo", rev)
+	require.NoError(t, err)
+
+	// Listed keys have their revisions
+	require.NoError(t, kv.Put(ctx, "dir/foo", "foo"))
+	_, rev, err = kv.GetRevision(ctx, "dir/foo")
+	require.NoError(t, err)
+	listed, err := kv.ListRevisions(ctx, "dir")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]RevisionValue{"foo": {Value: "foo", Revision: rev}}, listed)
+
+	// Copies keep revisions
+	copied := kv.Copy()
+	_, rev, err = kv.GetRevision(ctx, "foo")
+	require.NoError(t, err)
+	_, copiedRev, err := copied.GetRevision(ctx, "foo")
+	require.NoError(t, err)
+	assert.Equal(t, rev, copiedRev)
+}
+
+func TestTestKVTxn(t *testing.T) {
+	kv := NewTestKV()
+	ctx := context.Background()
+	require.NoError(t, kv.Put(ctx, "foo", "foo"))
+	_, rev, err := kv.GetRevision(ctx, "foo")
+	require.NoError(t, err)
+
+	// Nothing is applied if a revision does not match
+	_, err = kv.Txn(ctx, PutOp("bar", "bar"), PutRevisionOp("foo", "bar", rev+1))
+	assert.True(t, IsConflict(err))
+	assert.Equal(t, map[string]string{"foo": "foo"}, kv.Data)
+
+	_, err = kv.Txn(ctx, PutOp("foo", "bar"), DeleteOp("foo"))
+	require.Error(t, err)
+	_, err = kv.Txn(ctx, Op{Type: OpType(-1), Key: "foo"})
+	require.Error(t, err)
+
+	txnRev, err := kv.Txn(ctx,
+		PutOp("bar", "bar"),
+		PutRevisionOp("baz", "baz", 0),
+		PutRevisionOp("foo", "bar", rev),
+		DeleteOp("missing"),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"foo": "bar", "bar": "bar", "baz": "baz"}, kv.Data)
+	for _, key := range []string{"foo", "bar", "baz"} {
+		_, got, err := kv.GetRevision(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, txnRev, got)
+	}
+
+	_, err = kv.Txn(ctx, DeleteOp("foo"), DeleteOp("bar"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"baz": "baz"}, kv.Data)
+
+	// Deletes can be conditional too
+	_, err = kv.Txn(ctx, DeleteRevisionOp("baz", txnRev+1))
+	assert.True(t, IsConflict(err))
+	_, err = kv.Txn(ctx, DeleteRevisionOp("baz", txnRev))
+	require.NoError(t, err)
+	assert.Empty(t, kv.Data)
+}
+
+func TestTestKVWatch(t *testing.T) {
+	kv := NewTestKV()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	events, err := kv.Watch(ctx, "foo/")
+	require.NoError(t, err)
+
+	require.NoError(t, kv.Put(ctx, "foo/a", "a"))
+	require.NoError(t, kv.Put(ctx, "bar/a", "a"))
+	rev, err := kv.Txn(ctx, PutOp("foo/b", "b"), DeleteOp("foo/a"), DeleteOp("foo/missing"))
+	require.NoError(t, err)
+	require.NoError(t, kv.Delete(ctx, "foo/b"))
+
+	expected := []Event{
+		{Type: EventPut, Key: "foo/a", Value: "a", Revision: 1},
+		{Type: EventPut, Key: "foo/b", Value: "b", Revision: rev},
+		{Type: EventDelete, Key: "foo/a", Revision: rev},
+		{Type: EventDelete, Key: "foo/b", Revision: rev + 1},
+	}
+	for _, e := range expected {
+		select {
+		case got := <-events:
+			assert.Equal(t, e, got)
+		case <-time.After(time.Second):
+			t.Fatalf("no event for %s", e.Key)
+		}
+	}
+
+	cancel()
+	select {
+	case _, ok := <-events:
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("watch not closed")
+	}
+}
@@ -0,0 +1,94 @@
+// +build integration
+
+package backend
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type TxnReaderWriter interface {
+	Txn
+	RevisionReaderWriter
+}
+
+var txns = []struct {
+	Name string
+	New  func(t *testing.T) TxnReaderWriter
+}{
+	{
+		Name: "ETCD",
+		New: func(t *testing.T) TxnReaderWriter {
+			etcd, err := NewETCDClient([]string{testETCDEndpoint}, 1*time.Second)
+			require.NoError(t, err)
+			return etcd
+		},
+	},
+	{
+		Name: "TestKV",
+		New: func(t *testing.T) TxnReaderWriter {
+			return NewTestKV()
+		},
+	},
+	{
+		Name: "Bolt",
+		New: func(t *testing.T) TxnReaderWriter {
+			return newTestBolt(t)
+		},
+	},
+}
+
+func TestTxn(t *testing.T) {
+	for _, target := range txns {
+		t.Run(target.Name, func(t *testing.T) {
+			client := target.New(t)
+			ctx := context.Background()
+
+			// Delete keys in case they previously existed
+			_ = client.Delete(ctx, "/txn/foo")
+			_ = client.Delete(ctx, "/txn/bar")
+
+			rev, err := client.PutRevision(ctx, "/txn/foo", "foo", 0)
+			require.NoError(t, err)
+
+			_, err = client.Txn(ctx,
+				PutOp("/txn/bar", "bar"),
+				PutRevisionOp("/txn/foo", "bar", rev+1),
+			)
+			require.Error(t, err)
+			assert.True(t, IsConflict(err))
+			_, _, err = client.GetRevision(ctx, "/txn/bar")
+			assert.True(t, IsNotFound(err))
+
+			txnRev, err := client.Txn(ctx,
+				PutOp("/txn/bar", "bar"),
+				PutRevisionOp("/txn/foo", "bar", rev),
+			)
+			require.NoError(t, err)
+
+			value, got, err := client.GetRevision(ctx, "/txn/foo")
+			require.NoError(t, err)
+			assert.Equal(t, "bar", value)
+			assert.Equal(t, txnRev, got)
+			value, got, err = client.GetRevision(ctx, "/txn/bar")
+			require.NoError(t, err)
+			assert.Equal(t, "bar", value)
+			assert.Equal(t, txnRev, got)
+
+			_, err = client.Txn(ctx, DeleteOp("/txn/foo"), DeleteOp("/txn/bar"))
+			require.NoError(t, err)
+			_, _, err = client.GetRevision(ctx, "/txn/foo")
+			assert.True(t, IsNotFound(err))
+
+			if closer, ok := client.(io.Closer); ok {
+				err := closer.Close()
+				require.NoError(t, err)
+			}
+		})
+	}
+}
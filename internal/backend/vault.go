@@ -2,19 +2,63 @@ package backend
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	vaultapi "github.com/hashicorp/vault/api"
 	"github.com/pkg/errors"
 )
 
-// Vault implemnts KV by storing values in Vault as generic secrets.
-// Only the generic secret backend is supported.
+// Vault implemnts KV by storing values in a Vault KV secret engine. Both
+// version 1 (including the generic secret backend) and version 2 of the KV
+// secret engine are supported.
 type Vault struct {
-	client *vaultapi.Client
+	client    *vaultapi.Client
+	conf      VaultConfig
+	mount     string
+	kvVersion int
+	// mu guards the token of the client, which is replaced when logging in
+	// again.
+	mu sync.RWMutex
 }
 
-const vaultDataKey = "data"
+// VaultConfig configures how the Vault client connects and authenticates to
+// Vault.
+type VaultConfig struct {
+	// Address is the address of the Vault server.
+	Address string
+	// Mount is the path the KV secret engine is mounted at. Defaults to
+	// secret.
+	Mount string
+	// KVVersion is the version of the KV secret engine, 1 or 2. The version
+	// is read from the mount if it is 0.
+	KVVersion int
+	// Token is the token to authenticate with.
+	Token string
+	// TokenFile is a file to read the token to authenticate with from. The
+	// file is read again if the token can no longer be renewed.
+	TokenFile string
+	// AppRoleID and AppRoleSecretID log in with the AppRole auth method.
+	AppRoleID       string
+	AppRoleSecretID string
+	// AppRoleMount is the path the AppRole auth method is mounted at.
+	// Defaults to approle.
+	AppRoleMount string
+}
+
+const (
+	vaultDataKey          = "data"
+	defaultVaultMount     = "secret"
+	defaultVaultAppRole   = "approle"
+	vaultRenewRetryPeriod = 10 * time.Second
+)
 
 // wrapVaultData wraps a string in a map to be stored in vault.
 // This is because Vault can only store map[string]interface{} but we want to
@@ -36,7 +80,8 @@ func unwrapVaultData(input map[string]interface{}) (string, error) {
 
 // NewVaultClient creates a new Vault client and connects to the Vault server
 // The environment variable VAULT_TOKEN is read automatically to authenticate
-// the client.
+// the client. Values are stored in the version 1 KV secret engine mounted at
+// secret.
 // Returns an error if the address is not in a valid url.
 func NewVaultClient(address string) (*Vault, error) {
 	if address == "" {
@@ -49,12 +94,149 @@ func NewVaultClient(address string) (*Vault, error) {
 		return nil, err
 	}
 	return &Vault{
-		client: cli,
+		client:    cli,
+		conf:      VaultConfig{Address: address},
+		mount:     defaultVaultMount,
+		kvVersion: 1,
 	}, nil
 }
 
-// Put stores a generic secret in Vault. If the key already exists it is
-// overwritten.
+// NewVault creates a Vault client and logs in. The client authenticates with
+// AppRole if an AppRole ID is set, otherwise with the token file or token. The
+// environment variable VAULT_TOKEN is used if no auth method is configured.
+func NewVault(conf VaultConfig) (*Vault, error) {
+	if conf.Mount == "" {
+		conf.Mount = defaultVaultMount
+	}
+	conf.Mount = strings.Trim(conf.Mount, "/")
+	if conf.AppRoleMount == "" {
+		conf.AppRoleMount = defaultVaultAppRole
+	}
+	if conf.KVVersion < 0 || conf.KVVersion > 2 {
+		return nil, errors.Errorf("unsupported kv version %d", conf.KVVersion)
+	}
+
+	v, err := NewVaultClient(conf.Address)
+	if err != nil {
+		return nil, err
+	}
+	v.conf = conf
+	v.mount = conf.Mount
+
+	if err := v.login(); err != nil {
+		return nil, err
+	}
+
+	v.kvVersion = conf.KVVersion
+	if v.kvVersion == 0 {
+		v.kvVersion, err = v.mountVersion()
+		if err != nil {
+			return nil, errors.Wrap(err, "could not detect kv version, set it explicitly")
+		}
+	}
+
+	return v, nil
+}
+
+// login authenticates the client with the configured auth method.
+func (v *Vault) login() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	switch {
+	case v.conf.AppRoleID != "":
+		// Log in without the previous token, it may have expired
+		v.client.ClearToken()
+		s, err := v.client.Logical().Write(fmt.Sprintf("auth/%s/login", v.conf.AppRoleMount), map[string]interface{}{
+			"role_id":   v.conf.AppRoleID,
+			"secret_id": v.conf.AppRoleSecretID,
+		})
+		if err != nil {
+			return errors.Wrap(err, "could not log in with approle")
+		}
+		if s == nil || s.Auth == nil || s.Auth.ClientToken == "" {
+			return errors.New("approle login returned no token")
+		}
+		v.client.SetToken(s.Auth.ClientToken)
+	case v.conf.TokenFile != "":
+		raw, err := ioutil.ReadFile(v.conf.TokenFile)
+		if err != nil {
+			return errors.Wrap(err, "could not read token file")
+		}
+		token := strings.TrimSpace(string(raw))
+		if token == "" {
+			return errors.Errorf("token file %s is empty", v.conf.TokenFile)
+		}
+		v.client.SetToken(token)
+	case v.conf.Token != "":
+		v.client.SetToken(v.conf.Token)
+	}
+	// The client reads VAULT_TOKEN if no auth method is set
+	return nil
+}
+
+// mountVersion returns the version of the KV secret engine at the mount.
+func (v *Vault) mountVersion() (int, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	mounts, err := v.client.Sys().ListMounts()
+	if err != nil {
+		return 0, errors.Wrap(err, "could not list mounts")
+	}
+	m, ok := mounts[v.mount+"/"]
+	if !ok {
+		return 0, errors.Errorf("no secret engine mounted at %s", v.mount)
+	}
+	switch m.Type {
+	case "generic":
+		return 1, nil
+	case "kv":
+		if m.Options["version"] == "2" {
+			return 2, nil
+		}
+		return 1, nil
+	default:
+		return 0, errors.Errorf("secret engine at %s is %s, not kv", v.mount, m.Type)
+	}
+}
+
+// dataPath returns the path values are read from and written to.
+func (v *Vault) dataPath(key string) string {
+	if v.kvVersion == 2 {
+		return fmt.Sprintf("%s/data/%s", v.mount, key)
+	}
+	return fmt.Sprintf("%s/%s", v.mount, key)
+}
+
+// metadataPath returns the path keys are listed and permanently deleted at.
+func (v *Vault) metadataPath(key string) string {
+	if v.kvVersion == 2 {
+		return fmt.Sprintf("%s/metadata/%s", v.mount, key)
+	}
+	return fmt.Sprintf("%s/%s", v.mount, key)
+}
+
+// secretValue returns the value of a secret that was read. Returns false if
+// the secret does not exist or the version read was deleted.
+func (v *Vault) secretValue(s *vaultapi.Secret) (string, bool, error) {
+	if s == nil {
+		return "", false, nil
+	}
+	data := s.Data
+	if v.kvVersion == 2 {
+		data, _ = s.Data["data"].(map[string]interface{})
+		if data == nil {
+			return "", false, nil
+		}
+	}
+	value, err := unwrapVaultData(data)
+	if err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+// Put stores a secret in Vault. If the key already exists it is overwritten,
+// with KV version 2 a new version is created.
 // If the target data is other keys than the vaultDataKey the entire map is
 // overwritten.
 func (v *Vault) Put(ctx context.Context, key, value string) error {
@@ -65,34 +247,83 @@ func (v *Vault) Put(ctx context.Context, key, value string) error {
 		return errors.New("key is empty")
 	}
 	data := wrapVaultData(value)
-	_, err := v.client.Logical().Write(fmt.Sprintf("secret/%s", key), data)
+	if v.kvVersion == 2 {
+		data = map[string]interface{}{"data": data}
+	}
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	_, err := v.client.Logical().Write(v.dataPath(key), data)
 	if err != nil {
 		return errors.Wrap(err, "could not write data")
 	}
 	return nil
 }
 
-// Get gets a generic secret from Vault.
+// Get gets a secret from Vault. With KV version 2 the latest version is read.
 // Returns NotFoundError if the secret does not exist.
 func (v *Vault) Get(ctx context.Context, key string) (string, error) {
 	if err := ctx.Err(); err != nil {
 		return "", err
 	}
-	s, err := v.client.Logical().Read(fmt.Sprintf("secret/%s", key))
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	s, err := v.client.Logical().Read(v.dataPath(key))
 	if err != nil {
 		return "", errors.Wrap(err, "could not read value")
 	}
-	if s == nil {
+	value, ok, err := v.secretValue(s)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
 		return "", &NotFoundError{key}
 	}
-	value, err := unwrapVaultData(s.Data)
+	return value, nil
+}
+
+// GetVersion gets a version of a secret. It is only supported by KV version
+// 2. Returns NotFoundError if the version does not exist or was deleted.
+func (v *Vault) GetVersion(ctx context.Context, key string, version int) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if v.kvVersion != 2 {
+		return "", errors.New("versions are only supported by kv version 2")
+	}
+	if version < 1 {
+		return "", errors.Errorf("invalid version %d", version)
+	}
+
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	r := v.client.NewRequest("GET", "/v1/"+v.dataPath(key))
+	r.Params.Set("version", strconv.Itoa(version))
+	res, err := v.client.RawRequest(r)
+	if res != nil {
+		defer res.Body.Close() // nolint: errcheck
+	}
+	if res != nil && res.StatusCode == http.StatusNotFound {
+		return "", &NotFoundError{key}
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "could not read value")
+	}
+	s, err := vaultapi.ParseSecret(res.Body)
+	if err != nil {
+		return "", errors.Wrap(err, "could not parse value")
+	}
+	value, ok, err := v.secretValue(s)
 	if err != nil {
 		return "", err
 	}
+	if !ok {
+		return "", &NotFoundError{key}
+	}
 	return value, nil
 }
 
-// Delete deletes a generic secret from Vault.
+// Delete deletes a secret from Vault. With KV version 2 all versions of the
+// secret are deleted.
 // Returns NotFoundError if the secret does not exist.
 //
 // Since Vault doesn't return if the secret was actually deleted a check is
@@ -101,20 +332,179 @@ func (v *Vault) Delete(ctx context.Context, key string) error {
 	if err := ctx.Err(); err != nil {
 		return err
 	}
+	v.mu.RLock()
+	defer v.mu.RUnlock()
 	// Check if the value exists, Vault does not say if the value was actually
 	// deleted.
-	path := fmt.Sprintf("secret/%s", key)
-	s, err := v.client.Logical().Read(path)
+	s, err := v.client.Logical().Read(v.dataPath(key))
 	if err != nil {
 		return errors.Wrap(err, "could not check if value exists")
 	}
-	if s == nil {
+	if _, ok, err := v.secretValue(s); !ok && err == nil {
 		return &NotFoundError{key}
 	}
 
-	_, err = v.client.Logical().Delete(path)
+	_, err = v.client.Logical().Delete(v.metadataPath(key))
 	if err != nil {
 		return errors.Wrap(err, "could not delete key")
 	}
 	return nil
 }
+
+// List lists all secrets under a root key. Vault only lists keys, every
+// secret is read to get its value.
+func (v *Vault) List(ctx context.Context, root string) (map[string]string, error) {
+	if !strings.HasSuffix(root, "/") {
+		root = root + "/"
+	}
+	out := make(map[string]string)
+	if err := v.list(ctx, root, "", out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// list reads the secrets in a directory under root into out, descending into
+// subdirectories.
+func (v *Vault) list(ctx context.Context, root, dir string, out map[string]string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	v.mu.RLock()
+	s, err := v.client.Logical().List(v.metadataPath(root + dir))
+	v.mu.RUnlock()
+	if err != nil {
+		return errors.Wrapf(err, "could not list keys: %s", root+dir)
+	}
+	if s == nil {
+		return nil
+	}
+	keys, _ := s.Data["keys"].([]interface{})
+	for _, k := range keys {
+		name, ok := k.(string)
+		if !ok {
+			continue
+		}
+		if strings.HasSuffix(name, "/") {
+			if err := v.list(ctx, root, dir+name, out); err != nil {
+				return err
+			}
+			continue
+		}
+		value, err := v.Get(ctx, root+dir+name)
+		if IsNotFound(err) {
+			// Deleted after listing
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		out[dir+name] = value
+	}
+	return nil
+}
+
+// RunTokenRenewer renews the token of the client before it expires until the
+// context is cancelled. The client logs in again if the token can no longer
+// be renewed. Returns immediately if the token does not expire. Errors are
+// logged.
+func (v *Vault) RunTokenRenewer(ctx context.Context) {
+	for {
+		ttl, err := v.renewToken()
+		wait := ttl / 2
+		if err != nil {
+			log.Println(errors.Wrap(err, "could not renew vault token"))
+			wait = vaultRenewRetryPeriod
+		} else if ttl == 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// renewToken renews the token, or logs in again if the token can not be
+// renewed. The token is also replaced if its lookup fails, for example because
+// it expired, and if the lease granted on renewal is shorter than the retry
+// period as happens once the max TTL of the token is approached. Returns the
+// remaining time to live of the token, 0 if the token does not expire.
+func (v *Vault) renewToken() (time.Duration, error) {
+	ttl, renewable, err := v.lookupToken()
+	if err == nil {
+		if ttl == 0 {
+			return 0, nil
+		}
+		if !renewable {
+			err = errors.New("token is not renewable")
+		} else if ttl, err = v.renewSelf(); err == nil && ttl < vaultRenewRetryPeriod {
+			err = errors.Errorf("token expires in %s", ttl)
+		}
+		if err == nil {
+			return ttl, nil
+		}
+	}
+	if v.conf.AppRoleID == "" && v.conf.TokenFile == "" {
+		return 0, errors.Wrap(err, "no auth method to log in again is set")
+	}
+	if err := v.login(); err != nil {
+		return 0, err
+	}
+	ttl, _, err = v.lookupToken()
+	return ttl, err
+}
+
+// renewSelf renews the token and returns the lease duration granted.
+func (v *Vault) renewSelf() (time.Duration, error) {
+	v.mu.RLock()
+	s, err := v.client.Auth().Token().RenewSelf(0)
+	v.mu.RUnlock()
+	if err != nil {
+		return 0, errors.Wrap(err, "could not renew token")
+	}
+	if s == nil || s.Auth == nil {
+		return 0, errors.New("token renewal returned no auth data")
+	}
+	return time.Duration(s.Auth.LeaseDuration) * time.Second, nil
+}
+
+// lookupToken returns the remaining time to live of the token and whether it
+// can be renewed.
+func (v *Vault) lookupToken() (time.Duration, bool, error) {
+	v.mu.RLock()
+	s, err := v.client.Auth().Token().LookupSelf()
+	v.mu.RUnlock()
+	if err != nil {
+		return 0, false, errors.Wrap(err, "could not look up token")
+	}
+	if s == nil {
+		return 0, false, errors.New("token lookup returned no data")
+	}
+	ttl, err := vaultDuration(s.Data["ttl"])
+	if err != nil {
+		return 0, false, errors.Wrap(err, "could not read token ttl")
+	}
+	renewable, _ := s.Data["renewable"].(bool)
+	return ttl, renewable, nil
+}
+
+// vaultDuration converts a duration in seconds in a Vault response to a
+// duration.
+func vaultDuration(v interface{}) (time.Duration, error) {
+	switch n := v.(type) {
+	case nil:
+		return 0, nil
+	case json.Number:
+		seconds, err := n.Int64()
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(seconds) * time.Second, nil
+	case float64:
+		return time.Duration(n) * time.Second, nil
+	default:
+		return 0, errors.Errorf("unexpected duration %v", v)
+	}
+}
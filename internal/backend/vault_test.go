@@ -3,12 +3,16 @@
 package backend
 
 import (
+	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 
 	vaultapi "github.com/hashicorp/vault/api"
 	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -101,3 +105,250 @@ func TestNewVaultClient(t *testing.T) {
 		})
 	}
 }
+
+// newVaultRootClient returns a Vault client authenticated with the root token
+// of the test Vault.
+func newVaultRootClient(t *testing.T) *vaultapi.Client {
+	t.Helper()
+	cli, err := vaultapi.NewClient(&vaultapi.Config{
+		Address: testVaultEndpoint,
+	})
+	require.NoError(t, err)
+	cli.SetToken(os.Getenv("VAULT_TEST_ROOT_TOKEN"))
+	return cli
+}
+
+// mountTestKV mounts a new KV secret engine of a version at path.
+func mountTestKV(t *testing.T, path string, version int) {
+	t.Helper()
+	cli := newVaultRootClient(t)
+	_ = cli.Sys().Unmount(path)
+	err := cli.Sys().Mount(path, &vaultapi.MountInput{
+		Type:    "kv",
+		Options: map[string]string{"version": fmt.Sprintf("%d", version)},
+	})
+	require.NoError(t, err)
+}
+
+func TestVaultKVVersions(t *testing.T) {
+	for _, version := range []int{1, 2} {
+		t.Run(fmt.Sprintf("V%d", version), func(t *testing.T) {
+			mount := fmt.Sprintf("fragments-kv%d", version)
+			mountTestKV(t, mount, version)
+			ctx := context.Background()
+
+			v, err := NewVault(VaultConfig{
+				Address: testVaultEndpoint,
+				Token:   os.Getenv("VAULT_TEST_ROOT_TOKEN"),
+				Mount:   mount,
+			})
+			require.NoError(t, err)
+			assert.Equal(t, version, v.kvVersion)
+
+			require.NoError(t, v.Put(ctx, "foo/bar", "first"))
+			require.NoError(t, v.Put(ctx, "foo/bar", "second"))
+			require.NoError(t, v.Put(ctx, "foo/baz/qux", "qux"))
+
+			value, err := v.Get(ctx, "foo/bar")
+			require.NoError(t, err)
+			assert.Equal(t, "second", value)
+
+			values, err := v.List(ctx, "foo")
+			require.NoError(t, err)
+			assert.Equal(t, map[string]string{"bar": "second", "baz/qux": "qux"}, values)
+
+			if version == 2 {
+				value, err = v.GetVersion(ctx, "foo/bar", 1)
+				require.NoError(t, err)
+				assert.Equal(t, "first", value)
+				_, err = v.GetVersion(ctx, "foo/bar", 3)
+				assert.True(t, IsNotFound(err))
+			} else {
+				_, err = v.GetVersion(ctx, "foo/bar", 1)
+				assert.Error(t, err)
+			}
+
+			require.NoError(t, v.Delete(ctx, "foo/bar"))
+			_, err = v.Get(ctx, "foo/bar")
+			assert.True(t, IsNotFound(err))
+			values, err = v.List(ctx, "foo")
+			require.NoError(t, err)
+			assert.Equal(t, map[string]string{"baz/qux": "qux"}, values)
+		})
+	}
+
+	_, err := NewVault(VaultConfig{
+		Address: testVaultEndpoint,
+		Token:   os.Getenv("VAULT_TEST_ROOT_TOKEN"),
+		Mount:   "fragments-missing",
+	})
+	assert.Error(t, err)
+}
+
+func TestVaultAuth(t *testing.T) {
+	mountTestKV(t, "fragments-auth", 2)
+	root := newVaultRootClient(t)
+	ctx := context.Background()
+
+	err := root.Sys().PutPolicy("fragments-test", `path "fragments-auth/*" { capabilities = ["create", "read", "update", "delete", "list"] }`)
+	require.NoError(t, err)
+
+	// AppRole
+	_ = root.Sys().EnableAuth("approle", "approle", "")
+	_, err = root.Logical().Write("auth/approle/role/fragments-test", map[string]interface{}{
+		"policies":  "fragments-test",
+		"token_ttl": "1h",
+	})
+	require.NoError(t, err)
+	s, err := root.Logical().Read("auth/approle/role/fragments-test/role-id")
+	require.NoError(t, err)
+	roleID := s.Data["role_id"].(string)
+	s, err = root.Logical().Write("auth/approle/role/fragments-test/secret-id", nil)
+	require.NoError(t, err)
+	secretID := s.Data["secret_id"].(string)
+
+	v, err := NewVault(VaultConfig{
+		Address:         testVaultEndpoint,
+		Mount:           "fragments-auth",
+		KVVersion:       2,
+		AppRoleID:       roleID,
+		AppRoleSecretID: secretID,
+	})
+	require.NoError(t, err)
+	require.NoError(t, v.Put(ctx, "approle", "approle"))
+
+	ttl, err := v.renewToken()
+	require.NoError(t, err)
+	assert.True(t, ttl > 0)
+
+	// Logging in again replaces the token
+	token := v.client.Token()
+	require.NoError(t, v.login())
+	assert.NotEqual(t, token, v.client.Token())
+	value, err := v.Get(ctx, "approle")
+	require.NoError(t, err)
+	assert.Equal(t, "approle", value)
+
+	_, err = NewVault(VaultConfig{
+		Address:         testVaultEndpoint,
+		Mount:           "fragments-auth",
+		KVVersion:       2,
+		AppRoleID:       roleID,
+		AppRoleSecretID: "invalid",
+	})
+	assert.Error(t, err)
+
+	// Token file
+	renewable := true
+	s, err = root.Auth().Token().Create(&vaultapi.TokenCreateRequest{
+		Policies:  []string{"fragments-test"},
+		TTL:       "1h",
+		Renewable: &renewable,
+	})
+	require.NoError(t, err)
+	tokenFile, err := ioutil.TempFile("", "fragments-vault-token")
+	require.NoError(t, err)
+	defer os.Remove(tokenFile.Name()) // nolint: errcheck
+	_, err = tokenFile.WriteString(s.Auth.ClientToken + "\n")
+	require.NoError(t, err)
+	require.NoError(t, tokenFile.Close())
+
+	v, err = NewVault(VaultConfig{
+		Address:   testVaultEndpoint,
+		Mount:     "fragments-auth",
+		KVVersion: 2,
+		TokenFile: tokenFile.Name(),
+	})
+	require.NoError(t, err)
+	value, err = v.Get(ctx, "approle")
+	require.NoError(t, err)
+	assert.Equal(t, "approle", value)
+	ttl, err = v.renewToken()
+	require.NoError(t, err)
+	assert.True(t, ttl > 0)
+
+	// Root tokens don't expire and are not renewed
+	v, err = NewVault(VaultConfig{
+		Address:   testVaultEndpoint,
+		Mount:     "fragments-auth",
+		KVVersion: 2,
+		Token:     os.Getenv("VAULT_TEST_ROOT_TOKEN"),
+	})
+	require.NoError(t, err)
+	ttl, err = v.renewToken()
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), ttl)
+	done := make(chan struct{})
+	go func() {
+		v.RunTokenRenewer(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("renewer did not stop for a token that does not expire")
+	}
+}
+
+func TestVaultTokenExpired(t *testing.T) {
+	mountTestKV(t, "fragments-expiry", 2)
+	root := newVaultRootClient(t)
+	ctx := context.Background()
+
+	err := root.Sys().PutPolicy("fragments-expiry", `path "fragments-expiry/*" { capabilities = ["create", "read", "update", "delete", "list"] }`)
+	require.NoError(t, err)
+	_ = root.Sys().EnableAuth("approle", "approle", "")
+	_, err = root.Logical().Write("auth/approle/role/fragments-expiry", map[string]interface{}{
+		"policies":      "fragments-expiry",
+		"token_ttl":     "1h",
+		"token_max_ttl": "3s",
+	})
+	require.NoError(t, err)
+	s, err := root.Logical().Read("auth/approle/role/fragments-expiry/role-id")
+	require.NoError(t, err)
+	roleID := s.Data["role_id"].(string)
+	s, err = root.Logical().Write("auth/approle/role/fragments-expiry/secret-id", nil)
+	require.NoError(t, err)
+	secretID := s.Data["secret_id"].(string)
+
+	v, err := NewVault(VaultConfig{
+		Address:         testVaultEndpoint,
+		Mount:           "fragments-expiry",
+		KVVersion:       2,
+		AppRoleID:       roleID,
+		AppRoleSecretID: secretID,
+	})
+	require.NoError(t, err)
+	require.NoError(t, v.Put(ctx, "foo", "foo"))
+
+	// Renewing only grants the time left until the max TTL, the client logs
+	// in again instead
+	token := v.client.Token()
+	_, err = v.renewToken()
+	require.NoError(t, err)
+	assert.NotEqual(t, token, v.client.Token())
+
+	// The token expired, it can no longer be looked up or renewed
+	token = v.client.Token()
+	time.Sleep(4 * time.Second)
+	_, _, err = v.lookupToken()
+	require.Error(t, err)
+	_, err = v.renewToken()
+	require.NoError(t, err)
+	assert.NotEqual(t, token, v.client.Token())
+	value, err := v.Get(ctx, "foo")
+	require.NoError(t, err)
+	assert.Equal(t, "foo", value)
+
+	// Without an auth method to log in again the expired token is an error
+	expired, err := NewVault(VaultConfig{
+		Address:   testVaultEndpoint,
+		Mount:     "fragments-expiry",
+		KVVersion: 2,
+		Token:     token,
+	})
+	if err == nil {
+		_, err = expired.renewToken()
+	}
+	assert.Error(t, err)
+}
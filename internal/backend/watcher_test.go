@@ -0,0 +1,98 @@
+// +build integration
+
+package backend
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type WatcherWriter interface {
+	Watcher
+	Writer
+}
+
+var watchers = []struct {
+	Name string
+	New  func(t *testing.T) WatcherWriter
+}{
+	{
+		Name: "ETCD",
+		New: func(t *testing.T) WatcherWriter {
+			etcd, err := NewETCDClient([]string{testETCDEndpoint}, 1*time.Second)
+			require.NoError(t, err)
+			return etcd
+		},
+	},
+	{
+		Name: "TestKV",
+		New: func(t *testing.T) WatcherWriter {
+			return NewTestKV()
+		},
+	},
+	{
+		Name: "Bolt",
+		New: func(t *testing.T) WatcherWriter {
+			return newTestBolt(t)
+		},
+	},
+}
+
+func TestWatcherWatch(t *testing.T) {
+	for _, target := range watchers {
+		t.Run(target.Name, func(t *testing.T) {
+			client := target.New(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// Delete key in case it previously existed
+			_ = client.Delete(ctx, "/watch/foo")
+
+			events, err := client.Watch(ctx, "/watch/")
+			require.NoError(t, err)
+
+			require.NoError(t, client.Put(ctx, "/watch/foo", "foo"))
+			require.NoError(t, client.Put(ctx, "/other/foo", "foo"))
+			require.NoError(t, client.Delete(ctx, "/watch/foo"))
+
+			expected := []struct {
+				Type  EventType
+				Value string
+			}{
+				{Type: EventPut, Value: "foo"},
+				{Type: EventDelete},
+			}
+			var revision int64
+			for _, e := range expected {
+				select {
+				case got := <-events:
+					assert.Equal(t, e.Type, got.Type)
+					assert.Equal(t, "/watch/foo", got.Key)
+					assert.Equal(t, e.Value, got.Value)
+					assert.True(t, got.Revision > revision)
+					revision = got.Revision
+				case <-time.After(5 * time.Second):
+					t.Fatal("no event received")
+				}
+			}
+
+			cancel()
+			select {
+			case _, ok := <-events:
+				assert.False(t, ok)
+			case <-time.After(5 * time.Second):
+				t.Fatal("watch not closed")
+			}
+
+			if closer, ok := client.(io.Closer); ok {
+				err := closer.Close()
+				require.NoError(t, err)
+			}
+		})
+	}
+}
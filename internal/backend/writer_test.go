@@ -51,6 +51,16 @@ var writers = []struct {
 			return &testkvValidator{data: testkv.Data}
 		},
 	},
+	{
+		Name: "Bolt",
+		New: func(t *testing.T) Writer {
+			return newTestBolt(t)
+		},
+		NewValidator: func(t *testing.T, client Writer) validator {
+			b := client.(*testBolt)
+			return &boltValidator{db: b.db}
+		},
+	},
 }
 
 func TestWriterWrite(t *testing.T) {
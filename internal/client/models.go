@@ -14,6 +14,8 @@ const (
 	ModelTypeFunction ModelType = "function"
 	// ModelTypeDeployment is the type for a deployment.
 	ModelTypeDeployment ModelType = "deployment"
+	// ModelTypeEnvironment is the type for an environment.
+	ModelTypeEnvironment ModelType = "environment"
 )
 
 // Model is a generic model on disk. It can represent any model type.
@@ -76,6 +78,8 @@ type FunctionAWSSpec struct {
 	Timeout int64 `json:"timeout,omitempty"`
 	// Memory is the memory in mb for the function
 	Memory int64 `json:"memory,omitempty"`
+	// Handler is the function entrypoint within the source
+	Handler string `json:"handler,omitempty"`
 }
 
 // Deployment is the configuration for a deployment on disk.
@@ -115,3 +119,58 @@ type DeploymentSpec struct {
 	// of the deployment. Every label must match for the function to be included.
 	FunctionLabels map[string]string `json:"function"`
 }
+
+// Environment is the configuration for an environment on disk.
+type Environment interface {
+	Environment() *EnvironmentSpec
+}
+
+type environmentModel struct {
+	file string
+	meta *Meta
+	spec *EnvironmentSpec
+}
+
+func (e *environmentModel) File() string                  { return e.file }
+func (e *environmentModel) Meta() *Meta                   { return e.meta }
+func (e *environmentModel) Type() ModelType               { return ModelTypeEnvironment }
+func (e *environmentModel) Environment() *EnvironmentSpec { return e.spec }
+func (e *environmentModel) testString() string {
+	meta, err := json.MarshalIndent(e.Meta(), "", "    ")
+	if err != nil {
+		return err.Error()
+	}
+	spec, err := json.MarshalIndent(e.Environment(), "", "    ")
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("environment\nfile: %s\nmeta: %s\nspec: %s", e.File(), meta, spec)
+}
+
+// EnvironmentSpec represents a target deployment environment.
+type EnvironmentSpec struct {
+	// Infrastructure is the infrastructure provider of the environment.
+	Infrastructure string `json:"infrastructure"`
+	// Credentials reference the credentials used to authenticate to the
+	// infrastructure provider.
+	Credentials *CredentialsSpec `json:"credentials"`
+	// AWS contains AWS specific environment configuration.
+	AWS *EnvironmentAWSSpec `json:"aws,omitempty"`
+}
+
+// EnvironmentAWSSpec contains AWS specific environment configuration.
+type EnvironmentAWSSpec struct {
+	// Region is the region functions are deployed to.
+	Region string `json:"region,omitempty"`
+	// Role is the ARN of the IAM role functions are executed as.
+	Role string `json:"role,omitempty"`
+}
+
+// CredentialsSpec references the username and password of an environment.
+// The values are never stored in the model itself.
+type CredentialsSpec struct {
+	// Username references the username.
+	Username *SecretRef `json:"username"`
+	// Password references the password.
+	Password *SecretRef `json:"password"`
+}
@@ -114,6 +114,8 @@ func parse(data []byte, filepath string) (Model, error) {
 		return parseFunction(raw, filepath)
 	case "deployment":
 		return parseDeployment(raw, filepath)
+	case "environment":
+		return parseEnvironment(raw, filepath)
 	default:
 		return nil, errors.Errorf("unknown model type %s", raw.Type)
 	}
@@ -165,3 +167,28 @@ func parseDeployment(raw *rawModel, filepath string) (*deploymentModel, error) {
 
 	return d, nil
 }
+
+// parseEnvironment parses an environment spec.
+func parseEnvironment(raw *rawModel, filepath string) (*environmentModel, error) {
+	e := &environmentModel{
+		file: filepath,
+		meta: raw.Meta,
+		spec: &EnvironmentSpec{},
+	}
+
+	if err := json.Unmarshal(raw.Spec, e.spec); err != nil {
+		return nil, errors.Wrap(err, "could not unmarshal environment model")
+	}
+
+	if e.spec.Credentials == nil {
+		return nil, errors.New("environment credentials not set")
+	}
+	if err := e.spec.Credentials.Username.validate(); err != nil {
+		return nil, errors.Wrap(err, "username")
+	}
+	if err := e.spec.Credentials.Password.validate(); err != nil {
+		return nil, errors.Wrap(err, "password")
+	}
+
+	return e, nil
+}
@@ -83,6 +83,7 @@ func TestLoad(t *testing.T) {
 						AWS: &FunctionAWSSpec{
 							Timeout: 3,
 							Memory:  256,
+							Handler: "index.handler",
 						},
 					},
 				},
@@ -113,6 +114,37 @@ func TestLoad(t *testing.T) {
 				},
 			},
 		},
+		{
+			TestName: "Valid environment (yml)",
+			File:     "testdata/load/environment.yml",
+			Models: []Model{
+				&environmentModel{
+					file: "testdata/load/environment.yml",
+					meta: &Meta{
+						Name: "prod",
+						Labels: map[string]string{
+							"stage": "prod",
+						},
+					},
+					spec: &EnvironmentSpec{
+						Infrastructure: "aws",
+						Credentials: &CredentialsSpec{
+							Username: &SecretRef{Env: "AWS_ACCESS_KEY_ID"},
+							Password: &SecretRef{Vault: "aws/prod/secret"},
+						},
+						AWS: &EnvironmentAWSSpec{
+							Region: "us-east-1",
+							Role:   "arn:aws:iam::123456789012:role/lambda",
+						},
+					},
+				},
+			},
+		},
+		{
+			TestName: "Invalid environment credentials",
+			File:     "testdata/load/environment-invalid-credentials.yml",
+			Error:    true,
+		},
 		{
 			TestName: "Valid function (json)",
 			File:     "testdata/load/function.json",
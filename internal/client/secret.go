@@ -0,0 +1,69 @@
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SecretRef references a secret value outside of the model. Exactly one of
+// the sources must be set.
+type SecretRef struct {
+	// Env is the name of an environment variable containing the value.
+	Env string `json:"env,omitempty"`
+	// File is the path of a file containing the value. A relative path is
+	// relative to the model file. Surrounding whitespace is trimmed.
+	File string `json:"file,omitempty"`
+	// Vault is a key in the server's secret store containing the value. The
+	// value is read by the server.
+	Vault string `json:"vault,omitempty"`
+}
+
+// validate checks that exactly one source is set.
+func (s *SecretRef) validate() error {
+	if s == nil {
+		return errors.New("secret reference not set")
+	}
+	n := 0
+	for _, v := range []string{s.Env, s.File, s.Vault} {
+		if v != "" {
+			n++
+		}
+	}
+	if n != 1 {
+		return errors.New("secret reference must set exactly one of env, file or vault")
+	}
+	return nil
+}
+
+// Resolve returns the value of an env or file reference. Relative files are
+// resolved from the directory of modelFile. Vault references are read by the
+// server and return an empty value.
+func (s *SecretRef) Resolve(modelFile string) (string, error) {
+	if err := s.validate(); err != nil {
+		return "", err
+	}
+	switch {
+	case s.Env != "":
+		v, ok := os.LookupEnv(s.Env)
+		if !ok {
+			return "", errors.Errorf("environment variable %s not set", s.Env)
+		}
+		return v, nil
+	case s.File != "":
+		path := s.File
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(modelFile), path)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", errors.Wrap(err, "could not read secret file")
+		}
+		return strings.TrimSpace(string(data)), nil
+	default:
+		return "", nil
+	}
+}
@@ -0,0 +1,73 @@
+package client
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretRefResolve(t *testing.T) {
+	require.NoError(t, os.Setenv("FRAGMENTS_TEST_SECRET", "env-value"))
+	defer os.Unsetenv("FRAGMENTS_TEST_SECRET") // nolint: errcheck
+
+	tests := []struct {
+		TestName string
+		Ref      *SecretRef
+		Value    string
+		Error    bool
+	}{
+		{
+			TestName: "Nil",
+			Error:    true,
+		},
+		{
+			TestName: "Empty",
+			Ref:      &SecretRef{},
+			Error:    true,
+		},
+		{
+			TestName: "Multiple",
+			Ref:      &SecretRef{Env: "FRAGMENTS_TEST_SECRET", Vault: "foo"},
+			Error:    true,
+		},
+		{
+			TestName: "Env",
+			Ref:      &SecretRef{Env: "FRAGMENTS_TEST_SECRET"},
+			Value:    "env-value",
+		},
+		{
+			TestName: "Env not set",
+			Ref:      &SecretRef{Env: "FRAGMENTS_TEST_NONEXISTING"},
+			Error:    true,
+		},
+		{
+			TestName: "File",
+			Ref:      &SecretRef{File: "password.txt"},
+			Value:    "secret-value",
+		},
+		{
+			TestName: "File not found",
+			Ref:      &SecretRef{File: "nonexisting.txt"},
+			Error:    true,
+		},
+		{
+			TestName: "Vault",
+			Ref:      &SecretRef{Vault: "foo/bar"},
+			Value:    "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			value, err := test.Ref.Resolve("testdata/secret/environment.yml")
+			if test.Error {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.Value, value)
+		})
+	}
+}
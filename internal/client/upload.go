@@ -7,12 +7,15 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/fragments/fragments/internal/model"
 	"github.com/pkg/errors"
 )
 
 // Upload uploads data to a url. The upload is done through a http put.
 // Has a timeout of 1 minute
-func Upload(data io.Reader, url string) error {
+// In case the digest of data is set the Content-MD5 header is set, the target
+// rejects the upload if the data is corrupted.
+func Upload(data io.Reader, url string, digest *model.Digest) error {
 	client := &http.Client{
 		Timeout: 1 * time.Minute,
 	}
@@ -21,6 +24,9 @@ func Upload(data io.Reader, url string) error {
 	if err != nil {
 		return err
 	}
+	if digest != nil {
+		req.Header.Set("Content-MD5", digest.ContentMD5())
+	}
 	res, err := client.Do(req)
 	if err != nil {
 		return errors.Wrap(err, "upload request failed")
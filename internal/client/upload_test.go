@@ -7,12 +7,13 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/fragments/fragments/internal/model"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 func TestUpload(t *testing.T) {
-	err := Upload(nil, "not a valid url")
+	err := Upload(nil, "not a valid url", nil)
 	require.Error(t, err)
 
 	tests := []struct {
@@ -44,13 +45,16 @@ func TestUpload(t *testing.T) {
 	for _, test := range tests {
 		t.Run(test.TestName, func(t *testing.T) {
 			data := test.GetData()
+			digest, err := model.NewDigest(bytes.NewReader(data))
+			require.NoError(t, err)
 			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				w.WriteHeader(test.Response)
 				assert.Equal(t, http.MethodPut, r.Method)
 				assert.EqualValues(t, len(data), r.ContentLength)
+				assert.Equal(t, digest.ContentMD5(), r.Header.Get("Content-MD5"))
 			}))
 
-			err := Upload(bytes.NewReader(data), ts.URL)
+			err = Upload(bytes.NewReader(data), ts.URL, digest)
 			ts.Close()
 			if test.Error {
 				require.Error(t, err)
@@ -5,19 +5,97 @@ package filestore
 
 import (
 	"context"
-	"os"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/fragments/fragments/internal/model"
+	"github.com/pkg/errors"
 )
 
 // SourceTarget is a target that accepts source code uploads.
 type SourceTarget interface {
 	// NewUploadURL generates a new URL that the source can be uploaded to.
 	NewUploadURL(name string) (string, error)
-	// Persist persists an uploaded file.
-	Persist(ctx context.Context, name string) error
+	// Persist persists an uploaded file under name. An existing file with
+	// the name is replaced. The upload is verified against the digest the
+	// client declared, returns DigestMismatchError if it does not match and
+	// NotFoundError if the upload does not exist.
+	Persist(ctx context.Context, upload, name string, digest *model.Digest) error
+	// Delete deletes a persisted file. Deleting a file that does not exist is
+	// not an error.
+	Delete(ctx context.Context, name string) error
+	// DeleteUpload deletes an uploaded file that has not been persisted.
+	// Deleting an upload that does not exist is not an error.
+	DeleteUpload(ctx context.Context, name string) error
 }
 
 // SourceReader reads source code from the filestore.
 type SourceReader interface {
-	// GetFile gets a file from the filestore
-	GetFile(filename string) (*os.File, error)
+	// OpenFile opens a persisted file for reading. The file is read from
+	// offset to the end, or up to length bytes if length is greater than 0.
+	// The reader streams the file and must be closed. Returns NotFoundError
+	// if the file does not exist.
+	OpenFile(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error)
+}
+
+// NotFoundError is returned if a file does not exist.
+type NotFoundError struct {
+	// Name is the name of the file.
+	Name string
+}
+
+func (e *NotFoundError) Error() string {
+	if e.Name == "" {
+		return "file not found"
+	}
+	return fmt.Sprintf("file not found: %s", e.Name)
+}
+
+// IsNotFound returns true if the error is a NotFoundError.
+func IsNotFound(err error) bool {
+	_, ok := errors.Cause(err).(*NotFoundError)
+	return ok
+}
+
+// DigestMismatchError is returned if an upload does not match the digest it
+// was declared with.
+type DigestMismatchError struct {
+	// Name is the name of the upload.
+	Name string
+}
+
+func (e *DigestMismatchError) Error() string {
+	return fmt.Sprintf("upload %s does not match its digest", e.Name)
+}
+
+// IsDigestMismatch returns true if the error is a DigestMismatchError.
+func IsDigestMismatch(err error) bool {
+	_, ok := errors.Cause(err).(*DigestMismatchError)
+	return ok
+}
+
+// checkRange returns an error if a range to read is invalid.
+func checkRange(offset, length int64) error {
+	if offset < 0 || length < 0 {
+		return errors.New("offset and length must not be negative")
+	}
+	return nil
+}
+
+// File is a persisted source file.
+type File struct {
+	// Name is the name the file was persisted as.
+	Name string
+	// Size is the size of the file in bytes.
+	Size int64
+	// Modified is the time the file was last written.
+	Modified time.Time
+}
+
+// SourceLister lists the source code persisted in the filestore.
+type SourceLister interface {
+	// ListFiles returns all persisted files. Uploads that have not been
+	// persisted are not included.
+	ListFiles(ctx context.Context) ([]*File, error)
 }
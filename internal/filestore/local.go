@@ -2,16 +2,25 @@ package filestore
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/fragments/fragments/internal/model"
 	"github.com/pkg/errors"
 )
 
@@ -20,78 +29,237 @@ type Local struct {
 	UploadDirectory string
 	SourceDirectory string
 	httpServer      *http.Server
-	address         string
+	uploadURL       string
+	signingKey      []byte
 }
 
-// NewLocal creates a local filestore and starts listening on a port assigned
-// by the operating system. The files are stored on local disk on the server.
+// signatureParam is the query parameter of upload urls carrying the
+// signature of the upload name.
+const signatureParam = "signature"
+
+// NewLocal creates a local filestore and starts listening for uploads on the
+// listen address, a port assigned by the operating system on 127.0.0.1 is
+// used if it is not set. The files are stored on local disk on the server.
 // The uploadDir is used for storing uploads, files are moved to the sourceDir
 // when persisted. The directories are created if they don't already exist.
-func NewLocal(uploadDir, sourceDir string) (*Local, error) {
+// Upload urls are made relative to uploadURL, which must reach the listen
+// address from where clients upload. The listen address is advertised if it
+// is not set. Upload urls are signed with a key generated on creation, only
+// uploads to urls created by the filestore are accepted.
+func NewLocal(uploadDir, sourceDir, listen, uploadURL string) (*Local, error) {
 	if err := os.MkdirAll(uploadDir, 0700); err != nil {
 		return nil, errors.Wrap(err, "could not make upload directory")
 	}
 	if err := os.MkdirAll(sourceDir, 0700); err != nil {
 		return nil, errors.Wrap(err, "could not make source directory")
 	}
+	if listen == "" {
+		listen = "127.0.0.1:0"
+	}
+	signingKey := make([]byte, sha256.Size)
+	if _, err := rand.Read(signingKey); err != nil {
+		return nil, errors.Wrap(err, "could not generate signing key")
+	}
+	l := &Local{
+		UploadDirectory: uploadDir,
+		SourceDirectory: sourceDir,
+		signingKey:      signingKey,
+	}
 
-	srv := &http.Server{Addr: "127.0.0.1:0"}
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		token := strings.TrimPrefix(r.URL.Path, "/")
-		file, err := os.Create(fmt.Sprintf("%s/%s", uploadDir, token))
+		if !l.verify(token, r.URL.Query().Get(signatureParam)) {
+			http.Error(w, "upload url signature does not match", http.StatusForbidden)
+			return
+		}
+		filename := fmt.Sprintf("%s/%s", uploadDir, token)
+		file, err := os.Create(filename)
 		if err != nil {
 			http.Error(w, errors.Wrap(err, "could not create uploaded file").Error(), http.StatusInternalServerError)
 			return
 		}
-		_, err = io.Copy(file, r.Body)
+		// The Content-MD5 header is verified like S3 does, the upload is
+		// rejected if it does not match.
+		hash := md5.New()
+		_, err = io.Copy(io.MultiWriter(file, hash), r.Body)
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
 		if err != nil {
+			_ = os.Remove(filename)
 			http.Error(w, errors.Wrap(err, "could not save uploaded file").Error(), http.StatusInternalServerError)
 			return
 		}
+		if contentMD5 := r.Header.Get("Content-MD5"); contentMD5 != "" && contentMD5 != base64.StdEncoding.EncodeToString(hash.Sum(nil)) {
+			_ = os.Remove(filename)
+			http.Error(w, "content md5 does not match the uploaded file", http.StatusBadRequest)
+			return
+		}
 	})
+	l.httpServer = &http.Server{Addr: listen, Handler: mux}
 
-	addrc := make(chan string)
+	lis, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not listen for uploads")
+	}
 	go func() {
-		lis, err := net.Listen("tcp", "127.0.0.1:0")
-		if err != nil {
-			panic(err)
-		}
-		addrc <- lis.Addr().String()
-		close(addrc)
-		if err := srv.Serve(lis); err != nil {
+		if err := l.httpServer.Serve(lis); err != nil && err != http.ErrServerClosed {
 			log.Println(err)
 		}
 	}()
 
-	l := &Local{
-		UploadDirectory: uploadDir,
-		SourceDirectory: sourceDir,
-		httpServer:      srv,
-		address:         <-addrc,
+	if uploadURL == "" {
+		uploadURL = fmt.Sprintf("http://%s", lis.Addr().String())
 	}
+	l.uploadURL = strings.TrimSuffix(uploadURL, "/")
 
 	return l, nil
 }
 
-// NewUploadURL creates a new upload url that the local filestore will handle.
+// NewUploadURL creates a new signed upload url that the local filestore will
+// handle.
 func (l *Local) NewUploadURL(name string) (string, error) {
-	return fmt.Sprintf("http://%s/%s", l.address, name), nil
+	if !validUploadName(name) {
+		return "", errors.Errorf("invalid upload name %q", name)
+	}
+	query := url.Values{signatureParam: []string{l.sign(name)}}
+	return fmt.Sprintf("%s/%s?%s", l.uploadURL, url.PathEscape(name), query.Encode()), nil
+}
+
+// validUploadName returns true if an upload name is a single path segment.
+func validUploadName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.Contains(name, "/")
+}
+
+// sign returns the signature of an upload name.
+func (l *Local) sign(name string) string {
+	mac := hmac.New(sha256.New, l.signingKey)
+	_, _ = mac.Write([]byte(name))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verify returns true if the signature of an upload name is valid.
+func (l *Local) verify(name, signature string) bool {
+	if !validUploadName(name) {
+		return false
+	}
+	return hmac.Equal([]byte(l.sign(name)), []byte(signature))
 }
 
 // Persist moves the file from the upload directory to the source directory.
-func (l *Local) Persist(ctx context.Context, name string) error {
-	from := fmt.Sprintf("%s/%s", l.UploadDirectory, name)
+// The digest of the uploaded file is computed and compared to the declared
+// digest before the file is moved.
+func (l *Local) Persist(ctx context.Context, upload, name string, digest *model.Digest) error {
+	if upload == "" || name == "" {
+		return errors.New("name not set")
+	}
+	if err := digest.Validate(); err != nil {
+		return err
+	}
+	from := fmt.Sprintf("%s/%s", l.UploadDirectory, upload)
 	to := fmt.Sprintf("%s/%s", l.SourceDirectory, name)
+	actual, err := digestFile(from)
+	if err != nil {
+		if os.IsNotExist(errors.Cause(err)) {
+			return &NotFoundError{Name: upload}
+		}
+		return err
+	}
+	if !actual.Equal(digest) {
+		return &DigestMismatchError{Name: upload}
+	}
 	if err := os.Rename(from, to); err != nil {
 		return errors.Wrap(err, "could not move to source directory")
 	}
 	return nil
 }
 
-// GetFile returns a source file from the local filestore.
-func (l *Local) GetFile(name string) (*os.File, error) {
-	filename := filepath.Join(l.SourceDirectory, name)
-	return os.Open(filename)
+// digestFile returns the digest of a file.
+func digestFile(filename string) (*model.Digest, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open uploaded file")
+	}
+	defer file.Close() // nolint: errcheck
+	return model.NewDigest(file)
+}
+
+// Delete removes a file from the source directory.
+func (l *Local) Delete(ctx context.Context, name string) error {
+	if name == "" {
+		return errors.New("name not set")
+	}
+	err := os.Remove(filepath.Join(l.SourceDirectory, name))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "could not remove source file")
+	}
+	return nil
+}
+
+// DeleteUpload removes a file from the upload directory.
+func (l *Local) DeleteUpload(ctx context.Context, name string) error {
+	if name == "" {
+		return errors.New("name not set")
+	}
+	err := os.Remove(filepath.Join(l.UploadDirectory, name))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "could not remove uploaded file")
+	}
+	return nil
+}
+
+// OpenFile opens a file in the source directory for reading.
+func (l *Local) OpenFile(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	if name == "" {
+		return nil, errors.New("name not set")
+	}
+	if err := checkRange(offset, length); err != nil {
+		return nil, err
+	}
+	file, err := os.Open(filepath.Join(l.SourceDirectory, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, &NotFoundError{Name: name}
+		}
+		return nil, errors.Wrap(err, "could not open source file")
+	}
+	if offset > 0 {
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			_ = file.Close()
+			return nil, errors.Wrap(err, "could not seek source file")
+		}
+	}
+	if length > 0 {
+		return &limitedReadCloser{Reader: io.LimitReader(file, length), Closer: file}, nil
+	}
+	return file, nil
+}
+
+// limitedReadCloser reads part of a file and closes the file.
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// ListFiles lists the files in the source directory.
+func (l *Local) ListFiles(ctx context.Context) ([]*File, error) {
+	infos, err := ioutil.ReadDir(l.SourceDirectory)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list source directory")
+	}
+	files := make([]*File, 0, len(infos))
+	for _, info := range infos {
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		files = append(files, &File{
+			Name:     info.Name(),
+			Size:     info.Size(),
+			Modified: info.ModTime(),
+		})
+	}
+	return files, nil
 }
 
 // Shutdown gracefully closes the local filestore. New connections are not
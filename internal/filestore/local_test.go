@@ -7,8 +7,10 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/fragments/fragments/internal/model"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -20,13 +22,15 @@ func TestLocal(t *testing.T) {
 	source := filepath.Join(base, "source")
 
 	// Invalid arguments
-	_, err = NewLocal("", source)
+	_, err = NewLocal("", source, "", "")
 	require.Error(t, err)
-	_, err = NewLocal(uploads, "")
+	_, err = NewLocal(uploads, "", "", "")
+	require.Error(t, err)
+	_, err = NewLocal(uploads, source, "invalid", "")
 	require.Error(t, err)
 
 	// Create local filestore
-	local, err := NewLocal(uploads, source)
+	local, err := NewLocal(uploads, source, "", "")
 	require.NoError(t, err)
 
 	// Generate url
@@ -36,29 +40,49 @@ func TestLocal(t *testing.T) {
 	// Upload to url
 	fixture, err := ioutil.ReadFile("testdata/upload.txt")
 	require.NoError(t, err)
+	digest, err := model.NewDigest(bytes.NewReader(fixture))
+	require.NoError(t, err)
 	req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(fixture))
 	require.NoError(t, err)
-	_, err = http.DefaultClient.Do(req)
+	req.Header.Set("Content-MD5", digest.ContentMD5())
+	res, err := http.DefaultClient.Do(req)
 	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
 
 	// Upload should be set
 	_, err = os.Stat(filepath.Join(uploads, "test"))
 	require.NoError(t, err)
 
 	// Persist
-	err = local.Persist(context.Background(), "test")
+	other, err := model.NewDigest(strings.NewReader("other"))
 	require.NoError(t, err)
+	err = local.Persist(context.Background(), "", "test", digest)
+	require.Error(t, err)
+	err = local.Persist(context.Background(), "test", "persisted", nil)
+	require.Error(t, err)
+	err = local.Persist(context.Background(), "nonexisting", "persisted", digest)
+	assert.True(t, IsNotFound(err))
+	err = local.Persist(context.Background(), "test", "persisted", other)
+	assert.True(t, IsDigestMismatch(err))
+	_, err = os.Stat(filepath.Join(source, "persisted"))
+	assert.True(t, os.IsNotExist(err))
+	err = local.Persist(context.Background(), "test", "persisted", digest)
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(uploads, "test"))
+	assert.True(t, os.IsNotExist(err))
 
 	// Assert
-	actual, err := ioutil.ReadFile(filepath.Join(source, "test"))
+	actual, err := ioutil.ReadFile(filepath.Join(source, "persisted"))
 	require.NoError(t, err)
 	assert.Equal(t, string(fixture), string(actual))
 
-	// Get file
-	_, err = local.GetFile("nonexisting")
+	// Open file
+	_, err = local.OpenFile(context.Background(), "nonexisting", 0, 0)
+	assert.True(t, IsNotFound(err))
+	_, err = local.OpenFile(context.Background(), "persisted", -1, 0)
 	require.Error(t, err)
 
-	file, err := local.GetFile("test")
+	file, err := local.OpenFile(context.Background(), "persisted", 0, 0)
 	require.NoError(t, err)
 	data, err := ioutil.ReadAll(file)
 	require.NoError(t, err)
@@ -66,6 +90,142 @@ func TestLocal(t *testing.T) {
 	err = file.Close()
 	require.NoError(t, err)
 
+	// Open a range of the file
+	for _, r := range [][2]int64{{2, 0}, {2, 3}, {0, 1000}} {
+		file, err = local.OpenFile(context.Background(), "persisted", r[0], r[1])
+		require.NoError(t, err)
+		data, err = ioutil.ReadAll(file)
+		require.NoError(t, err)
+		end := int64(len(fixture))
+		if r[1] > 0 && r[0]+r[1] < end {
+			end = r[0] + r[1]
+		}
+		assert.Equal(t, fixture[r[0]:end], data)
+		require.NoError(t, file.Close())
+	}
+
+	// List
+	files, err := local.ListFiles(context.Background())
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Equal(t, "persisted", files[0].Name)
+	assert.EqualValues(t, len(fixture), files[0].Size)
+	assert.False(t, files[0].Modified.IsZero())
+
+	// Delete
+	err = local.Delete(context.Background(), "persisted")
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(source, "persisted"))
+	assert.True(t, os.IsNotExist(err))
+	err = local.Delete(context.Background(), "persisted")
+	require.NoError(t, err)
+	files, err = local.ListFiles(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, files)
+
+	// Delete upload
+	url, err = local.NewUploadURL("abandoned")
+	require.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPut, url, bytes.NewReader(fixture))
+	require.NoError(t, err)
+	_, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	err = local.DeleteUpload(context.Background(), "abandoned")
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(uploads, "abandoned"))
+	assert.True(t, os.IsNotExist(err))
+	err = local.DeleteUpload(context.Background(), "abandoned")
+	require.NoError(t, err)
+
+	// Uploads that do not match the Content-MD5 header are rejected
+	url, err = local.NewUploadURL("corrupted")
+	require.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPut, url, bytes.NewReader(fixture))
+	require.NoError(t, err)
+	req.Header.Set("Content-MD5", other.ContentMD5())
+	res, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	_, err = os.Stat(filepath.Join(uploads, "corrupted"))
+	assert.True(t, os.IsNotExist(err))
+
 	err = local.Shutdown()
 	require.NoError(t, err)
 }
+
+func TestLocalUploadURL(t *testing.T) {
+	base, err := ioutil.TempDir("", "fragments-test")
+	require.NoError(t, err)
+	uploads := filepath.Join(base, "uploads")
+	source := filepath.Join(base, "source")
+
+	local, err := NewLocal(uploads, source, "127.0.0.1:0", "https://uploads.example.com/")
+	require.NoError(t, err)
+	defer local.Shutdown() // nolint: errcheck
+
+	url, err := local.NewUploadURL("test")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(url, "https://uploads.example.com/test?signature="), url)
+
+	_, err = local.NewUploadURL("../test")
+	require.Error(t, err)
+}
+
+func TestLocalUploadSignature(t *testing.T) {
+	base, err := ioutil.TempDir("", "fragments-test")
+	require.NoError(t, err)
+	uploads := filepath.Join(base, "uploads")
+	source := filepath.Join(base, "source")
+
+	local, err := NewLocal(uploads, source, "", "")
+	require.NoError(t, err)
+	defer local.Shutdown() // nolint: errcheck
+
+	signed, err := local.NewUploadURL("signed")
+	require.NoError(t, err)
+	base = strings.TrimSuffix(signed, "/signed?"+signatureParam+"="+local.sign("signed"))
+	require.NotEqual(t, signed, base)
+
+	tests := []struct {
+		TestName string
+		URL      string
+		Status   int
+	}{
+		{
+			TestName: "Signed",
+			URL:      signed,
+			Status:   http.StatusOK,
+		},
+		{
+			TestName: "Unsigned",
+			URL:      base + "/unsigned",
+			Status:   http.StatusForbidden,
+		},
+		{
+			TestName: "Signature of other upload",
+			URL:      base + "/other?" + signatureParam + "=" + local.sign("signed"),
+			Status:   http.StatusForbidden,
+		},
+		{
+			TestName: "Nested path",
+			URL:      base + "/signed/other?" + signatureParam + "=" + local.sign("signed/other"),
+			Status:   http.StatusForbidden,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPut, test.URL, strings.NewReader("upload"))
+			require.NoError(t, err)
+			res, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			_ = res.Body.Close()
+			assert.Equal(t, test.Status, res.StatusCode)
+		})
+	}
+
+	files, err := ioutil.ReadDir(uploads)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Equal(t, "signed", files[0].Name())
+}
@@ -2,28 +2,29 @@ package mocks
 
 import "github.com/stretchr/testify/mock"
 
-import "os"
+import "context"
+import "io"
 
 type SourceReader struct {
 	mock.Mock
 }
 
-// GetFile provides a mock function with given fields: filename
-func (_m *SourceReader) GetFile(filename string) (*os.File, error) {
-	ret := _m.Called(filename)
+// OpenFile provides a mock function with given fields: ctx, name, offset, length
+func (_m *SourceReader) OpenFile(ctx context.Context, name string, offset int64, length int64) (io.ReadCloser, error) {
+	ret := _m.Called(ctx, name, offset, length)
 
-	var r0 *os.File
-	if rf, ok := ret.Get(0).(func(string) *os.File); ok {
-		r0 = rf(filename)
+	var r0 io.ReadCloser
+	if rf, ok := ret.Get(0).(func(context.Context, string, int64, int64) io.ReadCloser); ok {
+		r0 = rf(ctx, name, offset, length)
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).(*os.File)
+			r0 = ret.Get(0).(io.ReadCloser)
 		}
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string) error); ok {
-		r1 = rf(filename)
+	if rf, ok := ret.Get(1).(func(context.Context, string, int64, int64) error); ok {
+		r1 = rf(ctx, name, offset, length)
 	} else {
 		r1 = ret.Error(1)
 	}
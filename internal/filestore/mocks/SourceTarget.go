@@ -3,11 +3,40 @@ package mocks
 import "github.com/stretchr/testify/mock"
 
 import "context"
+import "github.com/fragments/fragments/internal/model"
 
 type SourceTarget struct {
 	mock.Mock
 }
 
+// Delete provides a mock function with given fields: ctx, name
+func (_m *SourceTarget) Delete(ctx context.Context, name string) error {
+	ret := _m.Called(ctx, name)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, name)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteUpload provides a mock function with given fields: ctx, name
+func (_m *SourceTarget) DeleteUpload(ctx context.Context, name string) error {
+	ret := _m.Called(ctx, name)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, name)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // NewUploadURL provides a mock function with given fields: name
 func (_m *SourceTarget) NewUploadURL(name string) (string, error) {
 	ret := _m.Called(name)
@@ -29,13 +58,13 @@ func (_m *SourceTarget) NewUploadURL(name string) (string, error) {
 	return r0, r1
 }
 
-// Persist provides a mock function with given fields: ctx, name
-func (_m *SourceTarget) Persist(ctx context.Context, name string) error {
-	ret := _m.Called(ctx, name)
+// Persist provides a mock function with given fields: ctx, upload, name, digest
+func (_m *SourceTarget) Persist(ctx context.Context, upload string, name string, digest *model.Digest) error {
+	ret := _m.Called(ctx, upload, name, digest)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
-		r0 = rf(ctx, name)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, *model.Digest) error); ok {
+		r0 = rf(ctx, upload, name, digest)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -3,15 +3,22 @@ package filestore
 import (
 	"context"
 	"fmt"
+	"io"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/fragments/fragments/internal/model"
 	"github.com/pkg/errors"
 )
 
+// errCodePreconditionFailed is the error code S3 returns if a condition of a
+// request is not met.
+const errCodePreconditionFailed = "PreconditionFailed"
+
 // S3 stores files in AWS S3
 type S3 struct {
 	Client       s3iface.S3API
@@ -73,29 +80,160 @@ func (s *S3) NewUploadURL(name string) (string, error) {
 
 // Persist moves an uploaded file to a permanent bucket. Files that are not
 // persisted might be cleaned up.
-func (s *S3) Persist(ctx context.Context, name string) error {
-	if name == "" {
+// The upload is streamed from S3 to compute its digest before it is copied.
+// The ETag is not the MD5 hash of objects uploaded in multiple parts or
+// encrypted with KMS, it is only used to copy the object that was verified.
+func (s *S3) Persist(ctx context.Context, upload, name string, digest *model.Digest) error {
+	if upload == "" || name == "" {
 		return errors.New("name not set")
 	}
+	if err := digest.Validate(); err != nil {
+		return err
+	}
+
+	etag, err := s.verifyUpload(ctx, upload, digest)
+	if err != nil {
+		return err
+	}
 
-	// Copy file to source bucket
-	_, err := s.Client.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
-		CopySource: aws.String(fmt.Sprintf("%s/%s", s.UploadBucket, name)),
-		Bucket:     aws.String(s.SourceBucket),
-		Key:        aws.String(name),
+	// Copy file to source bucket, unless it was replaced since it was verified
+	_, err = s.Client.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+		CopySource:        aws.String(fmt.Sprintf("%s/%s", s.UploadBucket, upload)),
+		CopySourceIfMatch: aws.String(etag),
+		Bucket:            aws.String(s.SourceBucket),
+		Key:               aws.String(name),
 	})
 	if err != nil {
-		return errors.Wrapf(err, "could not copy uploaded file %s from bucket %s to %s", name, s.UploadBucket, s.SourceBucket)
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case s3.ErrCodeNoSuchKey:
+				return &NotFoundError{Name: upload}
+			case errCodePreconditionFailed:
+				return &DigestMismatchError{Name: upload}
+			}
+		}
+		return errors.Wrapf(err, "could not copy uploaded file %s from bucket %s to %s", upload, s.UploadBucket, s.SourceBucket)
 	}
 
 	// Delete uploaded file
 	_, err = s.Client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.UploadBucket),
+		Key:    aws.String(upload),
+	})
+	if err != nil {
+		return errors.Wrap(err, "could not delete uploaded source after copy")
+	}
+
+	return nil
+}
+
+// verifyUpload reads an upload and compares its digest to the declared
+// digest. Returns the ETag of the object that was read.
+func (s *S3) verifyUpload(ctx context.Context, upload string, digest *model.Digest) (string, error) {
+	out, err := s.Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.UploadBucket),
+		Key:    aws.String(upload),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return "", &NotFoundError{Name: upload}
+		}
+		return "", errors.Wrapf(err, "could not get %s from bucket %s", upload, s.UploadBucket)
+	}
+	defer out.Body.Close() // nolint: errcheck
+
+	actual, err := model.NewDigest(out.Body)
+	if err != nil {
+		return "", errors.Wrapf(err, "could not read %s from bucket %s", upload, s.UploadBucket)
+	}
+	if !actual.Equal(digest) {
+		return "", &DigestMismatchError{Name: upload}
+	}
+	return aws.StringValue(out.ETag), nil
+}
+
+// Delete deletes a persisted file from the source bucket.
+func (s *S3) Delete(ctx context.Context, name string) error {
+	if name == "" {
+		return errors.New("name not set")
+	}
+
+	_, err := s.Client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(s.SourceBucket),
 		Key:    aws.String(name),
 	})
 	if err != nil {
-		return errors.Wrap(err, "could not delete uploaded source after copy")
+		return errors.Wrapf(err, "could not delete %s from bucket %s", name, s.SourceBucket)
 	}
 
 	return nil
 }
+
+// DeleteUpload deletes an uploaded file from the upload bucket.
+func (s *S3) DeleteUpload(ctx context.Context, name string) error {
+	if name == "" {
+		return errors.New("name not set")
+	}
+
+	_, err := s.Client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.UploadBucket),
+		Key:    aws.String(name),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "could not delete %s from bucket %s", name, s.UploadBucket)
+	}
+
+	return nil
+}
+
+// ListFiles lists the objects in the source bucket.
+func (s *S3) ListFiles(ctx context.Context) ([]*File, error) {
+	files := []*File{}
+	err := s.Client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.SourceBucket),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			files = append(files, &File{
+				Name:     aws.StringValue(obj.Key),
+				Size:     aws.Int64Value(obj.Size),
+				Modified: aws.TimeValue(obj.LastModified),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not list bucket %s", s.SourceBucket)
+	}
+	return files, nil
+}
+
+// OpenFile gets an object from the source bucket. The object is streamed from
+// S3 while it is read, a range of the object is requested if offset or length
+// are set.
+func (s *S3) OpenFile(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	if name == "" {
+		return nil, errors.New("name not set")
+	}
+	if err := checkRange(offset, length); err != nil {
+		return nil, err
+	}
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.SourceBucket),
+		Key:    aws.String(name),
+	}
+	switch {
+	case length > 0:
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	case offset > 0:
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-", offset))
+	}
+	out, err := s.Client.GetObjectWithContext(ctx, input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, &NotFoundError{Name: name}
+		}
+		return nil, errors.Wrapf(err, "could not get %s from bucket %s", name, s.SourceBucket)
+	}
+	return out.Body, nil
+}
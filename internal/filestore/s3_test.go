@@ -4,15 +4,19 @@ package filestore
 
 import (
 	"context"
+	"io/ioutil"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/fragments/fragments/internal/filestore/mocks"
+	"github.com/fragments/fragments/internal/model"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -119,40 +123,105 @@ func TestPersist(t *testing.T) {
 	ctx := context.Background()
 	ctxCanceled, cancel := context.WithCancel(ctx)
 	cancel()
+	digest, err := model.NewDigest(strings.NewReader("source"))
+	require.NoError(t, err)
 
 	tests := []struct {
 		TestName    string
+		Upload      string
 		Name        string
 		Ctx         context.Context
+		NoDigest    bool
+		Content     string
 		ArgError    bool
-		CopyError   bool
+		GetError    error
+		CopyError   error
 		DeleteError bool
+		NotFound    bool
+		Mismatch    bool
 	}{
+		{
+			TestName: "No upload",
+			Name:     "File",
+			ArgError: true,
+		},
 		{
 			TestName: "No name",
+			Upload:   "token",
 			Name:     "",
 			ArgError: true,
 		},
+		{
+			TestName: "No digest",
+			Upload:   "token",
+			Name:     "File",
+			Ctx:      ctx,
+			NoDigest: true,
+			ArgError: true,
+		},
 		{
 			TestName: "Context canceled",
+			Upload:   "token",
 			Name:     "File",
 			Ctx:      ctxCanceled,
 			ArgError: true,
 		},
+		{
+			TestName: "Not uploaded",
+			Upload:   "token",
+			Name:     "File",
+			Ctx:      ctx,
+			GetError: awserr.New(s3.ErrCodeNoSuchKey, "not found", nil),
+			NotFound: true,
+		},
+		{
+			TestName: "Get error",
+			Upload:   "token",
+			Name:     "File",
+			Ctx:      ctx,
+			GetError: errors.New("get error"),
+		},
+		{
+			TestName: "Digest mismatch",
+			Upload:   "token",
+			Name:     "File",
+			Ctx:      ctx,
+			Content:  "other",
+			Mismatch: true,
+		},
 		{
 			TestName:  "Copy error",
+			Upload:    "token",
+			Name:      "File",
+			Ctx:       ctx,
+			CopyError: errors.New("copy error"),
+		},
+		{
+			TestName:  "Deleted since verified",
+			Upload:    "token",
+			Name:      "File",
+			Ctx:       ctx,
+			CopyError: awserr.New(s3.ErrCodeNoSuchKey, "not found", nil),
+			NotFound:  true,
+		},
+		{
+			TestName:  "Replaced since verified",
+			Upload:    "token",
 			Name:      "File",
 			Ctx:       ctx,
-			CopyError: true,
+			CopyError: awserr.New("PreconditionFailed", "precondition failed", nil),
+			Mismatch:  true,
 		},
 		{
 			TestName:    "Delete error",
+			Upload:      "token",
 			Name:        "File",
 			Ctx:         ctx,
 			DeleteError: true,
 		},
 		{
 			TestName: "Ok",
+			Upload:   "token",
 			Name:     "File",
 			Ctx:      ctx,
 		},
@@ -169,31 +238,346 @@ func TestPersist(t *testing.T) {
 
 			var opts []request.Option
 			mockS3.
-				On("CopyObjectWithContext", ctxCanceled, mock.Anything, opts).
+				On("GetObjectWithContext", ctxCanceled, mock.Anything, opts).
 				Return(nil, errors.New("context canceled"))
 
-			var copyErr error
-			if test.CopyError {
-				copyErr = errors.New("copy error")
+			content := test.Content
+			if content == "" {
+				content = "source"
 			}
+			var out *s3.GetObjectOutput
+			if test.GetError == nil {
+				// The ETag of objects encrypted with KMS is not their MD5 hash
+				out = &s3.GetObjectOutput{
+					Body: ioutil.NopCloser(strings.NewReader(content)),
+					ETag: aws.String(`"kms-etag"`),
+				}
+			}
+			mockS3.
+				On("GetObjectWithContext", ctx, &s3.GetObjectInput{
+					Bucket: aws.String("uploads"),
+					Key:    aws.String(test.Upload),
+				}, opts).
+				Return(out, test.GetError)
+
 			mockS3.
-				On("CopyObjectWithContext", ctx, mock.Anything, opts).
-				Return(nil, copyErr)
+				On("CopyObjectWithContext", ctx, &s3.CopyObjectInput{
+					CopySource:        aws.String("uploads/" + test.Upload),
+					CopySourceIfMatch: aws.String(`"kms-etag"`),
+					Bucket:            aws.String("source"),
+					Key:               aws.String(test.Name),
+				}, opts).
+				Return(nil, test.CopyError)
 
 			var delErr error
 			if test.DeleteError {
 				delErr = errors.New("delete error")
 			}
 			mockS3.
-				On("DeleteObjectWithContext", ctx, mock.Anything, opts).
+				On("DeleteObjectWithContext", ctx, &s3.DeleteObjectInput{
+					Bucket: aws.String("uploads"),
+					Key:    aws.String(test.Upload),
+				}, opts).
 				Return(nil, delErr)
 
-			err := s.Persist(test.Ctx, test.Name)
-			if test.ArgError || test.CopyError || test.DeleteError {
+			d := digest
+			if test.NoDigest {
+				d = nil
+			}
+			err := s.Persist(test.Ctx, test.Upload, test.Name, d)
+			if test.GetError != nil || test.Content != "" {
+				mockS3.AssertNotCalled(t, "CopyObjectWithContext", mock.Anything, mock.Anything, mock.Anything)
+			}
+			if test.ArgError || test.GetError != nil || test.Content != "" || test.CopyError != nil || test.DeleteError {
 				require.Error(t, err)
+				assert.Equal(t, test.NotFound, IsNotFound(err))
+				assert.Equal(t, test.Mismatch, IsDigestMismatch(err))
+				if !test.DeleteError {
+					mockS3.AssertNotCalled(t, "DeleteObjectWithContext", mock.Anything, mock.Anything, mock.Anything)
+				}
 				return
 			}
 			require.NoError(t, err)
 		})
 	}
 }
+
+func TestS3Delete(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		TestName    string
+		Name        string
+		DeleteError bool
+		Error       bool
+	}{
+		{
+			TestName: "No name",
+			Name:     "",
+			Error:    true,
+		},
+		{
+			TestName:    "Delete error",
+			Name:        "File",
+			DeleteError: true,
+			Error:       true,
+		},
+		{
+			TestName: "Ok",
+			Name:     "File",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			mockS3 := &mocks.S3API{}
+			s := &S3{
+				Client:       mockS3,
+				UploadBucket: "uploads",
+				SourceBucket: "source",
+			}
+
+			var delErr error
+			if test.DeleteError {
+				delErr = errors.New("delete error")
+			}
+			var opts []request.Option
+			mockS3.
+				On("DeleteObjectWithContext", ctx, &s3.DeleteObjectInput{
+					Bucket: aws.String("source"),
+					Key:    aws.String(test.Name),
+				}, opts).
+				Return(nil, delErr)
+
+			err := s.Delete(ctx, test.Name)
+			if test.Error {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			mockS3.AssertExpectations(t)
+		})
+	}
+}
+
+func TestS3DeleteUpload(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		TestName    string
+		Name        string
+		DeleteError bool
+		Error       bool
+	}{
+		{
+			TestName: "No name",
+			Name:     "",
+			Error:    true,
+		},
+		{
+			TestName:    "Delete error",
+			Name:        "File",
+			DeleteError: true,
+			Error:       true,
+		},
+		{
+			TestName: "Ok",
+			Name:     "File",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			mockS3 := &mocks.S3API{}
+			s := &S3{
+				Client:       mockS3,
+				UploadBucket: "uploads",
+				SourceBucket: "source",
+			}
+
+			var delErr error
+			if test.DeleteError {
+				delErr = errors.New("delete error")
+			}
+			var opts []request.Option
+			mockS3.
+				On("DeleteObjectWithContext", ctx, &s3.DeleteObjectInput{
+					Bucket: aws.String("uploads"),
+					Key:    aws.String(test.Name),
+				}, opts).
+				Return(nil, delErr)
+
+			err := s.DeleteUpload(ctx, test.Name)
+			if test.Error {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			mockS3.AssertExpectations(t)
+		})
+	}
+}
+
+func TestS3ListFiles(t *testing.T) {
+	ctx := context.Background()
+	modified := time.Date(2017, 11, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		TestName  string
+		ListError bool
+		Expected  []*File
+		Error     bool
+	}{
+		{
+			TestName:  "List error",
+			ListError: true,
+			Error:     true,
+		},
+		{
+			TestName: "Ok",
+			Expected: []*File{
+				{Name: "a", Size: 1, Modified: modified},
+				{Name: "b", Size: 2, Modified: modified},
+				{Name: "c", Size: 3, Modified: modified},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			mockS3 := &mocks.S3API{}
+			s := &S3{
+				Client:       mockS3,
+				UploadBucket: "uploads",
+				SourceBucket: "source",
+			}
+
+			var listErr error
+			if test.ListError {
+				listErr = errors.New("list error")
+			}
+			object := func(name string, size int64) *s3.Object {
+				return &s3.Object{Key: aws.String(name), Size: aws.Int64(size), LastModified: aws.Time(modified)}
+			}
+			var opts []request.Option
+			mockS3.
+				On("ListObjectsV2PagesWithContext", ctx, &s3.ListObjectsV2Input{
+					Bucket: aws.String("source"),
+				}, mock.Anything, opts).
+				Run(func(args mock.Arguments) {
+					// Objects are returned in two pages
+					fn := args.Get(2).(func(*s3.ListObjectsV2Output, bool) bool)
+					if fn(&s3.ListObjectsV2Output{Contents: []*s3.Object{object("a", 1), object("b", 2)}}, false) {
+						fn(&s3.ListObjectsV2Output{Contents: []*s3.Object{object("c", 3)}}, true)
+					}
+				}).
+				Return(listErr)
+
+			files, err := s.ListFiles(ctx)
+			if test.Error {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			mockS3.AssertExpectations(t)
+			assert.Equal(t, test.Expected, files)
+		})
+	}
+}
+
+func TestS3OpenFile(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		TestName string
+		Name     string
+		Offset   int64
+		Length   int64
+		Range    string
+		GetError error
+		NotFound bool
+		Error    bool
+	}{
+		{
+			TestName: "No name",
+			Error:    true,
+		},
+		{
+			TestName: "Negative offset",
+			Name:     "File",
+			Offset:   -1,
+			Error:    true,
+		},
+		{
+			TestName: "Not found",
+			Name:     "File",
+			GetError: awserr.New(s3.ErrCodeNoSuchKey, "not found", nil),
+			NotFound: true,
+		},
+		{
+			TestName: "Get error",
+			Name:     "File",
+			GetError: errors.New("get error"),
+			Error:    true,
+		},
+		{
+			TestName: "Ok",
+			Name:     "File",
+		},
+		{
+			TestName: "Offset",
+			Name:     "File",
+			Offset:   10,
+			Range:    "bytes=10-",
+		},
+		{
+			TestName: "Range",
+			Name:     "File",
+			Offset:   10,
+			Length:   5,
+			Range:    "bytes=10-14",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			mockS3 := &mocks.S3API{}
+			s := &S3{
+				Client:       mockS3,
+				UploadBucket: "uploads",
+				SourceBucket: "source",
+			}
+
+			input := &s3.GetObjectInput{
+				Bucket: aws.String("source"),
+				Key:    aws.String(test.Name),
+			}
+			if test.Range != "" {
+				input.Range = aws.String(test.Range)
+			}
+			var out *s3.GetObjectOutput
+			if test.GetError == nil {
+				out = &s3.GetObjectOutput{Body: ioutil.NopCloser(strings.NewReader("content"))}
+			}
+			var opts []request.Option
+			mockS3.
+				On("GetObjectWithContext", ctx, input, opts).
+				Return(out, test.GetError)
+
+			body, err := s.OpenFile(ctx, test.Name, test.Offset, test.Length)
+			switch {
+			case test.NotFound:
+				assert.True(t, IsNotFound(err))
+				return
+			case test.Error:
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			mockS3.AssertExpectations(t)
+			data, err := ioutil.ReadAll(body)
+			require.NoError(t, err)
+			assert.Equal(t, "content", string(data))
+		})
+	}
+}
@@ -0,0 +1,34 @@
+// This file was automatically generated by genny.
+// Any changes will be lost if this file is regenerated.
+// see https://github.com/cheekybits/genny
+
+package model
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// MarshalAuditEvent marshals t to a json encoded byte array.
+func MarshalAuditEvent(t *AuditEvent) ([]byte, error) {
+	if t == nil {
+		return nil, errors.New("audit-event is nil")
+	}
+	s, err := json.Marshal(t)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not marshal audit-event")
+	}
+	return s, nil
+}
+
+// UnmarshalAuditEvent unmarshals a json encoded *AuditEvent to t
+func UnmarshalAuditEvent(s []byte, t *AuditEvent) error {
+	if t == nil {
+		return errors.New("target audit-event is nil")
+	}
+	if err := json.Unmarshal(s, t); err != nil {
+		return errors.Wrap(err, "could not unmarshal audit-event")
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+// This file was automatically generated by genny.
+// Any changes will be lost if this file is regenerated.
+// see https://github.com/cheekybits/genny
+
+package model
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/fragments/fragments/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshalAuditEvent(t *testing.T) {
+	// Marshal
+	_, err := MarshalAuditEvent(nil)
+	require.Error(t, err)
+	s, err := MarshalAuditEvent(mockAuditEvent)
+	require.NoError(t, err)
+	testutils.AssertGolden(t, string(s), "testdata/GoldenAuditEvent.json")
+
+	// Unmarshal
+	var m AuditEvent
+	s, err = ioutil.ReadFile("testdata/GoldenAuditEvent.json")
+	require.NoError(t, err)
+	err = UnmarshalAuditEvent(nil, nil)
+	require.Error(t, err)
+	err = UnmarshalAuditEvent(s, nil)
+	require.Error(t, err)
+	err = UnmarshalAuditEvent(nil, &m)
+	require.Error(t, err)
+	err = UnmarshalAuditEvent(s, &m)
+	require.NoError(t, err)
+	assert.EqualValues(t, *mockAuditEvent, m)
+}
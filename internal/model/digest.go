@@ -0,0 +1,62 @@
+package model
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// Digest is the digest of an uploaded file. The client declares the digest of
+// the file it uploaded, the file is only persisted if it matches.
+type Digest struct {
+	// MD5 is the MD5 hash of the file.
+	MD5 []byte `json:"md5"`
+	// SHA256 is the SHA-256 hash of the file.
+	SHA256 []byte `json:"sha256"`
+}
+
+// NewDigest reads r to the end and returns the digest of the data read.
+func NewDigest(r io.Reader) (*Digest, error) {
+	md5Hash := md5.New()
+	sha256Hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(md5Hash, sha256Hash), r); err != nil {
+		return nil, errors.Wrap(err, "could not read data to digest")
+	}
+	return &Digest{
+		MD5:    md5Hash.Sum(nil),
+		SHA256: sha256Hash.Sum(nil),
+	}, nil
+}
+
+// Validate returns an error if a hash of the digest is not set or does not
+// have the size of the hash.
+func (d *Digest) Validate() error {
+	if d == nil {
+		return errors.New("digest not set")
+	}
+	if len(d.MD5) != md5.Size {
+		return errors.Errorf("md5 must be %d bytes", md5.Size)
+	}
+	if len(d.SHA256) != sha256.Size {
+		return errors.Errorf("sha256 must be %d bytes", sha256.Size)
+	}
+	return nil
+}
+
+// Equal returns true if both hashes of the digests match.
+func (d *Digest) Equal(other *Digest) bool {
+	if d == nil || other == nil {
+		return d == other
+	}
+	return bytes.Equal(d.MD5, other.MD5) && bytes.Equal(d.SHA256, other.SHA256)
+}
+
+// ContentMD5 returns the MD5 hash encoded as the value of a Content-MD5
+// header.
+func (d *Digest) ContentMD5() string {
+	return base64.StdEncoding.EncodeToString(d.MD5)
+}
@@ -0,0 +1,98 @@
+package model
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("read failed")
+}
+
+func TestNewDigest(t *testing.T) {
+	digest, err := NewDigest(strings.NewReader("source"))
+	require.NoError(t, err)
+	assert.Equal(t, "36cd38f49b9afa08222c0dc9ebfe35eb", hex.EncodeToString(digest.MD5))
+	assert.Equal(t, "Ns049Jua+ggiLA3J6/416w==", digest.ContentMD5())
+	assert.Len(t, digest.SHA256, 32)
+	require.NoError(t, digest.Validate())
+
+	_, err = NewDigest(failingReader{})
+	assert.Error(t, err)
+}
+
+func TestDigestValidate(t *testing.T) {
+	digest, err := NewDigest(strings.NewReader("source"))
+	require.NoError(t, err)
+
+	tests := []struct {
+		TestName string
+		Digest   *Digest
+		Error    bool
+	}{
+		{
+			TestName: "Nil",
+			Error:    true,
+		},
+		{
+			TestName: "Empty",
+			Digest:   &Digest{},
+			Error:    true,
+		},
+		{
+			TestName: "No SHA256",
+			Digest:   &Digest{MD5: digest.MD5},
+			Error:    true,
+		},
+		{
+			TestName: "Short MD5",
+			Digest:   &Digest{MD5: digest.MD5[1:], SHA256: digest.SHA256},
+			Error:    true,
+		},
+		{
+			TestName: "Valid",
+			Digest:   digest,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			err := test.Digest.Validate()
+			if test.Error {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestDigestEqual(t *testing.T) {
+	source, err := NewDigest(strings.NewReader("source"))
+	require.NoError(t, err)
+	same, err := NewDigest(strings.NewReader("source"))
+	require.NoError(t, err)
+	other, err := NewDigest(strings.NewReader("other"))
+	require.NoError(t, err)
+
+	assert.True(t, source.Equal(same))
+	assert.False(t, source.Equal(other))
+	assert.False(t, source.Equal(&Digest{MD5: source.MD5}))
+	assert.False(t, source.Equal(nil))
+	assert.True(t, (*Digest)(nil).Equal(nil))
+
+	// Hashes are base64 encoded in json
+	raw, err := json.Marshal(source)
+	require.NoError(t, err)
+	var decoded Digest
+	require.NoError(t, json.Unmarshal(raw, &decoded))
+	assert.True(t, source.Equal(&decoded))
+}
@@ -0,0 +1,34 @@
+// This file was automatically generated by genny.
+// Any changes will be lost if this file is regenerated.
+// see https://github.com/cheekybits/genny
+
+package model
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// MarshalFunctionVersion marshals t to a json encoded byte array.
+func MarshalFunctionVersion(t *FunctionVersion) ([]byte, error) {
+	if t == nil {
+		return nil, errors.New("function-version is nil")
+	}
+	s, err := json.Marshal(t)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not marshal function-version")
+	}
+	return s, nil
+}
+
+// UnmarshalFunctionVersion unmarshals a json encoded *FunctionVersion to t
+func UnmarshalFunctionVersion(s []byte, t *FunctionVersion) error {
+	if t == nil {
+		return errors.New("target function-version is nil")
+	}
+	if err := json.Unmarshal(s, t); err != nil {
+		return errors.Wrap(err, "could not unmarshal function-version")
+	}
+	return nil
+}
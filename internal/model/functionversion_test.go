@@ -0,0 +1,37 @@
+// This file was automatically generated by genny.
+// Any changes will be lost if this file is regenerated.
+// see https://github.com/cheekybits/genny
+
+package model
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/fragments/fragments/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshalFunctionVersion(t *testing.T) {
+	// Marshal
+	_, err := MarshalFunctionVersion(nil)
+	require.Error(t, err)
+	s, err := MarshalFunctionVersion(mockFunctionVersion)
+	require.NoError(t, err)
+	testutils.AssertGolden(t, string(s), "testdata/GoldenFunctionVersion.json")
+
+	// Unmarshal
+	var m FunctionVersion
+	s, err = ioutil.ReadFile("testdata/GoldenFunctionVersion.json")
+	require.NoError(t, err)
+	err = UnmarshalFunctionVersion(nil, nil)
+	require.Error(t, err)
+	err = UnmarshalFunctionVersion(s, nil)
+	require.Error(t, err)
+	err = UnmarshalFunctionVersion(nil, &m)
+	require.Error(t, err)
+	err = UnmarshalFunctionVersion(s, &m)
+	require.NoError(t, err)
+	assert.EqualValues(t, *mockFunctionVersion, m)
+}
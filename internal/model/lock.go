@@ -0,0 +1,34 @@
+// This file was automatically generated by genny.
+// Any changes will be lost if this file is regenerated.
+// see https://github.com/cheekybits/genny
+
+package model
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// MarshalLock marshals t to a json encoded byte array.
+func MarshalLock(t *Lock) ([]byte, error) {
+	if t == nil {
+		return nil, errors.New("lock is nil")
+	}
+	s, err := json.Marshal(t)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not marshal lock")
+	}
+	return s, nil
+}
+
+// UnmarshalLock unmarshals a json encoded *Lock to t
+func UnmarshalLock(s []byte, t *Lock) error {
+	if t == nil {
+		return errors.New("target lock is nil")
+	}
+	if err := json.Unmarshal(s, t); err != nil {
+		return errors.Wrap(err, "could not unmarshal lock")
+	}
+	return nil
+}
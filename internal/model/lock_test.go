@@ -0,0 +1,37 @@
+// This file was automatically generated by genny.
+// Any changes will be lost if this file is regenerated.
+// see https://github.com/cheekybits/genny
+
+package model
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/fragments/fragments/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshalLock(t *testing.T) {
+	// Marshal
+	_, err := MarshalLock(nil)
+	require.Error(t, err)
+	s, err := MarshalLock(mockLock)
+	require.NoError(t, err)
+	testutils.AssertGolden(t, string(s), "testdata/GoldenLock.json")
+
+	// Unmarshal
+	var m Lock
+	s, err = ioutil.ReadFile("testdata/GoldenLock.json")
+	require.NoError(t, err)
+	err = UnmarshalLock(nil, nil)
+	require.Error(t, err)
+	err = UnmarshalLock(s, nil)
+	require.Error(t, err)
+	err = UnmarshalLock(nil, &m)
+	require.Error(t, err)
+	err = UnmarshalLock(s, &m)
+	require.NoError(t, err)
+	assert.EqualValues(t, *mockLock, m)
+}
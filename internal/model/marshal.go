@@ -1,7 +1,11 @@
+//go:generate genny -in=$GOFILE -out=auditevent.go gen "Type=*AuditEvent typename=audit-event"
 //go:generate genny -in=$GOFILE -out=deployment.go gen "Type=*Deployment typename=deployment"
 //go:generate genny -in=$GOFILE -out=environment.go gen "Type=*Environment typename=environment"
 //go:generate genny -in=$GOFILE -out=function.go gen "Type=*Function typename=function"
+//go:generate genny -in=$GOFILE -out=functionversion.go gen "Type=*FunctionVersion typename=function-version"
+//go:generate genny -in=$GOFILE -out=lock.go gen "Type=*Lock typename=lock"
 //go:generate genny -in=$GOFILE -out=pendingupload.go gen "Type=*PendingUpload typename=pending-upload"
+//go:generate genny -in=$GOFILE -out=sourcearchive.go gen "Type=*SourceArchive typename=source-archive"
 
 package model
 
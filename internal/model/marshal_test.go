@@ -1,7 +1,11 @@
+//go:generate genny -in=$GOFILE -out=auditevent_test.go gen "Type=AuditEvent typename=audit-event"
 //go:generate genny -in=$GOFILE -out=deployment_test.go gen "Type=Deployment typename=deployment"
 //go:generate genny -in=$GOFILE -out=environment_test.go gen "Type=Environment typename=environment"
 //go:generate genny -in=$GOFILE -out=function_test.go gen "Type=Function typename=function"
+//go:generate genny -in=$GOFILE -out=functionversion_test.go gen "Type=FunctionVersion typename=function-version"
+//go:generate genny -in=$GOFILE -out=lock_test.go gen "Type=Lock typename=lock"
 //go:generate genny -in=$GOFILE -out=pendingupload_test.go gen "Type=PendingUpload typename=pending-upload"
+//go:generate genny -in=$GOFILE -out=sourcearchive_test.go gen "Type=SourceArchive typename=source-archive"
 
 package model
 
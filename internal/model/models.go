@@ -1,9 +1,14 @@
 package model
 
+import "time"
+
 // Function represents a function specification.
 type Function struct {
 	// Name is the unique name for a function.
 	Name string `json:"name,omitempty"`
+	// Namespace is the namespace the function is in, function names are only
+	// unique within it. It is set from the key the function is stored under.
+	Namespace string `json:"namespace,omitempty"`
 	// Labels are labels used to identify a function.
 	Labels map[string]string `json:"labels,omitempty"`
 	// Runtime is the function runtime.
@@ -16,6 +21,16 @@ type Function struct {
 	SourceFilename string `json:"source_filename,omitempty"`
 	// AWS is the Amazon Web Services specific configuration for the function.
 	AWS *FunctionAWS `json:"aws,omitempty"`
+	// Owner identifies where the function was applied from. Only the owner can
+	// prune the function.
+	Owner string `json:"owner,omitempty"`
+	// Version is the current version of the function. It is set when source
+	// has been confirmed and is 0 until then.
+	Version int64 `json:"version,omitempty"`
+	// Revision is the revision of the state store the function was last
+	// modified at. It is not stored with the function. When set on input the
+	// function is only updated if it has not been modified since.
+	Revision int64 `json:"revision,omitempty"`
 }
 
 // FunctionAWS contains AWS function (Lambda) specific configuration info.
@@ -24,6 +39,8 @@ type FunctionAWS struct {
 	Timeout int64 `json:"timeout,omitempty"`
 	// Memory is the memory in mb for the function.,
 	Memory int64 `json:"memory,omitempty"`
+	// Handler is the function entrypoint within the source.
+	Handler string `json:"handler,omitempty"`
 }
 
 // PendingUpload is a source code request that has been returned to the client.
@@ -33,6 +50,8 @@ type PendingUpload struct {
 	// Token is the unique token to identify a pending upload.
 	Token string `json:"token,omitempty"`
 	// Filename is the filename to retrieve the source by from the filestore.
+	// It is only set for uploads requested before source was keyed by its
+	// digest, other uploads are named after their digest when confirmed.
 	Filename string `json:"filename,omitempty"`
 	// PreviousFilename is the filename of the previous source in case the
 	// function code has been updated. It is blank if the function did not exist
@@ -42,6 +61,116 @@ type PendingUpload struct {
 	// source code upload has been confirmed the function is created with this
 	// configuration.
 	Function *Function `json:"function,omitempty"`
+	// Created is the time the upload was requested. Pending uploads that are
+	// not confirmed in time are deleted.
+	Created time.Time `json:"created"`
+	// BaseVersion is the version of the function when the upload was
+	// requested. The upload can't be confirmed if the function has been
+	// updated since.
+	BaseVersion int64 `json:"base_version,omitempty"`
+}
+
+// SourceArchive is a persisted source archive. Archives are keyed by the
+// SHA256 of their content, functions with the same source checksum and owner
+// share an archive.
+type SourceArchive struct {
+	// Checksum is the source checksum declared for the archive when it was
+	// uploaded.
+	Checksum string `json:"checksum,omitempty"`
+	// Filename is the name of the archive in the filestore.
+	Filename string `json:"filename,omitempty"`
+	// Owner is the owner of the function the archive was uploaded for.
+	Owner string `json:"owner,omitempty"`
+	// References is the number of function versions referencing the
+	// archive. Archives without references can be collected.
+	References int64 `json:"references"`
+	// Created is the time the archive was persisted.
+	Created time.Time `json:"created"`
+}
+
+// FunctionVersion is an immutable record of a function's source and
+// configuration. A version is recorded every time function source is
+// confirmed or the function is rolled back.
+type FunctionVersion struct {
+	// Version is the version number. The first version of a function is 1.
+	Version int64 `json:"version,omitempty"`
+	// Function is the function configuration of the version, including the
+	// checksum and filename of the source.
+	Function *Function `json:"function,omitempty"`
+	// Created is the time the version was recorded.
+	Created time.Time `json:"created"`
+	// AppliedBy identifies who applied the version.
+	AppliedBy string `json:"applied_by,omitempty"`
+	// RollbackOf is the version that was restored in case the version was
+	// recorded by a rollback.
+	RollbackOf int64 `json:"rollback_of,omitempty"`
+}
+
+// Lock describes who holds a lock.
+type Lock struct {
+	// Holder identifies who holds the lock.
+	Holder string `json:"holder,omitempty"`
+	// Since is the time the lock was acquired.
+	Since time.Time `json:"since"`
+	// Expires is the time the lock is released automatically. It is zero for
+	// locks that are held until released.
+	Expires time.Time `json:"expires"`
+}
+
+// AuditAction is an action recorded in the audit log.
+type AuditAction string
+
+const (
+	// AuditActionPut creates or updates a model.
+	AuditActionPut AuditAction = "put"
+	// AuditActionConfirmUpload confirms the source upload of a function.
+	AuditActionConfirmUpload AuditAction = "confirm-upload"
+	// AuditActionRollback restores a previous version of a function.
+	AuditActionRollback AuditAction = "rollback"
+	// AuditActionCreate creates a model.
+	AuditActionCreate AuditAction = "create"
+	// AuditActionUpdate updates a model.
+	AuditActionUpdate AuditAction = "update"
+	// AuditActionDelete deletes a model.
+	AuditActionDelete AuditAction = "delete"
+)
+
+// AuditOutcome is the outcome of an audited action.
+type AuditOutcome string
+
+const (
+	// AuditOutcomeSuccess is the outcome of actions that succeeded.
+	AuditOutcomeSuccess AuditOutcome = "success"
+	// AuditOutcomeFailure is the outcome of actions that failed or were
+	// denied.
+	AuditOutcomeFailure AuditOutcome = "failure"
+)
+
+// AuditEvent records an attempt to change the state.
+type AuditEvent struct {
+	// Time is the time the action completed.
+	Time time.Time `json:"time"`
+	// Actor identifies who performed the action.
+	Actor string `json:"actor,omitempty"`
+	// SourceHost is the host the request was sent from.
+	SourceHost string `json:"source_host,omitempty"`
+	// Namespace is the namespace of the model.
+	Namespace string `json:"namespace,omitempty"`
+	// Action is what was done to the model.
+	Action AuditAction `json:"action"`
+	// ModelType is the type of the model, function, deployment or
+	// environment.
+	ModelType string `json:"model_type"`
+	// ModelName is the name of the model.
+	ModelName string `json:"model_name,omitempty"`
+	// OldChecksum is the source checksum of a function before the action.
+	OldChecksum string `json:"old_checksum,omitempty"`
+	// NewChecksum is the source checksum of a function after the action.
+	NewChecksum string `json:"new_checksum,omitempty"`
+	// Outcome is whether the action succeeded.
+	Outcome AuditOutcome `json:"outcome"`
+	// Error is the reason the action failed.
+	Error string `json:"error,omitempty"`
 }
 
 // InfraType is a target infrastructure to deploy to
@@ -56,23 +185,40 @@ const (
 type Environment struct {
 	// Name is the unique name for an environment.
 	Name string `json:"name,omitempty"`
+	// Namespace is the namespace the environment belongs to. It is not part of
+	// the stored environment, the server fills it in when reading it.
+	Namespace string `json:"namespace,omitempty"`
 	// Labels are labels used to identify an environment.
 	Labels map[string]string `json:"labels,omitempty"`
 	// Infrastructure defines what type the infrastructure type is for the environment.
 	Infrastructure InfraType `json:"infrastructure,omitempty"`
 	// AWS specifies AWS specific deployment information
 	AWS *InfrastructureAWS `json:"aws,omitempty"`
+	// CredentialsGeneration is incremented every time the credentials of the
+	// environment are rotated. It identifies the current credentials in the
+	// secret store.
+	CredentialsGeneration int64 `json:"credentials_generation,omitempty"`
+	// Revision is the revision of the state store the environment was last
+	// modified at. It is not stored with the environment.
+	Revision int64 `json:"revision,omitempty"`
 }
 
 // InfrastructureAWS contains information for an AWS deployment
 type InfrastructureAWS struct {
+	// Region is the region functions are deployed to.
 	Region string `json:"region,omitempty"`
+	// Role is the ARN of the IAM role functions are executed as.
+	Role string `json:"role,omitempty"`
 }
 
 // Deployment represents a connection between functions to environments.
 type Deployment struct {
 	// Name is the unique name for a deployment.
 	Name string `json:"name,omitempty"`
+	// Namespace is the namespace of the deployment. Deployments only select
+	// functions and environments of their own namespace. It is derived from
+	// the storage path and not stored.
+	Namespace string `json:"namespace,omitempty"`
 	// EnvironmentLabels is the label seletor for which environment(s) should be
 	// the destination of the deployment. The environment must have every label
 	// assigned to be included.
@@ -80,4 +226,11 @@ type Deployment struct {
 	// FunctionLabels is the label selector for which function(s) should be part
 	// of the deployment. Every label must match for the function to be included.
 	FunctionLabels map[string]string `json:"function_labels,omitempty"`
+	// Owner identifies where the deployment was applied from. Only the owner
+	// can prune the deployment.
+	Owner string `json:"owner,omitempty"`
+	// Revision is the revision of the state store the deployment was last
+	// modified at. It is not stored with the deployment. When set on input
+	// the deployment is only updated if it has not been modified since.
+	Revision int64 `json:"revision,omitempty"`
 }
@@ -1,5 +1,7 @@
 package model
 
+import "time"
+
 var mockType = &map[string]interface{}{
 	"Test": "generic",
 }
@@ -15,7 +17,18 @@ var mockFunction = &Function{
 	AWS: &FunctionAWS{
 		Timeout: 3,
 		Memory:  512,
+		Handler: "index.handler",
 	},
+	Owner:    "repo",
+	Version:  2,
+	Revision: 12,
+}
+
+var mockFunctionVersion = &FunctionVersion{
+	Version:   2,
+	Function:  mockFunction,
+	Created:   time.Date(2017, 11, 1, 12, 0, 0, 0, time.UTC),
+	AppliedBy: "user@host",
 }
 
 var mockDeployment = &Deployment{
@@ -26,6 +39,8 @@ var mockDeployment = &Deployment{
 	EnvironmentLabels: map[string]string{
 		"deploy": "bar",
 	},
+	Owner:    "repo",
+	Revision: 14,
 }
 
 var mockEnvironment = &Environment{
@@ -36,11 +51,42 @@ var mockEnvironment = &Environment{
 	Infrastructure: InfrastructureTypeAWS,
 	AWS: &InfrastructureAWS{
 		Region: "us-west-2",
+		Role:   "arn:aws:iam::123456789012:role/lambda",
 	},
+	CredentialsGeneration: 1,
+	Revision:              13,
+}
+
+var mockAuditEvent = &AuditEvent{
+	Time:        time.Date(2017, 11, 1, 12, 0, 0, 0, time.UTC),
+	Actor:       "user@host",
+	SourceHost:  "10.0.0.1",
+	Namespace:   "team-a",
+	Action:      AuditActionConfirmUpload,
+	ModelType:   "function",
+	ModelName:   "foo",
+	OldChecksum: "abc",
+	NewChecksum: "def",
+	Outcome:     AuditOutcomeSuccess,
+}
+
+var mockSourceArchive = &SourceArchive{
+	Checksum:   "abc",
+	Filename:   "abc",
+	References: 2,
+	Created:    time.Date(2017, 11, 1, 12, 0, 0, 0, time.UTC),
+}
+
+var mockLock = &Lock{
+	Holder:  "user@host",
+	Since:   time.Date(2017, 11, 1, 12, 0, 0, 0, time.UTC),
+	Expires: time.Date(2017, 11, 1, 12, 15, 0, 0, time.UTC),
 }
 
 var mockPendingUpload = &PendingUpload{
-	Token:    "abc",
-	Filename: "file.tar.gz",
-	Function: mockFunction,
+	Token:       "abc",
+	Filename:    "file.tar.gz",
+	Function:    mockFunction,
+	Created:     time.Date(2017, 11, 1, 12, 0, 0, 0, time.UTC),
+	BaseVersion: 1,
 }
@@ -0,0 +1,39 @@
+package model
+
+// MatchLabels returns true if labels contain every label in the selector with
+// the same value. An empty selector matches nothing.
+func MatchLabels(selector, labels map[string]string) bool {
+	if len(selector) == 0 {
+		return false
+	}
+	for k, v := range selector {
+		if l, ok := labels[k]; !ok || l != v {
+			return false
+		}
+	}
+	return true
+}
+
+// SelectFunctions returns the functions matched by the deployment's function
+// label selector.
+func (d *Deployment) SelectFunctions(functions []*Function) []*Function {
+	out := []*Function{}
+	for _, f := range functions {
+		if MatchLabels(d.FunctionLabels, f.Labels) {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// SelectEnvironments returns the environments matched by the deployment's
+// environment label selector.
+func (d *Deployment) SelectEnvironments(environments []*Environment) []*Environment {
+	out := []*Environment{}
+	for _, e := range environments {
+		if MatchLabels(d.EnvironmentLabels, e.Labels) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
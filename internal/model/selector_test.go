@@ -0,0 +1,76 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchLabels(t *testing.T) {
+	tests := []struct {
+		TestName string
+		Selector map[string]string
+		Labels   map[string]string
+		Match    bool
+	}{
+		{
+			TestName: "Empty selector",
+			Selector: nil,
+			Labels:   map[string]string{"foo": "foo"},
+			Match:    false,
+		},
+		{
+			TestName: "No labels",
+			Selector: map[string]string{"foo": "foo"},
+			Labels:   nil,
+			Match:    false,
+		},
+		{
+			TestName: "Different value",
+			Selector: map[string]string{"foo": "foo"},
+			Labels:   map[string]string{"foo": "bar"},
+			Match:    false,
+		},
+		{
+			TestName: "Partial match",
+			Selector: map[string]string{"foo": "foo", "bar": "bar"},
+			Labels:   map[string]string{"foo": "foo"},
+			Match:    false,
+		},
+		{
+			TestName: "Match",
+			Selector: map[string]string{"foo": "foo"},
+			Labels:   map[string]string{"foo": "foo"},
+			Match:    true,
+		},
+		{
+			TestName: "Match subset",
+			Selector: map[string]string{"foo": "foo"},
+			Labels:   map[string]string{"foo": "foo", "bar": "bar"},
+			Match:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			assert.Equal(t, test.Match, MatchLabels(test.Selector, test.Labels))
+		})
+	}
+}
+
+func TestDeploymentSelect(t *testing.T) {
+	d := &Deployment{
+		Name:              "deploy",
+		FunctionLabels:    map[string]string{"app": "foo"},
+		EnvironmentLabels: map[string]string{"stage": "prod"},
+	}
+
+	foo := &Function{Name: "foo", Labels: map[string]string{"app": "foo"}}
+	bar := &Function{Name: "bar", Labels: map[string]string{"app": "bar"}}
+	assert.Equal(t, []*Function{foo}, d.SelectFunctions([]*Function{foo, bar}))
+
+	prod := &Environment{Name: "prod", Labels: map[string]string{"stage": "prod"}}
+	dev := &Environment{Name: "dev", Labels: map[string]string{"stage": "dev"}}
+	assert.Equal(t, []*Environment{prod}, d.SelectEnvironments([]*Environment{dev, prod}))
+	assert.Empty(t, d.SelectEnvironments(nil))
+}
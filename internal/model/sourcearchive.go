@@ -0,0 +1,34 @@
+// This file was automatically generated by genny.
+// Any changes will be lost if this file is regenerated.
+// see https://github.com/cheekybits/genny
+
+package model
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// MarshalSourceArchive marshals t to a json encoded byte array.
+func MarshalSourceArchive(t *SourceArchive) ([]byte, error) {
+	if t == nil {
+		return nil, errors.New("source-archive is nil")
+	}
+	s, err := json.Marshal(t)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not marshal source-archive")
+	}
+	return s, nil
+}
+
+// UnmarshalSourceArchive unmarshals a json encoded *SourceArchive to t
+func UnmarshalSourceArchive(s []byte, t *SourceArchive) error {
+	if t == nil {
+		return errors.New("target source-archive is nil")
+	}
+	if err := json.Unmarshal(s, t); err != nil {
+		return errors.Wrap(err, "could not unmarshal source-archive")
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+// This file was automatically generated by genny.
+// Any changes will be lost if this file is regenerated.
+// see https://github.com/cheekybits/genny
+
+package model
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/fragments/fragments/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshalSourceArchive(t *testing.T) {
+	// Marshal
+	_, err := MarshalSourceArchive(nil)
+	require.Error(t, err)
+	s, err := MarshalSourceArchive(mockSourceArchive)
+	require.NoError(t, err)
+	testutils.AssertGolden(t, string(s), "testdata/GoldenSourceArchive.json")
+
+	// Unmarshal
+	var m SourceArchive
+	s, err = ioutil.ReadFile("testdata/GoldenSourceArchive.json")
+	require.NoError(t, err)
+	err = UnmarshalSourceArchive(nil, nil)
+	require.Error(t, err)
+	err = UnmarshalSourceArchive(s, nil)
+	require.Error(t, err)
+	err = UnmarshalSourceArchive(nil, &m)
+	require.Error(t, err)
+	err = UnmarshalSourceArchive(s, &m)
+	require.NoError(t, err)
+	assert.EqualValues(t, *mockSourceArchive, m)
+}
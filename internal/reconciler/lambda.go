@@ -0,0 +1,37 @@
+//go:generate mockery -name LambdaAPI
+
+package reconciler
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/pkg/errors"
+)
+
+// LambdaAPI is the subset of the AWS Lambda API used by the reconciler.
+type LambdaAPI interface {
+	GetFunctionWithContext(aws.Context, *lambda.GetFunctionInput, ...request.Option) (*lambda.GetFunctionOutput, error)
+	CreateFunctionWithContext(aws.Context, *lambda.CreateFunctionInput, ...request.Option) (*lambda.FunctionConfiguration, error)
+	UpdateFunctionCodeWithContext(aws.Context, *lambda.UpdateFunctionCodeInput, ...request.Option) (*lambda.FunctionConfiguration, error)
+	UpdateFunctionConfigurationWithContext(aws.Context, *lambda.UpdateFunctionConfigurationInput, ...request.Option) (*lambda.FunctionConfiguration, error)
+}
+
+// NewLambda creates a Lambda client for a region. The client authenticates
+// with a static access key.
+func NewLambda(region, accessKeyID, secretAccessKey string) (LambdaAPI, error) {
+	if region == "" {
+		return nil, errors.New("region not set")
+	}
+	conf := aws.
+		NewConfig().
+		WithRegion(region).
+		WithCredentials(credentials.NewStaticCredentials(accessKeyID, secretAccessKey, ""))
+	ses, err := session.NewSession(conf)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create session")
+	}
+	return lambda.New(ses), nil
+}
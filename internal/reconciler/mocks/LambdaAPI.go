@@ -0,0 +1,103 @@
+package mocks
+
+import "github.com/stretchr/testify/mock"
+
+import "github.com/aws/aws-sdk-go/aws"
+import "github.com/aws/aws-sdk-go/service/lambda"
+import "github.com/aws/aws-sdk-go/aws/request"
+
+type LambdaAPI struct {
+	mock.Mock
+}
+
+// CreateFunctionWithContext provides a mock function with given fields: _a0, _a1, _a2
+func (_m *LambdaAPI) CreateFunctionWithContext(_a0 aws.Context, _a1 *lambda.CreateFunctionInput, _a2 ...request.Option) (*lambda.FunctionConfiguration, error) {
+	ret := _m.Called(_a0, _a1, _a2)
+
+	var r0 *lambda.FunctionConfiguration
+	if rf, ok := ret.Get(0).(func(aws.Context, *lambda.CreateFunctionInput, ...request.Option) *lambda.FunctionConfiguration); ok {
+		r0 = rf(_a0, _a1, _a2...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*lambda.FunctionConfiguration)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(aws.Context, *lambda.CreateFunctionInput, ...request.Option) error); ok {
+		r1 = rf(_a0, _a1, _a2...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetFunctionWithContext provides a mock function with given fields: _a0, _a1, _a2
+func (_m *LambdaAPI) GetFunctionWithContext(_a0 aws.Context, _a1 *lambda.GetFunctionInput, _a2 ...request.Option) (*lambda.GetFunctionOutput, error) {
+	ret := _m.Called(_a0, _a1, _a2)
+
+	var r0 *lambda.GetFunctionOutput
+	if rf, ok := ret.Get(0).(func(aws.Context, *lambda.GetFunctionInput, ...request.Option) *lambda.GetFunctionOutput); ok {
+		r0 = rf(_a0, _a1, _a2...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*lambda.GetFunctionOutput)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(aws.Context, *lambda.GetFunctionInput, ...request.Option) error); ok {
+		r1 = rf(_a0, _a1, _a2...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UpdateFunctionCodeWithContext provides a mock function with given fields: _a0, _a1, _a2
+func (_m *LambdaAPI) UpdateFunctionCodeWithContext(_a0 aws.Context, _a1 *lambda.UpdateFunctionCodeInput, _a2 ...request.Option) (*lambda.FunctionConfiguration, error) {
+	ret := _m.Called(_a0, _a1, _a2)
+
+	var r0 *lambda.FunctionConfiguration
+	if rf, ok := ret.Get(0).(func(aws.Context, *lambda.UpdateFunctionCodeInput, ...request.Option) *lambda.FunctionConfiguration); ok {
+		r0 = rf(_a0, _a1, _a2...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*lambda.FunctionConfiguration)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(aws.Context, *lambda.UpdateFunctionCodeInput, ...request.Option) error); ok {
+		r1 = rf(_a0, _a1, _a2...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UpdateFunctionConfigurationWithContext provides a mock function with given fields: _a0, _a1, _a2
+func (_m *LambdaAPI) UpdateFunctionConfigurationWithContext(_a0 aws.Context, _a1 *lambda.UpdateFunctionConfigurationInput, _a2 ...request.Option) (*lambda.FunctionConfiguration, error) {
+	ret := _m.Called(_a0, _a1, _a2)
+
+	var r0 *lambda.FunctionConfiguration
+	if rf, ok := ret.Get(0).(func(aws.Context, *lambda.UpdateFunctionConfigurationInput, ...request.Option) *lambda.FunctionConfiguration); ok {
+		r0 = rf(_a0, _a1, _a2...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*lambda.FunctionConfiguration)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(aws.Context, *lambda.UpdateFunctionConfigurationInput, ...request.Option) error); ok {
+		r1 = rf(_a0, _a1, _a2...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
@@ -0,0 +1,388 @@
+// Package reconciler deploys functions to the environments selected by
+// deployments.
+package reconciler
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/fragments/fragments/internal/filestore"
+	"github.com/fragments/fragments/internal/model"
+	"github.com/fragments/fragments/internal/server"
+	"github.com/pkg/errors"
+)
+
+// Store provides the state deployments are resolved against. Models are read
+// from the namespace set in the context.
+type Store interface {
+	// ListNamespaces returns the names of all namespaces.
+	ListNamespaces(ctx context.Context) ([]string, error)
+	// ListDeployments returns all deployments.
+	ListDeployments(ctx context.Context) ([]*model.Deployment, error)
+	// ListFunctions returns all functions.
+	ListFunctions(ctx context.Context) ([]*model.Function, error)
+	// ListEnvironments returns all environments.
+	ListEnvironments(ctx context.Context) ([]*model.Environment, error)
+	// EnvironmentCredentials returns the username and password for an
+	// environment's infrastructure provider.
+	EnvironmentCredentials(ctx context.Context, name string) (string, string, error)
+}
+
+// Reconciler resolves deployments to functions and environments and makes
+// sure the functions are deployed with their current source and
+// configuration.
+type Reconciler struct {
+	Store       Store
+	SourceStore filestore.SourceReader
+	// NewLambda creates a Lambda client for an AWS environment.
+	NewLambda func(region, accessKeyID, secretAccessKey string) (LambdaAPI, error)
+
+	// Converted source is cached by filename. Persisted source files are
+	// never modified, a new source gets a new filename. Entries not used
+	// during a reconcile are dropped at the start of the next one.
+	codeMu   sync.Mutex
+	code     map[string]*functionCode
+	prevCode map[string]*functionCode
+}
+
+// functionCode is a zip archive of a function's source and its checksum.
+type functionCode struct {
+	zip    []byte
+	sha256 string
+}
+
+// New creates a new reconciler. Lambda clients are created with NewLambda.
+func New(store Store, sourceReader filestore.SourceReader) *Reconciler {
+	return &Reconciler{
+		Store:       store,
+		SourceStore: sourceReader,
+		NewLambda:   NewLambda,
+	}
+}
+
+// Run reconciles all deployments every interval until the context is
+// cancelled. Errors are logged.
+func (r *Reconciler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := r.Reconcile(ctx); err != nil {
+			log.Println(errors.Wrap(err, "reconcile failed"))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reconcile deploys every function selected by a deployment to every
+// environment selected by the same deployment. Deployments only select
+// functions and environments in their own namespace. A failure to deploy one
+// function does not stop the others from being deployed, all errors are
+// returned together.
+func (r *Reconciler) Reconcile(ctx context.Context) error {
+	r.codeMu.Lock()
+	r.prevCode, r.code = r.code, make(map[string]*functionCode)
+	r.codeMu.Unlock()
+
+	namespaces, err := r.Store.ListNamespaces(ctx)
+	if err != nil {
+		return errors.Wrap(err, "could not list namespaces")
+	}
+
+	errs := []string{}
+	for _, namespace := range namespaces {
+		nsErrs, err := r.reconcileNamespace(server.WithNamespace(ctx, namespace))
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("namespace %s: %s", namespace, err))
+			continue
+		}
+		for _, e := range nsErrs {
+			if namespace != server.DefaultNamespace {
+				e = fmt.Sprintf("namespace %s: %s", namespace, e)
+			}
+			errs = append(errs, e)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.Errorf("could not reconcile deployments:\n- %s", strings.Join(errs, "\n- "))
+	}
+	return nil
+}
+
+// reconcileNamespace reconciles the deployments of the namespace set in the
+// context. Returns the errors of the functions that could not be deployed,
+// or an error if the namespace could not be read.
+func (r *Reconciler) reconcileNamespace(ctx context.Context) ([]string, error) {
+	deployments, err := r.Store.ListDeployments(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list deployments")
+	}
+	functions, err := r.Store.ListFunctions(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list functions")
+	}
+	environments, err := r.Store.ListEnvironments(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list environments")
+	}
+
+	errs := []string{}
+	clients := make(map[string]LambdaAPI)
+	for _, d := range deployments {
+		for _, env := range d.SelectEnvironments(environments) {
+			client, ok := clients[env.Name]
+			if !ok {
+				client, err = r.lambdaClient(ctx, env)
+				if err != nil {
+					errs = append(errs, fmt.Sprintf("%s: environment %s: %s", d.Name, env.Name, err))
+					continue
+				}
+				clients[env.Name] = client
+			}
+			for _, f := range d.SelectFunctions(functions) {
+				if err := r.deployFunction(ctx, client, env, d, f); err != nil {
+					errs = append(errs, fmt.Sprintf("%s: function %s to %s: %s", d.Name, f.Name, env.Name, err))
+				}
+			}
+		}
+	}
+	return errs, nil
+}
+
+// lambdaClient creates a Lambda client for an environment.
+func (r *Reconciler) lambdaClient(ctx context.Context, env *model.Environment) (LambdaAPI, error) {
+	if env.Infrastructure != model.InfrastructureTypeAWS {
+		return nil, errors.Errorf("unsupported infrastructure %q", env.Infrastructure)
+	}
+	if env.AWS == nil {
+		return nil, errors.New("aws configuration not set")
+	}
+	username, password, err := r.Store.EnvironmentCredentials(ctx, env.Name)
+	if err != nil {
+		return nil, err
+	}
+	return r.NewLambda(env.AWS.Region, username, password)
+}
+
+// deployFunction creates or updates a Lambda function. The function code is
+// only updated if it differs from what is deployed.
+func (r *Reconciler) deployFunction(ctx context.Context, client LambdaAPI, env *model.Environment, d *model.Deployment, f *model.Function) error {
+	if f.SourceFilename == "" {
+		// Source not uploaded yet
+		return nil
+	}
+
+	code, err := r.cachedLambdaCode(ctx, f.SourceFilename)
+	if err != nil {
+		return errors.Wrap(err, "could not get function source")
+	}
+
+	name := lambdaName(d, f)
+	conf := lambdaConfig(env, f)
+
+	existing, err := client.GetFunctionWithContext(ctx, &lambda.GetFunctionInput{
+		FunctionName: aws.String(name),
+	})
+	if err != nil {
+		if e, ok := err.(awserr.Error); ok && e.Code() == lambda.ErrCodeResourceNotFoundException {
+			return createFunction(ctx, client, name, conf, code.zip)
+		}
+		return errors.Wrap(err, "could not get deployed function")
+	}
+
+	if aws.StringValue(existing.Configuration.CodeSha256) != code.sha256 {
+		_, err := client.UpdateFunctionCodeWithContext(ctx, &lambda.UpdateFunctionCodeInput{
+			FunctionName: aws.String(name),
+			ZipFile:      code.zip,
+		})
+		if err != nil {
+			return errors.Wrap(err, "could not update function code")
+		}
+	}
+
+	if !configEqual(existing.Configuration, conf) {
+		_, err := client.UpdateFunctionConfigurationWithContext(ctx, &lambda.UpdateFunctionConfigurationInput{
+			FunctionName: aws.String(name),
+			Handler:      conf.Handler,
+			MemorySize:   conf.MemorySize,
+			Role:         conf.Role,
+			Runtime:      conf.Runtime,
+			Timeout:      conf.Timeout,
+		})
+		if err != nil {
+			return errors.Wrap(err, "could not update function configuration")
+		}
+	}
+
+	return nil
+}
+
+func createFunction(ctx context.Context, client LambdaAPI, name string, conf *lambda.FunctionConfiguration, code []byte) error {
+	if aws.StringValue(conf.Role) == "" {
+		return errors.New("environment has no aws role")
+	}
+	if aws.StringValue(conf.Handler) == "" {
+		return errors.New("function has no aws handler")
+	}
+	_, err := client.CreateFunctionWithContext(ctx, &lambda.CreateFunctionInput{
+		FunctionName: aws.String(name),
+		Code:         &lambda.FunctionCode{ZipFile: code},
+		Handler:      conf.Handler,
+		MemorySize:   conf.MemorySize,
+		Role:         conf.Role,
+		Runtime:      conf.Runtime,
+		Timeout:      conf.Timeout,
+	})
+	if err != nil {
+		return errors.Wrap(err, "could not create function")
+	}
+	return nil
+}
+
+// cachedLambdaCode returns the converted source of a function. The source is
+// only read from the source store if it was not used in this or the previous
+// reconcile.
+func (r *Reconciler) cachedLambdaCode(ctx context.Context, filename string) (*functionCode, error) {
+	r.codeMu.Lock()
+	defer r.codeMu.Unlock()
+
+	if r.code == nil {
+		r.code = make(map[string]*functionCode)
+	}
+	if code, ok := r.code[filename]; ok {
+		return code, nil
+	}
+	if code, ok := r.prevCode[filename]; ok {
+		r.code[filename] = code
+		return code, nil
+	}
+
+	zip, err := r.lambdaCode(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+	code := &functionCode{zip: zip, sha256: codeSha256(zip)}
+	r.code[filename] = code
+	return code, nil
+}
+
+// lambdaCode reads a tar.gz source archive from the source store and converts
+// it to a zip archive.
+func (r *Reconciler) lambdaCode(ctx context.Context, filename string) ([]byte, error) {
+	file, err := r.SourceStore.OpenFile(ctx, filename, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close() // nolint: errcheck
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read source archive")
+	}
+
+	var buf bytes.Buffer
+	if err := filestore.Zip(tar.NewReader(gz), &buf); err != nil {
+		return nil, errors.Wrap(err, "could not convert source to zip")
+	}
+	return buf.Bytes(), nil
+}
+
+// maxLambdaNameLength is the maximum length of a Lambda function name.
+const maxLambdaNameLength = 64
+
+// lambdaNameHashLength is the number of hex characters of the hash suffix of
+// Lambda function names.
+const lambdaNameHashLength = 8
+
+// invalidLambdaNameChars matches the characters Lambda function names can not
+// contain.
+var invalidLambdaNameChars = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// lambdaName returns the name of the Lambda function for a function in a
+// deployment. The name starts with the deployment and function name,
+// prefixed with the namespace outside the default namespace, so it can be
+// recognized in AWS. Names may contain dashes, so the readable part alone
+// could be the same for different functions. A hash of the namespace,
+// deployment and function name is appended to keep the names unique, the
+// readable part is shortened to fit the 64 character limit of Lambda.
+func lambdaName(d *model.Deployment, f *model.Function) string {
+	namespace := d.Namespace
+	if namespace == "" {
+		namespace = server.DefaultNamespace
+	}
+	readable := fmt.Sprintf("%s-%s", d.Name, f.Name)
+	if namespace != server.DefaultNamespace {
+		readable = fmt.Sprintf("%s-%s", namespace, readable)
+	}
+	readable = invalidLambdaNameChars.ReplaceAllString(readable, "-")
+	if max := maxLambdaNameLength - lambdaNameHashLength - 1; len(readable) > max {
+		readable = readable[:max]
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join([]string{namespace, d.Name, f.Name}, "\x00")))
+	return fmt.Sprintf("%s-%s", readable, hex.EncodeToString(sum[:])[:lambdaNameHashLength])
+}
+
+// lambdaConfig returns the desired Lambda configuration of a function.
+func lambdaConfig(env *model.Environment, f *model.Function) *lambda.FunctionConfiguration {
+	conf := &lambda.FunctionConfiguration{
+		Runtime: aws.String(f.Runtime),
+		Role:    aws.String(env.AWS.Role),
+	}
+	if f.AWS != nil {
+		conf.Handler = aws.String(f.AWS.Handler)
+		if f.AWS.Memory > 0 {
+			conf.MemorySize = aws.Int64(f.AWS.Memory)
+		}
+		if f.AWS.Timeout > 0 {
+			conf.Timeout = aws.Int64(f.AWS.Timeout)
+		}
+	}
+	return conf
+}
+
+// configEqual returns true if the deployed configuration matches the desired
+// configuration. Unset desired values are not compared.
+func configEqual(deployed, desired *lambda.FunctionConfiguration) bool {
+	if aws.StringValue(deployed.Runtime) != aws.StringValue(desired.Runtime) {
+		return false
+	}
+	if aws.StringValue(deployed.Role) != aws.StringValue(desired.Role) {
+		return false
+	}
+	if aws.StringValue(deployed.Handler) != aws.StringValue(desired.Handler) {
+		return false
+	}
+	if desired.MemorySize != nil && aws.Int64Value(deployed.MemorySize) != aws.Int64Value(desired.MemorySize) {
+		return false
+	}
+	if desired.Timeout != nil && aws.Int64Value(deployed.Timeout) != aws.Int64Value(desired.Timeout) {
+		return false
+	}
+	return true
+}
+
+// codeSha256 returns the checksum of a zip archive in the format Lambda
+// reports it.
+func codeSha256(code []byte) string {
+	sum := sha256.Sum256(code)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,392 @@
+package reconciler
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	fsmocks "github.com/fragments/fragments/internal/filestore/mocks"
+	"github.com/fragments/fragments/internal/model"
+	"github.com/fragments/fragments/internal/reconciler/mocks"
+	"github.com/fragments/fragments/internal/server"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type testStore struct {
+	deployments  []*model.Deployment
+	functions    []*model.Function
+	environments []*model.Environment
+	credentials  map[string][2]string
+	// namespaces are the namespaces listed, only the default namespace has
+	// models.
+	namespaces []string
+	// listed records the namespaces deployments were listed in.
+	listed []string
+}
+
+func (s *testStore) ListNamespaces(ctx context.Context) ([]string, error) {
+	if s.namespaces == nil {
+		return []string{server.DefaultNamespace}, nil
+	}
+	return s.namespaces, nil
+}
+
+func (s *testStore) ListDeployments(ctx context.Context) ([]*model.Deployment, error) {
+	namespace := server.NamespaceFromContext(ctx)
+	s.listed = append(s.listed, namespace)
+	if namespace != server.DefaultNamespace {
+		return nil, nil
+	}
+	return s.deployments, nil
+}
+
+func (s *testStore) ListFunctions(ctx context.Context) ([]*model.Function, error) {
+	return s.functions, nil
+}
+
+func (s *testStore) ListEnvironments(ctx context.Context) ([]*model.Environment, error) {
+	return s.environments, nil
+}
+
+func (s *testStore) EnvironmentCredentials(ctx context.Context, name string) (string, string, error) {
+	c, ok := s.credentials[name]
+	if !ok {
+		return "", "", errors.New("not found")
+	}
+	return c[0], c[1], nil
+}
+
+func newTestStore() *testStore {
+	return &testStore{
+		deployments: []*model.Deployment{
+			{
+				Name:              "deploy",
+				FunctionLabels:    map[string]string{"app": "foo"},
+				EnvironmentLabels: map[string]string{"stage": "prod"},
+			},
+		},
+		functions: []*model.Function{
+			{
+				Name:           "foo",
+				Labels:         map[string]string{"app": "foo"},
+				Runtime:        "nodejs6.10",
+				SourceFilename: "source.tar.gz",
+				AWS: &model.FunctionAWS{
+					Timeout: 3,
+					Memory:  256,
+					Handler: "index.handler",
+				},
+			},
+			{
+				Name:           "bar",
+				Labels:         map[string]string{"app": "bar"},
+				Runtime:        "nodejs6.10",
+				SourceFilename: "other.tar.gz",
+			},
+		},
+		environments: []*model.Environment{
+			{
+				Name:           "prod",
+				Labels:         map[string]string{"stage": "prod"},
+				Infrastructure: model.InfrastructureTypeAWS,
+				AWS: &model.InfrastructureAWS{
+					Region: "us-east-1",
+					Role:   "arn:aws:iam::123456789012:role/lambda",
+				},
+			},
+			{
+				Name:           "dev",
+				Labels:         map[string]string{"stage": "dev"},
+				Infrastructure: model.InfrastructureTypeAWS,
+			},
+		},
+		credentials: map[string][2]string{
+			"prod": {"id", "secret"},
+		},
+	}
+}
+
+func newTestSourceReader(t *testing.T) *fsmocks.SourceReader {
+	sourceReader := &fsmocks.SourceReader{}
+	sourceReader.
+		On("OpenFile", mock.Anything, "source.tar.gz", int64(0), int64(0)).
+		Return(func(context.Context, string, int64, int64) io.ReadCloser {
+			f, err := os.Open("testdata/source.tar.gz")
+			require.NoError(t, err)
+			return f
+		}, nil)
+	return sourceReader
+}
+
+func TestReconcile(t *testing.T) {
+	code, err := New(nil, newTestSourceReader(t)).lambdaCode(context.Background(), "source.tar.gz")
+	require.NoError(t, err)
+	sha := codeSha256(code)
+
+	notFound := awserr.New(lambda.ErrCodeResourceNotFoundException, "not found", nil)
+
+	tests := []struct {
+		TestName string
+		Existing *lambda.FunctionConfiguration
+		GetError error
+		Create   bool
+		Code     bool
+		Config   bool
+		Error    bool
+	}{
+		{
+			TestName: "Create",
+			GetError: notFound,
+			Create:   true,
+		},
+		{
+			TestName: "Get error",
+			GetError: errors.New("get failed"),
+			Error:    true,
+		},
+		{
+			TestName: "No change",
+			Existing: &lambda.FunctionConfiguration{
+				CodeSha256: aws.String(sha),
+				Runtime:    aws.String("nodejs6.10"),
+				Role:       aws.String("arn:aws:iam::123456789012:role/lambda"),
+				Handler:    aws.String("index.handler"),
+				MemorySize: aws.Int64(256),
+				Timeout:    aws.Int64(3),
+			},
+		},
+		{
+			TestName: "Update code",
+			Existing: &lambda.FunctionConfiguration{
+				CodeSha256: aws.String("old"),
+				Runtime:    aws.String("nodejs6.10"),
+				Role:       aws.String("arn:aws:iam::123456789012:role/lambda"),
+				Handler:    aws.String("index.handler"),
+				MemorySize: aws.Int64(256),
+				Timeout:    aws.Int64(3),
+			},
+			Code: true,
+		},
+		{
+			TestName: "Update config",
+			Existing: &lambda.FunctionConfiguration{
+				CodeSha256: aws.String(sha),
+				Runtime:    aws.String("nodejs6.10"),
+				Role:       aws.String("arn:aws:iam::123456789012:role/lambda"),
+				Handler:    aws.String("index.handler"),
+				MemorySize: aws.Int64(128),
+				Timeout:    aws.Int64(3),
+			},
+			Config: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			ctx := context.Background()
+
+			mockLambda := &mocks.LambdaAPI{}
+			mockLambda.
+				On("GetFunctionWithContext", mock.Anything, &lambda.GetFunctionInput{FunctionName: aws.String("deploy-foo-11fb6e7d")}, mock.Anything).
+				Return(&lambda.GetFunctionOutput{Configuration: test.Existing}, test.GetError)
+			if test.Create {
+				mockLambda.
+					On("CreateFunctionWithContext", mock.Anything, mock.MatchedBy(func(input *lambda.CreateFunctionInput) bool {
+						return aws.StringValue(input.FunctionName) == "deploy-foo-11fb6e7d" &&
+							aws.StringValue(input.Handler) == "index.handler" &&
+							aws.StringValue(input.Role) == "arn:aws:iam::123456789012:role/lambda" &&
+							aws.Int64Value(input.MemorySize) == 256 &&
+							codeSha256(input.Code.ZipFile) == sha
+					}), mock.Anything).
+					Return(&lambda.FunctionConfiguration{}, nil)
+			}
+			if test.Code {
+				mockLambda.
+					On("UpdateFunctionCodeWithContext", mock.Anything, &lambda.UpdateFunctionCodeInput{
+						FunctionName: aws.String("deploy-foo-11fb6e7d"),
+						ZipFile:      code,
+					}, mock.Anything).
+					Return(&lambda.FunctionConfiguration{}, nil)
+			}
+			if test.Config {
+				mockLambda.
+					On("UpdateFunctionConfigurationWithContext", mock.Anything, mock.MatchedBy(func(input *lambda.UpdateFunctionConfigurationInput) bool {
+						return aws.StringValue(input.FunctionName) == "deploy-foo-11fb6e7d" &&
+							aws.Int64Value(input.MemorySize) == 256
+					}), mock.Anything).
+					Return(&lambda.FunctionConfiguration{}, nil)
+			}
+
+			r := New(newTestStore(), newTestSourceReader(t))
+			r.NewLambda = func(region, accessKeyID, secretAccessKey string) (LambdaAPI, error) {
+				assert.Equal(t, "us-east-1", region)
+				assert.Equal(t, "id", accessKeyID)
+				assert.Equal(t, "secret", secretAccessKey)
+				return mockLambda, nil
+			}
+
+			err := r.Reconcile(ctx)
+			if test.Error {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			mockLambda.AssertExpectations(t)
+		})
+	}
+}
+
+func TestReconcileEnvironmentErrors(t *testing.T) {
+	ctx := context.Background()
+
+	store := newTestStore()
+	store.deployments[0].EnvironmentLabels = map[string]string{"stage": "dev"}
+
+	r := New(store, newTestSourceReader(t))
+	r.NewLambda = func(region, accessKeyID, secretAccessKey string) (LambdaAPI, error) {
+		t.Fatal("lambda client should not be created")
+		return nil, nil
+	}
+
+	// dev environment has no aws configuration
+	err := r.Reconcile(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "environment dev")
+
+	// Unsupported infrastructure
+	store.environments[1].Infrastructure = "other"
+	err = r.Reconcile(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported infrastructure")
+}
+
+func TestReconcileNamespaces(t *testing.T) {
+	store := newTestStore()
+	store.namespaces = []string{server.DefaultNamespace, "team-a"}
+	store.deployments = nil
+
+	r := New(store, newTestSourceReader(t))
+	require.NoError(t, r.Reconcile(context.Background()))
+	assert.Equal(t, []string{server.DefaultNamespace, "team-a"}, store.listed)
+}
+
+func TestReconcileCachesCode(t *testing.T) {
+	ctx := context.Background()
+
+	code, err := New(nil, newTestSourceReader(t)).lambdaCode(ctx, "source.tar.gz")
+	require.NoError(t, err)
+
+	sourceReader := newTestSourceReader(t)
+	sourceReader.
+		On("OpenFile", mock.Anything, "next.tar.gz", int64(0), int64(0)).
+		Return(func(context.Context, string, int64, int64) io.ReadCloser {
+			f, err := os.Open("testdata/source.tar.gz")
+			require.NoError(t, err)
+			return f
+		}, nil)
+
+	mockLambda := &mocks.LambdaAPI{}
+	mockLambda.
+		On("GetFunctionWithContext", mock.Anything, mock.Anything, mock.Anything).
+		Return(&lambda.GetFunctionOutput{Configuration: &lambda.FunctionConfiguration{
+			CodeSha256: aws.String(codeSha256(code)),
+			Runtime:    aws.String("nodejs6.10"),
+			Role:       aws.String("arn:aws:iam::123456789012:role/lambda"),
+			Handler:    aws.String("index.handler"),
+			MemorySize: aws.Int64(256),
+			Timeout:    aws.Int64(3),
+		}}, nil)
+
+	store := newTestStore()
+	r := New(store, sourceReader)
+	r.NewLambda = func(region, accessKeyID, secretAccessKey string) (LambdaAPI, error) {
+		return mockLambda, nil
+	}
+
+	// Unchanged source is only read once
+	require.NoError(t, r.Reconcile(ctx))
+	require.NoError(t, r.Reconcile(ctx))
+	sourceReader.AssertNumberOfCalls(t, "OpenFile", 1)
+
+	// Source not used during a reconcile is dropped
+	store.functions[0].SourceFilename = "next.tar.gz"
+	require.NoError(t, r.Reconcile(ctx))
+	require.NoError(t, r.Reconcile(ctx))
+	sourceReader.AssertNumberOfCalls(t, "OpenFile", 2)
+	store.functions[0].SourceFilename = "source.tar.gz"
+	require.NoError(t, r.Reconcile(ctx))
+	sourceReader.AssertNumberOfCalls(t, "OpenFile", 3)
+	mockLambda.AssertNotCalled(t, "UpdateFunctionCodeWithContext", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestLambdaName(t *testing.T) {
+	tests := []struct {
+		TestName   string
+		Namespace  string
+		Deployment string
+		Function   string
+		Expected   string
+	}{
+		{
+			TestName:   "No namespace",
+			Deployment: "deploy",
+			Function:   "foo",
+			Expected:   "deploy-foo-11fb6e7d",
+		},
+		{
+			TestName:   "Default namespace",
+			Namespace:  server.DefaultNamespace,
+			Deployment: "deploy",
+			Function:   "foo",
+			Expected:   "deploy-foo-11fb6e7d",
+		},
+		{
+			TestName:   "Namespace",
+			Namespace:  "team-a",
+			Deployment: "deploy",
+			Function:   "foo",
+			Expected:   "team-a-deploy-foo-64c58956",
+		},
+		{
+			TestName:   "Namespace with dashed names",
+			Namespace:  "team-a",
+			Deployment: "b-c",
+			Function:   "f",
+			Expected:   "team-a-b-c-f-df66c753",
+		},
+		{
+			TestName:   "Same readable name in default namespace",
+			Deployment: "team-a-b-c",
+			Function:   "f",
+			Expected:   "team-a-b-c-f-37f4388f",
+		},
+		{
+			TestName:   "Invalid characters",
+			Deployment: "deploy",
+			Function:   "foo.bar/baz",
+			Expected:   "deploy-foo-bar-baz-7f326efa",
+		},
+		{
+			TestName:   "Long names",
+			Deployment: strings.Repeat("d", 40),
+			Function:   strings.Repeat("f", 40),
+			Expected:   strings.Repeat("d", 40) + "-" + strings.Repeat("f", 14) + "-88eea6de",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			name := lambdaName(&model.Deployment{Name: test.Deployment, Namespace: test.Namespace}, &model.Function{Name: test.Function})
+			assert.Equal(t, test.Expected, name)
+			assert.True(t, len(name) <= maxLambdaNameLength)
+		})
+	}
+}
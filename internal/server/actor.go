@@ -0,0 +1,33 @@
+package server
+
+import "context"
+
+type actorKey struct{}
+
+type sourceHostKey struct{}
+
+// WithActor returns a context that identifies who performs the requests made
+// with it. The actor is recorded in function versions.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor set with WithActor. Returns an empty
+// string if no actor is set.
+func ActorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// WithSourceHost returns a context that identifies the host the requests made
+// with it are sent from. The source host is recorded in audit events.
+func WithSourceHost(ctx context.Context, host string) context.Context {
+	return context.WithValue(ctx, sourceHostKey{}, host)
+}
+
+// SourceHostFromContext returns the source host set with WithSourceHost.
+// Returns an empty string if no source host is set.
+func SourceHostFromContext(ctx context.Context) string {
+	host, _ := ctx.Value(sourceHostKey{}).(string)
+	return host
+}
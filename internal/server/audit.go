@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/fragments/fragments/internal/auth"
+	"github.com/fragments/fragments/internal/model"
+	"github.com/pkg/errors"
+)
+
+// auditPath returns the prefix the audit events of the namespace set in the
+// context are stored under.
+func auditPath(ctx context.Context) string {
+	return namespacePath(ctx, "audit/")
+}
+
+// auditEventPath returns the key an audit event is stored at. Keys sort in the
+// order the events were recorded in.
+func auditEventPath(ctx context.Context, t time.Time, token string) string {
+	return fmt.Sprintf("%s%020d-%s", auditPath(ctx), t.UnixNano(), token)
+}
+
+// AuditFilter selects audit events. Fields that are not set match all events.
+type AuditFilter struct {
+	// ModelType is the type of the models, function, deployment or
+	// environment.
+	ModelType string
+	// ModelName is the name of the models.
+	ModelName string
+	// Actor is who performed the actions.
+	Actor string
+	// Since is the earliest time of the events.
+	Since time.Time
+	// Until is the latest time of the events.
+	Until time.Time
+}
+
+// matches returns true if the filter selects the event.
+func (f *AuditFilter) matches(e *model.AuditEvent) bool {
+	switch {
+	case f.ModelType != "" && f.ModelType != e.ModelType:
+		return false
+	case f.ModelName != "" && f.ModelName != e.ModelName:
+		return false
+	case f.Actor != "" && f.Actor != e.Actor:
+		return false
+	case !f.Since.IsZero() && e.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && e.Time.After(f.Until):
+		return false
+	}
+	return true
+}
+
+// ListAuditEvents returns the audit events of the namespace selected by the
+// filter, oldest first.
+func (s *Server) ListAuditEvents(ctx context.Context, filter *AuditFilter) ([]*model.AuditEvent, error) {
+	if err := s.authorize(ctx, auth.VerbRead, auth.ResourceAudit); err != nil {
+		return nil, err
+	}
+	if filter == nil {
+		filter = &AuditFilter{}
+	}
+	raw, err := s.StateStore.List(ctx, auditPath(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list audit events")
+	}
+	out := []*model.AuditEvent{}
+	for _, k := range sortedKeys(raw) {
+		var e model.AuditEvent
+		if err := model.UnmarshalAuditEvent([]byte(raw[k]), &e); err != nil {
+			return nil, errors.Wrap(err, k)
+		}
+		if filter.matches(&e) {
+			out = append(out, &e)
+		}
+	}
+	return out, nil
+}
+
+// audit records the outcome of an action that changes the state. It is
+// deferred at the start of the action with the fields describing the model
+// set as soon as they are known, err points to the error the action returns.
+// Recording is best effort, the action has already completed and failing to
+// record it is only logged.
+func (s *Server) audit(ctx context.Context, event *model.AuditEvent, err *error) {
+	event.Time = s.Now().UTC()
+	event.Actor = ActorFromContext(ctx)
+	event.SourceHost = SourceHostFromContext(ctx)
+	event.Namespace = NamespaceFromContext(ctx)
+	event.Outcome = model.AuditOutcomeSuccess
+	if *err != nil {
+		event.Outcome = model.AuditOutcomeFailure
+		event.Error = (*err).Error()
+	}
+
+	raw, merr := model.MarshalAuditEvent(event)
+	if merr != nil {
+		log.Println(errors.Wrap(merr, "could not record audit event"))
+		return
+	}
+	// The event is stored even if the request has been cancelled. The token
+	// keeps events recorded at the same time apart, it is not generated with
+	// s.GenerateToken which may be fixed.
+	storeCtx := WithNamespace(context.Background(), event.Namespace)
+	key := auditEventPath(storeCtx, event.Time, GenerateToken())
+	if perr := s.StateStore.Put(storeCtx, key, string(raw)); perr != nil {
+		log.Println(errors.Wrap(perr, "could not record audit event"))
+	}
+	if s.AuditLog != nil {
+		s.auditLogMu.Lock()
+		defer s.auditLogMu.Unlock()
+		if _, werr := fmt.Fprintf(s.AuditLog, "%s\n", raw); werr != nil {
+			log.Println(errors.Wrap(werr, "could not write audit log"))
+		}
+	}
+}
+
+// auditEvent returns an audit event for an action on a model.
+func auditEvent(action model.AuditAction, modelType, name string) *model.AuditEvent {
+	return &model.AuditEvent{
+		Action:    action,
+		ModelType: modelType,
+		ModelName: name,
+	}
+}
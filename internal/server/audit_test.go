@@ -0,0 +1,196 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fragments/fragments/internal/auth"
+	"github.com/fragments/fragments/internal/backend"
+	fsmocks "github.com/fragments/fragments/internal/filestore/mocks"
+	"github.com/fragments/fragments/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// withoutAudit returns the data of a store without audit events.
+func withoutAudit(data map[string]string) map[string]string {
+	out := make(map[string]string, len(data))
+	for k, v := range data {
+		if strings.HasPrefix(k, "audit/") || strings.Contains(k, "/audit/") {
+			continue
+		}
+		out[k] = v
+	}
+	return out
+}
+
+func TestAudit(t *testing.T) {
+	ctx := WithActor(context.Background(), "alice")
+	ctx = WithSourceHost(ctx, "10.0.0.1")
+	ctx = WithNamespace(ctx, "team-a")
+
+	sourceStore := &fsmocks.SourceTarget{}
+	sourceStore.On("NewUploadURL", "token").Return("https://token", nil)
+	sourceStore.On("Persist", mock.Anything, "token", "team-a_"+sourceKey(testDigest()), testDigest()).Return(nil)
+	sourceStore.On("Delete", mock.Anything, "token").Return(nil)
+
+	var auditLog bytes.Buffer
+	s := New(backend.NewTestKV(), backend.NewTestKV(), sourceStore)
+	// Every call advances the clock so events are ordered
+	now := testNow()
+	s.Now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+	s.GenerateToken = func() string { return "token" }
+	s.AuditLog = &auditLog
+
+	_, err := s.PutFunction(ctx, &model.Function{Name: "foo", Checksum: "abc"})
+	require.NoError(t, err)
+	require.NoError(t, s.ConfirmUpload(ctx, "token", testDigest()))
+	require.NoError(t, s.PutDeployment(ctx, &model.Deployment{Name: "foo"}))
+	require.NoError(t, s.CreateEnvironment(ctx, &EnvironmentInput{Name: "prod", Username: "u", Password: "p"}))
+	require.Error(t, s.DeleteDeployment(ctx, "bar"))
+	require.NoError(t, s.DeleteFunction(WithActor(ctx, "bob"), "foo", true))
+
+	events, err := s.ListAuditEvents(ctx, nil)
+	require.NoError(t, err)
+	require.Len(t, events, 6)
+	for i, e := range events {
+		if i > 0 {
+			assert.True(t, e.Time.After(events[i-1].Time))
+		}
+		e.Time = time.Time{}
+	}
+
+	event := func(action model.AuditAction, modelType, name, old, new string) *model.AuditEvent {
+		return &model.AuditEvent{
+			Actor:       "alice",
+			SourceHost:  "10.0.0.1",
+			Namespace:   "team-a",
+			Action:      action,
+			ModelType:   modelType,
+			ModelName:   name,
+			OldChecksum: old,
+			NewChecksum: new,
+			Outcome:     model.AuditOutcomeSuccess,
+		}
+	}
+	failed := event(model.AuditActionDelete, modelTypeDeployment, "bar", "", "")
+	failed.Outcome = model.AuditOutcomeFailure
+	failed.Error = "key not found: namespace/team-a/deployment/bar"
+	deleted := event(model.AuditActionDelete, modelTypeFunction, "foo", "abc", "")
+	deleted.Actor = "bob"
+	expected := []*model.AuditEvent{
+		event(model.AuditActionPut, modelTypeFunction, "foo", "", "abc"),
+		event(model.AuditActionConfirmUpload, modelTypeFunction, "foo", "", "abc"),
+		event(model.AuditActionPut, modelTypeDeployment, "foo", "", ""),
+		event(model.AuditActionCreate, modelTypeEnvironment, "prod", "", ""),
+		failed,
+		deleted,
+	}
+
+	assert.Equal(t, expected, events)
+
+	// Events are mirrored to the audit log
+	lines := strings.Split(strings.TrimSpace(auditLog.String()), "\n")
+	require.Len(t, lines, len(expected))
+	for i, line := range lines {
+		var e model.AuditEvent
+		require.NoError(t, model.UnmarshalAuditEvent([]byte(line), &e))
+		e.Time = time.Time{}
+		assert.Equal(t, expected[i], &e)
+	}
+
+	// Events are scoped to the namespace
+	events, err = s.ListAuditEvents(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Empty(t, events)
+}
+
+func TestAuditDenied(t *testing.T) {
+	ctx := auth.WithIdentity(WithActor(context.Background(), "alice"), &auth.Identity{Subject: "alice"})
+	s := New(backend.NewTestKV(), backend.NewTestKV(), nil)
+	s.Authorizer = &auth.Policy{}
+
+	err := s.PutDeployment(ctx, &model.Deployment{Name: "foo"})
+	require.Error(t, err)
+
+	events, err := s.ListAuditEvents(auth.WithIdentity(ctx, auth.SystemIdentity()), nil)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, model.AuditOutcomeFailure, events[0].Outcome)
+	assert.Contains(t, events[0].Error, "permission denied")
+}
+
+func TestListAuditEvents(t *testing.T) {
+	ctx := context.Background()
+	s := New(backend.NewTestKV(), nil, nil)
+	record := func(at time.Time, actor string, action model.AuditAction, modelType, name string) {
+		s.Now = func() time.Time { return at }
+		var err error
+		s.audit(WithActor(ctx, actor), auditEvent(action, modelType, name), &err)
+	}
+	t0 := testNow()
+	record(t0, "alice", model.AuditActionPut, modelTypeFunction, "foo")
+	record(t0.Add(time.Hour), "bob", model.AuditActionPut, modelTypeDeployment, "foo")
+	record(t0.Add(2*time.Hour), "alice", model.AuditActionDelete, modelTypeFunction, "bar")
+
+	tests := []struct {
+		TestName string
+		Filter   *AuditFilter
+		Expected []string
+	}{
+		{
+			TestName: "All",
+			Filter:   &AuditFilter{},
+			Expected: []string{"function/foo", "deployment/foo", "function/bar"},
+		},
+		{
+			TestName: "ModelType",
+			Filter:   &AuditFilter{ModelType: modelTypeFunction},
+			Expected: []string{"function/foo", "function/bar"},
+		},
+		{
+			TestName: "Model",
+			Filter:   &AuditFilter{ModelType: modelTypeFunction, ModelName: "foo"},
+			Expected: []string{"function/foo"},
+		},
+		{
+			TestName: "Actor",
+			Filter:   &AuditFilter{Actor: "bob"},
+			Expected: []string{"deployment/foo"},
+		},
+		{
+			TestName: "Since",
+			Filter:   &AuditFilter{Since: t0.Add(time.Hour)},
+			Expected: []string{"deployment/foo", "function/bar"},
+		},
+		{
+			TestName: "Until",
+			Filter:   &AuditFilter{Until: t0.Add(30 * time.Minute)},
+			Expected: []string{"function/foo"},
+		},
+		{
+			TestName: "None",
+			Filter:   &AuditFilter{Actor: "mallory"},
+			Expected: []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			events, err := s.ListAuditEvents(ctx, test.Filter)
+			require.NoError(t, err)
+			names := []string{}
+			for _, e := range events {
+				names = append(names, e.ModelType+"/"+e.ModelName)
+			}
+			assert.Equal(t, test.Expected, names)
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"context"
+
+	"github.com/fragments/fragments/internal/auth"
+)
+
+// authorize returns an auth.PermissionDeniedError unless the identity set in
+// the context is allowed to perform verb on all resources in the namespace
+// set in the context. Everything is allowed if the server has no authorizer
+// or the identity is a system identity.
+func (s *Server) authorize(ctx context.Context, verb auth.Verb, resources ...auth.Resource) error {
+	return s.authorizeNamespace(ctx, NamespaceFromContext(ctx), verb, resources...)
+}
+
+// authorizeGlobal is like authorize for actions that are not scoped to a
+// single namespace.
+func (s *Server) authorizeGlobal(ctx context.Context, verb auth.Verb, resources ...auth.Resource) error {
+	return s.authorizeNamespace(ctx, "", verb, resources...)
+}
+
+func (s *Server) authorizeNamespace(ctx context.Context, namespace string, verb auth.Verb, resources ...auth.Resource) error {
+	if s.Authorizer == nil {
+		return nil
+	}
+	id := auth.IdentityFromContext(ctx)
+	if id != nil && id.System {
+		return nil
+	}
+	for _, r := range resources {
+		if err := s.Authorizer.Authorize(id, namespace, verb, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
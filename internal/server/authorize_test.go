@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fragments/fragments/internal/auth"
+	"github.com/fragments/fragments/internal/backend"
+	"github.com/fragments/fragments/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthorize(t *testing.T) {
+	identity := func(ns string, id *auth.Identity) context.Context {
+		ctx := WithNamespace(context.Background(), ns)
+		if id != nil {
+			ctx = auth.WithIdentity(ctx, id)
+		}
+		return ctx
+	}
+	alice := &auth.Identity{Subject: "alice", Groups: []string{"team-a"}}
+	ops := &auth.Identity{Subject: "olivia", Groups: []string{"ops"}}
+	dave := &auth.Identity{Subject: "dave"}
+
+	s := New(backend.NewTestKV(), backend.NewTestKV(), nil)
+	s.Authorizer = &auth.Policy{
+		Roles: map[string][]auth.Rule{
+			"deployer": {{Verbs: []string{"read", "write"}, Resources: []string{"function", "deployment"}}},
+		},
+		Bindings: []auth.Binding{
+			{Role: "editor", Groups: []string{"team-a"}, Namespaces: []string{"team-a"}},
+			{Role: "deployer", Subjects: []string{"dave"}, Namespaces: []string{"team-a"}},
+			{Role: "admin", Groups: []string{"ops"}, Namespaces: []string{"*"}},
+		},
+	}
+
+	tests := []struct {
+		TestName string
+		Ctx      context.Context
+		Call     func(ctx context.Context) error
+		Allowed  bool
+	}{
+		{
+			TestName: "ApplyDeployment",
+			Ctx:      identity("team-a", alice),
+			Call: func(ctx context.Context) error {
+				return s.PutDeployment(ctx, &model.Deployment{Name: "api"})
+			},
+			Allowed: true,
+		},
+		{
+			TestName: "OtherNamespace",
+			Ctx:      identity("team-b", alice),
+			Call: func(ctx context.Context) error {
+				return s.PutDeployment(ctx, &model.Deployment{Name: "api"})
+			},
+		},
+		{
+			TestName: "CreateEnvironment",
+			Ctx:      identity("team-a", alice),
+			Call: func(ctx context.Context) error {
+				return s.CreateEnvironment(ctx, &EnvironmentInput{Name: "prod", Username: "u", Password: "p"})
+			},
+		},
+		{
+			TestName: "OpsCreateEnvironment",
+			Ctx:      identity("team-a", ops),
+			Call: func(ctx context.Context) error {
+				return s.CreateEnvironment(ctx, &EnvironmentInput{Name: "prod", Username: "u", Password: "p"})
+			},
+			Allowed: true,
+		},
+		{
+			TestName: "Credentials",
+			Ctx:      identity("team-a", alice),
+			Call: func(ctx context.Context) error {
+				_, _, err := s.EnvironmentCredentials(ctx, "prod")
+				return err
+			},
+		},
+		{
+			TestName: "Plan",
+			Ctx:      identity("team-a", alice),
+			Call: func(ctx context.Context) error {
+				_, err := s.Plan(ctx, &PlanInput{})
+				return err
+			},
+			Allowed: true,
+		},
+		{
+			TestName: "PlanWithoutEnvironmentRead",
+			Ctx:      identity("team-a", dave),
+			Call: func(ctx context.Context) error {
+				_, err := s.Plan(ctx, &PlanInput{})
+				return err
+			},
+		},
+		{
+			TestName: "ListNamespaces",
+			Ctx:      identity("team-a", alice),
+			Call: func(ctx context.Context) error {
+				_, err := s.ListNamespaces(ctx)
+				return err
+			},
+		},
+		{
+			TestName: "Anonymous",
+			Ctx:      identity("team-a", nil),
+			Call: func(ctx context.Context) error {
+				_, err := s.ListDeployments(ctx)
+				return err
+			},
+		},
+		{
+			TestName: "System",
+			Ctx:      identity("team-a", auth.SystemIdentity()),
+			Call: func(ctx context.Context) error {
+				_, err := s.ReencryptSecrets(ctx)
+				return err
+			},
+			Allowed: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			err := test.Call(test.Ctx)
+			if test.Allowed {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.True(t, auth.IsPermissionDenied(err), err.Error())
+		})
+	}
+}
+
+func TestAuthorizeDisabled(t *testing.T) {
+	ctx := WithNamespace(context.Background(), "team-a")
+	s := New(backend.NewTestKV(), backend.NewTestKV(), nil)
+	require.NoError(t, s.PutDeployment(ctx, &model.Deployment{Name: "api"}))
+	_, err := s.ListNamespaces(ctx)
+	require.NoError(t, err)
+}
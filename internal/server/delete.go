@@ -0,0 +1,306 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/fragments/fragments/internal/auth"
+	"github.com/fragments/fragments/internal/backend"
+	"github.com/fragments/fragments/internal/model"
+	"github.com/pkg/errors"
+)
+
+// ReferencedError is returned when deleting a model that is still selected by
+// deployments.
+type ReferencedError struct {
+	// Type is the type of the model that could not be deleted.
+	Type string
+	// Name is the name of the model that could not be deleted.
+	Name string
+	// Deployments are the names of the deployments selecting the model.
+	Deployments []string
+}
+
+// Error returns the error string for a referenced error.
+func (e *ReferencedError) Error() string {
+	return fmt.Sprintf("%s %s is selected by deployments: %s", e.Type, e.Name, strings.Join(e.Deployments, ", "))
+}
+
+// IsReferenced returns true if the error is caused by deleting a model that is
+// still selected by deployments.
+func IsReferenced(err error) bool {
+	_, ok := errors.Cause(err).(*ReferencedError)
+	return ok
+}
+
+// DeleteFunction deletes a function and its source. Unless force is set, a
+// ReferencedError is returned if the function is selected by a deployment.
+// Returns a backend.NotFoundError if the function does not exist.
+func (s *Server) DeleteFunction(ctx context.Context, name string, force bool) (err error) {
+	event := auditEvent(model.AuditActionDelete, modelTypeFunction, name)
+	defer s.audit(ctx, event, &err)
+	if err := s.authorize(ctx, auth.VerbWrite, auth.ResourceFunction); err != nil {
+		return err
+	}
+	if name == "" {
+		return errors.New("function has no name")
+	}
+	unlock, err := s.lock(ctx, functionPath(ctx, name))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	f, err := s.GetFunction(ctx, name)
+	if err != nil {
+		return err
+	}
+	event.OldChecksum = f.Checksum
+
+	if !force {
+		deployments, err := listDeployments(ctx, s.StateStore)
+		if err != nil {
+			return errors.Wrap(err, "could not list deployments")
+		}
+		if err := checkFunctionReferences(f, deployments); err != nil {
+			return err
+		}
+	}
+
+	versions, err := listFunctionVersions(ctx, s.StateStore, name)
+	if err != nil {
+		return errors.Wrap(err, "could not list function versions")
+	}
+
+	// The function is deleted together with its versions. Versions restored
+	// by a rollback share their source with the version they restored.
+	ops := []backend.Op{backend.DeleteOp(functionPath(ctx, name))}
+	references := make(map[string]int64)
+	referenced := make(map[string]*model.Function)
+	for _, v := range versions {
+		ops = append(ops, backend.DeleteOp(functionVersionPath(ctx, name, v.Version)))
+		if v.Function != nil {
+			references[v.Function.SourceFilename]++
+			referenced[v.Function.SourceFilename] = v.Function
+		}
+	}
+
+	// Content addressed archives may be shared with other functions, the
+	// references of the versions are released and archives without
+	// references are left to be collected. Other source is only referenced
+	// by the function and is deleted.
+	sources := []string{f.SourceFilename}
+	shared := make(map[string]bool)
+	for filename, n := range references {
+		refOps, err := s.referenceSourceOps(ctx, referenced[filename], -n)
+		if err != nil {
+			return err
+		}
+		if len(refOps) > 0 {
+			shared[filename] = true
+		}
+		ops = append(ops, refOps...)
+		sources = append(sources, filename)
+	}
+	if _, err := s.StateStore.Txn(ctx, ops...); err != nil {
+		return errors.Wrap(err, "could not delete function")
+	}
+
+	deleted := make(map[string]bool)
+	for _, filename := range sources {
+		if filename == "" || deleted[filename] || shared[filename] {
+			continue
+		}
+		if err := s.SourceStore.Delete(ctx, filename); err != nil {
+			return errors.Wrap(err, "could not delete function source")
+		}
+		deleted[filename] = true
+	}
+
+	return nil
+}
+
+// DeleteDeployment deletes a deployment. Returns a backend.NotFoundError if
+// the deployment does not exist.
+func (s *Server) DeleteDeployment(ctx context.Context, name string) (err error) {
+	event := auditEvent(model.AuditActionDelete, modelTypeDeployment, name)
+	defer s.audit(ctx, event, &err)
+	if err := s.authorize(ctx, auth.VerbWrite, auth.ResourceDeployment); err != nil {
+		return err
+	}
+	if name == "" {
+		return errors.New("deployment has no name")
+	}
+	unlock, err := s.lock(ctx, deploymentPath(ctx, name))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := s.StateStore.Delete(ctx, deploymentPath(ctx, name)); err != nil {
+		if backend.IsNotFound(err) {
+			return err
+		}
+		return errors.Wrap(err, "could not delete deployment")
+	}
+	return nil
+}
+
+// DeleteEnvironment deletes an environment and its credentials. Unless force
+// is set, a ReferencedError is returned if the environment is selected by a
+// deployment. Returns a backend.NotFoundError if the environment does not
+// exist.
+func (s *Server) DeleteEnvironment(ctx context.Context, name string, force bool) (err error) {
+	event := auditEvent(model.AuditActionDelete, modelTypeEnvironment, name)
+	defer s.audit(ctx, event, &err)
+	if err := s.authorize(ctx, auth.VerbWrite, auth.ResourceEnvironment); err != nil {
+		return err
+	}
+	if name == "" {
+		return errors.New("environment has no name")
+	}
+	unlock, err := s.lock(ctx, environmentPath(ctx, name))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	e, err := s.GetEnvironment(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	if !force {
+		deployments, err := listDeployments(ctx, s.StateStore)
+		if err != nil {
+			return errors.Wrap(err, "could not list deployments")
+		}
+		referenced := []string{}
+		for _, d := range deployments {
+			if len(d.SelectEnvironments([]*model.Environment{e})) > 0 {
+				referenced = append(referenced, d.Name)
+			}
+		}
+		if len(referenced) > 0 {
+			return &ReferencedError{Type: modelTypeEnvironment, Name: name, Deployments: referenced}
+		}
+	}
+
+	// The environment is deleted before the credentials so it is never
+	// deployed to without credentials.
+	if err := s.StateStore.Delete(ctx, environmentPath(ctx, name)); err != nil {
+		return errors.Wrap(err, "could not delete environment")
+	}
+
+	if err := deleteUserCredentials(ctx, s.SecretStore, name, e.CredentialsGeneration); err != nil {
+		return errors.Wrap(err, "could not delete credentials")
+	}
+
+	return nil
+}
+
+// PruneInput lists the models to delete when pruning.
+type PruneInput struct {
+	// Owner is the owner the models must have to be deleted.
+	Owner string `json:"owner"`
+	// Functions are the names of the functions to delete.
+	Functions []string `json:"functions,omitempty"`
+	// Deployments are the names of the deployments to delete.
+	Deployments []string `json:"deployments,omitempty"`
+}
+
+// Prune deletes functions and deployments, including the source of deleted
+// functions. Nothing is deleted if any of the models is owned by someone
+// else than the input owner, or if a function would still be selected by a
+// deployment that is not pruned. Models that don't exist are ignored.
+func (s *Server) Prune(ctx context.Context, input *PruneInput) error {
+	if err := s.authorize(ctx, auth.VerbWrite, auth.ResourceFunction, auth.ResourceDeployment); err != nil {
+		return err
+	}
+	if input == nil {
+		return errors.New("no prune input supplied")
+	}
+	if input.Owner == "" {
+		return errors.New("owner not set")
+	}
+
+	functions := []*model.Function{}
+	for _, name := range input.Functions {
+		f, err := getFunction(ctx, s.StateStore, name)
+		if err != nil {
+			return errors.Wrapf(err, "could not get function %s", name)
+		}
+		if f == nil {
+			continue
+		}
+		if f.Owner != input.Owner {
+			return errors.Errorf("function %s is not owned by %s", name, input.Owner)
+		}
+		functions = append(functions, f)
+	}
+
+	pruned := make(map[string]bool)
+	for _, name := range input.Deployments {
+		d, err := getDeployment(ctx, s.StateStore, name)
+		if err != nil {
+			return errors.Wrapf(err, "could not get deployment %s", name)
+		}
+		if d == nil {
+			continue
+		}
+		if d.Owner != input.Owner {
+			return errors.Errorf("deployment %s is not owned by %s", name, input.Owner)
+		}
+		pruned[name] = true
+	}
+
+	deployments, err := listDeployments(ctx, s.StateStore)
+	if err != nil {
+		return errors.Wrap(err, "could not list deployments")
+	}
+	remaining := []*model.Deployment{}
+	for _, d := range deployments {
+		if !pruned[d.Name] {
+			remaining = append(remaining, d)
+		}
+	}
+	for _, f := range functions {
+		if err := checkFunctionReferences(f, remaining); err != nil {
+			return err
+		}
+	}
+
+	// Deployments are deleted first so they never select a deleted function
+	for _, d := range deployments {
+		if !pruned[d.Name] {
+			continue
+		}
+		if err := s.DeleteDeployment(ctx, d.Name); err != nil {
+			return errors.Wrapf(err, "could not delete deployment %s", d.Name)
+		}
+	}
+
+	for _, f := range functions {
+		if err := s.DeleteFunction(ctx, f.Name, false); err != nil {
+			return errors.Wrapf(err, "could not delete function %s", f.Name)
+		}
+	}
+
+	return nil
+}
+
+// checkFunctionReferences returns a ReferencedError if any of the deployments
+// selects the function.
+func checkFunctionReferences(f *model.Function, deployments []*model.Deployment) error {
+	referenced := []string{}
+	for _, d := range deployments {
+		if len(d.SelectFunctions([]*model.Function{f})) > 0 {
+			referenced = append(referenced, d.Name)
+		}
+	}
+	if len(referenced) > 0 {
+		return &ReferencedError{Type: modelTypeFunction, Name: f.Name, Deployments: referenced}
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fragments/fragments/internal/backend"
+	fsmocks "github.com/fragments/fragments/internal/filestore/mocks"
+	"github.com/fragments/fragments/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeleteFunction(t *testing.T) {
+	ctx := context.Background()
+	initial := backend.NewTestKV()
+	require.NoError(t, putFunction(ctx, initial, &model.Function{Name: "foo", Labels: map[string]string{"app": "foo"}, SourceFilename: "foo.tar.gz"}))
+	require.NoError(t, putFunction(ctx, initial, &model.Function{Name: "bar", Labels: map[string]string{"app": "bar"}}))
+	require.NoError(t, putDeployment(ctx, initial, &model.Deployment{Name: "deploy", FunctionLabels: map[string]string{"app": "foo"}}))
+
+	tests := []struct {
+		TestName   string
+		Name       string
+		Force      bool
+		Source     bool
+		NotFound   bool
+		Referenced bool
+		Error      bool
+	}{
+		{
+			TestName: "NoName",
+			Error:    true,
+		},
+		{
+			TestName: "NotFound",
+			Name:     "nonexisting",
+			NotFound: true,
+		},
+		{
+			TestName:   "Referenced",
+			Name:       "foo",
+			Referenced: true,
+		},
+		{
+			TestName: "Force",
+			Name:     "foo",
+			Force:    true,
+			Source:   true,
+		},
+		{
+			TestName: "NoSource",
+			Name:     "bar",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			kv := initial.Copy()
+			mockSourceStore := &fsmocks.SourceTarget{}
+			if test.Source {
+				mockSourceStore.
+					On("Delete", ctx, "foo.tar.gz").
+					Return(nil)
+			}
+
+			s := New(kv, nil, mockSourceStore)
+			err := s.DeleteFunction(ctx, test.Name, test.Force)
+			switch {
+			case test.NotFound:
+				assert.True(t, backend.IsNotFound(err))
+				return
+			case test.Referenced:
+				assert.True(t, IsReferenced(err))
+				assert.Contains(t, kv.Data, functionPath(ctx, test.Name))
+				return
+			case test.Error:
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			mockSourceStore.AssertExpectations(t)
+			assert.NotContains(t, kv.Data, functionPath(ctx, test.Name))
+		})
+	}
+}
+
+func TestDeleteDeployment(t *testing.T) {
+	ctx := context.Background()
+	kv := backend.NewTestKV()
+	require.NoError(t, putDeployment(ctx, kv, &model.Deployment{Name: "foo"}))
+
+	s := New(kv, nil, nil)
+
+	err := s.DeleteDeployment(ctx, "")
+	require.Error(t, err)
+
+	err = s.DeleteDeployment(ctx, "nonexisting")
+	assert.True(t, backend.IsNotFound(err))
+
+	err = s.DeleteDeployment(ctx, "foo")
+	require.NoError(t, err)
+	assert.Empty(t, withoutAudit(kv.Data))
+}
+
+func TestDeleteEnvironment(t *testing.T) {
+	ctx := context.Background()
+	kv := backend.NewTestKV()
+	secrets := backend.NewTestKV()
+	require.NoError(t, putEnvironment(ctx, kv, &model.Environment{Name: "prod", Labels: map[string]string{"stage": "prod"}}))
+	require.NoError(t, putDeployment(ctx, kv, &model.Deployment{Name: "deploy", EnvironmentLabels: map[string]string{"stage": "prod"}}))
+	require.NoError(t, storeUserCredentials(ctx, secrets, "prod", 0, "user", "pass"))
+
+	s := New(kv, secrets, nil)
+
+	err := s.DeleteEnvironment(ctx, "", false)
+	require.Error(t, err)
+
+	err = s.DeleteEnvironment(ctx, "nonexisting", false)
+	assert.True(t, backend.IsNotFound(err))
+
+	err = s.DeleteEnvironment(ctx, "prod", false)
+	assert.True(t, IsReferenced(err))
+	assert.Contains(t, err.Error(), "deploy")
+
+	err = s.DeleteEnvironment(ctx, "prod", true)
+	require.NoError(t, err)
+	assert.NotContains(t, kv.Data, environmentPath(ctx, "prod"))
+	assert.Empty(t, secrets.Data)
+}
+
+func TestPrune(t *testing.T) {
+	ctx := context.Background()
+	initial := backend.NewTestKV()
+	require.NoError(t, putFunction(ctx, initial, &model.Function{Name: "foo", SourceFilename: "foo.tar.gz", Owner: "a"}))
+	require.NoError(t, putFunction(ctx, initial, &model.Function{Name: "bar", Owner: "b"}))
+	require.NoError(t, putDeployment(ctx, initial, &model.Deployment{Name: "foo", Owner: "a"}))
+	require.NoError(t, putDeployment(ctx, initial, &model.Deployment{Name: "bar", FunctionLabels: map[string]string{"app": "bar"}, Owner: "b"}))
+	require.NoError(t, putFunction(ctx, initial, &model.Function{Name: "baz", Labels: map[string]string{"app": "bar"}, Owner: "a"}))
+
+	tests := []struct {
+		TestName  string
+		Input     *PruneInput
+		Remaining []string
+		Error     bool
+	}{
+		{
+			TestName: "NoInput",
+			Error:    true,
+		},
+		{
+			TestName: "NoOwner",
+			Input:    &PruneInput{Functions: []string{"foo"}},
+			Error:    true,
+		},
+		{
+			TestName: "NotOwned",
+			Input:    &PruneInput{Owner: "a", Functions: []string{"foo", "bar"}},
+			Error:    true,
+		},
+		{
+			TestName: "Referenced",
+			Input:    &PruneInput{Owner: "a", Functions: []string{"foo", "baz"}},
+			Error:    true,
+		},
+		{
+			TestName:  "Ok",
+			Input:     &PruneInput{Owner: "a", Functions: []string{"foo", "nonexisting"}, Deployments: []string{"foo"}},
+			Remaining: []string{"deployment/bar", "function/bar", "function/baz"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			kv := initial.Copy()
+			mockSourceStore := &fsmocks.SourceTarget{}
+			mockSourceStore.
+				On("Delete", ctx, "foo.tar.gz").
+				Return(nil)
+
+			s := New(kv, nil, mockSourceStore)
+			err := s.Prune(ctx, test.Input)
+			if test.Error {
+				require.Error(t, err)
+				// Nothing is deleted on error
+				assert.Len(t, withoutAudit(kv.Data), 5)
+				return
+			}
+			require.NoError(t, err)
+			mockSourceStore.AssertExpectations(t)
+
+			assert.Equal(t, test.Remaining, sortedKeys(withoutAudit(kv.Data)))
+		})
+	}
+}
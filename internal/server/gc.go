@@ -0,0 +1,298 @@
+package server
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/fragments/fragments/internal/auth"
+	"github.com/fragments/fragments/internal/backend"
+	"github.com/fragments/fragments/internal/filestore"
+	"github.com/fragments/fragments/internal/model"
+	"github.com/pkg/errors"
+)
+
+// DefaultSourceGracePeriod is the default age unreferenced source must have
+// reached before it is collected.
+const DefaultSourceGracePeriod = 24 * time.Hour
+
+// SourceStatus is what the source collector did with a source file.
+type SourceStatus string
+
+const (
+	// SourceReferenced is source that is referenced by a function, a
+	// function version or a pending upload and is kept.
+	SourceReferenced SourceStatus = "referenced"
+	// SourceRecent is unreferenced source that is younger than the grace
+	// period and is kept.
+	SourceRecent SourceStatus = "recent"
+	// SourceDeleted is unreferenced source that was deleted, or would be
+	// deleted in a dry run.
+	SourceDeleted SourceStatus = "deleted"
+)
+
+// SourceCollection reports the source found by the source collector.
+type SourceCollection struct {
+	// DryRun is set if nothing was deleted.
+	DryRun bool `json:"dry_run"`
+	// Files contains every persisted source file and every source archive
+	// record without a file, sorted by name.
+	Files []*CollectedSource `json:"files"`
+}
+
+// CollectedSource is a source file found by the source collector.
+type CollectedSource struct {
+	// Name is the name of the file in the filestore.
+	Name string `json:"name"`
+	// Size is the size of the file in bytes.
+	Size int64 `json:"size"`
+	// Modified is the time the file was last written.
+	Modified time.Time `json:"modified"`
+	// Missing is set if an archive is recorded but the file does not exist.
+	Missing bool `json:"missing,omitempty"`
+	// Status is what the collector did with the file.
+	Status SourceStatus `json:"status"`
+}
+
+// sourceArchiveRecord is a source archive with the namespace, key and revision
+// it is stored at.
+type sourceArchiveRecord struct {
+	ctx      context.Context
+	key      string
+	archive  *model.SourceArchive
+	revision int64
+}
+
+// sourceReferences are the source files referenced in the state store.
+type sourceReferences struct {
+	// filenames is the set of files referenced by functions, function
+	// versions and pending uploads.
+	filenames map[string]bool
+	// archives are the recorded source archives by filename.
+	archives map[string]*sourceArchiveRecord
+}
+
+// CollectSource deletes persisted source of all namespaces that is not
+// referenced by any function, function version or pending upload and is
+// older than the grace period. DefaultSourceGracePeriod is used if the grace
+// period is 0. Nothing is deleted in a dry run. Returns every source file
+// found and what was done with it.
+func (s *Server) CollectSource(ctx context.Context, gracePeriod time.Duration, dryRun bool) (*SourceCollection, error) {
+	if err := s.authorizeGlobal(ctx, auth.VerbWrite, auth.ResourceFunction); err != nil {
+		return nil, err
+	}
+	if gracePeriod < 0 {
+		return nil, errors.New("grace period must not be negative")
+	}
+	if gracePeriod == 0 {
+		gracePeriod = DefaultSourceGracePeriod
+	}
+	lister, ok := s.SourceStore.(filestore.SourceLister)
+	if !ok {
+		return nil, errors.New("source store can not be listed")
+	}
+
+	// Files are listed before references are collected. Source persisted in
+	// between is younger than the grace period and is kept.
+	files, err := lister.ListFiles(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list source")
+	}
+	namespaces, err := s.ListNamespaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+	refs := &sourceReferences{
+		filenames: make(map[string]bool),
+		archives:  make(map[string]*sourceArchiveRecord),
+	}
+	for _, namespace := range namespaces {
+		if err := s.collectSourceReferences(WithNamespace(ctx, namespace), refs); err != nil {
+			return nil, errors.Wrapf(err, "namespace %s", namespace)
+		}
+	}
+
+	out := &SourceCollection{DryRun: dryRun, Files: []*CollectedSource{}}
+	found := make(map[string]bool)
+	for _, f := range files {
+		found[f.Name] = true
+		out.Files = append(out.Files, &CollectedSource{
+			Name:     f.Name,
+			Size:     f.Size,
+			Modified: f.Modified,
+		})
+	}
+	// Archives that were recorded but never persisted are collected too
+	for filename, record := range refs.archives {
+		if !found[filename] {
+			out.Files = append(out.Files, &CollectedSource{
+				Name:     filename,
+				Modified: record.archive.Created,
+				Missing:  true,
+			})
+		}
+	}
+	sort.Slice(out.Files, func(i, j int) bool {
+		return out.Files[i].Name < out.Files[j].Name
+	})
+
+	cutoff := s.Now().Add(-gracePeriod)
+	for _, c := range out.Files {
+		record := refs.archives[c.Name]
+		switch {
+		case refs.filenames[c.Name] || (record != nil && record.archive.References > 0):
+			c.Status = SourceReferenced
+		case c.Modified.After(cutoff) || (record != nil && record.archive.Created.After(cutoff)):
+			c.Status = SourceRecent
+		case dryRun:
+			c.Status = SourceDeleted
+		default:
+			deleted, err := s.deleteSource(ctx, c, record)
+			if err != nil {
+				return out, errors.Wrapf(err, "could not delete source %s", c.Name)
+			}
+			c.Status = SourceReferenced
+			if deleted {
+				c.Status = SourceDeleted
+			}
+		}
+	}
+	return out, nil
+}
+
+// collectSourceReferences adds the source referenced in the namespace set in
+// the context to refs.
+func (s *Server) collectSourceReferences(ctx context.Context, refs *sourceReferences) error {
+	functions, err := listFunctions(ctx, s.StateStore)
+	if err != nil {
+		return errors.Wrap(err, "could not list functions")
+	}
+	for _, f := range functions {
+		refs.add(f.SourceFilename)
+		versions, err := listFunctionVersions(ctx, s.StateStore, f.Name)
+		if err != nil {
+			return errors.Wrapf(err, "could not list versions of function %s", f.Name)
+		}
+		for _, v := range versions {
+			if v.Function != nil {
+				refs.add(v.Function.SourceFilename)
+			}
+		}
+	}
+
+	uploads, err := listPendingUploads(ctx, s.StateStore)
+	if err != nil {
+		return errors.Wrap(err, "could not list pending uploads")
+	}
+	for _, p := range uploads {
+		refs.add(p.Filename)
+		refs.add(p.PreviousFilename)
+	}
+
+	raw, err := s.StateStore.List(ctx, sourceArchivePath(ctx, ""))
+	if err != nil {
+		return errors.Wrap(err, "could not list source archives")
+	}
+	for key := range raw {
+		archive, revision, err := getSourceArchive(ctx, s.StateStore, key)
+		if err != nil {
+			return errors.Wrapf(err, "could not get source archive %s", key)
+		}
+		if archive == nil {
+			continue
+		}
+		refs.archives[archive.Filename] = &sourceArchiveRecord{
+			ctx:      ctx,
+			key:      key,
+			archive:  archive,
+			revision: revision,
+		}
+	}
+	return nil
+}
+
+// add marks a file as referenced.
+func (r *sourceReferences) add(filename string) {
+	if filename != "" {
+		r.filenames[filename] = true
+	}
+}
+
+// deleteSource deletes unreferenced source and its archive record. The record
+// is deleted first and only if it has not been modified since it was read,
+// the file is kept if the archive has been referenced since. The file is
+// locked while it is deleted and kept if a new record has been created for
+// it, the same source is being persisted again. Returns false if the source
+// was kept.
+func (s *Server) deleteSource(ctx context.Context, c *CollectedSource, record *sourceArchiveRecord) (bool, error) {
+	unlock, err := s.waitLock(ctx, sourceLockKey(c.Name))
+	if err != nil {
+		if IsLocked(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer unlock()
+
+	if record != nil {
+		path := sourceArchivePath(record.ctx, record.key)
+		if _, err := s.StateStore.Txn(record.ctx, backend.DeleteRevisionOp(path, record.revision)); err != nil {
+			if backend.IsConflict(err) {
+				return false, nil
+			}
+			return false, err
+		}
+	}
+	if nsCtx, key, ok := sourceArchiveOf(ctx, c.Name); ok {
+		archive, _, err := getSourceArchive(nsCtx, s.StateStore, key)
+		if err != nil {
+			return false, errors.Wrap(err, "could not check for a new source archive")
+		}
+		if archive != nil && archive.Filename == c.Name {
+			return false, nil
+		}
+	}
+	if c.Missing {
+		return true, nil
+	}
+	if err := s.SourceStore.Delete(ctx, c.Name); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RunSourceCollector collects unreferenced source older than the grace period
+// every interval until the context is cancelled. Errors are logged.
+func (s *Server) RunSourceCollector(ctx context.Context, interval, gracePeriod time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		res, err := s.CollectSource(ctx, gracePeriod, false)
+		if err != nil {
+			log.Println(errors.Wrap(err, "source collection failed"))
+		}
+		if n := res.count(SourceDeleted); n > 0 {
+			log.Printf("Deleted %d unreferenced source files", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// count returns the number of files with a status.
+func (c *SourceCollection) count(status SourceStatus) int {
+	if c == nil {
+		return 0
+	}
+	n := 0
+	for _, f := range c.Files {
+		if f.Status == status {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,254 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fragments/fragments/internal/backend"
+	"github.com/fragments/fragments/internal/filestore"
+	fsmocks "github.com/fragments/fragments/internal/filestore/mocks"
+	"github.com/fragments/fragments/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// listingSourceStore is a source store that lists a fixed set of files.
+type listingSourceStore struct {
+	*fsmocks.SourceTarget
+	files []*filestore.File
+}
+
+func (l *listingSourceStore) ListFiles(ctx context.Context) ([]*filestore.File, error) {
+	return l.files, nil
+}
+
+// putSourceArchive records a source archive with a key.
+func putSourceArchive(ctx context.Context, kv backend.Writer, key string, a *model.SourceArchive) error {
+	raw, err := model.MarshalSourceArchive(a)
+	if err != nil {
+		return err
+	}
+	return kv.Put(ctx, sourceArchivePath(ctx, key), string(raw))
+}
+
+// newSourceState returns a state store referencing source in the default
+// namespace and in team-a.
+func newSourceState(t *testing.T) *backend.TestKV {
+	ctx := context.Background()
+	teamCtx := WithNamespace(ctx, "team-a")
+	old := testNow().Add(-48 * time.Hour)
+	kv := backend.NewTestKV()
+
+	// Source persisted before archives were content addressed
+	require.NoError(t, putFunction(ctx, kv, &model.Function{Name: "foo", Checksum: "v2", SourceFilename: "current"}))
+	op, err := functionVersionOp(ctx, &model.FunctionVersion{
+		Version:  1,
+		Function: &model.Function{Name: "foo", Checksum: "v1", SourceFilename: "history"},
+	})
+	require.NoError(t, err)
+	_, err = kv.Txn(ctx, op)
+	require.NoError(t, err)
+	require.NoError(t, putPendingUpload(ctx, kv, &model.PendingUpload{
+		Token:            "token",
+		Filename:         "pending",
+		PreviousFilename: "current",
+		Function:         &model.Function{Name: "foo", Checksum: "v3"},
+	}))
+
+	// Content addressed archives
+	require.NoError(t, kv.Put(ctx, namespaceRecordPath("team-a"), testNow().Format(time.RFC3339)))
+	require.NoError(t, putSourceArchive(teamCtx, kv, "abc", &model.SourceArchive{Checksum: "abc", Filename: "team-a_abc", References: 1, Created: old}))
+	require.NoError(t, putSourceArchive(ctx, kv, "unused", &model.SourceArchive{Checksum: "unused", Filename: "unused", Created: old}))
+	require.NoError(t, putSourceArchive(ctx, kv, "new", &model.SourceArchive{Checksum: "new", Filename: "new", Created: testNow()}))
+	require.NoError(t, putSourceArchive(ctx, kv, "missing", &model.SourceArchive{Checksum: "missing", Filename: "missing", Created: old}))
+	return kv
+}
+
+func TestCollectSource(t *testing.T) {
+	ctx := context.Background()
+	old := testNow().Add(-48 * time.Hour)
+	files := []*filestore.File{
+		{Name: "current", Size: 1, Modified: old},
+		{Name: "history", Size: 1, Modified: old},
+		{Name: "pending", Size: 1, Modified: old},
+		{Name: "team-a_abc", Size: 1, Modified: old},
+		{Name: "unused", Size: 1, Modified: old},
+		{Name: "new", Size: 1, Modified: old},
+		{Name: "orphan", Size: 1, Modified: old},
+		{Name: "fresh", Size: 1, Modified: testNow().Add(-time.Hour)},
+	}
+	expected := map[string]SourceStatus{
+		"current":    SourceReferenced,
+		"fresh":      SourceRecent,
+		"history":    SourceReferenced,
+		"missing":    SourceDeleted,
+		"new":        SourceRecent,
+		"orphan":     SourceDeleted,
+		"pending":    SourceReferenced,
+		"team-a_abc": SourceReferenced,
+		"unused":     SourceDeleted,
+	}
+
+	tests := []struct {
+		TestName string
+		DryRun   bool
+	}{
+		{
+			TestName: "DryRun",
+			DryRun:   true,
+		},
+		{
+			TestName: "Delete",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			kv := newSourceState(t)
+			sourceStore := &listingSourceStore{SourceTarget: &fsmocks.SourceTarget{}, files: files}
+			if !test.DryRun {
+				sourceStore.On("Delete", ctx, "orphan").Return(nil).Once()
+				sourceStore.On("Delete", ctx, "unused").Return(nil).Once()
+			}
+			s := New(kv, nil, sourceStore)
+			s.Now = testNow
+
+			res, err := s.CollectSource(ctx, 0, test.DryRun)
+			require.NoError(t, err)
+			sourceStore.AssertExpectations(t)
+			assert.Equal(t, test.DryRun, res.DryRun)
+
+			statuses := make(map[string]SourceStatus)
+			names := []string{}
+			for _, f := range res.Files {
+				statuses[f.Name] = f.Status
+				names = append(names, f.Name)
+				assert.Equal(t, f.Name == "missing", f.Missing, f.Name)
+			}
+			assert.Equal(t, expected, statuses)
+			assert.Equal(t, []string{"current", "fresh", "history", "missing", "new", "orphan", "pending", "team-a_abc", "unused"}, names)
+
+			// Archive records are deleted together with their files
+			for checksum, deleted := range map[string]bool{"unused": true, "missing": true, "new": false} {
+				archive, _, err := getSourceArchive(ctx, kv, checksum)
+				require.NoError(t, err)
+				assert.Equal(t, deleted && !test.DryRun, archive == nil, checksum)
+			}
+		})
+	}
+}
+
+func TestCollectSourceReferencedSince(t *testing.T) {
+	ctx := context.Background()
+	kv := backend.NewTestKV()
+	require.NoError(t, putSourceArchive(ctx, kv, "abc", &model.SourceArchive{Checksum: "abc", Filename: "abc", Created: testNow()}))
+	_, revision, err := getSourceArchive(ctx, kv, "abc")
+	require.NoError(t, err)
+
+	// The archive is referenced after the collector read it
+	require.NoError(t, putSourceArchive(ctx, kv, "abc", &model.SourceArchive{Checksum: "abc", Filename: "abc", References: 1, Created: testNow()}))
+	sourceStore := &listingSourceStore{SourceTarget: &fsmocks.SourceTarget{}}
+	s := New(kv, nil, sourceStore)
+
+	deleted, err := s.deleteSource(ctx, &CollectedSource{Name: "abc"}, &sourceArchiveRecord{
+		ctx:      ctx,
+		key:      "abc",
+		archive:  &model.SourceArchive{Checksum: "abc", Filename: "abc"},
+		revision: revision,
+	})
+	require.NoError(t, err)
+	assert.False(t, deleted)
+	sourceStore.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+	archive, _, err := getSourceArchive(ctx, kv, "abc")
+	require.NoError(t, err)
+	assert.NotNil(t, archive)
+}
+
+func TestCollectSourceErrors(t *testing.T) {
+	ctx := context.Background()
+
+	// The source store must be listable
+	s := New(backend.NewTestKV(), nil, &fsmocks.SourceTarget{})
+	_, err := s.CollectSource(ctx, 0, true)
+	assert.Error(t, err)
+
+	s = New(backend.NewTestKV(), nil, &listingSourceStore{SourceTarget: &fsmocks.SourceTarget{}})
+	_, err = s.CollectSource(ctx, -time.Hour, true)
+	assert.Error(t, err)
+}
+
+func TestCollectSourceWhileConfirming(t *testing.T) {
+	ctx := context.Background()
+	kv := backend.NewTestKV()
+	old := testNow().Add(-48 * time.Hour)
+	key := sourceKey(testDigest())
+	require.NoError(t, putSourceArchive(ctx, kv, key, &model.SourceArchive{Checksum: "abc", Filename: key, Created: old}))
+	require.NoError(t, putPendingUpload(ctx, kv, &model.PendingUpload{
+		Token:    "token",
+		Function: &model.Function{Name: "foo", Checksum: "abc"},
+		Created:  testNow(),
+	}))
+
+	// The collector read the unreferenced archive before the upload is
+	// confirmed
+	archive, revision, err := getSourceArchive(ctx, kv, key)
+	require.NoError(t, err)
+	record := &sourceArchiveRecord{ctx: ctx, key: key, archive: archive, revision: revision}
+
+	sourceStore := &listingSourceStore{SourceTarget: &fsmocks.SourceTarget{}}
+	s := New(kv, nil, sourceStore)
+	s.Now = testNow
+	s.LockTimeout = 10 * time.Millisecond
+
+	// The collector tries to delete the archive while the upload is being
+	// persisted
+	var deleted bool
+	sourceStore.On("Persist", ctx, "token", key, testDigest()).Return(nil).Run(func(mock.Arguments) {
+		deleted, err = s.deleteSource(ctx, &CollectedSource{Name: key}, record)
+	}).Once()
+
+	require.NoError(t, s.ConfirmUpload(ctx, "token", testDigest()))
+	require.NoError(t, err)
+	assert.False(t, deleted)
+	sourceStore.AssertExpectations(t)
+	sourceStore.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+
+	archive, _, err = getSourceArchive(ctx, kv, key)
+	require.NoError(t, err)
+	require.NotNil(t, archive)
+	assert.EqualValues(t, 1, archive.References)
+	assert.Equal(t, testNow(), archive.Created)
+}
+
+func TestCollectSourceRecreated(t *testing.T) {
+	ctx := context.Background()
+	nsCtx := WithNamespace(ctx, "team-a")
+	kv := backend.NewTestKV()
+	key := sourceKey(testDigest())
+
+	// The archives were recorded again after the collector found the files
+	// without a record
+	require.NoError(t, putSourceArchive(ctx, kv, key, &model.SourceArchive{Checksum: "abc", Filename: key, Created: testNow()}))
+	require.NoError(t, putSourceArchive(nsCtx, kv, key, &model.SourceArchive{Checksum: "abc", Filename: "team-a_" + key, Created: testNow()}))
+
+	sourceStore := &listingSourceStore{SourceTarget: &fsmocks.SourceTarget{}}
+	sourceStore.On("Delete", ctx, "legacy.tar.gz").Return(nil).Once()
+	s := New(kv, nil, sourceStore)
+	s.Now = testNow
+
+	for _, name := range []string{key, "team-a_" + key} {
+		deleted, err := s.deleteSource(ctx, &CollectedSource{Name: name}, nil)
+		require.NoError(t, err)
+		assert.False(t, deleted, name)
+	}
+	sourceStore.AssertNotCalled(t, "Delete", ctx, key)
+	sourceStore.AssertNotCalled(t, "Delete", ctx, "team-a_"+key)
+
+	// Files that are not content addressed are never recorded again
+	deleted, err := s.deleteSource(ctx, &CollectedSource{Name: "legacy.tar.gz"}, nil)
+	require.NoError(t, err)
+	assert.True(t, deleted)
+	sourceStore.AssertExpectations(t)
+}
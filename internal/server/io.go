@@ -3,47 +3,101 @@ package server
 import (
 	"context"
 	"fmt"
+	"sort"
 
 	"github.com/fragments/fragments/internal/backend"
 	"github.com/fragments/fragments/internal/model"
-	"github.com/golang/sync/errgroup"
 	"github.com/pkg/errors"
 )
 
-func functionPath(name string) string {
-	return fmt.Sprintf("function/%s", name)
+func functionPath(ctx context.Context, name string) string {
+	return namespacePath(ctx, fmt.Sprintf("function/%s", name))
 }
 
-func deploymentPath(name string) string {
-	return fmt.Sprintf("deployment/%s", name)
+func deploymentPath(ctx context.Context, name string) string {
+	return namespacePath(ctx, fmt.Sprintf("deployment/%s", name))
 }
 
-func environmentPath(name string) string {
-	return fmt.Sprintf("environment/%s", name)
+func environmentPath(ctx context.Context, name string) string {
+	return namespacePath(ctx, fmt.Sprintf("environment/%s", name))
 }
 
-func pendingUploadPath(token string) string {
-	return fmt.Sprintf("pendingupload/%s", token)
+func pendingUploadPath(ctx context.Context, token string) string {
+	return namespacePath(ctx, fmt.Sprintf("pendingupload/%s", token))
 }
 
-func userSecretName(name string) string {
-	return fmt.Sprintf("user/%s/name", name)
+// functionVersionsPath returns the prefix all versions of a function are
+// stored under.
+func functionVersionsPath(ctx context.Context, name string) string {
+	return namespacePath(ctx, fmt.Sprintf("functionversion/%s/", name))
 }
 
-func userSecretPass(pass string) string {
-	return fmt.Sprintf("user/%s/pass", pass)
+func functionVersionPath(ctx context.Context, name string, version int64) string {
+	return fmt.Sprintf("%s%d", functionVersionsPath(ctx, name), version)
 }
 
-func putFunction(ctx context.Context, kv backend.Writer, f *model.Function) error {
-	raw, err := model.MarshalFunction(f)
+// lockPath returns the key locked to modify the model stored at key.
+func lockPath(key string) string {
+	return fmt.Sprintf("lock/%s", key)
+}
+
+// lockInfoPath returns the key describing who holds the lock on key.
+func lockInfoPath(key string) string {
+	return fmt.Sprintf("lockinfo/%s", key)
+}
+
+// userSecretPrefix prefixes all environment credentials in the secret store.
+const userSecretPrefix = "user/"
+
+// userSecretName returns the path of an environment's username. Credentials
+// of generation 0 are stored directly under the environment name, rotated
+// credentials under their generation.
+func userSecretName(ctx context.Context, name string, generation int64) string {
+	if generation == 0 {
+		return namespacePath(ctx, fmt.Sprintf("%s%s/name", userSecretPrefix, name))
+	}
+	return namespacePath(ctx, fmt.Sprintf("%s%s/%d/name", userSecretPrefix, name, generation))
+}
+
+// userSecretPass returns the path of an environment's password.
+func userSecretPass(ctx context.Context, name string, generation int64) string {
+	if generation == 0 {
+		return namespacePath(ctx, fmt.Sprintf("%s%s/pass", userSecretPrefix, name))
+	}
+	return namespacePath(ctx, fmt.Sprintf("%s%s/%d/pass", userSecretPrefix, name, generation))
+}
+
+// putFunction stores a function unless it was modified after f.Revision, a
+// revision of 0 requires that the function does not exist yet. The revision
+// is not stored, f.Revision is set to the revision the function was stored at.
+func putFunction(ctx context.Context, kv backend.RevisionWriter, f *model.Function) error {
+	op, err := functionOp(ctx, f)
+	if err != nil {
+		return err
+	}
+	revision, err := kv.PutRevision(ctx, op.Key, op.Value, op.Revision)
 	if err != nil {
 		return err
 	}
-	return kv.Put(ctx, functionPath(f.Name), string(raw))
+	f.Revision = revision
+	return nil
+}
+
+// functionOp returns a transaction operation that stores a function if it has
+// not been modified after its revision.
+func functionOp(ctx context.Context, f *model.Function) (backend.Op, error) {
+	stored := *f
+	stored.Revision = 0
+	stored.Namespace = ""
+	raw, err := model.MarshalFunction(&stored)
+	if err != nil {
+		return backend.Op{}, err
+	}
+	return backend.PutRevisionOp(functionPath(ctx, f.Name), string(raw), f.Revision), nil
 }
 
-func getFunction(ctx context.Context, kv backend.Reader, name string) (*model.Function, error) {
-	raw, err := kv.Get(ctx, functionPath(name))
+func getFunction(ctx context.Context, kv backend.RevisionReader, name string) (*model.Function, error) {
+	raw, revision, err := kv.GetRevision(ctx, functionPath(ctx, name))
 	if err != nil {
 		if backend.IsNotFound(err) {
 			return nil, nil
@@ -54,19 +108,129 @@ func getFunction(ctx context.Context, kv backend.Reader, name string) (*model.Fu
 	if err := model.UnmarshalFunction([]byte(raw), &f); err != nil {
 		return nil, err
 	}
+	f.Namespace = NamespaceFromContext(ctx)
+	f.Revision = revision
 	return &f, nil
 }
 
+func listFunctions(ctx context.Context, kv backend.RevisionReader) ([]*model.Function, error) {
+	raw, err := kv.ListRevisions(ctx, functionPath(ctx, ""))
+	if err != nil {
+		return nil, err
+	}
+	out := []*model.Function{}
+	for _, k := range sortedRevisionKeys(raw) {
+		var f model.Function
+		if err := model.UnmarshalFunction([]byte(raw[k].Value), &f); err != nil {
+			return nil, errors.Wrap(err, k)
+		}
+		f.Namespace = NamespaceFromContext(ctx)
+		f.Revision = raw[k].Revision
+		out = append(out, &f)
+	}
+	return out, nil
+}
+
+// functionVersionOp returns a transaction operation that stores a function
+// version. Versions are immutable, the version must not exist.
+func functionVersionOp(ctx context.Context, v *model.FunctionVersion) (backend.Op, error) {
+	raw, err := model.MarshalFunctionVersion(v)
+	if err != nil {
+		return backend.Op{}, err
+	}
+	return backend.PutRevisionOp(functionVersionPath(ctx, v.Function.Name, v.Version), string(raw), 0), nil
+}
+
+func getFunctionVersion(ctx context.Context, kv backend.Reader, name string, version int64) (*model.FunctionVersion, error) {
+	raw, err := kv.Get(ctx, functionVersionPath(ctx, name, version))
+	if err != nil {
+		if backend.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var v model.FunctionVersion
+	if err := model.UnmarshalFunctionVersion([]byte(raw), &v); err != nil {
+		return nil, err
+	}
+	if v.Function != nil {
+		v.Function.Namespace = NamespaceFromContext(ctx)
+	}
+	return &v, nil
+}
+
+// listFunctionVersions returns the versions of a function, oldest first.
+func listFunctionVersions(ctx context.Context, kv backend.Lister, name string) ([]*model.FunctionVersion, error) {
+	raw, err := kv.List(ctx, functionVersionsPath(ctx, name))
+	if err != nil {
+		return nil, err
+	}
+	out := []*model.FunctionVersion{}
+	for k, r := range raw {
+		var v model.FunctionVersion
+		if err := model.UnmarshalFunctionVersion([]byte(r), &v); err != nil {
+			return nil, errors.Wrap(err, k)
+		}
+		if v.Function != nil {
+			v.Function.Namespace = NamespaceFromContext(ctx)
+		}
+		out = append(out, &v)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Version < out[j].Version
+	})
+	return out, nil
+}
+
+func putLock(ctx context.Context, kv backend.Writer, key string, l *model.Lock) error {
+	raw, err := model.MarshalLock(l)
+	if err != nil {
+		return err
+	}
+	return kv.Put(ctx, lockInfoPath(key), string(raw))
+}
+
+func getLock(ctx context.Context, kv backend.Reader, key string) (*model.Lock, error) {
+	raw, err := kv.Get(ctx, lockInfoPath(key))
+	if err != nil {
+		if backend.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var l model.Lock
+	if err := model.UnmarshalLock([]byte(raw), &l); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
 func putPendingUpload(ctx context.Context, kv backend.Writer, p *model.PendingUpload) error {
 	raw, err := model.MarshalPendingUpload(p)
 	if err != nil {
 		return err
 	}
-	return kv.Put(ctx, pendingUploadPath(p.Token), string(raw))
+	return kv.Put(ctx, pendingUploadPath(ctx, p.Token), string(raw))
+}
+
+func listPendingUploads(ctx context.Context, kv backend.Lister) ([]*model.PendingUpload, error) {
+	raw, err := kv.List(ctx, pendingUploadPath(ctx, ""))
+	if err != nil {
+		return nil, err
+	}
+	out := []*model.PendingUpload{}
+	for _, k := range sortedKeys(raw) {
+		var p model.PendingUpload
+		if err := model.UnmarshalPendingUpload([]byte(raw[k]), &p); err != nil {
+			return nil, errors.Wrap(err, k)
+		}
+		out = append(out, &p)
+	}
+	return out, nil
 }
 
 func getPendingUpload(ctx context.Context, kv backend.Reader, token string) (*model.PendingUpload, error) {
-	raw, err := kv.Get(ctx, pendingUploadPath(token))
+	raw, err := kv.Get(ctx, pendingUploadPath(ctx, token))
 	if err != nil {
 		if backend.IsNotFound(err) {
 			return nil, nil
@@ -80,38 +244,178 @@ func getPendingUpload(ctx context.Context, kv backend.Reader, token string) (*mo
 	return &p, nil
 }
 
-func putEnvironment(ctx context.Context, kv backend.Writer, p *model.Environment) error {
-	raw, err := model.MarshalEnvironment(p)
+// putEnvironment stores an environment. Storing fails with a conflict if the
+// environment changed after p.Revision, or exists while p.Revision is 0. The
+// namespace and revision are derived from the store and not stored.
+func putEnvironment(ctx context.Context, kv backend.RevisionWriter, p *model.Environment) error {
+	stored := *p
+	stored.Revision = 0
+	stored.Namespace = ""
+	raw, err := model.MarshalEnvironment(&stored)
 	if err != nil {
 		return err
 	}
-	return kv.Put(ctx, environmentPath(p.Name), string(raw))
+	revision, err := kv.PutRevision(ctx, environmentPath(ctx, p.Name), string(raw), p.Revision)
+	if err != nil {
+		return err
+	}
+	p.Revision = revision
+	return nil
+}
+
+func getEnvironment(ctx context.Context, kv backend.RevisionReader, name string) (*model.Environment, error) {
+	raw, revision, err := kv.GetRevision(ctx, environmentPath(ctx, name))
+	if err != nil {
+		if backend.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var e model.Environment
+	if err := model.UnmarshalEnvironment([]byte(raw), &e); err != nil {
+		return nil, err
+	}
+	e.Namespace = NamespaceFromContext(ctx)
+	e.Revision = revision
+	return &e, nil
 }
 
-func putDeployment(ctx context.Context, kv backend.Writer, p *model.Deployment) error {
-	raw, err := model.MarshalDeployment(p)
+func listEnvironments(ctx context.Context, kv backend.RevisionReader) ([]*model.Environment, error) {
+	raw, err := kv.ListRevisions(ctx, environmentPath(ctx, ""))
+	if err != nil {
+		return nil, err
+	}
+	out := []*model.Environment{}
+	for _, k := range sortedRevisionKeys(raw) {
+		var e model.Environment
+		if err := model.UnmarshalEnvironment([]byte(raw[k].Value), &e); err != nil {
+			return nil, errors.Wrap(err, k)
+		}
+		e.Namespace = NamespaceFromContext(ctx)
+		e.Revision = raw[k].Revision
+		out = append(out, &e)
+	}
+	return out, nil
+}
+
+// putDeployment stores a deployment if the stored deployment is still at
+// p.Revision, 0 meaning it must be new. On success p.Revision is updated to
+// the revision the deployment was stored at.
+func putDeployment(ctx context.Context, kv backend.RevisionWriter, p *model.Deployment) error {
+	stored := *p
+	stored.Revision = 0
+	stored.Namespace = ""
+	raw, err := model.MarshalDeployment(&stored)
 	if err != nil {
 		return err
 	}
-	return kv.Put(ctx, deploymentPath(p.Name), string(raw))
+	revision, err := kv.PutRevision(ctx, deploymentPath(ctx, p.Name), string(raw), p.Revision)
+	if err != nil {
+		return err
+	}
+	p.Revision = revision
+	return nil
 }
 
-func storeUserCredentials(ctx context.Context, kv backend.Writer, name, u, p string) error {
-	g, ctx := errgroup.WithContext(ctx)
-	g.Go(func() error {
-		if err := kv.Put(ctx, userSecretName(name), u); err != nil {
-			return errors.Wrap(err, "user")
+func getDeployment(ctx context.Context, kv backend.RevisionReader, name string) (*model.Deployment, error) {
+	raw, revision, err := kv.GetRevision(ctx, deploymentPath(ctx, name))
+	if err != nil {
+		if backend.IsNotFound(err) {
+			return nil, nil
 		}
-		return nil
-	})
-	g.Go(func() error {
-		if err := kv.Put(ctx, userSecretPass(name), p); err != nil {
-			return errors.Wrap(err, "pass")
+		return nil, err
+	}
+	var d model.Deployment
+	if err := model.UnmarshalDeployment([]byte(raw), &d); err != nil {
+		return nil, err
+	}
+	d.Namespace = NamespaceFromContext(ctx)
+	d.Revision = revision
+	return &d, nil
+}
+
+func listDeployments(ctx context.Context, kv backend.RevisionReader) ([]*model.Deployment, error) {
+	raw, err := kv.ListRevisions(ctx, deploymentPath(ctx, ""))
+	if err != nil {
+		return nil, err
+	}
+	out := []*model.Deployment{}
+	for _, k := range sortedRevisionKeys(raw) {
+		var d model.Deployment
+		if err := model.UnmarshalDeployment([]byte(raw[k].Value), &d); err != nil {
+			return nil, errors.Wrap(err, k)
+		}
+		d.Namespace = NamespaceFromContext(ctx)
+		d.Revision = raw[k].Revision
+		out = append(out, &d)
+	}
+	return out, nil
+}
+
+// storeUserCredentials stores the username and password of an environment.
+// Stores that support transactions store both or neither. Otherwise the
+// username is deleted again if the password can't be stored.
+func storeUserCredentials(ctx context.Context, kv backend.Writer, name string, generation int64, u, p string) error {
+	if txn, ok := kv.(backend.Txn); ok {
+		_, err := txn.Txn(ctx,
+			backend.PutOp(userSecretName(ctx, name, generation), u),
+			backend.PutOp(userSecretPass(ctx, name, generation), p),
+		)
+		if err != nil {
+			return errors.Wrap(err, "could not store credentials")
 		}
 		return nil
-	})
-	if err := g.Wait(); err != nil {
-		return errors.Wrap(err, "could not store credentials")
+	}
+
+	if err := kv.Put(ctx, userSecretName(ctx, name, generation), u); err != nil {
+		return errors.Wrap(errors.Wrap(err, "user"), "could not store credentials")
+	}
+	if err := kv.Put(ctx, userSecretPass(ctx, name, generation), p); err != nil {
+		_ = kv.Delete(ctx, userSecretName(ctx, name, generation))
+		return errors.Wrap(errors.Wrap(err, "pass"), "could not store credentials")
 	}
 	return nil
 }
+
+func getUserCredentials(ctx context.Context, kv backend.Reader, name string, generation int64) (string, string, error) {
+	u, err := kv.Get(ctx, userSecretName(ctx, name, generation))
+	if err != nil {
+		return "", "", errors.Wrap(err, "user")
+	}
+	p, err := kv.Get(ctx, userSecretPass(ctx, name, generation))
+	if err != nil {
+		return "", "", errors.Wrap(err, "pass")
+	}
+	return u, p, nil
+}
+
+// deleteUserCredentials deletes a generation of an environment's
+// credentials. Credentials that don't exist are ignored.
+func deleteUserCredentials(ctx context.Context, kv backend.Writer, name string, generation int64) error {
+	for _, key := range []string{userSecretName(ctx, name, generation), userSecretPass(ctx, name, generation)} {
+		if err := kv.Delete(ctx, key); err != nil && !backend.IsNotFound(err) {
+			return errors.Wrap(err, key)
+		}
+	}
+	return nil
+}
+
+// sortedKeys returns the keys of m in sorted order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sortedRevisionKeys returns the keys of m in sorted order.
+func sortedRevisionKeys(m map[string]backend.RevisionValue) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -1,19 +1,72 @@
 package server
 
 import (
+	"context"
 	"testing"
 
+	"github.com/fragments/fragments/internal/backend"
 	"github.com/fragments/fragments/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestPaths(t *testing.T) {
+	ctx := context.Background()
+	ns := WithNamespace(ctx, "team-a")
 	paths := map[string]string{
-		"function":      functionPath("function-name"),
-		"deployment":    deploymentPath("deployment-name"),
-		"environment":   environmentPath("environment-name"),
-		"pendingupload": pendingUploadPath("pending-upload-token"),
-		"user-username": userSecretName("user-secret-username"),
-		"user-password": userSecretPass("user-secret-password"),
+		"function":                  functionPath(ctx, "function-name"),
+		"deployment":                deploymentPath(ctx, "deployment-name"),
+		"environment":               environmentPath(ctx, "environment-name"),
+		"pendingupload":             pendingUploadPath(ctx, "pending-upload-token"),
+		"functionversion":           functionVersionPath(ctx, "function-name", 3),
+		"lock":                      lockPath(functionPath(ctx, "function-name")),
+		"lockinfo":                  lockInfoPath(functionPath(ctx, "function-name")),
+		"user-username":             userSecretName(ctx, "user-secret-username", 0),
+		"user-password":             userSecretPass(ctx, "user-secret-password", 0),
+		"user-username-generation":  userSecretName(ctx, "user-secret-username", 2),
+		"user-password-generation":  userSecretPass(ctx, "user-secret-password", 2),
+		"namespace-function":        functionPath(ns, "function-name"),
+		"namespace-functionversion": functionVersionPath(ns, "function-name", 3),
+		"namespace-lock":            lockPath(functionPath(ns, "function-name")),
+		"namespace-user-username":   userSecretName(ns, "user-secret-username", 0),
+		"namespace-apply":           applyLockPath(ns),
 	}
 	testutils.AssertGolden(t, testutils.SnapshotStringMap(paths), "testdata/paths.yaml")
 }
+
+func TestStoreUserCredentials(t *testing.T) {
+	tests := []struct {
+		TestName string
+		NoTxn    bool
+	}{
+		{
+			TestName: "Txn",
+		},
+		{
+			TestName: "NoTxn",
+			NoTxn:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			ctx := context.Background()
+			kv := &failingKV{TestKV: backend.NewTestKV(), failKey: userSecretPass(ctx, "env", 1)}
+			var store backend.Writer = kv
+			if test.NoTxn {
+				// Hide the transaction support of the store
+				store = struct{ backend.Writer }{kv}
+			}
+
+			err := storeUserCredentials(ctx, store, "env", 0, "user", "pass")
+			require.NoError(t, err)
+			assert.Len(t, kv.Data, 2)
+
+			// The username is not stored without the password
+			err = storeUserCredentials(ctx, store, "env", 1, "user", "pass")
+			require.Error(t, err)
+			assert.Len(t, kv.Data, 2)
+			assert.NotContains(t, kv.Data, userSecretName(ctx, "env", 1))
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fragments/fragments/internal/auth"
+	"github.com/fragments/fragments/internal/model"
+	"github.com/pkg/errors"
+)
+
+// DefaultLockTimeout is the default time to wait for a model that is locked.
+const DefaultLockTimeout = 10 * time.Second
+
+// applyLockPath returns the key of the lock that reserves all models of the
+// namespace set in the context for a single actor.
+func applyLockPath(ctx context.Context) string {
+	return namespacePath(ctx, "apply")
+}
+
+// LockedError is returned when a model could not be locked because it is
+// locked by someone else.
+type LockedError struct {
+	// Key is the key that is locked.
+	Key string
+	// Holder identifies who holds the lock. It is empty if not known.
+	Holder string
+	// Since is the time the lock was acquired.
+	Since time.Time
+}
+
+// Error returns the error string for a locked error.
+func (e *LockedError) Error() string {
+	if e.Holder == "" {
+		return fmt.Sprintf("%s is locked", e.Key)
+	}
+	return fmt.Sprintf("%s is locked by %s since %s", e.Key, e.Holder, e.Since.Format(time.RFC3339))
+}
+
+// IsLocked returns true if the error is caused by a model being locked.
+func IsLocked(err error) bool {
+	_, ok := errors.Cause(err).(*LockedError)
+	return ok
+}
+
+// LockApply reserves all models for the actor set in the context. Until the
+// lock is released with UnlockApply or the ttl has passed, other actors can't
+// modify models. Locking again extends the lock. Returns a LockedError if
+// another actor holds the lock.
+func (s *Server) LockApply(ctx context.Context, ttl time.Duration) (*model.Lock, error) {
+	if err := s.authorize(ctx, auth.VerbWrite, auth.ResourceLock); err != nil {
+		return nil, err
+	}
+	actor := ActorFromContext(ctx)
+	if actor == "" {
+		return nil, errors.New("actor must be set to lock apply")
+	}
+	if ttl <= 0 {
+		return nil, errors.New("lock ttl must be set")
+	}
+
+	unlock, err := s.waitLock(ctx, applyLockPath(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	existing, err := s.applyLock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := s.Now().UTC()
+	l := &model.Lock{
+		Holder:  actor,
+		Since:   now,
+		Expires: now.Add(ttl),
+	}
+	if existing != nil {
+		if existing.Holder != actor {
+			return nil, &LockedError{Key: applyLockPath(ctx), Holder: existing.Holder, Since: existing.Since}
+		}
+		l.Since = existing.Since
+	}
+
+	if err := putLock(ctx, s.StateStore, applyLockPath(ctx), l); err != nil {
+		return nil, errors.Wrap(err, "could not store apply lock")
+	}
+	return l, nil
+}
+
+// UnlockApply releases the apply lock held by the actor set in the context.
+// Releasing a lock that is not held is not an error. Returns a LockedError if
+// another actor holds the lock.
+func (s *Server) UnlockApply(ctx context.Context) error {
+	if err := s.authorize(ctx, auth.VerbWrite, auth.ResourceLock); err != nil {
+		return err
+	}
+	unlock, err := s.waitLock(ctx, applyLockPath(ctx))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	existing, err := s.applyLock(ctx)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		return nil
+	}
+	if existing.Holder != ActorFromContext(ctx) {
+		return &LockedError{Key: applyLockPath(ctx), Holder: existing.Holder, Since: existing.Since}
+	}
+	if err := s.StateStore.Delete(ctx, lockInfoPath(applyLockPath(ctx))); err != nil {
+		return errors.Wrap(err, "could not delete apply lock")
+	}
+	return nil
+}
+
+// applyLock returns the apply lock. Returns nil if the lock is not held or
+// has expired.
+func (s *Server) applyLock(ctx context.Context) (*model.Lock, error) {
+	l, err := getLock(ctx, s.StateStore, applyLockPath(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get apply lock")
+	}
+	if l == nil || !s.Now().Before(l.Expires) {
+		return nil, nil
+	}
+	return l, nil
+}
+
+// lock locks a model for modification. The key is the key of the model in
+// the state store. In case the model is locked, lock waits for LockTimeout
+// before returning a LockedError describing who holds the lock. Models can't
+// be locked while another actor holds the apply lock.
+//
+// The returned function must be called to release the lock.
+func (s *Server) lock(ctx context.Context, key string) (func(), error) {
+	l, err := s.applyLock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if l != nil && l.Holder != ActorFromContext(ctx) {
+		return nil, &LockedError{Key: applyLockPath(ctx), Holder: l.Holder, Since: l.Since}
+	}
+
+	unlock, err := s.waitLock(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &model.Lock{
+		Holder: ActorFromContext(ctx),
+		Since:  s.Now().UTC(),
+	}
+	if err := putLock(ctx, s.StateStore, key, info); err != nil {
+		unlock()
+		return nil, errors.Wrapf(err, "could not store lock for %s", key)
+	}
+
+	return func() {
+		// The lock is released even if the request context is cancelled
+		_ = s.StateStore.Delete(context.Background(), lockInfoPath(key))
+		unlock()
+	}, nil
+}
+
+// waitLock waits for the lock on a key for LockTimeout. Returns a LockedError
+// if the timeout passes.
+func (s *Server) waitLock(ctx context.Context, key string) (func(), error) {
+	timeout := s.LockTimeout
+	if timeout <= 0 {
+		timeout = DefaultLockTimeout
+	}
+	lockCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	unlock, err := s.StateStore.Lock(lockCtx, lockPath(key))
+	if err == nil {
+		return unlock, nil
+	}
+	if ctx.Err() != nil || lockCtx.Err() != context.DeadlineExceeded {
+		return nil, errors.Wrapf(err, "could not lock %s", key)
+	}
+
+	lockedErr := &LockedError{Key: key}
+	if info, err := getLock(ctx, s.StateStore, key); err == nil && info != nil {
+		lockedErr.Holder = info.Holder
+		lockedErr.Since = info.Since
+	}
+	return nil, lockedErr
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fragments/fragments/internal/backend"
+	fsmocks "github.com/fragments/fragments/internal/filestore/mocks"
+	"github.com/fragments/fragments/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLock(t *testing.T) {
+	alice := WithActor(context.Background(), "alice")
+	bob := WithActor(context.Background(), "bob")
+
+	kv := backend.NewTestKV()
+	s := New(kv, nil, nil)
+	s.Now = testNow
+	s.LockTimeout = 50 * time.Millisecond
+
+	unlock, err := s.lock(alice, deploymentPath(alice, "foo"))
+	require.NoError(t, err)
+
+	err = s.PutDeployment(bob, &model.Deployment{Name: "foo"})
+	require.Error(t, err)
+	assert.True(t, IsLocked(err))
+	assert.Equal(t, "deployment/foo is locked by alice since 2017-11-01T12:00:00Z", err.Error())
+	assert.NotContains(t, kv.Data, deploymentPath(alice, "foo"))
+
+	// Other models are not locked
+	require.NoError(t, s.PutDeployment(bob, &model.Deployment{Name: "bar"}))
+
+	unlock()
+	assert.NotContains(t, kv.Data, lockInfoPath(deploymentPath(alice, "foo")))
+	require.NoError(t, s.PutDeployment(bob, &model.Deployment{Name: "foo"}))
+
+	// Cancelled requests are not reported as locked
+	unlock, err = s.lock(alice, deploymentPath(alice, "foo"))
+	require.NoError(t, err)
+	defer unlock()
+	ctx, cancel := context.WithCancel(bob)
+	cancel()
+	err = s.PutDeployment(ctx, &model.Deployment{Name: "foo"})
+	require.Error(t, err)
+	assert.False(t, IsLocked(err))
+}
+
+func TestApplyLock(t *testing.T) {
+	alice := WithActor(context.Background(), "alice")
+	bob := WithActor(context.Background(), "bob")
+
+	now := testNow()
+	s := New(backend.NewTestKV(), nil, nil)
+	s.Now = func() time.Time { return now }
+
+	_, err := s.LockApply(context.Background(), time.Minute)
+	require.Error(t, err, "no actor")
+	_, err = s.LockApply(alice, 0)
+	require.Error(t, err, "no ttl")
+
+	l, err := s.LockApply(alice, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, &model.Lock{Holder: "alice", Since: now, Expires: now.Add(time.Minute)}, l)
+
+	// Only alice can modify models
+	require.NoError(t, s.PutDeployment(alice, &model.Deployment{Name: "foo"}))
+	err = s.PutDeployment(bob, &model.Deployment{Name: "foo"})
+	assert.True(t, IsLocked(err))
+	assert.Contains(t, err.Error(), "alice")
+	_, err = s.LockApply(bob, time.Minute)
+	assert.True(t, IsLocked(err))
+	assert.True(t, IsLocked(s.UnlockApply(bob)))
+
+	// Locking again extends the lock
+	now = now.Add(30 * time.Second)
+	l, err = s.LockApply(alice, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, testNow(), l.Since)
+	assert.Equal(t, now.Add(time.Minute), l.Expires)
+
+	// The lock expires
+	now = now.Add(2 * time.Minute)
+	require.NoError(t, s.PutDeployment(bob, &model.Deployment{Name: "foo"}))
+	_, err = s.LockApply(bob, time.Minute)
+	require.NoError(t, err)
+
+	// Unlocking releases the lock
+	require.NoError(t, s.UnlockApply(bob))
+	require.NoError(t, s.UnlockApply(bob))
+	require.NoError(t, s.PutDeployment(alice, &model.Deployment{Name: "foo"}))
+}
+
+func TestConcurrentApply(t *testing.T) {
+	const clients = 10
+
+	kv := backend.NewTestKV()
+	mockSourceStore := &fsmocks.SourceTarget{}
+	mockSourceStore.On("NewUploadURL", mock.Anything).Return("url", nil)
+	mockSourceStore.On("Persist", mock.Anything, mock.Anything, mock.Anything, testDigest()).Return(nil)
+	s := New(kv, nil, mockSourceStore)
+
+	var wg sync.WaitGroup
+	confirmed := make(chan string, clients)
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx := WithActor(context.Background(), fmt.Sprintf("client-%d", i))
+			upload, err := s.PutFunction(ctx, &model.Function{
+				Name:     "foo",
+				Checksum: fmt.Sprintf("checksum-%d", i),
+			})
+			require.NoError(t, err)
+			if err := s.ConfirmUpload(ctx, upload.Token, testDigest()); err != nil {
+				return
+			}
+			confirmed <- fmt.Sprintf("checksum-%d", i)
+		}(i)
+	}
+	wg.Wait()
+	close(confirmed)
+
+	checksums := []string{}
+	for c := range confirmed {
+		checksums = append(checksums, c)
+	}
+	require.NotEmpty(t, checksums)
+
+	// Every confirmed upload is recorded as a version and the function is the
+	// latest version
+	versions, err := s.FunctionHistory(context.Background(), "foo")
+	require.NoError(t, err)
+	require.Len(t, versions, len(checksums))
+	for i, v := range versions {
+		assert.EqualValues(t, i+1, v.Version)
+		assert.Contains(t, checksums, v.Function.Checksum)
+	}
+	f, err := s.GetFunction(context.Background(), "foo")
+	require.NoError(t, err)
+	latest := versions[len(versions)-1]
+	assert.Equal(t, latest.Version, f.Version)
+	assert.Equal(t, latest.Function.Checksum, f.Checksum)
+	assert.Equal(t, latest.Function.SourceFilename, f.SourceFilename)
+}
+
+func TestConfirmStaleUpload(t *testing.T) {
+	ctx := context.Background()
+	mockSourceStore := &fsmocks.SourceTarget{}
+	mockSourceStore.On("NewUploadURL", mock.Anything).Return("url", nil)
+	mockSourceStore.On("Persist", ctx, mock.Anything, mock.Anything, testDigest()).Return(nil)
+	s := New(backend.NewTestKV(), nil, mockSourceStore)
+
+	first, err := s.PutFunction(ctx, &model.Function{Name: "foo", Checksum: "first"})
+	require.NoError(t, err)
+	second, err := s.PutFunction(ctx, &model.Function{Name: "foo", Checksum: "second"})
+	require.NoError(t, err)
+
+	require.NoError(t, s.ConfirmUpload(ctx, second.Token, testDigest()))
+	err = s.ConfirmUpload(ctx, first.Token, testDigest())
+	require.Error(t, err)
+
+	f, err := s.GetFunction(ctx, "foo")
+	require.NoError(t, err)
+	assert.Equal(t, "second", f.Checksum)
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fragments/fragments/internal/auth"
+	"github.com/fragments/fragments/internal/backend"
+	"github.com/pkg/errors"
+)
+
+// DefaultNamespace is the namespace models are stored in if no namespace is
+// set.
+const DefaultNamespace = "default"
+
+// namespacePattern is the pattern namespace names must match.
+var namespacePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+type namespaceKey struct{}
+
+// WithNamespace returns a context that scopes the requests made with it to a
+// namespace. Models in different namespaces are isolated from each other,
+// models with the same name can exist in every namespace.
+func WithNamespace(ctx context.Context, namespace string) context.Context {
+	return context.WithValue(ctx, namespaceKey{}, namespace)
+}
+
+// NamespaceFromContext returns the namespace set with WithNamespace. Returns
+// DefaultNamespace if no namespace is set.
+func NamespaceFromContext(ctx context.Context) string {
+	namespace, _ := ctx.Value(namespaceKey{}).(string)
+	if namespace == "" {
+		return DefaultNamespace
+	}
+	return namespace
+}
+
+// ValidateNamespace returns an error if a namespace name is not valid.
+// Namespaces consist of up to 63 lower case letters, digits and dashes, and
+// start and end with a letter or digit.
+func ValidateNamespace(namespace string) error {
+	if !namespacePattern.MatchString(namespace) {
+		return errors.Errorf("invalid namespace %q, namespaces consist of lower case letters, digits and dashes", namespace)
+	}
+	return nil
+}
+
+// namespacePrefix prefixes the keys of all models in namespaces other than
+// the default namespace.
+const namespacePrefix = "namespace/"
+
+// namespacePath prefixes a key with the namespace set in the context. Keys in
+// the default namespace are not prefixed, models stored before namespaces
+// were introduced are in the default namespace.
+func namespacePath(ctx context.Context, key string) string {
+	namespace := NamespaceFromContext(ctx)
+	if namespace == DefaultNamespace {
+		return key
+	}
+	return fmt.Sprintf("%s%s/%s", namespacePrefix, namespace, key)
+}
+
+// namespaceRecordPath returns the key recording that a namespace is in use.
+func namespaceRecordPath(namespace string) string {
+	return fmt.Sprintf("namespaces/%s", namespace)
+}
+
+// checkNamespace returns an error if a model is for a different namespace
+// than the one set in the context.
+func checkNamespace(ctx context.Context, namespace string) error {
+	if namespace != "" && namespace != NamespaceFromContext(ctx) {
+		return errors.Errorf("model is in namespace %s, not %s", namespace, NamespaceFromContext(ctx))
+	}
+	return nil
+}
+
+// registerNamespace records that the namespace set in the context is in use
+// so it is included in ListNamespaces.
+func (s *Server) registerNamespace(ctx context.Context) error {
+	namespace := NamespaceFromContext(ctx)
+	if namespace == DefaultNamespace {
+		return nil
+	}
+	_, err := s.StateStore.Get(ctx, namespaceRecordPath(namespace))
+	if err == nil {
+		return nil
+	}
+	if !backend.IsNotFound(err) {
+		return errors.Wrapf(err, "could not check namespace %s", namespace)
+	}
+	created := s.Now().UTC().Format(time.RFC3339)
+	if err := s.StateStore.Put(ctx, namespaceRecordPath(namespace), created); err != nil {
+		return errors.Wrapf(err, "could not register namespace %s", namespace)
+	}
+	return nil
+}
+
+// ListNamespaces returns the names of all namespaces models have been stored
+// in, sorted by name. The default namespace is always included.
+func (s *Server) ListNamespaces(ctx context.Context) ([]string, error) {
+	if err := s.authorizeGlobal(ctx, auth.VerbRead, auth.ResourceNamespace); err != nil {
+		return nil, err
+	}
+	raw, err := s.StateStore.List(ctx, namespaceRecordPath(""))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list namespaces")
+	}
+	out := []string{DefaultNamespace}
+	for k := range raw {
+		if k != DefaultNamespace && !strings.Contains(k, "/") {
+			out = append(out, k)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
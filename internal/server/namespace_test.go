@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fragments/fragments/internal/backend"
+	"github.com/fragments/fragments/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNamespaceFromContext(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, DefaultNamespace, NamespaceFromContext(ctx))
+	assert.Equal(t, DefaultNamespace, NamespaceFromContext(WithNamespace(ctx, "")))
+	assert.Equal(t, "team-a", NamespaceFromContext(WithNamespace(ctx, "team-a")))
+}
+
+func TestValidateNamespace(t *testing.T) {
+	tests := []struct {
+		Namespace string
+		Valid     bool
+	}{
+		{Namespace: "default", Valid: true},
+		{Namespace: "team-a", Valid: true},
+		{Namespace: "a", Valid: true},
+		{Namespace: "0", Valid: true},
+		{Namespace: "", Valid: false},
+		{Namespace: "Team", Valid: false},
+		{Namespace: "-team", Valid: false},
+		{Namespace: "team-", Valid: false},
+		{Namespace: "team/a", Valid: false},
+		{Namespace: "team_a", Valid: false},
+		{Namespace: "abcdefghijklmnopqrstuvwxyzabcdefghijklmnopqrstuvwxyzabcdefghijk", Valid: true},
+		{Namespace: "abcdefghijklmnopqrstuvwxyzabcdefghijklmnopqrstuvwxyzabcdefghijkl", Valid: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Namespace, func(t *testing.T) {
+			err := ValidateNamespace(test.Namespace)
+			if test.Valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestNamespaceIsolation(t *testing.T) {
+	kv := backend.NewTestKV()
+	secrets := backend.NewTestKV()
+	s := New(kv, secrets, nil)
+	s.Now = testNow
+
+	teamA := WithNamespace(context.Background(), "team-a")
+	teamB := WithNamespace(context.Background(), "team-b")
+
+	for _, ctx := range []context.Context{teamA, teamB} {
+		require.NoError(t, s.PutDeployment(ctx, &model.Deployment{Name: "api", Owner: NamespaceFromContext(ctx)}))
+		require.NoError(t, s.CreateEnvironment(ctx, &EnvironmentInput{
+			Name:     "prod",
+			Username: NamespaceFromContext(ctx),
+			Password: "pass",
+		}))
+	}
+
+	// Models with the same name exist in both namespaces
+	a, err := s.GetDeployment(teamA, "api")
+	require.NoError(t, err)
+	assert.Equal(t, "team-a", a.Owner)
+	assert.Equal(t, "team-a", a.Namespace)
+	b, err := s.GetDeployment(teamB, "api")
+	require.NoError(t, err)
+	assert.Equal(t, "team-b", b.Owner)
+	assert.Equal(t, "team-b", b.Namespace)
+
+	username, _, err := s.EnvironmentCredentials(teamA, "prod")
+	require.NoError(t, err)
+	assert.Equal(t, "team-a", username)
+
+	// The default namespace is not affected
+	deployments, err := s.ListDeployments(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, deployments)
+
+	// Deleting only deletes from one namespace
+	require.NoError(t, s.DeleteDeployment(teamA, "api"))
+	deployments, err = s.ListDeployments(teamA)
+	require.NoError(t, err)
+	assert.Empty(t, deployments)
+	deployments, err = s.ListDeployments(teamB)
+	require.NoError(t, err)
+	assert.Len(t, deployments, 1)
+
+	namespaces, err := s.ListNamespaces(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"default", "team-a", "team-b"}, namespaces)
+	assert.Equal(t, testNow().UTC().Format(time.RFC3339), kv.Data[namespaceRecordPath("team-a")])
+}
+
+func TestNamespaceMismatch(t *testing.T) {
+	ctx := WithNamespace(context.Background(), "team-a")
+	kv := backend.NewTestKV()
+	s := New(kv, backend.NewTestKV(), nil)
+
+	err := s.PutDeployment(ctx, &model.Deployment{Name: "api", Namespace: "team-b"})
+	require.Error(t, err)
+	_, err = s.PutFunction(ctx, &model.Function{Name: "api", Namespace: "team-b"})
+	require.Error(t, err)
+	err = s.CreateEnvironment(ctx, &EnvironmentInput{Name: "prod", Namespace: "team-b"})
+	require.Error(t, err)
+	assert.Empty(t, withoutAudit(kv.Data))
+
+	require.NoError(t, s.PutDeployment(ctx, &model.Deployment{Name: "api", Namespace: "team-a"}))
+}
+
+func TestNamespacedApplyLock(t *testing.T) {
+	alice := WithActor(WithNamespace(context.Background(), "team-a"), "alice")
+	bob := WithActor(WithNamespace(context.Background(), "team-b"), "bob")
+	s := New(backend.NewTestKV(), nil, nil)
+	s.Now = testNow
+
+	_, err := s.LockApply(alice, time.Minute)
+	require.NoError(t, err)
+
+	// The apply lock of one namespace does not lock other namespaces
+	require.NoError(t, s.PutDeployment(bob, &model.Deployment{Name: "api"}))
+	err = s.PutDeployment(WithActor(alice, "carol"), &model.Deployment{Name: "api"})
+	assert.True(t, IsLocked(err))
+}
+
+func TestReencryptNamespacedSecrets(t *testing.T) {
+	ctx := context.Background()
+	oldKey := backend.SecretKey{ID: "old", Key: make([]byte, 32)}
+	newKey := backend.SecretKey{ID: "new", Key: []byte("0123456789abcdef0123456789abcdef")}
+
+	secretsKV := backend.NewTestKV()
+	secrets, err := backend.NewEncrypted(secretsKV, oldKey)
+	require.NoError(t, err)
+	kv := backend.NewTestKV()
+	s := New(kv, secrets, nil)
+	s.Now = testNow
+	require.NoError(t, s.CreateEnvironment(ctx, &EnvironmentInput{Name: "prod", Username: "user", Password: "pass"}))
+	require.NoError(t, s.CreateEnvironment(WithNamespace(ctx, "team-a"), &EnvironmentInput{Name: "prod", Username: "user", Password: "pass"}))
+
+	s.SecretStore, err = backend.NewEncrypted(secretsKV, newKey, oldKey)
+	require.NoError(t, err)
+	n, err := s.ReencryptSecrets(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+}
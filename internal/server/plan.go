@@ -0,0 +1,321 @@
+package server
+
+import (
+	"context"
+	"reflect"
+	"sort"
+
+	"github.com/fragments/fragments/internal/auth"
+	"github.com/fragments/fragments/internal/model"
+	"github.com/pkg/errors"
+)
+
+// Action is a change that applying a model would make.
+type Action string
+
+const (
+	// ActionCreate creates a model that does not exist.
+	ActionCreate Action = "create"
+	// ActionUpdate updates an existing model.
+	ActionUpdate Action = "update"
+	// ActionNone means the stored model is up to date.
+	ActionNone Action = "no-op"
+	// ActionDelete deletes a stored model.
+	ActionDelete Action = "delete"
+)
+
+const (
+	modelTypeFunction    = "function"
+	modelTypeDeployment  = "deployment"
+	modelTypeEnvironment = "environment"
+)
+
+// PlanInput is the desired state to compare with the stored state.
+type PlanInput struct {
+	// Functions are the functions that would be applied.
+	Functions []*model.Function `json:"functions,omitempty"`
+	// Deployments are the deployments that would be applied.
+	Deployments []*model.Deployment `json:"deployments,omitempty"`
+	// Environments are the environments that would be applied. Environments
+	// are never pruned.
+	Environments []*model.Environment `json:"environments,omitempty"`
+	// Prune plans deleting stored models that are not part of the input.
+	// Only models owned by Owner are deleted.
+	Prune bool `json:"prune,omitempty"`
+	// Owner is the owner of the models in the input.
+	Owner string `json:"owner,omitempty"`
+}
+
+// Plan is the set of changes applying models would make.
+type Plan struct {
+	// Changes contains a change for every model in the input and every model
+	// that would be deleted. Changes are sorted by type and name.
+	Changes []*Change `json:"changes"`
+}
+
+// Change is a change to a single model.
+type Change struct {
+	// Type is the type of the model.
+	Type string `json:"type"`
+	// Name is the name of the model.
+	Name string `json:"name"`
+	// Action is the action applying the model would take.
+	Action Action `json:"action"`
+	// Fields lists the fields that would be updated.
+	Fields []string `json:"fields,omitempty"`
+	// Upload is set if the function source would be uploaded.
+	Upload bool `json:"upload,omitempty"`
+	// Revision is the revision of the stored model the change is based on.
+	// It is 0 if the model does not exist. Applying the model with the
+	// revision fails if the model has been modified since it was planned.
+	Revision int64 `json:"revision,omitempty"`
+}
+
+// Count returns the number of changes with an action.
+func (p *Plan) Count(action Action) int {
+	n := 0
+	for _, c := range p.Changes {
+		if c.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+// Plan compares the input with the stored models and returns the changes
+// applying the input would make. Nothing is modified.
+func (s *Server) Plan(ctx context.Context, input *PlanInput) (*Plan, error) {
+	if err := s.authorize(ctx, auth.VerbRead, auth.ResourceFunction, auth.ResourceDeployment, auth.ResourceEnvironment); err != nil {
+		return nil, err
+	}
+	if input == nil {
+		return nil, errors.New("no plan input supplied")
+	}
+	if input.Prune && input.Owner == "" {
+		return nil, errors.New("owner must be set to prune")
+	}
+
+	plan := &Plan{
+		Changes: []*Change{},
+	}
+
+	functionNames := make(map[string]bool)
+	for _, f := range input.Functions {
+		if f == nil || f.Name == "" {
+			return nil, errors.New("function has no name")
+		}
+		existing, err := getFunction(ctx, s.StateStore, f.Name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not get function %s", f.Name)
+		}
+		c, err := s.planFunction(ctx, f, existing)
+		if err != nil {
+			return nil, errors.Wrapf(err, "function %s", f.Name)
+		}
+		plan.Changes = append(plan.Changes, c)
+		functionNames[f.Name] = true
+	}
+
+	deploymentNames := make(map[string]bool)
+	for _, d := range input.Deployments {
+		if d == nil || d.Name == "" {
+			return nil, errors.New("deployment has no name")
+		}
+		existing, err := getDeployment(ctx, s.StateStore, d.Name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not get deployment %s", d.Name)
+		}
+		plan.Changes = append(plan.Changes, planDeployment(d, existing))
+		deploymentNames[d.Name] = true
+	}
+
+	for _, e := range input.Environments {
+		if e == nil || e.Name == "" {
+			return nil, errors.New("environment has no name")
+		}
+		existing, err := getEnvironment(ctx, s.StateStore, e.Name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not get environment %s", e.Name)
+		}
+		c, err := planEnvironment(e, existing)
+		if err != nil {
+			return nil, errors.Wrapf(err, "environment %s", e.Name)
+		}
+		plan.Changes = append(plan.Changes, c)
+	}
+
+	if input.Prune {
+		functions, err := listFunctions(ctx, s.StateStore)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not list functions")
+		}
+		for _, f := range functions {
+			if !functionNames[f.Name] && f.Owner == input.Owner {
+				plan.Changes = append(plan.Changes, &Change{Type: modelTypeFunction, Name: f.Name, Action: ActionDelete})
+			}
+		}
+		deployments, err := listDeployments(ctx, s.StateStore)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not list deployments")
+		}
+		for _, d := range deployments {
+			if !deploymentNames[d.Name] && d.Owner == input.Owner {
+				plan.Changes = append(plan.Changes, &Change{Type: modelTypeDeployment, Name: d.Name, Action: ActionDelete})
+			}
+		}
+	}
+
+	sort.Slice(plan.Changes, func(i, j int) bool {
+		a, b := plan.Changes[i], plan.Changes[j]
+		if a.Type != b.Type {
+			return a.Type > b.Type
+		}
+		return a.Name < b.Name
+	})
+
+	return plan, nil
+}
+
+// planFunction compares a function with the existing function. The source
+// is not uploaded if it is shared with another function, like PutFunction
+// does.
+func (s *Server) planFunction(ctx context.Context, f, existing *model.Function) (*Change, error) {
+	c := &Change{
+		Type: modelTypeFunction,
+		Name: f.Name,
+	}
+	if existing == nil || f.Checksum != existing.Checksum {
+		owner := f.Owner
+		if owner == "" && existing != nil {
+			owner = existing.Owner
+		}
+		upload, err := s.uploadRequired(ctx, f.Checksum, owner)
+		if err != nil {
+			return nil, err
+		}
+		c.Upload = upload
+	}
+	if existing == nil {
+		c.Action = ActionCreate
+		return c, nil
+	}
+	c.Revision = existing.Revision
+
+	if !labelsEqual(f.Labels, existing.Labels) {
+		c.Fields = append(c.Fields, "labels")
+	}
+	if f.Runtime != existing.Runtime {
+		c.Fields = append(c.Fields, "runtime")
+	}
+	if !reflect.DeepEqual(f.AWS, existing.AWS) {
+		c.Fields = append(c.Fields, "aws")
+	}
+	if f.Owner != "" && f.Owner != existing.Owner {
+		c.Fields = append(c.Fields, "owner")
+	}
+	if f.Checksum != existing.Checksum {
+		c.Fields = append(c.Fields, "source")
+	}
+
+	c.Action = ActionNone
+	if len(c.Fields) > 0 {
+		c.Action = ActionUpdate
+	}
+	return c, nil
+}
+
+// uploadRequired returns true if source with the checksum would be uploaded
+// for a function with the owner. Invalid checksums are rejected when the
+// function is applied.
+func (s *Server) uploadRequired(ctx context.Context, checksum, owner string) (bool, error) {
+	if validateChecksum(checksum) != nil {
+		return true, nil
+	}
+	archive, err := s.sharedSourceArchive(ctx, checksum, owner)
+	if err != nil {
+		return false, err
+	}
+	return archive == nil, nil
+}
+
+// planDeployment compares a deployment with the existing deployment.
+func planDeployment(d, existing *model.Deployment) *Change {
+	c := &Change{
+		Type: modelTypeDeployment,
+		Name: d.Name,
+	}
+	if existing == nil {
+		c.Action = ActionCreate
+		return c
+	}
+	c.Revision = existing.Revision
+
+	if !labelsEqual(d.FunctionLabels, existing.FunctionLabels) {
+		c.Fields = append(c.Fields, "function_labels")
+	}
+	if !labelsEqual(d.EnvironmentLabels, existing.EnvironmentLabels) {
+		c.Fields = append(c.Fields, "environment_labels")
+	}
+	if d.Owner != "" && d.Owner != existing.Owner {
+		c.Fields = append(c.Fields, "owner")
+	}
+
+	c.Action = ActionNone
+	if len(c.Fields) > 0 {
+		c.Action = ActionUpdate
+	}
+	return c
+}
+
+// planEnvironment compares an environment with the existing environment.
+// Only the fields an environment update applies are compared: labels if they
+// are set and the AWS region and role if they are set. Credentials are not
+// compared. Returns an error if the infrastructure differs, the
+// infrastructure of an environment can not be changed.
+func planEnvironment(e, existing *model.Environment) (*Change, error) {
+	c := &Change{
+		Type: modelTypeEnvironment,
+		Name: e.Name,
+	}
+	if existing == nil {
+		c.Action = ActionCreate
+		return c, nil
+	}
+	c.Revision = existing.Revision
+
+	if e.Infrastructure != "" && e.Infrastructure != existing.Infrastructure {
+		return nil, errors.Errorf("infrastructure can not be changed from %q to %q", existing.Infrastructure, e.Infrastructure)
+	}
+	if e.Labels != nil && !labelsEqual(e.Labels, existing.Labels) {
+		c.Fields = append(c.Fields, "labels")
+	}
+	if e.AWS != nil {
+		var region, role string
+		if existing.AWS != nil {
+			region, role = existing.AWS.Region, existing.AWS.Role
+		}
+		if (e.AWS.Region != "" && e.AWS.Region != region) || (e.AWS.Role != "" && e.AWS.Role != role) {
+			c.Fields = append(c.Fields, "aws")
+		}
+	}
+
+	c.Action = ActionNone
+	if len(c.Fields) > 0 {
+		c.Action = ActionUpdate
+	}
+	return c, nil
+}
+
+// labelsEqual returns true if a and b contain the same labels. A nil map is
+// equal to an empty map.
+func labelsEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fragments/fragments/internal/backend"
+	"github.com/fragments/fragments/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlan(t *testing.T) {
+	ctx := context.Background()
+	kv := backend.NewTestKV()
+
+	existing := &model.Function{
+		Name:           "existing",
+		Labels:         map[string]string{"app": "foo"},
+		Runtime:        "nodejs",
+		Checksum:       "ABC",
+		SourceFilename: "existing.tar.gz",
+		AWS:            &model.FunctionAWS{Timeout: 3, Memory: 256},
+		Owner:          "repo",
+	}
+	require.NoError(t, putFunction(ctx, kv, existing))
+	other := &model.Function{Name: "other", Owner: "repo"}
+	require.NoError(t, putFunction(ctx, kv, other))
+	require.NoError(t, putSourceArchive(ctx, kv, "shared", &model.SourceArchive{Checksum: "shared", Filename: "shared", Owner: "repo", References: 1}))
+	require.NoError(t, putSourceArchive(ctx, kv, "unreferenced", &model.SourceArchive{Checksum: "unreferenced", Filename: "unreferenced"}))
+	require.NoError(t, putFunction(ctx, kv, &model.Function{Name: "foreign", Owner: "other"}))
+	existingDeployment := &model.Deployment{
+		Name:           "existing",
+		FunctionLabels: map[string]string{"app": "foo"},
+		Owner:          "repo",
+	}
+	require.NoError(t, putDeployment(ctx, kv, existingDeployment))
+	existingEnvironment := &model.Environment{
+		Name:                  "existing",
+		Labels:                map[string]string{"stage": "prod"},
+		Infrastructure:        model.InfrastructureTypeAWS,
+		AWS:                   &model.InfrastructureAWS{Region: "eu-west-1", Role: "lambda"},
+		CredentialsGeneration: 2,
+	}
+	require.NoError(t, putEnvironment(ctx, kv, existingEnvironment))
+
+	tests := []struct {
+		TestName string
+		Input    *PlanInput
+		Expected []*Change
+		Error    bool
+	}{
+		{
+			TestName: "NoInput",
+			Error:    true,
+		},
+		{
+			TestName: "NoName",
+			Input: &PlanInput{
+				Functions: []*model.Function{{}},
+			},
+			Error: true,
+		},
+		{
+			TestName: "Create",
+			Input: &PlanInput{
+				Functions:   []*model.Function{{Name: "new", Checksum: "new"}},
+				Deployments: []*model.Deployment{{Name: "new"}},
+			},
+			Expected: []*Change{
+				{Type: "function", Name: "new", Action: ActionCreate, Upload: true},
+				{Type: "deployment", Name: "new", Action: ActionCreate},
+			},
+		},
+		{
+			TestName: "NoChange",
+			Input: &PlanInput{
+				Functions: []*model.Function{
+					{
+						Name:     "existing",
+						Labels:   map[string]string{"app": "foo"},
+						Runtime:  "nodejs",
+						Checksum: "ABC",
+						AWS:      &model.FunctionAWS{Timeout: 3, Memory: 256},
+						Owner:    "repo",
+					},
+				},
+				Deployments: []*model.Deployment{
+					{
+						Name:              "existing",
+						FunctionLabels:    map[string]string{"app": "foo"},
+						EnvironmentLabels: map[string]string{},
+					},
+				},
+			},
+			Expected: []*Change{
+				{Type: "function", Name: "existing", Action: ActionNone, Revision: existing.Revision},
+				{Type: "deployment", Name: "existing", Action: ActionNone, Revision: existingDeployment.Revision},
+			},
+		},
+		{
+			TestName: "Update",
+			Input: &PlanInput{
+				Functions: []*model.Function{
+					{
+						Name:     "existing",
+						Labels:   map[string]string{"app": "bar"},
+						Runtime:  "nodejs",
+						Checksum: "UPDATED",
+						AWS:      &model.FunctionAWS{Timeout: 3, Memory: 128},
+					},
+				},
+				Deployments: []*model.Deployment{
+					{
+						Name:              "existing",
+						FunctionLabels:    map[string]string{"app": "foo"},
+						EnvironmentLabels: map[string]string{"stage": "dev"},
+					},
+				},
+			},
+			Expected: []*Change{
+				{Type: "function", Name: "existing", Action: ActionUpdate, Fields: []string{"labels", "aws", "source"}, Upload: true, Revision: existing.Revision},
+				{Type: "deployment", Name: "existing", Action: ActionUpdate, Fields: []string{"environment_labels"}, Revision: existingDeployment.Revision},
+			},
+		},
+		{
+			TestName: "SharedSource",
+			Input: &PlanInput{
+				Functions: []*model.Function{
+					{Name: "new", Checksum: "shared", Owner: "repo"},
+					{Name: "existing", Labels: existing.Labels, Runtime: "nodejs", Checksum: "shared", AWS: existing.AWS},
+					{Name: "other", Checksum: "unreferenced"},
+					{Name: "unowned", Checksum: "shared"},
+				},
+			},
+			Expected: []*Change{
+				{Type: "function", Name: "existing", Action: ActionUpdate, Fields: []string{"source"}, Revision: existing.Revision},
+				{Type: "function", Name: "new", Action: ActionCreate},
+				{Type: "function", Name: "other", Action: ActionUpdate, Fields: []string{"source"}, Upload: true, Revision: other.Revision},
+				{Type: "function", Name: "unowned", Action: ActionCreate, Upload: true},
+			},
+		},
+		{
+			TestName: "Environments",
+			Input: &PlanInput{
+				Environments: []*model.Environment{
+					{Name: "new"},
+					{Name: "existing", Labels: map[string]string{"stage": "prod"}, AWS: &model.InfrastructureAWS{Region: "us-east-1"}},
+				},
+			},
+			Expected: []*Change{
+				{Type: "environment", Name: "existing", Action: ActionUpdate, Fields: []string{"aws"}, Revision: existingEnvironment.Revision},
+				{Type: "environment", Name: "new", Action: ActionCreate},
+			},
+		},
+		{
+			TestName: "EnvironmentUnsetFields",
+			Input: &PlanInput{
+				Environments: []*model.Environment{
+					{Name: "existing", Infrastructure: model.InfrastructureTypeAWS, AWS: &model.InfrastructureAWS{}},
+				},
+			},
+			Expected: []*Change{
+				{Type: "environment", Name: "existing", Action: ActionNone, Revision: existingEnvironment.Revision},
+			},
+		},
+		{
+			TestName: "EnvironmentInfrastructure",
+			Input: &PlanInput{
+				Environments: []*model.Environment{
+					{Name: "existing", Infrastructure: "other"},
+				},
+			},
+			Error: true,
+		},
+		{
+			TestName: "ChangeOwner",
+			Input: &PlanInput{
+				Deployments: []*model.Deployment{
+					{
+						Name:           "existing",
+						FunctionLabels: map[string]string{"app": "foo"},
+						Owner:          "other",
+					},
+				},
+			},
+			Expected: []*Change{
+				{Type: "deployment", Name: "existing", Action: ActionUpdate, Fields: []string{"owner"}, Revision: existingDeployment.Revision},
+			},
+		},
+		{
+			TestName: "PruneWithoutOwner",
+			Input: &PlanInput{
+				Prune: true,
+			},
+			Error: true,
+		},
+		{
+			TestName: "Prune",
+			Input: &PlanInput{
+				Functions: []*model.Function{existing},
+				Prune:     true,
+				Owner:     "repo",
+			},
+			Expected: []*Change{
+				{Type: "function", Name: "existing", Action: ActionNone, Revision: existing.Revision},
+				{Type: "function", Name: "other", Action: ActionDelete},
+				{Type: "deployment", Name: "existing", Action: ActionDelete},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			s := &Server{StateStore: kv}
+			plan, err := s.Plan(ctx, test.Input)
+			if test.Error {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.Expected, plan.Changes)
+			// Planning never modifies the store
+			assert.Len(t, kv.Data, 7)
+		})
+	}
+}
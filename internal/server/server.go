@@ -2,7 +2,12 @@ package server
 
 import (
 	"context"
+	"io"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/fragments/fragments/internal/auth"
 	"github.com/fragments/fragments/internal/backend"
 	"github.com/fragments/fragments/internal/filestore"
 	"github.com/fragments/fragments/internal/model"
@@ -12,54 +17,132 @@ import (
 type statestore interface {
 	backend.Reader
 	backend.Writer
+	backend.Lister
+	backend.Locker
+	backend.RevisionReader
+	backend.RevisionWriter
+	backend.Txn
+	backend.Watcher
+}
+
+type secretstore interface {
+	backend.Reader
+	backend.Writer
 }
 
 // Server is the fragments server that accepts models and keeps them in the
 // store.
 type Server struct {
 	StateStore    statestore
-	SecretStore   backend.Writer
+	SecretStore   secretstore
 	SourceStore   filestore.SourceTarget
 	GenerateToken func() string
+	Now           func() time.Time
+	// UploadTTL is the time source uploads must be confirmed in. Pending
+	// uploads never expire if it is 0.
+	UploadTTL time.Duration
+	// LockTimeout is the time to wait for a model that is locked.
+	LockTimeout time.Duration
+	// Authorizer decides which actions the identity set in the request
+	// context can perform. Authorization is disabled if it is nil.
+	Authorizer auth.Authorizer
+	// AuditLog is written a JSON line for every audit event in addition to
+	// storing the event in the state store, if it is set.
+	AuditLog   io.Writer
+	auditLogMu sync.Mutex
 }
 
 // New creates a new server.
 // Upload tokens are generated by server.GenerateToken.
-func New(statestore statestore, secretstore backend.Writer, sourceTarget filestore.SourceTarget) *Server {
+func New(statestore statestore, secretstore secretstore, sourceTarget filestore.SourceTarget) *Server {
 	return &Server{
 		StateStore:    statestore,
 		SecretStore:   secretstore,
 		SourceStore:   sourceTarget,
 		GenerateToken: GenerateToken,
+		Now:           time.Now,
+		UploadTTL:     DefaultUploadTTL,
+		LockTimeout:   DefaultLockTimeout,
 	}
 }
 
 // UploadRequest is a request for source code, returned from the server.
 type UploadRequest struct {
 	// Token is the token to use when confirming the upload.
-	Token string
+	Token string `json:"token"`
 	// URL is where to upload the source code. The source must be uploaded as a
 	// tar.gz. After completion the upload should be confirmed.
-	URL string
+	URL string `json:"url"`
 }
 
 // PutFunction creates or updates a function. In case the function already
-// exists it is updated. If not, source upload is requested.
-func (s *Server) PutFunction(ctx context.Context, input *model.Function) (*UploadRequest, error) {
+// exists it is updated. If not, source upload is requested. If the input has a
+// revision, a backend.ConflictError is returned if the function has been
+// modified after it.
+func (s *Server) PutFunction(ctx context.Context, input *model.Function) (upload *UploadRequest, err error) {
+	event := auditEvent(model.AuditActionPut, modelTypeFunction, "")
+	defer s.audit(ctx, event, &err)
+	if err := s.authorize(ctx, auth.VerbWrite, auth.ResourceFunction); err != nil {
+		return nil, err
+	}
 	if input == nil {
 		return nil, errors.New("no function supplied")
 	}
 	name := input.Name
+	event.ModelName = name
+	event.NewChecksum = input.Checksum
 	if name == "" {
 		return nil, errors.New("function has no meta or name")
 	}
+	if err := checkNamespace(ctx, input.Namespace); err != nil {
+		return nil, errors.Wrapf(err, "function %s", name)
+	}
+
+	unlock, err := s.lock(ctx, functionPath(ctx, name))
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 
 	existing, err := getFunction(ctx, s.StateStore, name)
 	if err != nil {
 		return nil, errors.Wrap(err, "check existing function")
 	}
+	var revision int64
+	if existing != nil {
+		event.OldChecksum = existing.Checksum
+		input.Owner, err = resolveOwner(input.Owner, existing.Owner)
+		if err != nil {
+			return nil, errors.Wrapf(err, "function %s", name)
+		}
+		revision = existing.Revision
+	}
+	if err = checkRevision(functionPath(ctx, name), input.Revision, revision); err != nil {
+		return nil, errors.Wrapf(err, "function %s", name)
+	}
+	input.Revision = revision
+	if err = s.registerNamespace(ctx); err != nil {
+		return nil, err
+	}
 
 	if existing == nil || existing.Checksum != input.Checksum {
+		if err = validateChecksum(input.Checksum); err != nil {
+			return nil, errors.Wrapf(err, "function %s", name)
+		}
+		archive, err := s.sharedSourceArchive(ctx, input.Checksum, input.Owner)
+		if err != nil {
+			return nil, err
+		}
+		if archive != nil {
+			// The source has been persisted before, the function is stored
+			// as a new version referencing it without an upload.
+			input.SourceFilename = archive.Filename
+			if err := s.storeFunctionVersion(ctx, input, 0); err != nil {
+				return nil, err
+			}
+			return nil, nil
+		}
+
 		// nolint: vetshadow
 		res, err := s.requestUpload(ctx, input, existing)
 		if err != nil {
@@ -69,6 +152,7 @@ func (s *Server) PutFunction(ctx context.Context, input *model.Function) (*Uploa
 	}
 
 	input.SourceFilename = existing.SourceFilename
+	input.Version = existing.Version
 	if err = putFunction(ctx, s.StateStore, input); err != nil {
 		return nil, errors.Wrap(err, "could not update function configuration")
 	}
@@ -76,38 +160,85 @@ func (s *Server) PutFunction(ctx context.Context, input *model.Function) (*Uploa
 	return nil, nil
 }
 
-// ConfirmUpload is called by the client when the source has been uploaded
-func (s *Server) ConfirmUpload(ctx context.Context, token string) error {
+// ConfirmUpload is called by the client when the source has been uploaded.
+// The function is stored as a new version. Returns an error if the function
+// has been updated since the upload was requested, a backend.ConflictError
+// if its configuration has been modified.
+// The upload is verified against the digest declared by the client. In case
+// it does not match or the upload does not exist the upload is rejected, it
+// is deleted together with the pending upload and the source must be uploaded
+// again.
+func (s *Server) ConfirmUpload(ctx context.Context, token string, digest *model.Digest) (err error) {
+	event := auditEvent(model.AuditActionConfirmUpload, modelTypeFunction, "")
+	defer s.audit(ctx, event, &err)
+	if err := s.authorize(ctx, auth.VerbWrite, auth.ResourceFunction); err != nil {
+		return err
+	}
 	if token == "" {
 		return errors.New("token not set")
 	}
+	if err := digest.Validate(); err != nil {
+		return errors.Wrap(err, "invalid digest")
+	}
 
-	p := pendingUploadPath(token)
+	p := pendingUploadPath(ctx, token)
 
 	upload, err := getPendingUpload(ctx, s.StateStore, token)
 	if err != nil {
 		return err
 	}
+	if upload == nil || upload.Function == nil {
+		return errors.New("not found")
+	}
+	event.ModelName = upload.Function.Name
+	event.NewChecksum = upload.Function.Checksum
+
+	unlock, err := s.lock(ctx, functionPath(ctx, upload.Function.Name))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// The upload may have been confirmed while waiting for the lock
+	upload, err = getPendingUpload(ctx, s.StateStore, token)
+	if err != nil {
+		return err
+	}
 	if upload == nil {
 		return errors.New("not found")
 	}
+	if s.uploadExpired(upload) {
+		return errors.New("upload expired")
+	}
 
-	if err := s.SourceStore.Persist(ctx, token); err != nil {
-		return errors.Wrap(err, "could not persist source")
+	existing, err := getFunction(ctx, s.StateStore, upload.Function.Name)
+	if err != nil {
+		return errors.Wrap(err, "check existing function")
+	}
+	var version int64
+	if existing != nil {
+		event.OldChecksum = existing.Checksum
+		version = existing.Version
+	}
+	if version != upload.BaseVersion {
+		return errors.Errorf("function %s was updated to version %d after the upload was requested", upload.Function.Name, version)
 	}
 
 	function := upload.Function
-	function.SourceFilename = upload.Filename
-
-	if err := putFunction(ctx, s.StateStore, function); err != nil {
-		return errors.Wrap(err, "error storing function update")
+	function.SourceFilename, err = s.persistSource(ctx, upload, function, digest)
+	if err != nil {
+		return err
 	}
 
-	if err := s.StateStore.Delete(ctx, p); err != nil {
-		return errors.Wrap(err, "could not delete pending upload")
+	// The pending upload is removed in the same transaction the function is
+	// updated in. In case the transaction fails the persisted source is not
+	// referenced by any function.
+	if err := s.storeFunctionVersion(ctx, function, 0, backend.DeleteOp(p)); err != nil {
+		return err
 	}
 
-	// TODO(akupila): if set; archive upload.PreviousFilename from source store
+	// The previous source is not deleted, it is referenced by the previous
+	// version of the function.
 	return nil
 }
 
@@ -116,33 +247,57 @@ func (s *Server) ConfirmUpload(ctx context.Context, token string) error {
 // state.Environment does not contain these fields.
 type EnvironmentInput struct {
 	// Name is the name that identifies the environment.
-	Name string
+	Name string `json:"name"`
+	// Namespace is the namespace to create the environment in. It must match
+	// the namespace of the request if set.
+	Namespace string `json:"namespace,omitempty"`
 	// Labels are used to map a deployment to the environment.
-	Labels map[string]string
+	Labels map[string]string `json:"labels,omitempty"`
 	// Infrastructure is the type of infrastructure the environment is for
-	Infrastructure model.InfraType
+	Infrastructure model.InfraType `json:"infrastructure"`
 	// Username is the username used to authenticate to the infrastructure
 	// provider.
-	Username string
+	Username string `json:"username"`
 	// Password is the password used to authenticate to the infrastructure
 	// provider.
-	Password string
+	Password string `json:"password"`
+	// UsernameSecret is a key in the secret store to read the username from
+	// in case Username is not set.
+	UsernameSecret string `json:"username_secret,omitempty"`
+	// PasswordSecret is a key in the secret store to read the password from
+	// in case Password is not set.
+	PasswordSecret string `json:"password_secret,omitempty"`
 	// AWS contains AWS environment specific information
-	AWS *model.InfrastructureAWS
+	AWS *model.InfrastructureAWS `json:"aws,omitempty"`
 }
 
 // CreateEnvironment creates a new target deployment environment. Returns an
 // error if an environment with the same name already exists.
-func (s *Server) CreateEnvironment(ctx context.Context, input *EnvironmentInput) error {
+func (s *Server) CreateEnvironment(ctx context.Context, input *EnvironmentInput) (err error) {
+	event := auditEvent(model.AuditActionCreate, modelTypeEnvironment, "")
+	defer s.audit(ctx, event, &err)
+	if err := s.authorize(ctx, auth.VerbWrite, auth.ResourceEnvironment); err != nil {
+		return err
+	}
 	if input == nil {
 		return errors.New("no environment supplied")
 	}
+	event.ModelName = input.Name
 	if input.Name == "" {
 		return errors.New("environment has no name")
 	}
+	if err := checkNamespace(ctx, input.Namespace); err != nil {
+		return errors.Wrapf(err, "environment %s", input.Name)
+	}
+
+	unlock, err := s.lock(ctx, environmentPath(ctx, input.Name))
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	// Check for existing environment
-	_, err := s.StateStore.Get(ctx, environmentPath(input.Name))
+	_, err = s.StateStore.Get(ctx, environmentPath(ctx, input.Name))
 	notFound := backend.IsNotFound(err)
 	if err != nil && !notFound {
 		return errors.Wrap(err, "could not check for existing environment")
@@ -151,7 +306,19 @@ func (s *Server) CreateEnvironment(ctx context.Context, input *EnvironmentInput)
 		return errors.Errorf("an environment with name %s already exists", input.Name)
 	}
 
-	if err := storeUserCredentials(ctx, s.SecretStore, input.Name, input.Username, input.Password); err != nil {
+	username, err := s.resolveCredential(ctx, input.Username, input.UsernameSecret)
+	if err != nil {
+		return errors.Wrap(err, "could not resolve username")
+	}
+	password, err := s.resolveCredential(ctx, input.Password, input.PasswordSecret)
+	if err != nil {
+		return errors.Wrap(err, "could not resolve password")
+	}
+	if err := s.registerNamespace(ctx); err != nil {
+		return err
+	}
+
+	if err := storeUserCredentials(ctx, s.SecretStore, input.Name, 0, username, password); err != nil {
 		return errors.Wrap(err, "could not store user credentials")
 	}
 
@@ -169,21 +336,402 @@ func (s *Server) CreateEnvironment(ctx context.Context, input *EnvironmentInput)
 	return nil
 }
 
+// EnvironmentUpdate is the input to specify when updating an environment.
+// Fields that are not set are left unchanged.
+type EnvironmentUpdate struct {
+	// Name is the name of the environment to update.
+	Name string `json:"name"`
+	// Labels replace the labels of the environment.
+	Labels map[string]string `json:"labels,omitempty"`
+	// AWS contains the AWS settings to update.
+	AWS *model.InfrastructureAWS `json:"aws,omitempty"`
+	// Username is the new username used to authenticate to the
+	// infrastructure provider. Must be set together with Password.
+	Username string `json:"username,omitempty"`
+	// Password is the new password used to authenticate to the
+	// infrastructure provider. Must be set together with Username.
+	Password string `json:"password,omitempty"`
+	// UsernameSecret is a key in the secret store to read the new username
+	// from in case Username is not set.
+	UsernameSecret string `json:"username_secret,omitempty"`
+	// PasswordSecret is a key in the secret store to read the new password
+	// from in case Password is not set.
+	PasswordSecret string `json:"password_secret,omitempty"`
+	// Revision is the revision of the environment the update is based on. The
+	// update is rejected if the environment has been modified after it.
+	Revision int64 `json:"revision,omitempty"`
+}
+
+// UpdateEnvironment updates an existing environment. Returns a
+// backend.NotFoundError if the environment does not exist, a
+// backend.ConflictError if it has been modified after the input revision.
+//
+// In case credentials are set and differ from the current credentials they
+// are rotated: the new credentials are stored as a new generation before the
+// environment is updated to use that generation, after which the previous
+// credentials are deleted. A failure before the environment has been updated
+// leaves the environment using the previous credentials.
+func (s *Server) UpdateEnvironment(ctx context.Context, input *EnvironmentUpdate) (err error) {
+	event := auditEvent(model.AuditActionUpdate, modelTypeEnvironment, "")
+	defer s.audit(ctx, event, &err)
+	if err := s.authorize(ctx, auth.VerbWrite, auth.ResourceEnvironment); err != nil {
+		return err
+	}
+	if input == nil {
+		return errors.New("no environment supplied")
+	}
+	event.ModelName = input.Name
+	if input.Name == "" {
+		return errors.New("environment has no name")
+	}
+	username, err := s.resolveCredential(ctx, input.Username, input.UsernameSecret)
+	if err != nil {
+		return errors.Wrap(err, "could not resolve username")
+	}
+	password, err := s.resolveCredential(ctx, input.Password, input.PasswordSecret)
+	if err != nil {
+		return errors.Wrap(err, "could not resolve password")
+	}
+	rotate := username != "" || password != ""
+	if rotate && (username == "" || password == "") {
+		return errors.New("both username and password must be set to rotate credentials")
+	}
+
+	unlock, err := s.lock(ctx, environmentPath(ctx, input.Name))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	env, err := s.GetEnvironment(ctx, input.Name)
+	if err != nil {
+		return err
+	}
+	if err := checkRevision(environmentPath(ctx, env.Name), input.Revision, env.Revision); err != nil {
+		return errors.Wrapf(err, "environment %s", env.Name)
+	}
+
+	if rotate {
+		u, p, err := getUserCredentials(ctx, s.SecretStore, env.Name, env.CredentialsGeneration)
+		if err == nil && u == username && p == password {
+			rotate = false
+		}
+	}
+
+	if input.Labels != nil {
+		env.Labels = input.Labels
+	}
+	if input.AWS != nil {
+		if env.AWS == nil {
+			env.AWS = &model.InfrastructureAWS{}
+		}
+		if input.AWS.Region != "" {
+			env.AWS.Region = input.AWS.Region
+		}
+		if input.AWS.Role != "" {
+			env.AWS.Role = input.AWS.Role
+		}
+	}
+
+	if !rotate {
+		if err := putEnvironment(ctx, s.StateStore, env); err != nil {
+			return errors.Wrap(err, "could not store environment")
+		}
+		return nil
+	}
+
+	previous := env.CredentialsGeneration
+	env.CredentialsGeneration++
+
+	if err := storeUserCredentials(ctx, s.SecretStore, env.Name, env.CredentialsGeneration, username, password); err != nil {
+		_ = deleteUserCredentials(ctx, s.SecretStore, env.Name, env.CredentialsGeneration)
+		return errors.Wrap(err, "could not store user credentials")
+	}
+
+	if err := putEnvironment(ctx, s.StateStore, env); err != nil {
+		_ = deleteUserCredentials(ctx, s.SecretStore, env.Name, env.CredentialsGeneration)
+		return errors.Wrap(err, "could not store environment")
+	}
+
+	// The environment no longer uses the previous credentials. Failing to
+	// delete them leaves unused secrets behind, the environment is still
+	// consistent.
+	_ = deleteUserCredentials(ctx, s.SecretStore, env.Name, previous)
+
+	return nil
+}
+
 // PutDeployment creates or updates a deployment. In case the deployment already
-// exists it is updated.
-func (s *Server) PutDeployment(ctx context.Context, input *model.Deployment) error {
+// exists it is updated. If the input has a revision, a backend.ConflictError
+// is returned if the deployment has been modified after it.
+func (s *Server) PutDeployment(ctx context.Context, input *model.Deployment) (err error) {
+	event := auditEvent(model.AuditActionPut, modelTypeDeployment, "")
+	defer s.audit(ctx, event, &err)
+	if err := s.authorize(ctx, auth.VerbWrite, auth.ResourceDeployment); err != nil {
+		return err
+	}
 	if input == nil {
 		return errors.New("no deployment supplied")
 	}
+	event.ModelName = input.Name
 	if input.Name == "" {
 		return errors.New("deployment has no name")
 	}
+	if err := checkNamespace(ctx, input.Namespace); err != nil {
+		return errors.Wrapf(err, "deployment %s", input.Name)
+	}
+	unlock, err := s.lock(ctx, deploymentPath(ctx, input.Name))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	existing, err := getDeployment(ctx, s.StateStore, input.Name)
+	if err != nil {
+		return errors.Wrap(err, "check existing deployment")
+	}
+	var revision int64
+	if existing != nil {
+		input.Owner, err = resolveOwner(input.Owner, existing.Owner)
+		if err != nil {
+			return errors.Wrapf(err, "deployment %s", input.Name)
+		}
+		revision = existing.Revision
+	}
+	if err := checkRevision(deploymentPath(ctx, input.Name), input.Revision, revision); err != nil {
+		return errors.Wrapf(err, "deployment %s", input.Name)
+	}
+	input.Revision = revision
+	if err := s.registerNamespace(ctx); err != nil {
+		return err
+	}
 	if err := putDeployment(ctx, s.StateStore, input); err != nil {
 		return errors.Wrap(err, "could not store deployment")
 	}
 	return nil
 }
 
+// GetFunction returns a stored function. Returns a backend.NotFoundError if
+// the function does not exist.
+func (s *Server) GetFunction(ctx context.Context, name string) (*model.Function, error) {
+	if err := s.authorize(ctx, auth.VerbRead, auth.ResourceFunction); err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, errors.New("function has no name")
+	}
+	f, err := getFunction(ctx, s.StateStore, name)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get function")
+	}
+	if f == nil {
+		return nil, &backend.NotFoundError{Key: functionPath(ctx, name)}
+	}
+	return f, nil
+}
+
+// GetDeployment returns a stored deployment. Returns a backend.NotFoundError
+// if the deployment does not exist.
+func (s *Server) GetDeployment(ctx context.Context, name string) (*model.Deployment, error) {
+	if err := s.authorize(ctx, auth.VerbRead, auth.ResourceDeployment); err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, errors.New("deployment has no name")
+	}
+	d, err := getDeployment(ctx, s.StateStore, name)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get deployment")
+	}
+	if d == nil {
+		return nil, &backend.NotFoundError{Key: deploymentPath(ctx, name)}
+	}
+	return d, nil
+}
+
+// GetEnvironment returns a stored environment. Returns a
+// backend.NotFoundError if the environment does not exist.
+func (s *Server) GetEnvironment(ctx context.Context, name string) (*model.Environment, error) {
+	if err := s.authorize(ctx, auth.VerbRead, auth.ResourceEnvironment); err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, errors.New("environment has no name")
+	}
+	e, err := getEnvironment(ctx, s.StateStore, name)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get environment")
+	}
+	if e == nil {
+		return nil, &backend.NotFoundError{Key: environmentPath(ctx, name)}
+	}
+	return e, nil
+}
+
+// DeploymentDescription is a deployment together with the functions and
+// environments its selectors resolve to.
+type DeploymentDescription struct {
+	// Deployment is the described deployment.
+	Deployment *model.Deployment `json:"deployment"`
+	// Functions are the functions currently selected by the deployment.
+	Functions []*model.Function `json:"functions"`
+	// Environments are the environments currently selected by the deployment.
+	Environments []*model.Environment `json:"environments"`
+}
+
+// DescribeDeployment returns a deployment and resolves its label selectors
+// against the stored functions and environments.
+func (s *Server) DescribeDeployment(ctx context.Context, name string) (*DeploymentDescription, error) {
+	if err := s.authorize(ctx, auth.VerbRead, auth.ResourceDeployment, auth.ResourceFunction, auth.ResourceEnvironment); err != nil {
+		return nil, err
+	}
+	d, err := s.GetDeployment(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	functions, err := s.ListFunctions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	environments, err := s.ListEnvironments(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &DeploymentDescription{
+		Deployment:   d,
+		Functions:    d.SelectFunctions(functions),
+		Environments: d.SelectEnvironments(environments),
+	}, nil
+}
+
+// ListFunctions returns all stored functions, sorted by name.
+func (s *Server) ListFunctions(ctx context.Context) ([]*model.Function, error) {
+	if err := s.authorize(ctx, auth.VerbRead, auth.ResourceFunction); err != nil {
+		return nil, err
+	}
+	functions, err := listFunctions(ctx, s.StateStore)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list functions")
+	}
+	return functions, nil
+}
+
+// ListDeployments returns all stored deployments, sorted by name.
+func (s *Server) ListDeployments(ctx context.Context) ([]*model.Deployment, error) {
+	if err := s.authorize(ctx, auth.VerbRead, auth.ResourceDeployment); err != nil {
+		return nil, err
+	}
+	deployments, err := listDeployments(ctx, s.StateStore)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list deployments")
+	}
+	return deployments, nil
+}
+
+// ListEnvironments returns all stored environments, sorted by name.
+func (s *Server) ListEnvironments(ctx context.Context) ([]*model.Environment, error) {
+	if err := s.authorize(ctx, auth.VerbRead, auth.ResourceEnvironment); err != nil {
+		return nil, err
+	}
+	environments, err := listEnvironments(ctx, s.StateStore)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list environments")
+	}
+	return environments, nil
+}
+
+// EnvironmentCredentials returns the credentials used to authenticate to the
+// infrastructure provider of an environment.
+func (s *Server) EnvironmentCredentials(ctx context.Context, name string) (string, string, error) {
+	if err := s.authorize(ctx, auth.VerbRead, auth.ResourceCredentials); err != nil {
+		return "", "", err
+	}
+	if name == "" {
+		return "", "", errors.New("environment has no name")
+	}
+	env, err := s.GetEnvironment(ctx, name)
+	if err != nil {
+		return "", "", err
+	}
+	username, password, err := getUserCredentials(ctx, s.SecretStore, name, env.CredentialsGeneration)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "could not get credentials for environment %s", name)
+	}
+	return username, password, nil
+}
+
+// secretReencrypter is implemented by secret stores that encrypt values with
+// keys that can be rotated.
+type secretReencrypter interface {
+	Reencrypt(ctx context.Context, root string) (int, error)
+}
+
+// ReencryptSecrets re-encrypts the environment credentials of all namespaces
+// in the secret store with its current key after keys have been rotated.
+// Returns the number of re-encrypted values, nothing is done if the secret
+// store does not encrypt values.
+func (s *Server) ReencryptSecrets(ctx context.Context) (int, error) {
+	if err := s.authorizeGlobal(ctx, auth.VerbWrite, auth.ResourceCredentials); err != nil {
+		return 0, err
+	}
+	r, ok := s.SecretStore.(secretReencrypter)
+	if !ok {
+		return 0, nil
+	}
+	namespaces, err := s.ListNamespaces(ctx)
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	for _, namespace := range namespaces {
+		n, err := r.Reencrypt(ctx, namespacePath(WithNamespace(ctx, namespace), userSecretPrefix))
+		total += n
+		if err != nil {
+			return total, errors.Wrapf(err, "could not re-encrypt secrets of namespace %s", namespace)
+		}
+	}
+	return total, nil
+}
+
+// resolveCredential returns value if set, otherwise the value stored under
+// secret in the secret store. Credentials managed by the server can not be
+// referenced.
+func (s *Server) resolveCredential(ctx context.Context, value, secret string) (string, error) {
+	if value != "" || secret == "" {
+		return value, nil
+	}
+	if strings.HasPrefix(secret, userSecretPrefix) || strings.HasPrefix(secret, namespacePrefix) {
+		return "", errors.Errorf("secret %s is managed by fragments and can not be referenced", secret)
+	}
+	v, err := s.SecretStore.Get(ctx, secret)
+	if err != nil {
+		return "", errors.Wrapf(err, "could not read secret %s", secret)
+	}
+	return v, nil
+}
+
+// resolveOwner returns the owner to store for a model. The existing owner is
+// kept if no owner is set. Returns an error if the model is owned by someone
+// else.
+func resolveOwner(owner, existing string) (string, error) {
+	if owner == "" {
+		return existing, nil
+	}
+	if existing != "" && existing != owner {
+		return "", errors.Errorf("owned by %s", existing)
+	}
+	return owner, nil
+}
+
+// checkRevision returns a backend.ConflictError if the model stored at key has
+// been modified after the revision an input is based on. Inputs without a
+// revision are not checked.
+func checkRevision(key string, input, stored int64) error {
+	if input != 0 && input != stored {
+		return &backend.ConflictError{Key: key, Revision: input}
+	}
+	return nil
+}
+
 // requestUpload creates a url the client can upload source code to. The upload
 // request is stored as a PendingUpload in the store so it can be retrieved
 // when the client confirms the upload.
@@ -195,13 +743,16 @@ func (s *Server) requestUpload(ctx context.Context, input *model.Function, exist
 		return nil, errors.New("could not create upload url")
 	}
 
+	function := *input
+	function.Namespace = ""
 	pendingUpload := &model.PendingUpload{
 		Token:    token,
-		Filename: token,
-		Function: input,
+		Function: &function,
+		Created:  s.Now().UTC(),
 	}
 	if existing != nil {
 		pendingUpload.PreviousFilename = existing.SourceFilename
+		pendingUpload.BaseVersion = existing.Version
 	}
 
 	if err := putPendingUpload(ctx, s.StateStore, pendingUpload); err != nil {
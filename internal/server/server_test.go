@@ -3,13 +3,17 @@ package server
 import (
 	"context"
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/fragments/fragments/internal/backend"
 	fsmocks "github.com/fragments/fragments/internal/filestore/mocks"
 	"github.com/fragments/fragments/internal/model"
 	"github.com/fragments/fragments/pkg/testutils"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 )
 
@@ -146,6 +150,7 @@ func TestPutFunction(t *testing.T) {
 			s.GenerateToken = func() string {
 				return test.Token
 			}
+			s.Now = testNow
 
 			res, err := s.PutFunction(ctx, test.Function)
 			if test.Error {
@@ -158,7 +163,7 @@ func TestPutFunction(t *testing.T) {
 
 			testutils.AssertGolden(
 				t,
-				testutils.SnapshotJSONMap(kv.Data),
+				testutils.SnapshotJSONMap(withoutAudit(kv.Data)),
 				fmt.Sprintf("testdata/TestPutFunction-%s.yaml", test.TestName),
 			)
 		})
@@ -168,13 +173,14 @@ func TestPutFunction(t *testing.T) {
 func TestConfirmUpload(t *testing.T) {
 	initial := backend.NewTestKV()
 	ctx := context.Background()
-	err := putFunction(ctx, initial, &model.Function{
+	existing := &model.Function{
 		Name:           "existing",
 		AWS:            &model.FunctionAWS{Timeout: 3, Memory: 256},
 		Runtime:        "go",
 		SourceFilename: "previous.tar.gz",
 		Checksum:       "foo",
-	})
+	}
+	err := putFunction(ctx, initial, existing)
 	require.NoError(t, err)
 	err = putPendingUpload(ctx, initial, &model.PendingUpload{
 		Token:    "new",
@@ -195,6 +201,7 @@ func TestConfirmUpload(t *testing.T) {
 			AWS:      &model.FunctionAWS{Timeout: 5, Memory: 1024},
 			Runtime:  "nodejs",
 			Checksum: "foo",
+			Revision: existing.Revision,
 		},
 	})
 	require.NoError(t, err)
@@ -206,6 +213,18 @@ func TestConfirmUpload(t *testing.T) {
 			AWS:      &model.FunctionAWS{Timeout: 3, Memory: 256},
 			Runtime:  "go",
 			Checksum: "updated",
+			Revision: existing.Revision,
+		},
+	})
+	require.NoError(t, err)
+	err = putPendingUpload(ctx, initial, &model.PendingUpload{
+		Token:    "modified",
+		Filename: "baz.tar.gz",
+		Function: &model.Function{
+			Name:     "existing",
+			Runtime:  "go",
+			Checksum: "updated",
+			Revision: existing.Revision - 1,
 		},
 	})
 	require.NoError(t, err)
@@ -237,15 +256,22 @@ func TestConfirmUpload(t *testing.T) {
 			TestName: "UpdateCode",
 			Token:    "update-code",
 		},
+		{
+			TestName: "Modified",
+			Token:    "modified",
+			Error:    true,
+		},
 	}
 
 	for _, test := range tests {
 		t.Run(test.TestName, func(t *testing.T) {
 			ctx := context.Background()
 
+			ctx = WithActor(ctx, "user@host")
+
 			mockSourceStore := &fsmocks.SourceTarget{}
 			mockSourceStore.
-				On("Persist", ctx, test.Token).
+				On("Persist", ctx, test.Token, mock.Anything, testDigest()).
 				Return(nil)
 
 			kv := initial.Copy()
@@ -254,8 +280,9 @@ func TestConfirmUpload(t *testing.T) {
 			s.GenerateToken = func() string {
 				return test.Token
 			}
+			s.Now = testNow
 
-			err := s.ConfirmUpload(ctx, test.Token)
+			err := s.ConfirmUpload(ctx, test.Token, testDigest())
 			if test.Error {
 				require.Error(t, err)
 				return
@@ -266,13 +293,59 @@ func TestConfirmUpload(t *testing.T) {
 
 			testutils.AssertGolden(
 				t,
-				testutils.SnapshotJSONMap(kv.Data),
+				testutils.SnapshotJSONMap(withoutAudit(kv.Data)),
 				fmt.Sprintf("testdata/TestConfirmUpload-%s.yaml", test.TestName),
 			)
 		})
 	}
 }
 
+func TestConfirmUploadFailure(t *testing.T) {
+	ctx := context.Background()
+	kv := &failingKV{TestKV: backend.NewTestKV(), failKey: functionPath(ctx, "foo")}
+	err := putPendingUpload(ctx, kv, &model.PendingUpload{
+		Token:    "token",
+		Filename: "token",
+		Function: &model.Function{Name: "foo", Checksum: "abc"},
+		Created:  testNow(),
+	})
+	require.NoError(t, err)
+	initial := kv.Copy()
+
+	mockSourceStore := &fsmocks.SourceTarget{}
+	mockSourceStore.
+		On("Persist", ctx, "token", "token", testDigest()).
+		Return(nil)
+
+	s := New(kv, nil, mockSourceStore)
+	s.Now = testNow
+
+	err = s.ConfirmUpload(ctx, "token", testDigest())
+	require.Error(t, err)
+
+	// Neither the function nor its version is stored and the upload can be
+	// confirmed again
+	assert.Equal(t, initial.Data, withoutAudit(kv.Data))
+}
+
+func testNow() time.Time {
+	return time.Date(2017, 11, 1, 12, 0, 0, 0, time.UTC)
+}
+
+// testDigest returns the digest of an uploaded archive.
+func testDigest() *model.Digest {
+	return digestOf("source")
+}
+
+// digestOf returns the digest of an archive with the content.
+func digestOf(content string) *model.Digest {
+	digest, err := model.NewDigest(strings.NewReader(content))
+	if err != nil {
+		panic(err)
+	}
+	return digest
+}
+
 func TestCreateEnvironment(t *testing.T) {
 	initial := backend.NewTestKV()
 	ctx := context.Background()
@@ -337,7 +410,7 @@ func TestCreateEnvironment(t *testing.T) {
 
 			testutils.AssertGolden(
 				t,
-				testutils.SnapshotJSONMap(kv.Data),
+				testutils.SnapshotJSONMap(withoutAudit(kv.Data)),
 				fmt.Sprintf("testdata/TestCreateEnvironment-%s-state.yaml", test.TestName),
 			)
 			testutils.AssertGolden(
@@ -349,6 +422,247 @@ func TestCreateEnvironment(t *testing.T) {
 	}
 }
 
+func TestCreateEnvironmentSecretReference(t *testing.T) {
+	ctx := context.Background()
+	kv := backend.NewTestKV()
+	secrets := backend.NewTestKV()
+	secrets.Data["aws/prod/id"] = "user"
+	secrets.Data["aws/prod/secret"] = "pass"
+	secrets.Data["user/other/pass"] = "other"
+
+	s := New(kv, secrets, nil)
+
+	// Managed credentials can't be referenced
+	err := s.CreateEnvironment(ctx, &EnvironmentInput{
+		Name:           "prod",
+		UsernameSecret: "aws/prod/id",
+		PasswordSecret: "user/other/pass",
+	})
+	require.Error(t, err)
+
+	err = s.CreateEnvironment(ctx, &EnvironmentInput{
+		Name:           "prod",
+		UsernameSecret: "aws/prod/id",
+		PasswordSecret: "nonexisting",
+	})
+	require.Error(t, err)
+
+	err = s.CreateEnvironment(ctx, &EnvironmentInput{
+		Name:           "prod",
+		UsernameSecret: "aws/prod/id",
+		PasswordSecret: "aws/prod/secret",
+	})
+	require.NoError(t, err)
+
+	username, password, err := s.EnvironmentCredentials(ctx, "prod")
+	require.NoError(t, err)
+	assert.Equal(t, "user", username)
+	assert.Equal(t, "pass", password)
+}
+
+// failingKV is a TestKV that fails to put a key.
+type failingKV struct {
+	*backend.TestKV
+	failKey string
+}
+
+func (f *failingKV) Put(ctx context.Context, key, value string) error {
+	if key == f.failKey {
+		return errors.New("put failed")
+	}
+	return f.TestKV.Put(ctx, key, value)
+}
+
+func (f *failingKV) PutRevision(ctx context.Context, key, value string, revision int64) (int64, error) {
+	if key == f.failKey {
+		return 0, errors.New("put failed")
+	}
+	return f.TestKV.PutRevision(ctx, key, value, revision)
+}
+
+func (f *failingKV) Txn(ctx context.Context, ops ...backend.Op) (int64, error) {
+	for _, op := range ops {
+		if op.Key == f.failKey {
+			return 0, errors.New("transaction failed")
+		}
+	}
+	return f.TestKV.Txn(ctx, ops...)
+}
+
+func TestUpdateEnvironment(t *testing.T) {
+	ctx := context.Background()
+	initial := backend.NewTestKV()
+	initialSecrets := backend.NewTestKV()
+	existing := &model.Environment{
+		Name:           "env",
+		Labels:         map[string]string{"stage": "dev"},
+		Infrastructure: model.InfrastructureTypeAWS,
+		AWS:            &model.InfrastructureAWS{Region: "us-east-1", Role: "role"},
+	}
+	err := putEnvironment(ctx, initial, existing)
+	require.NoError(t, err)
+	err = storeUserCredentials(ctx, initialSecrets, "env", 0, "user", "pass")
+	require.NoError(t, err)
+
+	tests := []struct {
+		TestName    string
+		Input       *EnvironmentUpdate
+		FailKey     string
+		Environment *model.Environment
+		Username    string
+		Password    string
+		Error       bool
+	}{
+		{
+			TestName: "NoInput",
+			Error:    true,
+		},
+		{
+			TestName: "NoName",
+			Input:    &EnvironmentUpdate{},
+			Error:    true,
+		},
+		{
+			TestName: "NotFound",
+			Input:    &EnvironmentUpdate{Name: "nonexisting"},
+			Error:    true,
+		},
+		{
+			TestName: "OnlyUsername",
+			Input:    &EnvironmentUpdate{Name: "env", Username: "new"},
+			Error:    true,
+		},
+		{
+			TestName: "Update",
+			Input: &EnvironmentUpdate{
+				Name:   "env",
+				Labels: map[string]string{"stage": "prod"},
+				AWS:    &model.InfrastructureAWS{Region: "eu-west-1"},
+			},
+			Environment: &model.Environment{
+				Name:           "env",
+				Namespace:      DefaultNamespace,
+				Labels:         map[string]string{"stage": "prod"},
+				Infrastructure: model.InfrastructureTypeAWS,
+				AWS:            &model.InfrastructureAWS{Region: "eu-west-1", Role: "role"},
+			},
+			Username: "user",
+			Password: "pass",
+		},
+		{
+			TestName: "Revision",
+			Input: &EnvironmentUpdate{
+				Name:     "env",
+				Labels:   map[string]string{"stage": "prod"},
+				Revision: existing.Revision,
+			},
+			Environment: &model.Environment{
+				Name:           "env",
+				Namespace:      DefaultNamespace,
+				Labels:         map[string]string{"stage": "prod"},
+				Infrastructure: model.InfrastructureTypeAWS,
+				AWS:            &model.InfrastructureAWS{Region: "us-east-1", Role: "role"},
+			},
+			Username: "user",
+			Password: "pass",
+		},
+		{
+			TestName: "StaleRevision",
+			Input: &EnvironmentUpdate{
+				Name:     "env",
+				Labels:   map[string]string{"stage": "prod"},
+				Revision: existing.Revision + 100,
+			},
+			Error: true,
+		},
+		{
+			TestName: "Rotate",
+			Input: &EnvironmentUpdate{
+				Name:     "env",
+				Username: "newuser",
+				Password: "newpass",
+			},
+			Environment: &model.Environment{
+				Name:                  "env",
+				Namespace:             DefaultNamespace,
+				Labels:                map[string]string{"stage": "dev"},
+				Infrastructure:        model.InfrastructureTypeAWS,
+				AWS:                   &model.InfrastructureAWS{Region: "us-east-1", Role: "role"},
+				CredentialsGeneration: 1,
+			},
+			Username: "newuser",
+			Password: "newpass",
+		},
+		{
+			TestName: "SameCredentials",
+			Input: &EnvironmentUpdate{
+				Name:     "env",
+				Username: "user",
+				Password: "pass",
+			},
+			Environment: &model.Environment{
+				Name:           "env",
+				Namespace:      DefaultNamespace,
+				Labels:         map[string]string{"stage": "dev"},
+				Infrastructure: model.InfrastructureTypeAWS,
+				AWS:            &model.InfrastructureAWS{Region: "us-east-1", Role: "role"},
+			},
+			Username: "user",
+			Password: "pass",
+		},
+		{
+			TestName: "RotateSecretFailure",
+			Input: &EnvironmentUpdate{
+				Name:     "env",
+				Username: "newuser",
+				Password: "newpass",
+			},
+			FailKey: userSecretPass(ctx, "env", 1),
+			Error:   true,
+		},
+		{
+			TestName: "RotateEnvironmentFailure",
+			Input: &EnvironmentUpdate{
+				Name:     "env",
+				Username: "newuser",
+				Password: "newpass",
+			},
+			FailKey: environmentPath(ctx, "env"),
+			Error:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			kv := &failingKV{TestKV: initial.Copy(), failKey: test.FailKey}
+			secrets := &failingKV{TestKV: initialSecrets.Copy(), failKey: test.FailKey}
+			s := New(kv, secrets, nil)
+
+			err := s.UpdateEnvironment(ctx, test.Input)
+			if test.Error {
+				require.Error(t, err)
+				// The environment still uses the previous credentials
+				assert.Equal(t, initial.Data, withoutAudit(kv.Data))
+				assert.Equal(t, initialSecrets.Data, secrets.Data)
+				return
+			}
+			require.NoError(t, err)
+
+			env, err := s.GetEnvironment(ctx, "env")
+			require.NoError(t, err)
+			assert.True(t, env.Revision > existing.Revision)
+			env.Revision = 0
+			assert.Equal(t, test.Environment, env)
+
+			username, password, err := s.EnvironmentCredentials(ctx, "env")
+			require.NoError(t, err)
+			assert.Equal(t, test.Username, username)
+			assert.Equal(t, test.Password, password)
+			assert.Len(t, secrets.Data, 2)
+		})
+	}
+}
+
 func TestPutDeployment(t *testing.T) {
 	initial := backend.NewTestKV()
 	ctx := context.Background()
@@ -408,9 +722,247 @@ func TestPutDeployment(t *testing.T) {
 
 			testutils.AssertGolden(
 				t,
-				testutils.SnapshotJSONMap(kv.Data),
+				testutils.SnapshotJSONMap(withoutAudit(kv.Data)),
 				fmt.Sprintf("testdata/TestPutDeployment-%s-state.yaml", test.TestName),
 			)
 		})
 	}
 }
+
+func TestOwner(t *testing.T) {
+	ctx := context.Background()
+	kv := backend.NewTestKV()
+	require.NoError(t, putFunction(ctx, kv, &model.Function{Name: "foo", Checksum: "abc", Owner: "a"}))
+	require.NoError(t, putDeployment(ctx, kv, &model.Deployment{Name: "foo", Owner: "a"}))
+
+	s := New(kv, nil, nil)
+
+	// Owned by someone else
+	_, err := s.PutFunction(ctx, &model.Function{Name: "foo", Checksum: "abc", Owner: "b"})
+	require.Error(t, err)
+	err = s.PutDeployment(ctx, &model.Deployment{Name: "foo", Owner: "b"})
+	require.Error(t, err)
+
+	// Owner is kept if not set
+	_, err = s.PutFunction(ctx, &model.Function{Name: "foo", Checksum: "abc"})
+	require.NoError(t, err)
+	err = s.PutDeployment(ctx, &model.Deployment{Name: "foo"})
+	require.NoError(t, err)
+
+	f, err := getFunction(ctx, kv, "foo")
+	require.NoError(t, err)
+	assert.Equal(t, "a", f.Owner)
+	d, err := getDeployment(ctx, kv, "foo")
+	require.NoError(t, err)
+	assert.Equal(t, "a", d.Owner)
+}
+
+func TestRevision(t *testing.T) {
+	ctx := context.Background()
+	kv := backend.NewTestKV()
+	function := &model.Function{Name: "foo", Checksum: "abc"}
+	deployment := &model.Deployment{Name: "foo"}
+	require.NoError(t, putFunction(ctx, kv, function))
+	require.NoError(t, putDeployment(ctx, kv, deployment))
+
+	s := New(kv, nil, nil)
+
+	// Modified after the revision
+	_, err := s.PutFunction(ctx, &model.Function{Name: "foo", Checksum: "abc", Revision: function.Revision + 100})
+	assert.True(t, backend.IsConflict(errors.Cause(err)))
+	err = s.PutDeployment(ctx, &model.Deployment{Name: "foo", Revision: deployment.Revision + 100})
+	assert.True(t, backend.IsConflict(errors.Cause(err)))
+
+	// Up to date
+	_, err = s.PutFunction(ctx, &model.Function{Name: "foo", Checksum: "abc", Runtime: "go", Revision: function.Revision})
+	require.NoError(t, err)
+	err = s.PutDeployment(ctx, &model.Deployment{Name: "foo", Owner: "a", Revision: deployment.Revision})
+	require.NoError(t, err)
+
+	f, err := s.GetFunction(ctx, "foo")
+	require.NoError(t, err)
+	assert.Equal(t, "go", f.Runtime)
+	assert.True(t, f.Revision > function.Revision)
+
+	// The previous revision is stale
+	_, err = s.PutFunction(ctx, &model.Function{Name: "foo", Checksum: "abc", Revision: function.Revision})
+	assert.True(t, backend.IsConflict(errors.Cause(err)))
+
+	// Not checked without a revision
+	_, err = s.PutFunction(ctx, &model.Function{Name: "foo", Checksum: "abc"})
+	require.NoError(t, err)
+	err = s.PutDeployment(ctx, &model.Deployment{Name: "foo"})
+	require.NoError(t, err)
+}
+
+func TestList(t *testing.T) {
+	ctx := context.Background()
+	kv := backend.NewTestKV()
+	var (
+		wantFunctions    []*model.Function
+		wantDeployments  []*model.Deployment
+		wantEnvironments []*model.Environment
+	)
+	for _, name := range []string{"b", "a"} {
+		f := &model.Function{Name: name, Namespace: DefaultNamespace}
+		d := &model.Deployment{Name: name, Namespace: DefaultNamespace}
+		e := &model.Environment{Name: name, Namespace: DefaultNamespace}
+		require.NoError(t, putFunction(ctx, kv, f))
+		require.NoError(t, putDeployment(ctx, kv, d))
+		require.NoError(t, putEnvironment(ctx, kv, e))
+		wantFunctions = append([]*model.Function{f}, wantFunctions...)
+		wantDeployments = append([]*model.Deployment{d}, wantDeployments...)
+		wantEnvironments = append([]*model.Environment{e}, wantEnvironments...)
+	}
+
+	s := New(kv, nil, nil)
+
+	// Listed models have the revision they were stored at
+	functions, err := s.ListFunctions(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, wantFunctions, functions)
+	assert.NotZero(t, functions[0].Revision)
+
+	deployments, err := s.ListDeployments(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, wantDeployments, deployments)
+
+	environments, err := s.ListEnvironments(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, wantEnvironments, environments)
+
+	kv.Data["function/malformed"] = "{"
+	_, err = s.ListFunctions(ctx)
+	require.Error(t, err)
+}
+
+func TestGet(t *testing.T) {
+	ctx := context.Background()
+	kv := backend.NewTestKV()
+	function := &model.Function{Name: "foo", Namespace: DefaultNamespace}
+	deployment := &model.Deployment{Name: "foo", Namespace: DefaultNamespace}
+	environment := &model.Environment{Name: "foo", Namespace: DefaultNamespace}
+	require.NoError(t, putFunction(ctx, kv, function))
+	require.NoError(t, putDeployment(ctx, kv, deployment))
+	require.NoError(t, putEnvironment(ctx, kv, environment))
+
+	s := New(kv, nil, nil)
+
+	f, err := s.GetFunction(ctx, "foo")
+	require.NoError(t, err)
+	assert.Equal(t, function, f)
+	assert.NotZero(t, f.Revision)
+	_, err = s.GetFunction(ctx, "bar")
+	assert.True(t, backend.IsNotFound(err))
+	_, err = s.GetFunction(ctx, "")
+	require.Error(t, err)
+
+	d, err := s.GetDeployment(ctx, "foo")
+	require.NoError(t, err)
+	assert.Equal(t, deployment, d)
+	_, err = s.GetDeployment(ctx, "bar")
+	assert.True(t, backend.IsNotFound(err))
+
+	e, err := s.GetEnvironment(ctx, "foo")
+	require.NoError(t, err)
+	assert.Equal(t, environment, e)
+	_, err = s.GetEnvironment(ctx, "bar")
+	assert.True(t, backend.IsNotFound(err))
+}
+
+func TestDescribeDeployment(t *testing.T) {
+	ctx := context.Background()
+	kv := backend.NewTestKV()
+	function := &model.Function{Name: "a", Namespace: DefaultNamespace, Labels: map[string]string{"app": "foo"}}
+	require.NoError(t, putFunction(ctx, kv, function))
+	require.NoError(t, putFunction(ctx, kv, &model.Function{Name: "b", Labels: map[string]string{"app": "bar"}}))
+	environment := &model.Environment{Name: "prod", Namespace: DefaultNamespace, Labels: map[string]string{"stage": "prod"}}
+	require.NoError(t, putEnvironment(ctx, kv, environment))
+	require.NoError(t, putEnvironment(ctx, kv, &model.Environment{Name: "dev", Labels: map[string]string{"stage": "dev"}}))
+	deployment := &model.Deployment{
+		Name:              "deploy",
+		Namespace:         DefaultNamespace,
+		FunctionLabels:    map[string]string{"app": "foo"},
+		EnvironmentLabels: map[string]string{"stage": "prod"},
+	}
+	require.NoError(t, putDeployment(ctx, kv, deployment))
+
+	s := New(kv, nil, nil)
+
+	_, err := s.DescribeDeployment(ctx, "nonexisting")
+	assert.True(t, backend.IsNotFound(err))
+
+	description, err := s.DescribeDeployment(ctx, "deploy")
+	require.NoError(t, err)
+	assert.Equal(t, &DeploymentDescription{
+		Deployment:   deployment,
+		Functions:    []*model.Function{function},
+		Environments: []*model.Environment{environment},
+	}, description)
+}
+
+func TestEnvironmentCredentials(t *testing.T) {
+	ctx := context.Background()
+	secrets := backend.NewTestKV()
+	err := storeUserCredentials(ctx, secrets, "env", 0, "user", "pass")
+	require.NoError(t, err)
+	kv := backend.NewTestKV()
+	err = putEnvironment(ctx, kv, &model.Environment{Name: "env"})
+	require.NoError(t, err)
+
+	s := New(kv, secrets, nil)
+
+	_, _, err = s.EnvironmentCredentials(ctx, "")
+	require.Error(t, err)
+
+	_, _, err = s.EnvironmentCredentials(ctx, "nonexisting")
+	require.Error(t, err)
+
+	username, password, err := s.EnvironmentCredentials(ctx, "env")
+	require.NoError(t, err)
+	assert.Equal(t, "user", username)
+	assert.Equal(t, "pass", password)
+}
+
+func TestReencryptSecrets(t *testing.T) {
+	ctx := context.Background()
+	oldKey := backend.SecretKey{ID: "old", Key: make([]byte, 32)}
+	newKey := backend.SecretKey{ID: "new", Key: []byte("0123456789abcdef0123456789abcdef")}
+
+	secretsKV := backend.NewTestKV()
+	secrets, err := backend.NewEncrypted(secretsKV, oldKey)
+	require.NoError(t, err)
+	kv := backend.NewTestKV()
+	s := New(kv, secrets, nil)
+	err = s.CreateEnvironment(ctx, &EnvironmentInput{
+		Name:           "env",
+		Infrastructure: model.InfrastructureTypeAWS,
+		Username:       "user",
+		Password:       "pass",
+	})
+	require.NoError(t, err)
+
+	// Secret stores that don't encrypt are not modified
+	plain := New(kv, backend.NewTestKV(), nil)
+	n, err := plain.ReencryptSecrets(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// Rotate keys
+	s.SecretStore, err = backend.NewEncrypted(secretsKV, newKey, oldKey)
+	require.NoError(t, err)
+	n, err = s.ReencryptSecrets(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	n, err = s.ReencryptSecrets(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// The old key is no longer needed
+	s.SecretStore, err = backend.NewEncrypted(secretsKV, newKey)
+	require.NoError(t, err)
+	username, password, err := s.EnvironmentCredentials(ctx, "env")
+	require.NoError(t, err)
+	assert.Equal(t, "user", username)
+	assert.Equal(t, "pass", password)
+}
@@ -0,0 +1,266 @@
+package server
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/fragments/fragments/internal/backend"
+	"github.com/fragments/fragments/internal/filestore"
+	"github.com/fragments/fragments/internal/model"
+	"github.com/pkg/errors"
+)
+
+// checksumPattern matches valid source checksums. Checksums are recorded in
+// source archives and audit events, so they are restricted to characters that
+// are safe in keys.
+var checksumPattern = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+
+// sourceKeyPattern matches the keys of source archives, the hex encoded
+// SHA256 of the archive.
+var sourceKeyPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// maxSourceArchiveAttempts is the number of times recording a source archive
+// is attempted while it is modified concurrently.
+const maxSourceArchiveAttempts = 5
+
+// validateChecksum returns an error if a checksum is not a valid source
+// checksum.
+func validateChecksum(checksum string) error {
+	if !checksumPattern.MatchString(checksum) {
+		return errors.Errorf("invalid source checksum %q", checksum)
+	}
+	return nil
+}
+
+// sourceKey returns the key of the source archive with a digest. Archives are
+// keyed by their SHA256, which the filestore verifies when the archive is
+// persisted, so the content of an archive always matches its key.
+func sourceKey(digest *model.Digest) string {
+	return hex.EncodeToString(digest.SHA256)
+}
+
+// sourceArchivePath returns the key the source archive with a key is recorded
+// at.
+func sourceArchivePath(ctx context.Context, key string) string {
+	return namespacePath(ctx, fmt.Sprintf("source/%s", key))
+}
+
+// sourceFilename returns the name the source archive with a key is persisted
+// as. Archives are only shared within a namespace, the archives of other
+// namespaces are prefixed with the namespace and an underscore, which neither
+// namespaces nor keys contain.
+func sourceFilename(ctx context.Context, key string) string {
+	if ns := NamespaceFromContext(ctx); ns != DefaultNamespace {
+		return fmt.Sprintf("%s_%s", ns, key)
+	}
+	return key
+}
+
+// sourceArchiveOf returns the context with the namespace and the key of the
+// source archive persisted as filename. Returns false if the file is not
+// content addressed.
+func sourceArchiveOf(ctx context.Context, filename string) (context.Context, string, bool) {
+	namespace, key := DefaultNamespace, filename
+	if i := strings.Index(filename, "_"); i >= 0 {
+		namespace, key = filename[:i], filename[i+1:]
+	}
+	if ValidateNamespace(namespace) != nil || !sourceKeyPattern.MatchString(key) {
+		return nil, "", false
+	}
+	return WithNamespace(ctx, namespace), key, true
+}
+
+// sourceArchiveKey returns the key of the source archive persisted as
+// filename in the namespace set in the context. Returns false if the file is
+// not content addressed.
+func sourceArchiveKey(ctx context.Context, filename string) (string, bool) {
+	nsCtx, key, ok := sourceArchiveOf(ctx, filename)
+	if !ok || NamespaceFromContext(nsCtx) != NamespaceFromContext(ctx) {
+		return "", false
+	}
+	return key, true
+}
+
+// sourceLockKey returns the key that is locked while the source file with a
+// filename is persisted or deleted. Filenames are unique across namespaces.
+func sourceLockKey(filename string) string {
+	return fmt.Sprintf("sourcefile/%s", filename)
+}
+
+// getSourceArchive returns the source archive with a key and the revision it
+// was stored at. Returns nil if the archive does not exist.
+func getSourceArchive(ctx context.Context, kv backend.RevisionReader, key string) (*model.SourceArchive, int64, error) {
+	raw, revision, err := kv.GetRevision(ctx, sourceArchivePath(ctx, key))
+	if err != nil {
+		if backend.IsNotFound(err) {
+			return nil, 0, nil
+		}
+		return nil, 0, err
+	}
+	var a model.SourceArchive
+	if err := model.UnmarshalSourceArchive([]byte(raw), &a); err != nil {
+		return nil, 0, err
+	}
+	return &a, revision, nil
+}
+
+// sharedSourceArchive returns a referenced archive of the source with the
+// checksum, the source is not uploaded again. The checksum is declared by the
+// client and is not verified against the archive, so only archives uploaded
+// for a function with the same owner in the same namespace are shared.
+// Archives without references may be collected at any time, they are never
+// returned.
+func (s *Server) sharedSourceArchive(ctx context.Context, checksum, owner string) (*model.SourceArchive, error) {
+	raw, err := s.StateStore.List(ctx, sourceArchivePath(ctx, ""))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not check for existing source")
+	}
+	keys := make([]string, 0, len(raw))
+	for key := range raw {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var a model.SourceArchive
+		if err := model.UnmarshalSourceArchive([]byte(raw[key]), &a); err != nil {
+			return nil, errors.Wrapf(err, "could not read source archive %s", key)
+		}
+		if a.Checksum == checksum && a.Owner == owner && a.References > 0 {
+			return &a, nil
+		}
+	}
+	return nil, nil
+}
+
+// createSourceArchive records the source archive of a function that is about
+// to be persisted, without references. Recording the archive before the file
+// is persisted ensures the file is never left without a record the garbage
+// collector can check. An existing archive is kept, one without references is
+// touched so a collector that read it before fails to delete it and the file
+// that is about to be persisted.
+func (s *Server) createSourceArchive(ctx context.Context, key, filename string, f *model.Function) error {
+	path := sourceArchivePath(ctx, key)
+	for i := 0; i < maxSourceArchiveAttempts; i++ {
+		archive, revision, err := getSourceArchive(ctx, s.StateStore, key)
+		if err != nil {
+			return errors.Wrap(err, "could not read source archive")
+		}
+		if archive != nil && archive.References > 0 {
+			return nil
+		}
+		if archive == nil {
+			archive = &model.SourceArchive{Checksum: f.Checksum, Filename: filename, Owner: f.Owner}
+		}
+		archive.Created = s.Now().UTC()
+		raw, err := model.MarshalSourceArchive(archive)
+		if err != nil {
+			return err
+		}
+		_, err = s.StateStore.PutRevision(ctx, path, string(raw), revision)
+		if err == nil {
+			return nil
+		}
+		if !backend.IsConflict(err) {
+			return errors.Wrap(err, "could not record source archive")
+		}
+	}
+	return errors.Errorf("source archive %s was modified concurrently", key)
+}
+
+// persistSource persists an upload as the source of a function. Source is
+// persisted as the archive keyed by its digest, unless the upload was
+// requested with a filename before archives were keyed by digest. The
+// archive is recorded before it is persisted and the file is locked while it
+// is recorded and persisted so the garbage collector can't delete it in
+// between. The upload is persisted even if the archive exists, the filestore
+// verifies it matches the digest. An upload that does not match its digest
+// or no longer exists is rejected. Returns the filename the source was
+// persisted as.
+func (s *Server) persistSource(ctx context.Context, upload *model.PendingUpload, f *model.Function, digest *model.Digest) (string, error) {
+	filename := upload.Filename
+	if filename == "" {
+		filename = sourceFilename(ctx, sourceKey(digest))
+	}
+	if key, ok := sourceArchiveKey(ctx, filename); ok {
+		unlock, err := s.waitLock(ctx, sourceLockKey(filename))
+		if err != nil {
+			return "", err
+		}
+		defer unlock()
+		if err := s.createSourceArchive(ctx, key, filename, f); err != nil {
+			return "", err
+		}
+	}
+	if err := s.SourceStore.Persist(ctx, upload.Token, filename, digest); err != nil {
+		if filestore.IsDigestMismatch(err) || filestore.IsNotFound(err) {
+			s.rejectUpload(ctx, upload.Token)
+		}
+		return "", errors.Wrap(err, "could not persist source")
+	}
+	return filename, nil
+}
+
+// referenceSourceOps returns the transaction operations that add delta
+// references to the source archive of a function. Source persisted before
+// archives were content addressed is not reference counted, no operations
+// are returned for it. The operations fail if the archive is modified before
+// they are committed, which keeps the garbage collector from deleting an
+// archive that is being referenced.
+func (s *Server) referenceSourceOps(ctx context.Context, f *model.Function, delta int64) ([]backend.Op, error) {
+	if f == nil || f.SourceFilename == "" {
+		return nil, nil
+	}
+	key, ok := sourceArchiveKey(ctx, f.SourceFilename)
+	if !ok {
+		return nil, nil
+	}
+	archive, revision, err := getSourceArchive(ctx, s.StateStore, key)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get source archive")
+	}
+	if archive == nil {
+		if delta > 0 {
+			return nil, errors.Errorf("source archive %s no longer exists", f.SourceFilename)
+		}
+		return nil, nil
+	}
+	archive.References += delta
+	if archive.References < 0 {
+		archive.References = 0
+	}
+	raw, err := model.MarshalSourceArchive(archive)
+	if err != nil {
+		return nil, err
+	}
+	return []backend.Op{backend.PutRevisionOp(sourceArchivePath(ctx, key), string(raw), revision)}, nil
+}
+
+// OpenFunctionSource opens the source archive of the current version of a
+// function for reading, the source that is deployed. The reader streams the
+// archive from the filestore and must be closed. Returns a
+// backend.NotFoundError if the function does not exist and a
+// filestore.NotFoundError if its source has not been uploaded or does not
+// exist.
+func (s *Server) OpenFunctionSource(ctx context.Context, name string) (io.ReadCloser, *model.Function, error) {
+	f, err := s.GetFunction(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if f.SourceFilename == "" {
+		return nil, nil, errors.Wrapf(&filestore.NotFoundError{}, "function %s has no source", name)
+	}
+	reader, ok := s.SourceStore.(filestore.SourceReader)
+	if !ok {
+		return nil, nil, errors.New("source store can not be read from")
+	}
+	r, err := reader.OpenFile(ctx, f.SourceFilename, 0, 0)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "could not open source of function %s", name)
+	}
+	return r, f, nil
+}
@@ -0,0 +1,302 @@
+package server
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/fragments/fragments/internal/backend"
+	"github.com/fragments/fragments/internal/filestore"
+	fsmocks "github.com/fragments/fragments/internal/filestore/mocks"
+	"github.com/fragments/fragments/internal/model"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateChecksum(t *testing.T) {
+	tests := []struct {
+		Checksum string
+		Error    bool
+	}{
+		{Checksum: "abc123"},
+		{Checksum: "ABC-123"},
+		{Checksum: "", Error: true},
+		{Checksum: "../abc", Error: true},
+		{Checksum: "a/b", Error: true},
+		{Checksum: "team_abc", Error: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Checksum, func(t *testing.T) {
+			err := validateChecksum(test.Checksum)
+			if test.Error {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestSourceFilename(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, "abc", sourceFilename(ctx, "abc"))
+	assert.Equal(t, "abc", sourceFilename(WithNamespace(ctx, DefaultNamespace), "abc"))
+	assert.Equal(t, "team-a_abc", sourceFilename(WithNamespace(ctx, "team-a"), "abc"))
+}
+
+func TestSourceArchiveKey(t *testing.T) {
+	ctx := context.Background()
+	teamCtx := WithNamespace(ctx, "team-a")
+	key := sourceKey(testDigest())
+
+	tests := []struct {
+		TestName string
+		Context  context.Context
+		Filename string
+		Valid    bool
+	}{
+		{TestName: "Default", Context: ctx, Filename: key, Valid: true},
+		{TestName: "Namespace", Context: teamCtx, Filename: "team-a_" + key, Valid: true},
+		{TestName: "OtherNamespace", Context: ctx, Filename: "team-a_" + key},
+		{TestName: "Token", Context: ctx, Filename: "01C0B5H7Y4XD4MNJ4QFG3RG3J9"},
+		{TestName: "Checksum", Context: ctx, Filename: "abc"},
+		{TestName: "UpperCase", Context: ctx, Filename: strings.ToUpper(key)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			actual, ok := sourceArchiveKey(test.Context, test.Filename)
+			assert.Equal(t, test.Valid, ok)
+			if test.Valid {
+				assert.Equal(t, key, actual)
+			}
+		})
+	}
+}
+
+func TestSharedSource(t *testing.T) {
+	ctx := context.Background()
+	kv := backend.NewTestKV()
+	key := sourceKey(testDigest())
+	mockSourceStore := &fsmocks.SourceTarget{}
+	mockSourceStore.On("NewUploadURL", "token").Return("url", nil).Once()
+	mockSourceStore.On("Persist", ctx, "token", key, testDigest()).Return(nil).Once()
+
+	s := New(kv, nil, mockSourceStore)
+	s.Now = testNow
+	s.GenerateToken = func() string { return "token" }
+
+	references := func() int64 {
+		archive, _, err := getSourceArchive(ctx, kv, key)
+		require.NoError(t, err)
+		require.NotNil(t, archive)
+		return archive.References
+	}
+
+	upload, err := s.PutFunction(ctx, &model.Function{Name: "foo", Checksum: "abc", Owner: "repo"})
+	require.NoError(t, err)
+	require.NotNil(t, upload)
+	require.NoError(t, s.ConfirmUpload(ctx, upload.Token, testDigest()))
+	assert.EqualValues(t, 1, references())
+	archive, _, err := getSourceArchive(ctx, kv, key)
+	require.NoError(t, err)
+	assert.Equal(t, &model.SourceArchive{Checksum: "abc", Filename: key, Owner: "repo", References: 1, Created: testNow()}, archive)
+
+	// The source of bar has already been uploaded for foo
+	upload, err = s.PutFunction(ctx, &model.Function{Name: "bar", Checksum: "abc", Owner: "repo"})
+	require.NoError(t, err)
+	assert.Nil(t, upload)
+	assert.EqualValues(t, 2, references())
+	mockSourceStore.AssertExpectations(t)
+
+	bar, err := s.GetFunction(ctx, "bar")
+	require.NoError(t, err)
+	assert.Equal(t, key, bar.SourceFilename)
+	assert.EqualValues(t, 1, bar.Version)
+
+	// Rolling back adds a reference
+	_, err = s.RollbackFunction(ctx, "bar", 1)
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, references())
+
+	// The archive is kept while it is referenced
+	require.NoError(t, s.DeleteFunction(ctx, "bar", false))
+	assert.EqualValues(t, 1, references())
+	require.NoError(t, s.DeleteFunction(ctx, "foo", false))
+	assert.EqualValues(t, 0, references())
+	mockSourceStore.AssertNotCalled(t, "Delete", ctx, key)
+
+	// Archives without references may be collected, the source is uploaded
+	// again
+	mockSourceStore.On("NewUploadURL", "token").Return("url", nil).Once()
+	upload, err = s.PutFunction(ctx, &model.Function{Name: "foo", Checksum: "abc", Owner: "repo"})
+	require.NoError(t, err)
+	assert.NotNil(t, upload)
+}
+
+func TestSharedSourceOwner(t *testing.T) {
+	ctx := context.Background()
+	kv := backend.NewTestKV()
+	mockSourceStore := &fsmocks.SourceTarget{}
+	mockSourceStore.On("NewUploadURL", "token").Return("url", nil)
+	mockSourceStore.On("Persist", ctx, "token", sourceKey(testDigest()), testDigest()).Return(nil).Once()
+	mockSourceStore.On("Persist", ctx, "token", sourceKey(digestOf("other")), digestOf("other")).Return(nil).Once()
+
+	s := New(kv, nil, mockSourceStore)
+	s.Now = testNow
+	s.GenerateToken = func() string { return "token" }
+
+	upload, err := s.PutFunction(ctx, &model.Function{Name: "foo", Checksum: "abc", Owner: "repo"})
+	require.NoError(t, err)
+	require.NotNil(t, upload)
+	require.NoError(t, s.ConfirmUpload(ctx, upload.Token, testDigest()))
+
+	// The declared checksum is not verified, another owner must upload the
+	// source itself. Different content with the same checksum is persisted
+	// as a separate archive.
+	upload, err = s.PutFunction(ctx, &model.Function{Name: "bar", Checksum: "abc", Owner: "other"})
+	require.NoError(t, err)
+	require.NotNil(t, upload)
+	require.NoError(t, s.ConfirmUpload(ctx, upload.Token, digestOf("other")))
+	mockSourceStore.AssertExpectations(t)
+
+	foo, err := s.GetFunction(ctx, "foo")
+	require.NoError(t, err)
+	assert.Equal(t, sourceKey(testDigest()), foo.SourceFilename)
+	bar, err := s.GetFunction(ctx, "bar")
+	require.NoError(t, err)
+	assert.Equal(t, sourceKey(digestOf("other")), bar.SourceFilename)
+}
+
+func TestSharedSourceConfirmedTwice(t *testing.T) {
+	ctx := context.Background()
+	kv := backend.NewTestKV()
+	key := sourceKey(testDigest())
+	mockSourceStore := &fsmocks.SourceTarget{}
+	mockSourceStore.On("NewUploadURL", "token-foo").Return("url", nil)
+	mockSourceStore.On("NewUploadURL", "token-bar").Return("url", nil)
+	mockSourceStore.On("Persist", ctx, "token-foo", key, testDigest()).Return(nil).Once()
+	mockSourceStore.On("Persist", ctx, "token-bar", key, testDigest()).Return(nil).Once()
+
+	s := New(kv, nil, mockSourceStore)
+	s.Now = testNow
+
+	// Both uploads are requested before either is confirmed, both are
+	// verified when they are persisted
+	for _, name := range []string{"foo", "bar"} {
+		token := "token-" + name
+		s.GenerateToken = func() string { return token }
+		upload, err := s.PutFunction(ctx, &model.Function{Name: name, Checksum: "abc"})
+		require.NoError(t, err)
+		require.NotNil(t, upload)
+	}
+	require.NoError(t, s.ConfirmUpload(ctx, "token-foo", testDigest()))
+	require.NoError(t, s.ConfirmUpload(ctx, "token-bar", testDigest()))
+	mockSourceStore.AssertExpectations(t)
+
+	archive, _, err := getSourceArchive(ctx, kv, key)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, archive.References)
+}
+
+func TestSharedSourceNamespaces(t *testing.T) {
+	ctx := context.Background()
+	kv := backend.NewTestKV()
+	key := sourceKey(testDigest())
+	mockSourceStore := &fsmocks.SourceTarget{}
+	mockSourceStore.On("NewUploadURL", "token").Return("url", nil)
+	mockSourceStore.On("Persist", WithNamespace(ctx, "team-a"), "token", "team-a_"+key, testDigest()).Return(nil).Once()
+	mockSourceStore.On("Persist", WithNamespace(ctx, "team-b"), "token", "team-b_"+key, testDigest()).Return(nil).Once()
+
+	s := New(kv, nil, mockSourceStore)
+	s.Now = testNow
+	s.GenerateToken = func() string { return "token" }
+
+	// Archives are not shared across namespaces
+	for _, ns := range []string{"team-a", "team-b"} {
+		nsCtx := WithNamespace(ctx, ns)
+		upload, err := s.PutFunction(nsCtx, &model.Function{Name: "foo", Checksum: "abc"})
+		require.NoError(t, err)
+		require.NotNil(t, upload)
+		require.NoError(t, s.ConfirmUpload(nsCtx, upload.Token, testDigest()))
+	}
+	mockSourceStore.AssertExpectations(t)
+}
+
+func TestLegacySource(t *testing.T) {
+	ctx := context.Background()
+	kv := backend.NewTestKV()
+	// Source persisted before archives were content addressed is named after
+	// the upload token
+	require.NoError(t, putFunction(ctx, kv, &model.Function{Name: "foo", Checksum: "abc", SourceFilename: "token"}))
+
+	mockSourceStore := &fsmocks.SourceTarget{}
+	mockSourceStore.On("Delete", ctx, "token").Return(nil).Once()
+
+	s := New(kv, nil, mockSourceStore)
+	s.Now = testNow
+
+	f := &model.Function{Name: "foo", Checksum: "abc", SourceFilename: "token"}
+	ops, err := s.referenceSourceOps(ctx, f, 1)
+	require.NoError(t, err)
+	assert.Empty(t, ops)
+
+	require.NoError(t, s.DeleteFunction(ctx, "foo", false))
+	mockSourceStore.AssertExpectations(t)
+	assert.Empty(t, withoutAudit(kv.Data))
+}
+
+func TestPutFunctionInvalidChecksum(t *testing.T) {
+	s := New(backend.NewTestKV(), nil, &fsmocks.SourceTarget{})
+	_, err := s.PutFunction(context.Background(), &model.Function{Name: "foo", Checksum: "../foo"})
+	assert.Error(t, err)
+}
+
+// readingSourceStore is a source store that reads files from memory.
+type readingSourceStore struct {
+	*fsmocks.SourceTarget
+	files map[string]string
+}
+
+func (r *readingSourceStore) OpenFile(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	content, ok := r.files[name]
+	if !ok {
+		return nil, &filestore.NotFoundError{Name: name}
+	}
+	return ioutil.NopCloser(strings.NewReader(content)), nil
+}
+
+func TestOpenFunctionSource(t *testing.T) {
+	ctx := context.Background()
+	kv := backend.NewTestKV()
+	require.NoError(t, putFunction(ctx, kv, &model.Function{Name: "foo", Checksum: "abc", SourceFilename: "abc"}))
+	require.NoError(t, putFunction(ctx, kv, &model.Function{Name: "bar", Checksum: "def", SourceFilename: "def"}))
+	require.NoError(t, putFunction(ctx, kv, &model.Function{Name: "pending", Checksum: "ghi"}))
+
+	sourceStore := &readingSourceStore{files: map[string]string{"abc": "source"}}
+	s := New(kv, nil, sourceStore)
+
+	r, f, err := s.OpenFunctionSource(ctx, "foo")
+	require.NoError(t, err)
+	assert.Equal(t, "abc", f.Checksum)
+	data, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "source", string(data))
+	require.NoError(t, r.Close())
+
+	_, _, err = s.OpenFunctionSource(ctx, "missing")
+	assert.True(t, backend.IsNotFound(errors.Cause(err)))
+	_, _, err = s.OpenFunctionSource(ctx, "bar")
+	assert.True(t, filestore.IsNotFound(err))
+	_, _, err = s.OpenFunctionSource(ctx, "pending")
+	assert.True(t, filestore.IsNotFound(err))
+
+	// The source store must be readable
+	s.SourceStore = &fsmocks.SourceTarget{}
+	_, _, err = s.OpenFunctionSource(ctx, "foo")
+	assert.Error(t, err)
+}
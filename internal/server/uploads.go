@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/fragments/fragments/internal/auth"
+	"github.com/fragments/fragments/internal/backend"
+	"github.com/fragments/fragments/internal/model"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+)
+
+// DefaultUploadTTL is the default time source uploads must be confirmed in.
+const DefaultUploadTTL = 1 * time.Hour
+
+// CollectPendingUploads deletes pending uploads of all namespaces that have
+// not been confirmed within the upload TTL together with their uploaded
+// files. Returns the number of pending uploads deleted.
+func (s *Server) CollectPendingUploads(ctx context.Context) (int, error) {
+	if err := s.authorizeGlobal(ctx, auth.VerbWrite, auth.ResourceFunction); err != nil {
+		return 0, err
+	}
+	namespaces, err := s.ListNamespaces(ctx)
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	for _, namespace := range namespaces {
+		n, err := s.collectPendingUploads(WithNamespace(ctx, namespace))
+		total += n
+		if err != nil {
+			return total, errors.Wrapf(err, "namespace %s", namespace)
+		}
+	}
+	return total, nil
+}
+
+// collectPendingUploads deletes the expired pending uploads of the namespace
+// set in the context.
+func (s *Server) collectPendingUploads(ctx context.Context) (int, error) {
+	uploads, err := listPendingUploads(ctx, s.StateStore)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not list pending uploads")
+	}
+
+	n := 0
+	for _, p := range uploads {
+		if !s.uploadExpired(p) {
+			continue
+		}
+		// The file is deleted first, a pending upload without a file can
+		// still be collected later but a file without a pending upload is
+		// never found again.
+		if err := s.SourceStore.DeleteUpload(ctx, p.Token); err != nil {
+			return n, errors.Wrapf(err, "could not delete upload %s", p.Token)
+		}
+		if err := s.StateStore.Delete(ctx, pendingUploadPath(ctx, p.Token)); err != nil && !backend.IsNotFound(err) {
+			return n, errors.Wrapf(err, "could not delete pending upload %s", p.Token)
+		}
+		n++
+	}
+	return n, nil
+}
+
+// rejectUpload deletes an upload that can not be persisted together with its
+// pending upload. The file is deleted first, the pending upload is kept if it
+// fails and is collected once it expired. Errors are ignored.
+func (s *Server) rejectUpload(ctx context.Context, token string) {
+	if err := s.SourceStore.DeleteUpload(ctx, token); err != nil {
+		return
+	}
+	_ = s.StateStore.Delete(ctx, pendingUploadPath(ctx, token))
+}
+
+// RunUploadCollector collects expired pending uploads every interval until
+// the context is cancelled. Errors are logged.
+func (s *Server) RunUploadCollector(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, err := s.CollectPendingUploads(ctx)
+		if err != nil {
+			log.Println(errors.Wrap(err, "pending upload collection failed"))
+		}
+		if n > 0 {
+			log.Printf("Deleted %d expired pending uploads", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// uploadExpired returns true if a pending upload was created more than the
+// upload TTL ago.
+func (s *Server) uploadExpired(p *model.PendingUpload) bool {
+	if s.UploadTTL <= 0 {
+		return false
+	}
+	created, ok := pendingUploadCreated(p)
+	if !ok {
+		return false
+	}
+	return s.Now().Sub(created) > s.UploadTTL
+}
+
+// pendingUploadCreated returns the time a pending upload was created. Pending
+// uploads stored without a creation time fall back to the time encoded in the
+// ulid token. Returns false if the time is not known.
+func pendingUploadCreated(p *model.PendingUpload) (time.Time, bool) {
+	if !p.Created.IsZero() {
+		return p.Created, true
+	}
+	id, err := ulid.Parse(p.Token)
+	if err != nil {
+		return time.Time{}, false
+	}
+	ms := int64(id.Time())
+	return time.Unix(ms/1000, (ms%1000)*int64(time.Millisecond)), true
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/fragments/fragments/internal/backend"
+	"github.com/fragments/fragments/internal/filestore"
+	fsmocks "github.com/fragments/fragments/internal/filestore/mocks"
+	"github.com/fragments/fragments/internal/model"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectPendingUploads(t *testing.T) {
+	ctx := context.Background()
+	legacy := ulid.MustNew(ulid.Timestamp(testNow().Add(-2*time.Hour)), rand.Reader).String()
+
+	initial := backend.NewTestKV()
+	for _, p := range []*model.PendingUpload{
+		{Token: "fresh", Filename: "fresh", Created: testNow().Add(-30 * time.Minute)},
+		{Token: "expired", Filename: "expired", Created: testNow().Add(-90 * time.Minute)},
+		{Token: legacy, Filename: legacy},
+		{Token: "unknown", Filename: "unknown"},
+	} {
+		require.NoError(t, putPendingUpload(ctx, initial, p))
+	}
+
+	tests := []struct {
+		TestName    string
+		TTL         time.Duration
+		DeleteError bool
+		Deleted     []string
+		Error       bool
+	}{
+		{
+			TestName: "Collect",
+			TTL:      time.Hour,
+			Deleted:  []string{"expired", legacy},
+		},
+		{
+			TestName: "ShortTTL",
+			TTL:      10 * time.Minute,
+			Deleted:  []string{"expired", "fresh", legacy},
+		},
+		{
+			TestName: "NoExpiry",
+			TTL:      0,
+		},
+		{
+			TestName:    "DeleteError",
+			TTL:         time.Hour,
+			DeleteError: true,
+			Error:       true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			kv := initial.Copy()
+			mockSourceStore := &fsmocks.SourceTarget{}
+			for _, name := range test.Deleted {
+				mockSourceStore.On("DeleteUpload", mock.Anything, name).Return(nil).Once()
+			}
+			if test.DeleteError {
+				mockSourceStore.On("DeleteUpload", mock.Anything, mock.Anything).Return(assert.AnError)
+			}
+
+			s := New(kv, nil, mockSourceStore)
+			s.Now = testNow
+			s.UploadTTL = test.TTL
+
+			n, err := s.CollectPendingUploads(ctx)
+			if test.Error {
+				require.Error(t, err)
+				assert.Len(t, kv.Data, 4)
+				return
+			}
+			require.NoError(t, err)
+			mockSourceStore.AssertExpectations(t)
+			assert.Equal(t, len(test.Deleted), n)
+			for _, name := range test.Deleted {
+				assert.NotContains(t, kv.Data, pendingUploadPath(ctx, name))
+			}
+			assert.Len(t, kv.Data, 4-len(test.Deleted))
+		})
+	}
+}
+
+func TestConfirmExpiredUpload(t *testing.T) {
+	ctx := context.Background()
+	kv := backend.NewTestKV()
+	require.NoError(t, putPendingUpload(ctx, kv, &model.PendingUpload{
+		Token:    "expired",
+		Filename: "expired",
+		Function: &model.Function{Name: "foo"},
+		Created:  testNow().Add(-2 * time.Hour),
+	}))
+
+	s := New(kv, nil, &fsmocks.SourceTarget{})
+	s.Now = testNow
+
+	err := s.ConfirmUpload(ctx, "expired", testDigest())
+	require.Error(t, err)
+	assert.NotContains(t, kv.Data, functionPath(ctx, "foo"))
+}
+
+func TestConfirmRejectedUpload(t *testing.T) {
+	ctx := context.Background()
+	initial := backend.NewTestKV()
+	require.NoError(t, putPendingUpload(ctx, initial, &model.PendingUpload{
+		Token:    "token",
+		Filename: "abc",
+		Function: &model.Function{Name: "foo", Checksum: "abc"},
+		Created:  testNow(),
+	}))
+
+	tests := []struct {
+		TestName     string
+		Digest       *model.Digest
+		PersistError error
+		Rejected     bool
+	}{
+		{
+			TestName: "NoDigest",
+		},
+		{
+			TestName: "InvalidDigest",
+			Digest:   &model.Digest{MD5: []byte("md5")},
+		},
+		{
+			TestName:     "DigestMismatch",
+			Digest:       testDigest(),
+			PersistError: &filestore.DigestMismatchError{Name: "token"},
+			Rejected:     true,
+		},
+		{
+			TestName:     "NotUploaded",
+			Digest:       testDigest(),
+			PersistError: &filestore.NotFoundError{Name: "token"},
+			Rejected:     true,
+		},
+		{
+			TestName:     "PersistError",
+			Digest:       testDigest(),
+			PersistError: assert.AnError,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			kv := initial.Copy()
+			mockSourceStore := &fsmocks.SourceTarget{}
+			if test.PersistError != nil {
+				mockSourceStore.On("Persist", ctx, "token", "abc", test.Digest).Return(test.PersistError).Once()
+			}
+			if test.Rejected {
+				mockSourceStore.On("DeleteUpload", ctx, "token").Return(nil).Once()
+			}
+
+			s := New(kv, nil, mockSourceStore)
+			s.Now = testNow
+
+			err := s.ConfirmUpload(ctx, "token", test.Digest)
+			require.Error(t, err)
+			mockSourceStore.AssertExpectations(t)
+			if test.PersistError != nil {
+				assert.Equal(t, test.PersistError, errors.Cause(err))
+			}
+			if !test.Rejected {
+				mockSourceStore.AssertNotCalled(t, "DeleteUpload", mock.Anything, mock.Anything)
+			}
+
+			// Rejected uploads must be requested again
+			_, ok := kv.Data[pendingUploadPath(ctx, "token")]
+			assert.Equal(t, !test.Rejected, ok)
+			assert.NotContains(t, kv.Data, functionPath(ctx, "foo"))
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+
+	"github.com/fragments/fragments/internal/auth"
+	"github.com/fragments/fragments/internal/backend"
+	"github.com/fragments/fragments/internal/model"
+	"github.com/pkg/errors"
+)
+
+// FunctionHistory returns the versions of a function, oldest first. Returns a
+// backend.NotFoundError if the function does not exist.
+func (s *Server) FunctionHistory(ctx context.Context, name string) ([]*model.FunctionVersion, error) {
+	if err := s.authorize(ctx, auth.VerbRead, auth.ResourceFunction); err != nil {
+		return nil, err
+	}
+	if _, err := s.GetFunction(ctx, name); err != nil {
+		return nil, err
+	}
+	versions, err := listFunctionVersions(ctx, s.StateStore, name)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list function versions")
+	}
+	return versions, nil
+}
+
+// RollbackFunction restores the configuration and source of a previous
+// version of a function. The source is not uploaded again. The rollback is
+// recorded as a new version. Returns a backend.NotFoundError if the function
+// or version does not exist.
+func (s *Server) RollbackFunction(ctx context.Context, name string, version int64) (function *model.Function, err error) {
+	event := auditEvent(model.AuditActionRollback, modelTypeFunction, name)
+	defer s.audit(ctx, event, &err)
+	if err := s.authorize(ctx, auth.VerbWrite, auth.ResourceFunction); err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, errors.New("function has no name")
+	}
+	unlock, err := s.lock(ctx, functionPath(ctx, name))
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	current, err := s.GetFunction(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	event.OldChecksum = current.Checksum
+	v, err := getFunctionVersion(ctx, s.StateStore, name, version)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get function version")
+	}
+	if v == nil || v.Function == nil {
+		return nil, &backend.NotFoundError{Key: functionVersionPath(ctx, name, version)}
+	}
+
+	restored := *v.Function
+	event.NewChecksum = restored.Checksum
+	// The owner is not part of the function configuration, the function
+	// stays owned by the current owner.
+	restored.Owner = current.Owner
+	restored.Revision = current.Revision
+
+	if err := s.storeFunctionVersion(ctx, &restored, version); err != nil {
+		return nil, err
+	}
+	return &restored, nil
+}
+
+// storeFunctionVersion records the function as a new version and stores it
+// as the current function. rollbackOf is set if the function was restored
+// from a previous version. The version, the function, the reference to its
+// source archive and any additional operations are committed in a single
+// transaction. Returns a backend.ConflictError if the function has been
+// modified after the revision of f.
+func (s *Server) storeFunctionVersion(ctx context.Context, f *model.Function, rollbackOf int64, ops ...backend.Op) error {
+	existing, err := getFunction(ctx, s.StateStore, f.Name)
+	if err != nil {
+		return errors.Wrap(err, "check existing function")
+	}
+	var revision int64
+	f.Version = 1
+	if existing != nil {
+		f.Version = existing.Version + 1
+		revision = existing.Revision
+	}
+	if f.Revision != revision {
+		return errors.Wrapf(&backend.ConflictError{Key: functionPath(ctx, f.Name), Revision: f.Revision}, "function %s", f.Name)
+	}
+
+	recorded := *f
+	recorded.Revision = 0
+	recorded.Namespace = ""
+	v := &model.FunctionVersion{
+		Version:    f.Version,
+		Function:   &recorded,
+		Created:    s.Now().UTC(),
+		AppliedBy:  ActorFromContext(ctx),
+		RollbackOf: rollbackOf,
+	}
+	versionOp, err := functionVersionOp(ctx, v)
+	if err != nil {
+		return errors.Wrap(err, "could not store function version")
+	}
+	updateOp, err := functionOp(ctx, f)
+	if err != nil {
+		return errors.Wrap(err, "error storing function update")
+	}
+
+	// The version references the source archive
+	refOps, err := s.referenceSourceOps(ctx, f, 1)
+	if err != nil {
+		return err
+	}
+	ops = append(append([]backend.Op{versionOp, updateOp}, refOps...), ops...)
+
+	committed, err := s.StateStore.Txn(ctx, ops...)
+	if err != nil {
+		return errors.Wrap(err, "error storing function update")
+	}
+	f.Revision = committed
+	return nil
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fragments/fragments/internal/backend"
+	fsmocks "github.com/fragments/fragments/internal/filestore/mocks"
+	"github.com/fragments/fragments/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newVersionedFunction returns a server with function foo uploaded twice.
+func newVersionedFunction(t *testing.T) (*Server, *backend.TestKV) {
+	ctx := WithActor(context.Background(), "user@host")
+	kv := backend.NewTestKV()
+	mockSourceStore := &fsmocks.SourceTarget{}
+	mockSourceStore.On("NewUploadURL", "v1").Return("url", nil)
+	mockSourceStore.On("NewUploadURL", "v2").Return("url", nil)
+	mockSourceStore.On("Persist", ctx, "v1", sourceKey(digestOf("v1")), digestOf("v1")).Return(nil)
+	mockSourceStore.On("Persist", ctx, "v2", sourceKey(digestOf("v2")), digestOf("v2")).Return(nil)
+
+	s := New(kv, nil, mockSourceStore)
+	s.Now = testNow
+
+	for _, f := range []*model.Function{
+		{Name: "foo", Runtime: "go", Checksum: "v1", AWS: &model.FunctionAWS{Memory: 128}, Owner: "repo"},
+		{Name: "foo", Runtime: "go", Checksum: "v2", AWS: &model.FunctionAWS{Memory: 256}},
+	} {
+		token := f.Checksum
+		s.GenerateToken = func() string { return token }
+		_, err := s.PutFunction(ctx, f)
+		require.NoError(t, err)
+		require.NoError(t, s.ConfirmUpload(ctx, token, digestOf(token)))
+	}
+	return s, kv
+}
+
+func TestFunctionHistory(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newVersionedFunction(t)
+
+	versions, err := s.FunctionHistory(ctx, "foo")
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	assert.EqualValues(t, 1, versions[0].Version)
+	assert.Equal(t, "v1", versions[0].Function.Checksum)
+	assert.Equal(t, sourceKey(digestOf("v1")), versions[0].Function.SourceFilename)
+	assert.Equal(t, "user@host", versions[0].AppliedBy)
+	assert.Equal(t, testNow(), versions[0].Created)
+	assert.EqualValues(t, 2, versions[1].Version)
+	assert.Equal(t, "v2", versions[1].Function.Checksum)
+
+	f, err := s.GetFunction(ctx, "foo")
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, f.Version)
+
+	_, err = s.FunctionHistory(ctx, "bar")
+	assert.True(t, backend.IsNotFound(err))
+}
+
+func TestRollbackFunction(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		TestName string
+		Name     string
+		Version  int64
+		NotFound bool
+		Error    bool
+	}{
+		{
+			TestName: "NoName",
+			Error:    true,
+		},
+		{
+			TestName: "FunctionNotFound",
+			Name:     "bar",
+			Version:  1,
+			NotFound: true,
+		},
+		{
+			TestName: "VersionNotFound",
+			Name:     "foo",
+			Version:  5,
+			NotFound: true,
+		},
+		{
+			TestName: "Rollback",
+			Name:     "foo",
+			Version:  1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			s, _ := newVersionedFunction(t)
+
+			f, err := s.RollbackFunction(ctx, test.Name, test.Version)
+			switch {
+			case test.NotFound:
+				assert.True(t, backend.IsNotFound(err))
+				return
+			case test.Error:
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			assert.EqualValues(t, 3, f.Version)
+			assert.Equal(t, "v1", f.Checksum)
+			assert.Equal(t, sourceKey(digestOf("v1")), f.SourceFilename)
+			assert.EqualValues(t, 128, f.AWS.Memory)
+			assert.Equal(t, "repo", f.Owner)
+
+			stored, err := s.GetFunction(ctx, "foo")
+			require.NoError(t, err)
+			assert.Equal(t, f, stored)
+
+			versions, err := s.FunctionHistory(ctx, "foo")
+			require.NoError(t, err)
+			require.Len(t, versions, 3)
+			assert.EqualValues(t, 1, versions[2].RollbackOf)
+			// Revisions are not recorded
+			recorded := *f
+			recorded.Revision = 0
+			assert.Equal(t, &recorded, versions[2].Function)
+		})
+	}
+}
+
+func TestDeleteFunctionVersions(t *testing.T) {
+	ctx := context.Background()
+	s, kv := newVersionedFunction(t)
+	_, err := s.RollbackFunction(ctx, "foo", 1)
+	require.NoError(t, err)
+
+	// Content addressed archives are left for the garbage collector
+	mockSourceStore := &fsmocks.SourceTarget{}
+	s.SourceStore = mockSourceStore
+
+	require.NoError(t, s.DeleteFunction(ctx, "foo", false))
+	mockSourceStore.AssertExpectations(t)
+	data := withoutAudit(kv.Data)
+	require.Len(t, data, 2)
+	for _, content := range []string{"v1", "v2"} {
+		archive, _, err := getSourceArchive(ctx, kv, sourceKey(digestOf(content)))
+		require.NoError(t, err)
+		require.NotNil(t, archive)
+		assert.Zero(t, archive.References)
+	}
+}
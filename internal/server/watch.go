@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/fragments/fragments/internal/auth"
+	"github.com/fragments/fragments/internal/backend"
+	"github.com/fragments/fragments/internal/model"
+	"github.com/pkg/errors"
+)
+
+// WatchEvent is a change to a stored function, deployment or environment.
+type WatchEvent struct {
+	// Type is the type of the change.
+	Type backend.EventType `json:"type"`
+	// Model is the type of the model that changed.
+	Model string `json:"model"`
+	// Name is the name of the model that changed.
+	Name string `json:"name"`
+	// Revision is the revision the change was made at.
+	Revision int64 `json:"revision"`
+	// Function is the function after the change. It is only set when a
+	// function is put.
+	Function *model.Function `json:"function,omitempty"`
+	// Deployment is the deployment after the change. It is only set when a
+	// deployment is put.
+	Deployment *model.Deployment `json:"deployment,omitempty"`
+	// Environment is the environment after the change. It is only set when an
+	// environment is put.
+	Environment *model.Environment `json:"environment,omitempty"`
+}
+
+// Watch sends an event for every change to a function, deployment or
+// environment in the namespace set in the context until the context is
+// cancelled. Changes to a single model type are sent in order, changes to
+// different model types may be sent out of order. The channel is closed when
+// the context is cancelled or watching any of the model types fails.
+func (s *Server) Watch(ctx context.Context) (<-chan *WatchEvent, error) {
+	if err := s.authorize(ctx, auth.VerbRead, auth.ResourceFunction, auth.ResourceDeployment, auth.ResourceEnvironment); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+
+	prefixes := map[string]string{
+		modelTypeFunction:    functionPath(ctx, ""),
+		modelTypeDeployment:  deploymentPath(ctx, ""),
+		modelTypeEnvironment: environmentPath(ctx, ""),
+	}
+
+	out := make(chan *WatchEvent)
+	var wg sync.WaitGroup
+	for modelType, prefix := range prefixes {
+		events, err := s.StateStore.Watch(ctx, prefix)
+		if err != nil {
+			cancel()
+			return nil, errors.Wrapf(err, "could not watch %s", prefix)
+		}
+		wg.Add(1)
+		go func(modelType, prefix string, events <-chan backend.Event, stop context.CancelFunc) {
+			defer wg.Done()
+			// Stop the other watches in case this one fails
+			defer stop()
+			for e := range events {
+				event, err := watchEvent(ctx, modelType, prefix, e)
+				if err != nil {
+					log.Println(errors.Wrapf(err, "could not decode %s", e.Key))
+					continue
+				}
+				select {
+				case out <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(modelType, prefix, events, cancel)
+	}
+
+	go func(stop context.CancelFunc) {
+		wg.Wait()
+		stop()
+		close(out)
+	}(cancel)
+
+	return out, nil
+}
+
+// watchEvent converts a change to a key under prefix to a change of a model.
+func watchEvent(ctx context.Context, modelType, prefix string, e backend.Event) (*WatchEvent, error) {
+	event := &WatchEvent{
+		Type:     e.Type,
+		Model:    modelType,
+		Name:     strings.TrimPrefix(e.Key, prefix),
+		Revision: e.Revision,
+	}
+	if e.Type != backend.EventPut {
+		return event, nil
+	}
+
+	switch modelType {
+	case modelTypeFunction:
+		var f model.Function
+		if err := model.UnmarshalFunction([]byte(e.Value), &f); err != nil {
+			return nil, err
+		}
+		f.Namespace = NamespaceFromContext(ctx)
+		f.Revision = e.Revision
+		event.Function = &f
+	case modelTypeDeployment:
+		var d model.Deployment
+		if err := model.UnmarshalDeployment([]byte(e.Value), &d); err != nil {
+			return nil, err
+		}
+		d.Namespace = NamespaceFromContext(ctx)
+		d.Revision = e.Revision
+		event.Deployment = &d
+	case modelTypeEnvironment:
+		var env model.Environment
+		if err := model.UnmarshalEnvironment([]byte(e.Value), &env); err != nil {
+			return nil, err
+		}
+		env.Namespace = NamespaceFromContext(ctx)
+		env.Revision = e.Revision
+		event.Environment = &env
+	}
+	return event, nil
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fragments/fragments/internal/backend"
+	"github.com/fragments/fragments/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	kv := backend.NewTestKV()
+	s := New(kv, backend.NewTestKV(), nil)
+
+	events, err := s.Watch(ctx)
+	require.NoError(t, err)
+
+	next := func() *WatchEvent {
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(time.Second):
+			t.Fatal("no event received")
+			return nil
+		}
+	}
+
+	require.NoError(t, s.PutDeployment(ctx, &model.Deployment{Name: "deploy", Owner: "repo"}))
+	e := next()
+	assert.Equal(t, backend.EventPut, e.Type)
+	assert.Equal(t, "deployment", e.Model)
+	assert.Equal(t, "deploy", e.Name)
+	require.NotNil(t, e.Deployment)
+	assert.Equal(t, "repo", e.Deployment.Owner)
+	assert.Equal(t, e.Revision, e.Deployment.Revision)
+
+	require.NoError(t, s.CreateEnvironment(ctx, &EnvironmentInput{Name: "prod", Username: "user", Password: "pass"}))
+	e = next()
+	assert.Equal(t, "environment", e.Model)
+	assert.Equal(t, "prod", e.Name)
+	require.NotNil(t, e.Environment)
+
+	require.NoError(t, putFunction(ctx, kv, &model.Function{Name: "foo", Runtime: "go"}))
+	e = next()
+	assert.Equal(t, "function", e.Model)
+	require.NotNil(t, e.Function)
+	assert.Equal(t, "go", e.Function.Runtime)
+
+	// Other keys are not watched
+	require.NoError(t, kv.Put(ctx, functionVersionPath(ctx, "foo", 1), "{}"))
+
+	require.NoError(t, s.DeleteDeployment(ctx, "deploy"))
+	e = next()
+	assert.Equal(t, &WatchEvent{Type: backend.EventDelete, Model: "deployment", Name: "deploy", Revision: e.Revision}, e)
+
+	cancel()
+	select {
+	case _, ok := <-events:
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("watch not closed")
+	}
+}